@@ -3,11 +3,20 @@ package main
 import (
 	"log"
 	"os"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/bizino-services/biz-panel-backend/internal/api"
 	"github.com/bizino-services/biz-panel-backend/internal/auth"
+	"github.com/bizino-services/biz-panel-backend/internal/config"
+	"github.com/bizino-services/biz-panel-backend/internal/deploy"
 	"github.com/bizino-services/biz-panel-backend/internal/docker"
 	"github.com/bizino-services/biz-panel-backend/internal/middleware"
+	"github.com/bizino-services/biz-panel-backend/internal/proxy"
+	"github.com/bizino-services/biz-panel-backend/internal/reconcile"
+	"github.com/bizino-services/biz-panel-backend/internal/secrets"
+	"github.com/bizino-services/biz-panel-backend/internal/store"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
@@ -19,50 +28,167 @@ func main() {
 		log.Printf("Warning: Docker not available: %v", err)
 	}
 
+	// Load configuration (file + env overrides), reloaded on SIGHUP
+	configPath := os.Getenv("CONFIG_FILE")
+	if configPath == "" {
+		configPath = config.DefaultPath
+	}
+
+	cfgManager, err := config.Load(configPath)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	cfg := cfgManager.Current()
+
+	// Open persistent storage
+	dataStore, err := store.Open(cfg.Database.Path)
+	if err != nil {
+		log.Fatalf("Failed to open store at %s: %v", cfg.Database.Path, err)
+	}
+	defer dataStore.Close()
+	api.SetStore(dataStore)
+	auth.SetStore(dataStore)
+
+	// Project secrets are encrypted at rest with the master key
+	keyring, err := secrets.Load(cfg.Secrets.KeyFile)
+	if err != nil {
+		log.Fatalf("Failed to load master key from %s: %v", cfg.Secrets.KeyFile, err)
+	}
+	if err := keyring.SealAll(dataStore.Projects); err != nil {
+		log.Printf("Warning: failed to encrypt stored secrets: %v", err)
+	}
+	api.SetKeyring(keyring)
+
+	// Rebuild records for sites, certificates and project networks found on the host
+	reconciler := reconcile.New(dataStore, dockerClient)
+	reconciler.RunAtBoot(60 * time.Second)
+
+	// Builds and runs projects on their isolated networks
+	deployer := deploy.New(dataStore, dockerClient)
+	deployer.SetRetention(cfg.Deploy.KeepImages, cfg.Deploy.KeepDeployments)
+	deployer.SetDrainTimeout(cfg.Deploy.DrainTimeout)
+	deployer.SetConcurrency(cfg.Deploy.MaxConcurrent)
+	deployer.Proxy = proxy.NewNginx(cfg.Proxy.SitesDir, cfg.Proxy.EnabledDir)
+	deployer.Secrets = keyring
+	// Remove preview environments of closed or stale pull requests
+	deployer.ExpirePreviews()
+	deployer.ReapPreviews(time.Minute)
+
 	// Initialize Authentication
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		jwtSecret = "biz-panel-default-secret-change-in-production"
+	applyAuthConfig := func(cfg *config.Config) {
+		auth.Initialize(auth.Config{
+			JWTSecret:     cfg.Auth.JWTSecret,
+			TokenDuration: cfg.TokenDuration(),
+			AccessTTL:     cfg.Auth.AccessTokenTTL,
+			AdminUser:     cfg.Admin.Username,
+			AdminPassHash: cfg.Admin.PasswordHash,
+			Require2FA:    cfg.Security.Enable2FA,
+			BruteForce: auth.BruteForcePolicy{
+				MaxAttempts: cfg.Security.BruteForce.MaxAttempts,
+				Window:      cfg.Security.BruteForce.Window,
+				Lockout:     cfg.Security.BruteForce.Lockout,
+				MaxLockout:  cfg.Security.BruteForce.MaxLockout,
+				Firewall:    cfg.Security.BruteForce.Firewall,
+			},
+			OIDC: auth.OIDCConfig{
+				Enabled:       cfg.Auth.OIDC.Enabled,
+				DisplayName:   cfg.Auth.OIDC.DisplayName,
+				Issuer:        cfg.Auth.OIDC.Issuer,
+				ClientID:      cfg.Auth.OIDC.ClientID,
+				ClientSecret:  cfg.Auth.OIDC.ClientSecret,
+				RedirectURL:   cfg.Auth.OIDC.RedirectURL,
+				Scopes:        cfg.Auth.OIDC.Scopes,
+				UsernameClaim: cfg.Auth.OIDC.UsernameClaim,
+				GroupsClaim:   cfg.Auth.OIDC.GroupsClaim,
+				Roles:         auth.RoleMapping{Groups: cfg.Auth.OIDC.RoleMapping, Default: cfg.Auth.OIDC.DefaultRole},
+			},
+			LDAP: auth.LDAPConfig{
+				Enabled:            cfg.Auth.LDAP.Enabled,
+				URL:                cfg.Auth.LDAP.URL,
+				StartTLS:           cfg.Auth.LDAP.StartTLS,
+				InsecureSkipVerify: cfg.Auth.LDAP.InsecureSkipVerify,
+				BindDN:             cfg.Auth.LDAP.BindDN,
+				BindPassword:       cfg.Auth.LDAP.BindPassword,
+				UserBaseDN:         cfg.Auth.LDAP.UserBaseDN,
+				UserFilter:         cfg.Auth.LDAP.UserFilter,
+				UsernameAttribute:  cfg.Auth.LDAP.UsernameAttribute,
+				EmailAttribute:     cfg.Auth.LDAP.EmailAttribute,
+				GroupBaseDN:        cfg.Auth.LDAP.GroupBaseDN,
+				GroupFilter:        cfg.Auth.LDAP.GroupFilter,
+				GroupNameAttribute: cfg.Auth.LDAP.GroupNameAttribute,
+				Timeout:            cfg.Auth.LDAP.Timeout,
+				Roles:              auth.RoleMapping{Groups: cfg.Auth.LDAP.RoleMapping, Default: cfg.Auth.LDAP.DefaultRole},
+			},
+			PersistPasswordHash: func(hash string) error {
+				// Keep an env override in step so a reload doesn't restore the old hash
+				if os.Getenv("ADMIN_PASS_HASH") != "" {
+					os.Setenv("ADMIN_PASS_HASH", hash)
+				}
+				return cfgManager.Update(func(c *config.Config) {
+					c.Admin.PasswordHash = hash
+				})
+			},
+		})
 	}
+	applyAuthConfig(cfg)
 
-	adminUser := os.Getenv("ADMIN_USER")
-	if adminUser == "" {
-		adminUser = "admin"
+	// The rate limiter keeps its buckets across reloads; only the policies change
+	rateLimiter := middleware.NewRateLimiter(rateLimitPolicies(cfg))
+	if err := rateLimiter.SetExempt(cfg.RateLimit.Exempt); err != nil {
+		log.Fatalf("Invalid rate_limit.exempt: %v", err)
 	}
 
-	adminPassHash := os.Getenv("ADMIN_PASS_HASH")
-	if adminPassHash == "" {
-		// Default password hash for "admin123" - CHANGE IN PRODUCTION
-		adminPassHash = "$2a$10$JpCkpb4PGX4QmbEaqLO6RulAsCF4.hkiI557ujaLzuHUP4Shc/ht6"
+	// IP allowlist from the config file and SecuritySettings.AllowedIPs
+	ipAllowlist := middleware.NewIPAllowlist()
+	if err := ipAllowlist.SetConfigEntries(cfg.Security.AllowedIPs); err != nil {
+		log.Fatalf("Invalid security.allowed_ips: %v", err)
 	}
+	if settings, err := dataStore.Settings.Get(); err == nil {
+		if err := ipAllowlist.SetSettingsEntries(settings.Security.AllowedIPs); err != nil {
+			log.Printf("Warning: ignoring invalid allowed IPs in settings: %v", err)
+		}
+	}
+	api.SetIPAllowlist(ipAllowlist)
+
+	// CORS origins are looked up per request so reloads take effect immediately
+	var allowedOrigins atomic.Value
+	setAllowedOrigins := func(origins []string) {
+		set := make(map[string]bool, len(origins))
+		for _, origin := range origins {
+			set[strings.TrimSuffix(origin, "/")] = true
+		}
+		allowedOrigins.Store(set)
+	}
+	setAllowedOrigins(cfg.Server.CORSOrigins)
+	api.SetAllowedOrigins(func(origin string) bool {
+		return allowedOrigins.Load().(map[string]bool)[origin]
+	})
 
-	auth.Initialize(auth.Config{
-		JWTSecret:     jwtSecret,
-		AdminUser:     adminUser,
-		AdminPassHash: adminPassHash,
+	cfgManager.OnReload(func(cfg *config.Config) {
+		applyAuthConfig(cfg)
+		rateLimiter.SetPolicies(rateLimitPolicies(cfg))
+		rateLimiter.SetExempt(cfg.RateLimit.Exempt)
+		setAllowedOrigins(cfg.Server.CORSOrigins)
+		ipAllowlist.SetConfigEntries(cfg.Security.AllowedIPs)
+		deployer.SetRetention(cfg.Deploy.KeepImages, cfg.Deploy.KeepDeployments)
+		deployer.SetDrainTimeout(cfg.Deploy.DrainTimeout)
+		deployer.SetConcurrency(cfg.Deploy.MaxConcurrent)
 	})
+	cfgManager.WatchSignals()
 
 	// Create Gin router
 	r := gin.Default()
 
-	// CORS configuration - Secure: Allow specific origins
-	corsOrigins := os.Getenv("CORS_ORIGINS")
-	allowedOrigins := []string{
-		"http://localhost:5173",
-		"http://localhost:5174",
-		"http://localhost:8888",
-		"http://127.0.0.1:5173",
-		"http://127.0.0.1:5174",
-		"http://127.0.0.1:8888",
-	}
-	if corsOrigins != "" {
-		allowedOrigins = append(allowedOrigins, corsOrigins)
+	// Only trusted proxies may supply X-Forwarded-For, so c.ClientIP() can't be spoofed
+	if err := r.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		log.Fatalf("Invalid server.trusted_proxies: %v", err)
 	}
-	// Also allow the server's own IP
-	allowedOrigins = append(allowedOrigins, "http://116.118.2.95:5173", "http://116.118.2.95:5174", "http://116.118.2.95:8888")
 
+	// CORS configuration - Secure: Allow configured origins only
 	r.Use(cors.New(cors.Config{
-		AllowOrigins:     allowedOrigins,
+		AllowOriginFunc: func(origin string) bool {
+			return allowedOrigins.Load().(map[string]bool)[origin]
+		},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
 		AllowCredentials: true,
@@ -75,65 +201,142 @@ func main() {
 		apiGroup.GET("/health", api.HealthCheck)
 
 		// Auth routes (no auth required for login, but rate limited)
+		// Every mutating request is audited, including rejected ones
+		auditLog := middleware.Audit(dataStore.Audit)
+
 		authGroup := apiGroup.Group("/auth")
-		authGroup.Use(middleware.LoginRateLimiter()) // Rate limit: 5 attempts/minute
+		authGroup.Use(auditLog)
+		authGroup.Use(ipAllowlist.Middleware())
+		authGroup.Use(auth.BanMiddleware())
+		authGroup.Use(rateLimiter.Middleware("login")) // Rate limit: rate_limit.login
 		{
 			authGroup.POST("/login", auth.LoginHandler)
+			authGroup.POST("/login/2fa", auth.TwoFactorLoginHandler)
+			authGroup.POST("/refresh", auth.RefreshHandler)
+			authGroup.GET("/providers", auth.ProvidersHandler)
+			authGroup.GET("/oidc/login", auth.OIDCLoginHandler)
+			authGroup.POST("/oidc/callback", auth.OIDCCallbackHandler)
+		}
+
+		// Git hosts call webhooks without a session; the project's webhook
+		// secret authenticates them
+		webhooks := apiGroup.Group("/webhooks")
+		webhooks.Use(auditLog)
+		webhooks.Use(auth.BanMiddleware())
+		webhooks.Use(rateLimiter.Middleware("api"))
+		{
+			webhooks.POST("/git/:id", api.GitWebhook(deployer))
 		}
 
 		// Apply auth middleware and rate limiting to all protected routes
 		protected := apiGroup.Group("")
-		protected.Use(middleware.APIRateLimiter()) // Rate limit: 100 requests/minute
+		protected.Use(auditLog)
+		protected.Use(ipAllowlist.Middleware()) // Also covers WebSocket upgrades
+		protected.Use(auth.BanMiddleware())
 		protected.Use(auth.AuthMiddleware())
+		protected.Use(rateLimiter.Middleware("api")) // Rate limit: rate_limit.api or a policy; after auth so it can count per user
 		{
 			// Auth routes (protected)
 			protected.GET("/auth/me", auth.GetCurrentUser)
 			protected.POST("/auth/change-password", auth.ChangePasswordHandler)
+			protected.GET("/auth/2fa", auth.GetTwoFactorStatus)
+			protected.POST("/auth/2fa/setup", auth.SetupTwoFactor)
+			protected.POST("/auth/2fa/verify", auth.VerifyTwoFactor)
+			protected.POST("/auth/2fa/recovery-codes", auth.RegenerateRecoveryCodes)
+			protected.POST("/auth/2fa/disable", auth.DisableTwoFactor)
+			protected.POST("/auth/logout", auth.LogoutHandler)
+			protected.GET("/auth/sessions", auth.ListSessions)
+			protected.DELETE("/auth/sessions/:id", auth.RevokeSessionHandler)
+			protected.POST("/auth/sessions/revoke-all", auth.RevokeAllSessions)
+			protected.GET("/auth/tokens", auth.ListAPITokens)
+			protected.POST("/auth/tokens", auth.CreateAPIToken)
+			protected.DELETE("/auth/tokens/:id", auth.RevokeAPIToken)
+
+			// Browsers open WebSockets with a single-use ticket (?ticket= or subprotocol)
+			protected.POST("/auth/ws-ticket", auth.CreateWSTicket)
 
 			// System metrics
-			protected.GET("/metrics", api.GetSystemMetrics)
-			protected.GET("/metrics/ws", api.MetricsWebSocket)
+			protected.GET("/metrics", auth.RequireAccess(auth.ResourceMetrics), api.GetSystemMetrics)
+			protected.GET("/metrics/ws", auth.RequireAccess(auth.ResourceMetrics), api.MetricsWebSocket)
 
 			// Set global Docker client for projects
 			api.SetDockerClient(dockerClient)
 
 			// Projects (Coolify-style with isolated networks)
+			// Project routes are further limited to the caller's memberships
+			projectAccess := auth.RequireProject(auth.ProjectParam, "Project not found")
+			projectManage := auth.RequireProjectAction(auth.ActionManage, auth.ProjectParam, "Project not found")
+
 			projects := protected.Group("/projects")
+			projects.Use(auth.RequireAccess(auth.ResourceProjects))
 			{
 				projects.GET("", api.ListProjects)
 				projects.POST("", api.CreateProject)
-				projects.GET("/:id", api.GetProject)
-				projects.PUT("/:id", api.UpdateProject)
-				projects.DELETE("/:id", api.DeleteProject)
-				projects.POST("/:id/deploy", api.DeployProject)
-				projects.GET("/:id/logs", api.GetProjectLogs)
-				projects.GET("/:id/containers", api.GetProjectContainers(dockerClient))
-				projects.POST("/:id/containers", api.AddContainerToProject(dockerClient))
+				projects.GET("/:id", projectAccess, api.GetProject)
+				projects.PUT("/:id", projectAccess, api.UpdateProject)
+				projects.POST("/:id/secrets/reveal", projectManage, api.RevealProjectSecrets)
+				projects.DELETE("/:id", projectManage, api.DeleteProject(deployer))
+				projects.GET("/:id/previews", projectAccess, api.ListPreviews(deployer))
+				projects.DELETE("/:id/previews/:previewId", projectAccess, api.DeletePreview(deployer))
+				projects.GET("/:id/logs", projectAccess, api.GetProjectLogs)
+				projects.GET("/:id/containers", projectAccess, api.GetProjectContainers(dockerClient))
+				projects.POST("/:id/containers", projectAccess, api.AddContainerToProject(dockerClient))
+				projects.GET("/:id/members", projectAccess, auth.ListProjectMembers)
+				projects.POST("/:id/members", projectManage, auth.AddProjectMember)
+				projects.PUT("/:id/members/:userId", projectManage, auth.UpdateProjectMember)
+				projects.DELETE("/:id/members/:userId", projectManage, auth.RemoveProjectMember)
+				projects.GET("/:id/webhook", projectManage, api.GetProjectWebhook)
+				projects.POST("/:id/webhook", projectManage, api.CreateProjectWebhook)
+				projects.DELETE("/:id/webhook", projectManage, api.DeleteProjectWebhook)
+				projects.GET("/:id/webhook/deliveries", projectAccess, api.ListWebhookDeliveries)
+				projects.GET("/:id/build-plan", projectAccess, api.GetBuildPlan(deployer))
+				projects.GET("/:id/deployments", projectAccess, api.ListDeployments(deployer))
+				projects.GET("/:id/deployments/:deployId", projectAccess, api.GetDeployment(deployer))
+				projects.GET("/:id/deployments/:deployId/logs/ws", projectAccess, api.DeploymentLogSocket(deployer))
+				projects.GET("/:id/services", projectAccess, api.ListProjectServices(deployer))
+				projects.POST("/:id/services/down", projectAccess, api.ServicesDown(deployer))
+				projects.POST("/:id/services/pull", projectAccess, api.PullServices(deployer))
+				projects.POST("/:id/services/:service/restart", projectAccess, api.RestartService(deployer))
+			}
+
+			// Deploying only needs projects:deploy so CI tokens can be scoped to it
+			deployAccess := []gin.HandlerFunc{
+				auth.RequirePermission(auth.NewPermission(auth.ResourceProjects, auth.ActionDeploy)),
+				auth.RequireProjectAction(auth.ActionDeploy, auth.ProjectParam, "Project not found"),
 			}
+			protected.POST("/projects/:id/deploy", append(deployAccess, api.DeployProject(deployer))...)
+			protected.POST("/projects/:id/services/up", append(deployAccess, api.ServicesUp(deployer))...)
+			protected.POST("/projects/:id/deployments/:deployId/rollback", append(deployAccess, api.RollbackDeployment(deployer))...)
+			protected.POST("/projects/:id/deployments/:deployId/cancel", append(deployAccess, api.CancelDeployment(deployer))...)
 
 			// Docker
+			// Containers, images, networks and volumes belong to the project in their biz-panel.project label
+			containerAccess := auth.RequireProject(api.ContainerProject(dockerClient), "Container not found")
+
 			containers := protected.Group("/docker")
+			containers.Use(auth.RequireAccess(auth.ResourceDocker))
 			{
 				containers.GET("/containers", api.ListContainers(dockerClient))
-				containers.GET("/containers/:id", api.GetContainer(dockerClient))
-				containers.POST("/containers/:id/start", api.StartContainer(dockerClient))
-				containers.POST("/containers/:id/stop", api.StopContainer(dockerClient))
-				containers.POST("/containers/:id/restart", api.RestartContainer(dockerClient))
-				containers.DELETE("/containers/:id", api.RemoveContainer(dockerClient))
-				containers.GET("/containers/:id/logs", api.ContainerLogs(dockerClient))
-				containers.GET("/containers/:id/stats", api.ContainerStats(dockerClient))
+				containers.GET("/containers/:id", containerAccess, api.GetContainer(dockerClient))
+				containers.POST("/containers/:id/start", containerAccess, api.StartContainer(dockerClient))
+				containers.POST("/containers/:id/stop", containerAccess, api.StopContainer(dockerClient))
+				containers.POST("/containers/:id/restart", containerAccess, api.RestartContainer(dockerClient))
+				containers.DELETE("/containers/:id", containerAccess, api.RemoveContainer(dockerClient))
+				containers.GET("/containers/:id/logs", containerAccess, api.ContainerLogs(dockerClient))
+				containers.GET("/containers/:id/stats", containerAccess, api.ContainerStats(dockerClient))
 				containers.GET("/images", api.ListImages(dockerClient))
-				containers.DELETE("/images/:id", api.RemoveImage(dockerClient))
+				containers.DELETE("/images/:id", auth.RequireProject(api.ImageProject(dockerClient), "Image not found"), api.RemoveImage(dockerClient))
 				containers.GET("/networks", api.ListNetworks(dockerClient))
 				containers.POST("/networks", api.CreateNetwork(dockerClient))
-				containers.DELETE("/networks/:id", api.RemoveNetwork(dockerClient))
+				containers.DELETE("/networks/:id", auth.RequireProject(api.NetworkProject(dockerClient), "Network not found"), api.RemoveNetwork(dockerClient))
 				containers.GET("/volumes", api.ListVolumes(dockerClient))
 				containers.POST("/volumes", api.CreateVolume(dockerClient))
-				containers.DELETE("/volumes/:name", api.RemoveVolume(dockerClient))
+				containers.DELETE("/volumes/:name", auth.RequireProject(api.VolumeProject(dockerClient), "Volume not found"), api.RemoveVolume(dockerClient))
 			}
 
 			// Websites
 			websites := protected.Group("/websites")
+			websites.Use(auth.RequireAccess(auth.ResourceWebsites))
 			{
 				websites.GET("", api.ListWebsites)
 				websites.POST("", api.CreateWebsiteReal)
@@ -142,6 +345,7 @@ func main() {
 
 			// Databases
 			databases := protected.Group("/databases")
+			databases.Use(auth.RequireAccess(auth.ResourceDatabases))
 			{
 				databases.GET("", api.ListDatabases)
 				databases.POST("", api.CreateDatabase)
@@ -150,6 +354,7 @@ func main() {
 
 			// Cronjobs
 			crons := protected.Group("/crons")
+			crons.Use(auth.RequireAccess(auth.ResourceCrons))
 			{
 				crons.GET("", api.ListCronjobs)
 				crons.POST("", api.CreateCronjob)
@@ -160,6 +365,7 @@ func main() {
 
 			// Firewall
 			firewall := protected.Group("/firewall")
+			firewall.Use(auth.RequireAccess(auth.ResourceFirewall))
 			{
 				firewall.GET("/rules", api.ListFirewallRules)
 				firewall.POST("/rules", api.CreateFirewallRule)
@@ -167,14 +373,52 @@ func main() {
 			}
 
 			// Settings
-			protected.GET("/settings", api.GetSettings)
-			protected.PUT("/settings", api.UpdateSettings)
+			protected.GET("/settings", auth.RequireAccess(auth.ResourceSettings), api.GetSettings)
+			protected.PUT("/settings", auth.RequireAccess(auth.ResourceSettings), api.UpdateSettings)
 
 			// Activities
-			protected.GET("/activities", api.ListActivities)
+			protected.GET("/activities", auth.RequireAccess(auth.ResourceActivities), api.ListActivities)
+
+			// Users
+			users := protected.Group("/users")
+			users.Use(auth.RequireAccess(auth.ResourceUsers))
+			{
+				users.GET("", auth.ListUsers)
+				users.POST("", auth.CreateUser)
+				users.GET("/:id", auth.GetUser)
+				users.PUT("/:id", auth.UpdateUser)
+				users.DELETE("/:id", auth.DeleteUser)
+			}
+
+			// Security (brute-force bans)
+			security := protected.Group("/security")
+			security.Use(auth.RequireAccess(auth.ResourceSecurity))
+			{
+				security.GET("/bans", auth.ListBans)
+				security.POST("/bans", auth.CreateBan)
+				security.DELETE("/bans/:id", auth.DeleteBan)
+			}
+
+			// Audit log
+			audit := protected.Group("/audit")
+			audit.Use(auth.RequireAccess(auth.ResourceAudit))
+			{
+				audit.GET("", api.ListAuditLog)
+				audit.GET("/export", api.ExportAuditLog)
+			}
+
+			// System
+			system := protected.Group("/system")
+			system.Use(auth.RequireAccess(auth.ResourceSystem))
+			{
+				system.POST("/reconcile", api.ReconcileSystem(reconciler))
+				system.GET("/reconcile", api.GetReconcileReport(reconciler))
+				system.POST("/secrets/rotate", api.RotateMasterKey)
+			}
 
 			// File Manager
 			files := protected.Group("/files")
+			files.Use(auth.RequireAccess(auth.ResourceFiles))
 			{
 				files.GET("", api.ListDirectory)
 				files.GET("/read", api.ReadFile)
@@ -189,6 +433,7 @@ func main() {
 
 			// Logs
 			logs := protected.Group("/logs")
+			logs.Use(auth.RequireAccess(auth.ResourceLogs))
 			{
 				logs.GET("/sources", api.ListLogSources)
 				logs.GET("/:source", api.GetLogs)
@@ -200,15 +445,22 @@ func main() {
 
 			// Terminal
 			terminal := protected.Group("/terminal")
+			terminal.Use(auth.RequirePermission(auth.NewPermission(auth.ResourceTerminal, auth.ActionWrite)))
 			{
 				terminal.GET("/shells", api.ListShells)
 				terminal.GET("/ws", api.CreateTerminal)
 				terminal.POST("/exec", api.ExecuteCommand)
-				terminal.GET("/container/:id", api.ContainerTerminal)
 			}
 
+			// A container shell is project-scoped and does not need host terminal access
+			protected.GET("/terminal/container/:id",
+				auth.RequirePermission(auth.NewPermission(auth.ResourceDocker, auth.ActionWrite)),
+				auth.RequireProjectAction(auth.ActionWrite, api.ContainerProject(dockerClient), "Container not found"),
+				api.ContainerTerminal)
+
 			// App Store / Templates
 			templates := protected.Group("/templates")
+			templates.Use(auth.RequireAccess(auth.ResourceTemplates))
 			{
 				templates.GET("", api.ListTemplates)
 				templates.GET("/categories", api.GetTemplateCategories)
@@ -218,6 +470,7 @@ func main() {
 
 			// SSL Certificates
 			ssl := protected.Group("/ssl")
+			ssl.Use(auth.RequireAccess(auth.ResourceSSL))
 			{
 				ssl.GET("", api.ListSSLCertificates)
 				ssl.GET("/:id", api.GetSSLCertificate)
@@ -231,6 +484,7 @@ func main() {
 
 			// Software Management
 			software := protected.Group("/software")
+			software.Use(auth.RequireAccess(auth.ResourceSoftware))
 			{
 				software.GET("", api.ListSoftware)
 				software.POST("/:id/install", api.InstallSoftware)
@@ -241,6 +495,7 @@ func main() {
 
 			// PHP Management
 			php := protected.Group("/php")
+			php.Use(auth.RequireAccess(auth.ResourcePHP))
 			{
 				php.GET("/versions", api.ListPHPVersions)
 				php.POST("/versions/:version/install", api.InstallPHPVersion)
@@ -256,6 +511,7 @@ func main() {
 
 			// Unified Services Management
 			services := protected.Group("/services")
+			services.Use(auth.RequireAccess(auth.ResourceServices))
 			{
 				services.GET("", api.ListServices)
 				services.GET("/:id", api.GetService)
@@ -269,15 +525,33 @@ func main() {
 		}
 	}
 
-	// Get port from env or default
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
-
-	log.Printf("🚀 Biz-Panel Backend starting on :%s", port)
+	// Listen address changes require a restart
+	log.Printf("🚀 Biz-Panel Backend starting on %s", cfg.ListenAddr())
 	log.Printf("📋 Default credentials: admin / admin123 (CHANGE IN PRODUCTION!)")
-	if err := r.Run(":" + port); err != nil {
+	if err := r.Run(cfg.ListenAddr()); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 }
+
+// rateLimitPolicies converts the rate_limit config section to limiter policies
+func rateLimitPolicies(cfg *config.Config) []middleware.RateLimitPolicy {
+	policy := func(name string, limit config.RateLimit, routes []string) middleware.RateLimitPolicy {
+		return middleware.RateLimitPolicy{
+			Name:     name,
+			Requests: limit.Requests,
+			Window:   limit.Window,
+			Burst:    limit.Burst,
+			Key:      limit.Key,
+			Routes:   routes,
+		}
+	}
+
+	policies := []middleware.RateLimitPolicy{
+		policy("login", cfg.RateLimit.Login, nil),
+		policy("api", cfg.RateLimit.API, nil),
+	}
+	for name, p := range cfg.RateLimit.Policies {
+		policies = append(policies, policy(name, p.RateLimit, p.Routes))
+	}
+	return policies
+}
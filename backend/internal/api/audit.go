@@ -0,0 +1,114 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bizino-services/biz-panel-backend/internal/models"
+	"github.com/bizino-services/biz-panel-backend/internal/store"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// parseAuditFilter builds a filter from query parameters:
+// user, method, route, resourceId, ip, status (404 or 4xx), from, to (RFC 3339), limit
+func parseAuditFilter(c *gin.Context) (store.AuditFilter, error) {
+	filter := store.AuditFilter{
+		User:       c.Query("user"),
+		Method:     c.Query("method"),
+		Route:      c.Query("route"),
+		ResourceID: c.Query("resourceId"),
+		ClientIP:   c.Query("ip"),
+	}
+
+	if status := strings.ToLower(c.Query("status")); status != "" {
+		if len(status) == 3 && strings.HasSuffix(status, "xx") && status[0] >= '1' && status[0] <= '5' {
+			class := int(status[0]-'0') * 100
+			filter.StatusMin, filter.StatusMax = class, class+99
+		} else {
+			code, err := strconv.Atoi(status)
+			if err != nil {
+				return filter, fmt.Errorf("invalid status %q", status)
+			}
+			filter.StatusMin, filter.StatusMax = code, code
+		}
+	}
+
+	for param, dst := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if v := c.Query(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s: use RFC 3339", param)
+			}
+			*dst = t
+		}
+	}
+
+	if v := c.Query("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 0 {
+			return filter, fmt.Errorf("invalid limit %q", v)
+		}
+		filter.Limit = limit
+	}
+
+	return filter, nil
+}
+
+// ListAuditLog returns audit entries, newest first
+func ListAuditLog(c *gin.Context) {
+	filter, err := parseAuditFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if filter.Limit == 0 {
+		filter.Limit = defaultAuditLimit
+	}
+	if filter.Limit > maxAuditLimit {
+		filter.Limit = maxAuditLimit
+	}
+
+	entries := make([]*models.AuditEntry, 0)
+	err = dataStore.Audit.Query(filter, func(entry *models.AuditEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"entries": entries, "count": len(entries)})
+}
+
+// ExportAuditLog streams matching audit entries as JSON Lines
+func ExportAuditLog(c *gin.Context) {
+	filter, err := parseAuditFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filename := fmt.Sprintf("biz-panel-audit-%s.jsonl", time.Now().Format("20060102-150405"))
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	c.Status(http.StatusOK)
+
+	enc := json.NewEncoder(c.Writer)
+	err = dataStore.Audit.Query(filter, func(entry *models.AuditEntry) error {
+		return enc.Encode(entry)
+	})
+	if err != nil {
+		// Headers are already sent; the truncated file is all we can do
+		c.Error(err)
+	}
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/bizino-services/biz-panel-backend/internal/deploy"
+	"github.com/bizino-services/biz-panel-backend/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// respondServiceError maps deployer errors of service operations to responses
+func respondServiceError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, deploy.ErrNoDocker):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Docker not available"})
+	case errors.Is(err, deploy.ErrInProgress):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, deploy.ErrNotCompose):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Project has no compose file"})
+	case errors.Is(err, deploy.ErrServiceNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Service not found"})
+	default:
+		respondStoreError(c, err, "Project not found")
+	}
+}
+
+// ListProjectServices returns the service containers of a compose project
+func ListProjectServices(deployer *deploy.Deployer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		services, err := deployer.Services(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			respondServiceError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, services)
+	}
+}
+
+// ServicesUp deploys every service of a compose project
+func ServicesUp(deployer *deploy.Deployer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		project, err := dataStore.Projects.Get(id)
+		if err != nil {
+			respondStoreError(c, err, "Project not found")
+			return
+		}
+
+		deployment, err := deployer.Up(id, c.GetString("user_id"))
+		if err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		addActivity(&models.Activity{
+			ID:          uuid.New().String()[:8],
+			Type:        "deploy",
+			Title:       "Deployment Started",
+			Description: "Services of '" + project.Name + "' are being brought up",
+			Status:      "pending",
+			ProjectID:   id,
+			UserID:      c.GetString("user_id"),
+			Timestamp:   deployment.StartedAt,
+		})
+
+		c.JSON(http.StatusAccepted, gin.H{
+			"message":  startMessage(deployment, "Deployment"),
+			"deployId": deployment.ID,
+			"status":   deployment.Status,
+			"network":  project.NetworkID,
+		})
+	}
+}
+
+// ServicesDown stops and removes a project's containers.
+// ?volumes=true also removes the volumes created from its compose file.
+func ServicesDown(deployer *deploy.Deployer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		removeVolumes := c.Query("volumes") == "true"
+
+		removed, err := deployer.Down(id, removeVolumes)
+		if err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		addActivity(&models.Activity{
+			ID:          uuid.New().String()[:8],
+			Type:        "project",
+			Title:       "Services Stopped",
+			Description: "Services of project " + id + " were taken down",
+			Status:      "success",
+			ProjectID:   id,
+			UserID:      c.GetString("user_id"),
+			Timestamp:   time.Now(),
+		})
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Services stopped",
+			"removed": removed,
+		})
+	}
+}
+
+// PullServices pulls the images of a compose project's services
+func PullServices(deployer *deploy.Deployer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		pulled, err := deployer.Pull(c.Param("id"))
+		if err != nil {
+			if errors.Is(err, deploy.ErrNoDocker) || errors.Is(err, deploy.ErrInProgress) ||
+				errors.Is(err, deploy.ErrNotCompose) {
+				respondServiceError(c, err)
+				return
+			}
+			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error(), "pulled": pulled})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Images pulled",
+			"pulled":  pulled,
+		})
+	}
+}
+
+// RestartService restarts one service of a compose project
+func RestartService(deployer *deploy.Deployer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, service := c.Param("id"), c.Param("service")
+
+		if err := deployer.RestartService(id, service); err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		addActivity(&models.Activity{
+			ID:          uuid.New().String()[:8],
+			Type:        "project",
+			Title:       "Service Restarted",
+			Description: "Service '" + service + "' of project " + id + " was restarted",
+			Status:      "success",
+			ProjectID:   id,
+			UserID:      c.GetString("user_id"),
+			Timestamp:   time.Now(),
+		})
+
+		c.JSON(http.StatusOK, gin.H{"message": "Service restarted"})
+	}
+}
@@ -0,0 +1,248 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/bizino-services/biz-panel-backend/internal/buildpack"
+	"github.com/bizino-services/biz-panel-backend/internal/deploy"
+	"github.com/bizino-services/biz-panel-backend/internal/models"
+	"github.com/bizino-services/biz-panel-backend/internal/store"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// buildPlanTimeout bounds cloning a repository to detect its build
+const buildPlanTimeout = 2 * time.Minute
+
+// ListDeployments returns a project's deployment history, newest first
+func ListDeployments(deployer *deploy.Deployer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		deployments, err := deployer.History(c.Param("id"))
+		if err != nil {
+			respondStoreError(c, err, "Project not found")
+			return
+		}
+		c.JSON(http.StatusOK, deployments)
+	}
+}
+
+// GetDeployment returns one deployment with its logs
+func GetDeployment(deployer *deploy.Deployer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		deployment, err := deployer.Deployment(c.Param("id"), c.Param("deployId"))
+		if err != nil {
+			respondStoreError(c, err, "Deployment not found")
+			return
+		}
+		c.JSON(http.StatusOK, deployment)
+	}
+}
+
+// RollbackDeployment redeploys the images of an earlier successful deployment
+func RollbackDeployment(deployer *deploy.Deployer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, deployID := c.Param("id"), c.Param("deployId")
+
+		project, err := dataStore.Projects.Get(id)
+		if err != nil {
+			respondStoreError(c, err, "Project not found")
+			return
+		}
+
+		deployment, err := deployer.Rollback(id, deployID, c.GetString("user_id"))
+		switch {
+		case errors.Is(err, deploy.ErrNoDocker):
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Docker not available"})
+			return
+		case errors.Is(err, deploy.ErrNotRollbackable):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		case err != nil:
+			respondStoreError(c, err, "Deployment not found")
+			return
+		}
+
+		addActivity(&models.Activity{
+			ID:          uuid.New().String()[:8],
+			Type:        "deploy",
+			Title:       "Rollback Started",
+			Description: "Rolling '" + project.Name + "' back to deployment " + deployID,
+			Status:      "pending",
+			ProjectID:   id,
+			UserID:      c.GetString("user_id"),
+			Timestamp:   deployment.StartedAt,
+		})
+
+		c.JSON(http.StatusAccepted, gin.H{
+			"message":    startMessage(deployment, "Rollback"),
+			"deployId":   deployment.ID,
+			"status":     deployment.Status,
+			"rollbackOf": deployID,
+		})
+	}
+}
+
+// GetBuildPlan clones a git project's repository and returns the Dockerfile
+// it is built with, or the build detected for repositories without one
+func GetBuildPlan(deployer *deploy.Deployer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), buildPlanTimeout)
+		defer cancel()
+
+		dockerfile, plan, err := deployer.BuildPlan(ctx, c.Param("id"))
+		switch {
+		case errors.Is(err, deploy.ErrNotBuilt), errors.Is(err, deploy.ErrNothingToBuild):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		case errors.Is(err, buildpack.ErrNotDetected), errors.Is(err, buildpack.ErrInvalidCommand):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		case errors.Is(err, store.ErrNotFound):
+			respondStoreError(c, err, "Project not found")
+			return
+		case err != nil:
+			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+			return
+		}
+
+		if plan == nil {
+			c.JSON(http.StatusOK, gin.H{"source": "repository", "dockerfile": dockerfile})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"source": "detected", "dockerfile": dockerfile, "plan": plan})
+	}
+}
+
+// CancelDeployment cancels a waiting deployment, or aborts a running one
+// and removes the containers and images it created
+func CancelDeployment(deployer *deploy.Deployer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, deployID := c.Param("id"), c.Param("deployId")
+
+		running, err := deployer.Cancel(id, deployID)
+		switch {
+		case errors.Is(err, deploy.ErrNotCancellable):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		case err != nil:
+			respondStoreError(c, err, "Deployment not found")
+			return
+		}
+
+		addActivity(&models.Activity{
+			ID:          uuid.New().String()[:8],
+			Type:        "deploy",
+			Title:       "Deployment Cancelled",
+			Description: "Deployment " + deployID + " of project " + id + " was cancelled",
+			Status:      "cancelled",
+			ProjectID:   id,
+			UserID:      c.GetString("user_id"),
+			Timestamp:   time.Now(),
+		})
+
+		if running {
+			c.JSON(http.StatusAccepted, gin.H{"message": "Cancelling deployment", "deployId": deployID})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Deployment cancelled", "deployId": deployID})
+	}
+}
+
+// startMessage tells whether a deployment started right away or was queued
+func startMessage(deployment *models.Deployment, what string) string {
+	if deployment.Status == models.DeployStatusQueued {
+		return what + " queued"
+	}
+	return what + " started"
+}
+
+// logMessage is a message of the deployment log socket: "log" carries an
+// entry, "end" the outcome of the deployment
+type logMessage struct {
+	Type string `json:"type"`
+	*deploy.LogEntry
+	Status models.DeployStatus `json:"status,omitempty"`
+}
+
+// DeploymentLogSocket streams a deployment's output over a WebSocket. The
+// output so far is sent first, then each new line, then an "end" message.
+// Deployments that finished a while ago send their stored log.
+func DeploymentLogSocket(deployer *deploy.Deployer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, deployID := c.Param("id"), c.Param("deployId")
+		stream, err := deployer.LogStream(id, deployID)
+		if err != nil {
+			respondStoreError(c, err, "Deployment not found")
+			return
+		}
+
+		conn, err := upgradeWebSocket(c)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// Reading handles control frames and notices the client leaving
+		gone := make(chan struct{})
+		go func() {
+			defer close(gone)
+			for {
+				if _, _, err := conn.NextReader(); err != nil {
+					return
+				}
+			}
+		}()
+
+		end := func(status models.DeployStatus) {
+			conn.WriteJSON(logMessage{Type: "end", Status: status})
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		}
+
+		if stream == nil {
+			deployment, err := deployer.Deployment(id, deployID)
+			if err != nil {
+				return
+			}
+			for _, entry := range deploy.StoredEntries(deployment.Logs) {
+				entry := entry
+				if err := conn.WriteJSON(logMessage{Type: "log", LogEntry: &entry}); err != nil {
+					return
+				}
+			}
+			end(deployment.Status)
+			return
+		}
+
+		backlog, entries, cancel := stream.Subscribe()
+		defer cancel()
+		for i := range backlog {
+			if err := conn.WriteJSON(logMessage{Type: "log", LogEntry: &backlog[i]}); err != nil {
+				return
+			}
+		}
+		for {
+			select {
+			case <-gone:
+				return
+			case entry, ok := <-entries:
+				if !ok {
+					if status := stream.Status(); status != "" {
+						end(status)
+					} else {
+						// Fell behind; the client reconnects and gets the backlog again
+						msg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too far behind")
+						conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+					}
+					return
+				}
+				if err := conn.WriteJSON(logMessage{Type: "log", LogEntry: &entry}); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
@@ -18,6 +18,11 @@ func ListContainers(dockerClient *docker.Client) gin.HandlerFunc {
 		}
 
 		projectID := c.Query("projectId")
+		scope, ok := projectScope(c)
+		if !ok || !checkProjectQuery(c, scope, projectID) {
+			return
+		}
+
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
@@ -27,6 +32,16 @@ func ListContainers(dockerClient *docker.Client) gin.HandlerFunc {
 			return
 		}
 
+		if !scope.All {
+			visible := make([]docker.ContainerInfo, 0, len(containers))
+			for _, item := range containers {
+				if scope.Visible(item.ProjectID) {
+					visible = append(visible, item)
+				}
+			}
+			containers = visible
+		}
+
 		c.JSON(http.StatusOK, containers)
 	}
 }
@@ -190,6 +205,11 @@ func ListImages(dockerClient *docker.Client) gin.HandlerFunc {
 			return
 		}
 
+		scope, ok := projectScope(c)
+		if !ok {
+			return
+		}
+
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
@@ -199,6 +219,16 @@ func ListImages(dockerClient *docker.Client) gin.HandlerFunc {
 			return
 		}
 
+		if !scope.All {
+			visible := make([]docker.ImageInfo, 0, len(images))
+			for _, item := range images {
+				if scope.Visible(item.ProjectID) {
+					visible = append(visible, item)
+				}
+			}
+			images = visible
+		}
+
 		c.JSON(http.StatusOK, images)
 	}
 }
@@ -234,6 +264,11 @@ func ListNetworks(dockerClient *docker.Client) gin.HandlerFunc {
 		}
 
 		projectID := c.Query("projectId")
+		scope, ok := projectScope(c)
+		if !ok || !checkProjectQuery(c, scope, projectID) {
+			return
+		}
+
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
@@ -243,6 +278,16 @@ func ListNetworks(dockerClient *docker.Client) gin.HandlerFunc {
 			return
 		}
 
+		if !scope.All {
+			visible := make([]docker.NetworkInfo, 0, len(networks))
+			for _, item := range networks {
+				if scope.Visible(item.ProjectID) {
+					visible = append(visible, item)
+				}
+			}
+			networks = visible
+		}
+
 		c.JSON(http.StatusOK, networks)
 	}
 }
@@ -264,6 +309,9 @@ func CreateNetwork(dockerClient *docker.Client) gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		if !requireProjectWrite(c, req.ProjectID) {
+			return
+		}
 
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
@@ -308,6 +356,11 @@ func ListVolumes(dockerClient *docker.Client) gin.HandlerFunc {
 		}
 
 		projectID := c.Query("projectId")
+		scope, ok := projectScope(c)
+		if !ok || !checkProjectQuery(c, scope, projectID) {
+			return
+		}
+
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
@@ -317,6 +370,16 @@ func ListVolumes(dockerClient *docker.Client) gin.HandlerFunc {
 			return
 		}
 
+		if !scope.All {
+			visible := make([]docker.VolumeInfo, 0, len(volumes))
+			for _, item := range volumes {
+				if scope.Visible(item.ProjectID) {
+					visible = append(visible, item)
+				}
+			}
+			volumes = visible
+		}
+
 		c.JSON(http.StatusOK, volumes)
 	}
 }
@@ -339,6 +402,9 @@ func CreateVolume(dockerClient *docker.Client) gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		if !requireProjectWrite(c, req.ProjectID) {
+			return
+		}
 
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
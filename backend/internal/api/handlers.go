@@ -1,135 +1,51 @@
 package api
 
 import (
+	"errors"
+	"log"
 	"net/http"
-	"sync"
 	"time"
 
+	"github.com/bizino-services/biz-panel-backend/internal/middleware"
 	"github.com/bizino-services/biz-panel-backend/internal/models"
+	"github.com/bizino-services/biz-panel-backend/internal/store"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
-// In-memory storage (replace with database in production)
-var (
-	websiteStore   = make(map[string]*models.Website)
-	websiteMu      sync.RWMutex
-	databaseStore  = make(map[string]*models.Database)
-	databaseMu     sync.RWMutex
-	cronjobStore   = make(map[string]*models.Cronjob)
-	cronjobMu      sync.RWMutex
-	firewallStore  = make(map[string]*models.FirewallRule)
-	firewallMu     sync.RWMutex
-	activityStore  = make([]*models.Activity, 0)
-	activityMu     sync.RWMutex
-	settingsStore  = &models.Settings{
-		General: models.GeneralSettings{
-			PanelTitle: "Biz-Panel",
-			PanelPort:  5173,
-			Timezone:   "Asia/Ho_Chi_Minh",
-			Language:   "en",
-			DarkMode:   true,
-		},
-		Security: models.SecuritySettings{
-			EnableSSL:         true,
-			SessionTimeout:    30,
-			BruteForceEnabled: true,
-		},
-		Notifications: models.NotificationSettings{
-			NotifyDeploy: true,
-			NotifySSL:    true,
-		},
-		Backup: models.BackupSettings{
-			Enabled:         true,
-			Schedule:        "daily",
-			RetentionDays:   30,
-			BackupDatabases: true,
-			BackupWebsites:  true,
-		},
-	}
-	settingsMu sync.RWMutex
-)
+// Persistent storage (set from main, in-memory until then)
+var dataStore = store.NewMemory()
 
-// Initialize mock data
-func init() {
-	// Add mock websites
-	websiteStore["1"] = &models.Website{
-		ID:           "1",
-		Domain:       "example.com",
-		Aliases:      []string{"www.example.com"},
-		Engine:       models.WebEngineNginx,
-		ProjectType:  "php",
-		PHPVersion:   "8.2",
-		SSL:          models.SSLConfig{Enabled: true, Provider: "letsencrypt", AutoRenew: true},
-		DocumentRoot: "/var/www/example.com",
-		Status:       models.StatusRunning,
-		CreatedAt:    time.Now().Add(-7 * 24 * time.Hour),
-		UpdatedAt:    time.Now(),
-	}
+// SetStore sets the repositories used by the API handlers
+func SetStore(s *store.Store) {
+	dataStore = s
+}
 
-	// Add mock databases
-	databaseStore["1"] = &models.Database{
-		ID:        "1",
-		Name:      "app_production",
-		Engine:    models.DatabasePostgreSQL,
-		Size:      1024 * 1024 * 500, // 500MB
-		Tables:    45,
-		Charset:   "UTF8",
-		CreatedAt: time.Now().Add(-30 * 24 * time.Hour),
-	}
+// IP allowlist fed by SecuritySettings.AllowedIPs (set from main)
+var ipAllowlist *middleware.IPAllowlist
 
-	// Add mock cronjobs
-	cronjobStore["1"] = &models.Cronjob{
-		ID:       "1",
-		Name:     "Database Backup",
-		Schedule: "0 2 * * *",
-		Command:  "/scripts/backup-db.sh",
-		Type:     models.CronjobScript,
-		Enabled:  true,
-		NextRun:  time.Now().Add(8 * time.Hour),
-		CreatedAt: time.Now().Add(-14 * 24 * time.Hour),
-	}
+// SetIPAllowlist sets the allowlist updated when settings are saved
+func SetIPAllowlist(a *middleware.IPAllowlist) {
+	ipAllowlist = a
+}
 
-	// Add mock firewall rules
-	firewallStore["1"] = &models.FirewallRule{
-		ID:          "1",
-		Port:        22,
-		Protocol:    "tcp",
-		Source:      "0.0.0.0/0",
-		Action:      "allow",
-		Description: "SSH Access",
-		Enabled:     true,
-	}
-	firewallStore["2"] = &models.FirewallRule{
-		ID:          "2",
-		Port:        80,
-		Protocol:    "tcp",
-		Source:      "0.0.0.0/0",
-		Action:      "allow",
-		Description: "HTTP",
-		Enabled:     true,
-	}
-	firewallStore["3"] = &models.FirewallRule{
-		ID:          "3",
-		Port:        443,
-		Protocol:    "tcp",
-		Source:      "0.0.0.0/0",
-		Action:      "allow",
-		Description: "HTTPS",
-		Enabled:     true,
+// respondStoreError writes the response for a failed repository call
+func respondStoreError(c *gin.Context, err error, notFoundMsg string) {
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": notFoundMsg})
+		return
 	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 }
 
 // ========== WEBSITES ==========
 
 // ListWebsites returns all websites
 func ListWebsites(c *gin.Context) {
-	websiteMu.RLock()
-	defer websiteMu.RUnlock()
-
-	websites := make([]*models.Website, 0, len(websiteStore))
-	for _, w := range websiteStore {
-		websites = append(websites, w)
+	websites, err := dataStore.Websites.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
 
 	c.JSON(http.StatusOK, websites)
@@ -148,9 +64,10 @@ func CreateWebsite(c *gin.Context) {
 	website.CreatedAt = time.Now()
 	website.UpdatedAt = time.Now()
 
-	websiteMu.Lock()
-	websiteStore[website.ID] = &website
-	websiteMu.Unlock()
+	if err := dataStore.Websites.Save(&website); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
 	addActivity(&models.Activity{
 		ID:          uuid.New().String()[:8],
@@ -168,15 +85,9 @@ func CreateWebsite(c *gin.Context) {
 func DeleteWebsite(c *gin.Context) {
 	id := c.Param("id")
 
-	websiteMu.Lock()
-	website, exists := websiteStore[id]
-	if exists {
-		delete(websiteStore, id)
-	}
-	websiteMu.Unlock()
-
-	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Website not found"})
+	website, err := dataStore.Websites.Delete(id)
+	if err != nil {
+		respondStoreError(c, err, "Website not found")
 		return
 	}
 
@@ -196,12 +107,10 @@ func DeleteWebsite(c *gin.Context) {
 
 // ListDatabases returns all databases
 func ListDatabases(c *gin.Context) {
-	databaseMu.RLock()
-	defer databaseMu.RUnlock()
-
-	databases := make([]*models.Database, 0, len(databaseStore))
-	for _, d := range databaseStore {
-		databases = append(databases, d)
+	databases, err := dataStore.Databases.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
 
 	c.JSON(http.StatusOK, databases)
@@ -218,9 +127,10 @@ func CreateDatabase(c *gin.Context) {
 	db.ID = uuid.New().String()[:8]
 	db.CreatedAt = time.Now()
 
-	databaseMu.Lock()
-	databaseStore[db.ID] = &db
-	databaseMu.Unlock()
+	if err := dataStore.Databases.Save(&db); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
 	addActivity(&models.Activity{
 		ID:          uuid.New().String()[:8],
@@ -238,15 +148,9 @@ func CreateDatabase(c *gin.Context) {
 func DeleteDatabase(c *gin.Context) {
 	id := c.Param("id")
 
-	databaseMu.Lock()
-	db, exists := databaseStore[id]
-	if exists {
-		delete(databaseStore, id)
-	}
-	databaseMu.Unlock()
-
-	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Database not found"})
+	db, err := dataStore.Databases.Delete(id)
+	if err != nil {
+		respondStoreError(c, err, "Database not found")
 		return
 	}
 
@@ -266,12 +170,10 @@ func DeleteDatabase(c *gin.Context) {
 
 // ListCronjobs returns all cronjobs
 func ListCronjobs(c *gin.Context) {
-	cronjobMu.RLock()
-	defer cronjobMu.RUnlock()
-
-	cronjobs := make([]*models.Cronjob, 0, len(cronjobStore))
-	for _, cj := range cronjobStore {
-		cronjobs = append(cronjobs, cj)
+	cronjobs, err := dataStore.Cronjobs.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
 
 	c.JSON(http.StatusOK, cronjobs)
@@ -290,9 +192,10 @@ func CreateCronjob(c *gin.Context) {
 	cj.NextRun = time.Now().Add(time.Hour)
 	cj.CreatedAt = time.Now()
 
-	cronjobMu.Lock()
-	cronjobStore[cj.ID] = &cj
-	cronjobMu.Unlock()
+	if err := dataStore.Cronjobs.Save(&cj); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
 	c.JSON(http.StatusCreated, cj)
 }
@@ -301,32 +204,29 @@ func CreateCronjob(c *gin.Context) {
 func UpdateCronjob(c *gin.Context) {
 	id := c.Param("id")
 
-	cronjobMu.Lock()
-	cj, exists := cronjobStore[id]
-	if !exists {
-		cronjobMu.Unlock()
-		c.JSON(http.StatusNotFound, gin.H{"error": "Cronjob not found"})
-		return
-	}
-
 	var updates models.Cronjob
 	if err := c.ShouldBindJSON(&updates); err != nil {
-		cronjobMu.Unlock()
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	if updates.Name != "" {
-		cj.Name = updates.Name
-	}
-	if updates.Schedule != "" {
-		cj.Schedule = updates.Schedule
-	}
-	if updates.Command != "" {
-		cj.Command = updates.Command
+	cj, err := dataStore.Cronjobs.Update(id, func(cj *models.Cronjob) error {
+		if updates.Name != "" {
+			cj.Name = updates.Name
+		}
+		if updates.Schedule != "" {
+			cj.Schedule = updates.Schedule
+		}
+		if updates.Command != "" {
+			cj.Command = updates.Command
+		}
+		cj.Enabled = updates.Enabled
+		return nil
+	})
+	if err != nil {
+		respondStoreError(c, err, "Cronjob not found")
+		return
 	}
-	cj.Enabled = updates.Enabled
-	cronjobMu.Unlock()
 
 	c.JSON(http.StatusOK, cj)
 }
@@ -335,15 +235,8 @@ func UpdateCronjob(c *gin.Context) {
 func DeleteCronjob(c *gin.Context) {
 	id := c.Param("id")
 
-	cronjobMu.Lock()
-	_, exists := cronjobStore[id]
-	if exists {
-		delete(cronjobStore, id)
-	}
-	cronjobMu.Unlock()
-
-	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Cronjob not found"})
+	if _, err := dataStore.Cronjobs.Delete(id); err != nil {
+		respondStoreError(c, err, "Cronjob not found")
 		return
 	}
 
@@ -354,17 +247,14 @@ func DeleteCronjob(c *gin.Context) {
 func RunCronjob(c *gin.Context) {
 	id := c.Param("id")
 
-	cronjobMu.Lock()
-	cj, exists := cronjobStore[id]
-	if exists {
+	_, err := dataStore.Cronjobs.Update(id, func(cj *models.Cronjob) error {
 		now := time.Now()
 		cj.LastRun = &now
 		cj.LastStatus = "success"
-	}
-	cronjobMu.Unlock()
-
-	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Cronjob not found"})
+		return nil
+	})
+	if err != nil {
+		respondStoreError(c, err, "Cronjob not found")
 		return
 	}
 
@@ -375,12 +265,10 @@ func RunCronjob(c *gin.Context) {
 
 // ListFirewallRules returns all firewall rules
 func ListFirewallRules(c *gin.Context) {
-	firewallMu.RLock()
-	defer firewallMu.RUnlock()
-
-	rules := make([]*models.FirewallRule, 0, len(firewallStore))
-	for _, r := range firewallStore {
-		rules = append(rules, r)
+	rules, err := dataStore.Firewall.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
 
 	c.JSON(http.StatusOK, rules)
@@ -397,9 +285,10 @@ func CreateFirewallRule(c *gin.Context) {
 	rule.ID = uuid.New().String()[:8]
 	rule.Enabled = true
 
-	firewallMu.Lock()
-	firewallStore[rule.ID] = &rule
-	firewallMu.Unlock()
+	if err := dataStore.Firewall.Save(&rule); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
 	c.JSON(http.StatusCreated, rule)
 }
@@ -408,15 +297,8 @@ func CreateFirewallRule(c *gin.Context) {
 func DeleteFirewallRule(c *gin.Context) {
 	id := c.Param("id")
 
-	firewallMu.Lock()
-	_, exists := firewallStore[id]
-	if exists {
-		delete(firewallStore, id)
-	}
-	firewallMu.Unlock()
-
-	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Firewall rule not found"})
+	if _, err := dataStore.Firewall.Delete(id); err != nil {
+		respondStoreError(c, err, "Firewall rule not found")
 		return
 	}
 
@@ -427,9 +309,12 @@ func DeleteFirewallRule(c *gin.Context) {
 
 // GetSettings returns current settings
 func GetSettings(c *gin.Context) {
-	settingsMu.RLock()
-	defer settingsMu.RUnlock()
-	c.JSON(http.StatusOK, settingsStore)
+	settings, err := dataStore.Settings.Get()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, settings)
 }
 
 // UpdateSettings updates settings
@@ -440,9 +325,30 @@ func UpdateSettings(c *gin.Context) {
 		return
 	}
 
-	settingsMu.Lock()
-	settingsStore = &settings
-	settingsMu.Unlock()
+	// Refuse an allowlist that would lock out the admin saving it
+	if ipAllowlist != nil {
+		allowed, err := ipAllowlist.AllowedWithSettings(c.ClientIP(), settings.Security.AllowedIPs)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid allowed IPs: " + err.Error()})
+			return
+		}
+		if !allowed {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":    "The IP allowlist would block your current address",
+				"clientIP": c.ClientIP(),
+			})
+			return
+		}
+	}
+
+	if err := dataStore.Settings.Save(&settings); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if ipAllowlist != nil {
+		ipAllowlist.SetSettingsEntries(settings.Security.AllowedIPs)
+	}
 
 	c.JSON(http.StatusOK, settings)
 }
@@ -451,27 +357,19 @@ func UpdateSettings(c *gin.Context) {
 
 // ListActivities returns recent activities
 func ListActivities(c *gin.Context) {
-	activityMu.RLock()
-	defer activityMu.RUnlock()
-
 	// Return last 50 activities
-	start := 0
-	if len(activityStore) > 50 {
-		start = len(activityStore) - 50
+	activities, err := dataStore.Activities.Recent(50)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
 
-	c.JSON(http.StatusOK, activityStore[start:])
+	c.JSON(http.StatusOK, activities)
 }
 
 // addActivity adds an activity to the log
 func addActivity(activity *models.Activity) {
-	activityMu.Lock()
-	defer activityMu.Unlock()
-
-	activityStore = append(activityStore, activity)
-
-	// Keep only last 100 activities
-	if len(activityStore) > 100 {
-		activityStore = activityStore[len(activityStore)-100:]
+	if err := dataStore.Activities.Append(activity); err != nil {
+		log.Printf("Warning: failed to record activity %q: %v", activity.Title, err)
 	}
 }
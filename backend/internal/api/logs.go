@@ -3,7 +3,6 @@ package api
 import (
 	"bufio"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
 	"os/exec"
@@ -272,7 +271,7 @@ func StreamLogs(c *gin.Context) {
 	}
 
 	// Upgrade to WebSocket
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	conn, err := upgradeWebSocket(c)
 	if err != nil {
 		return
 	}
@@ -291,18 +290,27 @@ func StreamLogs(c *gin.Context) {
 		return
 	}
 
-	defer cmd.Process.Kill()
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	// Stop tailing once the client disconnects or the socket is closed on expiry
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				cmd.Process.Kill()
+				return
+			}
+		}
+	}()
 
-	// Read and stream lines
+	// Read and stream lines until tail exits
 	reader := bufio.NewReader(stdout)
 	for {
 		line, err := reader.ReadString('\n')
 		if err != nil {
-			if err != io.EOF {
-				break
-			}
-			time.Sleep(100 * time.Millisecond)
-			continue
+			break
 		}
 
 		entry := LogEntry{
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/bizino-services/biz-panel-backend/internal/deploy"
+	"github.com/bizino-services/biz-panel-backend/internal/models"
+	"github.com/bizino-services/biz-panel-backend/internal/secrets"
+	"github.com/bizino-services/biz-panel-backend/internal/store"
+	"github.com/bizino-services/biz-panel-backend/internal/webhook"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ListPreviews returns a project's preview environments with their status
+func ListPreviews(deployer *deploy.Deployer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		if _, err := dataStore.Projects.Get(id); err != nil {
+			respondStoreError(c, err, "Project not found")
+			return
+		}
+		previews, err := deployer.Previews(id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for _, p := range previews {
+			secrets.MaskProject(p)
+		}
+		c.JSON(http.StatusOK, previews)
+	}
+}
+
+// DeletePreview removes one of a project's preview environments before its
+// pull request is closed
+func DeletePreview(deployer *deploy.Deployer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		preview, err := dataStore.Projects.Get(c.Param("previewId"))
+		if err == nil && preview.ParentID != c.Param("id") {
+			err = store.ErrNotFound
+		}
+		if err != nil {
+			respondStoreError(c, err, "Preview not found")
+			return
+		}
+		removePreview(c, deployer, preview)
+	}
+}
+
+// removePreview destroys a preview environment and responds
+func removePreview(c *gin.Context, deployer *deploy.Deployer, preview *models.Project) {
+	removed, err := deployer.RemovePreview(preview.ID)
+	if err != nil {
+		respondStoreError(c, err, "Preview not found")
+		return
+	}
+	if !removed {
+		c.JSON(http.StatusAccepted, gin.H{"message": "Preview deployment is being cancelled; the preview is removed once it stopped", "id": preview.ID})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Preview removed", "id": preview.ID})
+}
+
+// previewWebhook deploys the pull request of a webhook to its preview
+// environment, or removes that once the pull request is closed
+func previewWebhook(deployer *deploy.Deployer, project *models.Project, delivery *models.WebhookDelivery, body []byte, respond func(int, models.WebhookStatus, string)) {
+	pr, err := webhook.ParsePullRequest(delivery.Provider, body)
+	if err != nil {
+		respond(http.StatusBadRequest, models.WebhookStatusRejected, "malformed pull request event: "+err.Error())
+		return
+	}
+	delivery.Ref, delivery.CommitSHA = "refs/heads/"+pr.Branch, pr.SHA
+	delivery.Author, delivery.Message = pr.Author, pr.Message
+
+	repo := project.Repository
+	branch := pr.DefaultBranch
+	if repo != nil && repo.Branch != "" {
+		branch = repo.Branch
+	}
+	switch {
+	case (project.Type != models.ProjectTypeGit && project.Type != models.ProjectTypeStatic) || repo == nil:
+		respond(http.StatusOK, models.WebhookStatusIgnored, "project is not deployed from git")
+		return
+	case project.Previews == nil || !project.Previews.Enabled:
+		respond(http.StatusOK, models.WebhookStatusIgnored, "preview environments are disabled")
+		return
+	case pr.Base != branch:
+		respond(http.StatusOK, models.WebhookStatusIgnored, fmt.Sprintf("pull request into %s; the project deploys %s", pr.Base, branch))
+		return
+	case pr.Fork:
+		respond(http.StatusOK, models.WebhookStatusIgnored, "pull requests from forks are not deployed")
+		return
+	}
+
+	if pr.Closed {
+		preview, removed, err := deployer.ClosePreview(project.ID, pr.Number)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			respond(http.StatusOK, models.WebhookStatusIgnored, fmt.Sprintf("pull request #%d has no preview environment", pr.Number))
+		case err != nil:
+			respond(http.StatusInternalServerError, models.WebhookStatusFailed, err.Error())
+		case !removed:
+			respond(http.StatusAccepted, models.WebhookStatusRemoved, "removed once its deployment stopped")
+		default:
+			respond(http.StatusOK, models.WebhookStatusRemoved, preview.Domain)
+		}
+		return
+	}
+
+	info := models.PreviewInfo{Number: pr.Number, Title: pr.Title, URL: pr.URL, Branch: pr.Branch}
+	commit := deploy.Commit{SHA: pr.SHA, Author: pr.Author, Message: pr.Message}
+	preview, deployment, err := deployer.Preview(project.ID, info, commit)
+	if err != nil {
+		code := http.StatusInternalServerError
+		status := models.WebhookStatusFailed
+		switch {
+		case errors.Is(err, deploy.ErrPreviewsDisabled), errors.Is(err, deploy.ErrPreviewUnsupported):
+			code, status = http.StatusOK, models.WebhookStatusIgnored
+		case errors.Is(err, deploy.ErrNoDocker):
+			code = http.StatusServiceUnavailable
+		case errors.Is(err, deploy.ErrNothingToBuild):
+			code = http.StatusBadRequest
+		}
+		respond(code, status, err.Error())
+		return
+	}
+	if deployment == nil {
+		respond(http.StatusOK, models.WebhookStatusIgnored, fmt.Sprintf("commit %s is already deployed to %s", shortCommit(pr.SHA), preview.Domain))
+		return
+	}
+	delivery.DeployID = deployment.ID
+
+	addActivity(&models.Activity{
+		ID:          uuid.New().String()[:8],
+		Type:        "deploy",
+		Title:       "Preview Deployment Started",
+		Description: fmt.Sprintf("Pull request #%d triggered a deployment of %s to %s", pr.Number, shortCommit(pr.SHA), preview.Domain),
+		Status:      "pending",
+		ProjectID:   project.ID,
+		Timestamp:   deployment.StartedAt,
+	})
+	reason := preview.Domain
+	if deployment.Status == models.DeployStatusQueued {
+		reason += " (queued)"
+	}
+	respond(http.StatusAccepted, models.WebhookStatusDeployed, reason)
+}
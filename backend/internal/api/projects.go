@@ -2,23 +2,20 @@ package api
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
-	"sync"
 	"time"
 
+	"github.com/bizino-services/biz-panel-backend/internal/auth"
+	"github.com/bizino-services/biz-panel-backend/internal/deploy"
 	"github.com/bizino-services/biz-panel-backend/internal/docker"
 	"github.com/bizino-services/biz-panel-backend/internal/models"
+	"github.com/bizino-services/biz-panel-backend/internal/secrets"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
-// In-memory storage (replace with database in production)
-var (
-	projectStore = make(map[string]*models.Project)
-	projectMu    sync.RWMutex
-)
-
 // Global Docker client (set from main)
 var dockerClientGlobal *docker.Client
 
@@ -27,14 +24,29 @@ func SetDockerClient(client *docker.Client) {
 	dockerClientGlobal = client
 }
 
-// ListProjects returns all projects
+// ListProjects returns the projects the caller can see
 func ListProjects(c *gin.Context) {
-	projectMu.RLock()
-	defer projectMu.RUnlock()
+	scope, ok := projectScope(c)
+	if !ok {
+		return
+	}
+
+	projects, err := dataStore.Projects.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
-	projects := make([]*models.Project, 0, len(projectStore))
-	for _, p := range projectStore {
-		projects = append(projects, p)
+	// Preview environments are listed under their parent project
+	visible := make([]*models.Project, 0, len(projects))
+	for _, p := range projects {
+		if p.ParentID == "" && (scope.All || scope.Visible(p.ID)) {
+			visible = append(visible, p)
+		}
+	}
+	projects = visible
+	for _, p := range projects {
+		secrets.MaskProject(p)
 	}
 
 	c.JSON(http.StatusOK, projects)
@@ -44,12 +56,9 @@ func ListProjects(c *gin.Context) {
 func GetProject(c *gin.Context) {
 	id := c.Param("id")
 
-	projectMu.RLock()
-	project, exists := projectStore[id]
-	projectMu.RUnlock()
-
-	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+	project, err := dataStore.Projects.Get(id)
+	if err != nil {
+		respondStoreError(c, err, "Project not found")
 		return
 	}
 
@@ -69,6 +78,7 @@ func GetProject(c *gin.Context) {
 		}
 	}
 
+	secrets.MaskProject(project)
 	c.JSON(http.StatusOK, project)
 }
 
@@ -96,14 +106,23 @@ func CreateProject(c *gin.Context) {
 		Status:      models.ProjectStatusIdle,
 		Repository:  req.Repository,
 		Docker:      req.Docker,
+		Static:      req.Static,
 		Environment: req.Environment,
+		Secrets:     req.Secrets,
 		Domain:      req.Domain,
 		SSL:         req.SSL,
+		Strategy:    req.Strategy,
+		HealthCheck: req.HealthCheck,
 		Resources:   req.Resources,
+		Previews:    req.Previews,
 		Containers:  []string{},
 		CreatedAt:   now,
 		UpdatedAt:   now,
 	}
+	if err := deploy.ValidateStrategy(project); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	// Create isolated Docker network for this project (like Coolify)
 	networkName := fmt.Sprintf("biz-panel-%s", project.ID)
@@ -123,9 +142,25 @@ func CreateProject(c *gin.Context) {
 		}
 	}
 
-	projectMu.Lock()
-	projectStore[project.ID] = project
-	projectMu.Unlock()
+	// A key rotation waits for the project, so it can't retire the key its
+	// secrets were sealed with before they are saved
+	err := keyring.Hold(func() error {
+		if err := keyring.Seal(project); err != nil {
+			return err
+		}
+		return dataStore.Projects.Save(project)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Callers limited to their memberships maintain the projects they create
+	if scope, err := auth.CallerProjectScope(c); err == nil && !scope.All {
+		if _, err := auth.GrantProjectRole(project.ID, c.GetString("user_id"), auth.ProjectRoleMaintainer, c.GetString("username")); err != nil {
+			fmt.Printf("Warning: Failed to add creator to project %s: %v\n", project.ID, err)
+		}
+	}
 
 	// Log activity
 	addActivity(&models.Activity{
@@ -138,6 +173,7 @@ func CreateProject(c *gin.Context) {
 		Timestamp:   now,
 	})
 
+	secrets.MaskProject(project)
 	c.JSON(http.StatusCreated, project)
 }
 
@@ -145,22 +181,37 @@ func CreateProject(c *gin.Context) {
 func UpdateProject(c *gin.Context) {
 	id := c.Param("id")
 
-	projectMu.RLock()
-	project, exists := projectStore[id]
-	projectMu.RUnlock()
-
-	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+	var req models.UpdateProjectRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	var req models.UpdateProjectRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	project, err := dataStore.Projects.Update(id, func(project *models.Project) error {
+		stored := *project
+		applyProjectUpdate(project, req)
+		// Secrets are sent back masked unless they were changed
+		secrets.KeepMasked(project, &stored)
+		if err := deploy.ValidateStrategy(project); err != nil {
+			return err
+		}
+		return keyring.Seal(project)
+	})
+	if errors.Is(err, deploy.ErrInvalidStrategy) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if err != nil {
+		respondStoreError(c, err, "Project not found")
+		return
+	}
 
-	// Update fields
+	secrets.MaskProject(project)
+	c.JSON(http.StatusOK, project)
+}
+
+// applyProjectUpdate copies the set fields of an update request onto a project
+func applyProjectUpdate(project *models.Project, req models.UpdateProjectRequest) {
 	if req.Name != "" {
 		project.Name = req.Name
 	}
@@ -173,62 +224,89 @@ func UpdateProject(c *gin.Context) {
 	if req.Docker != nil {
 		project.Docker = req.Docker
 	}
+	if req.Static != nil {
+		project.Static = req.Static
+	}
 	if req.Environment != nil {
 		project.Environment = req.Environment
 	}
+	if req.Secrets != nil {
+		project.Secrets = req.Secrets
+	}
 	if req.Domain != "" {
 		project.Domain = req.Domain
 	}
 	project.SSL = req.SSL
+	if req.Strategy != "" {
+		project.Strategy = req.Strategy
+	}
+	if req.HealthCheck != nil {
+		project.HealthCheck = req.HealthCheck
+	}
+	if req.Previews != nil {
+		project.Previews = req.Previews
+	}
 	project.Resources = req.Resources
 	project.UpdatedAt = time.Now()
-
-	projectMu.Lock()
-	projectStore[id] = project
-	projectMu.Unlock()
-
-	c.JSON(http.StatusOK, project)
 }
 
-// DeleteProject deletes a project and its network
-func DeleteProject(c *gin.Context) {
-	id := c.Param("id")
-
-	projectMu.Lock()
-	project, exists := projectStore[id]
-	if exists {
-		delete(projectStore, id)
-	}
-	projectMu.Unlock()
-
-	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
-		return
-	}
+// DeleteProject deletes a project, its network and its nginx site
+func DeleteProject(deployer *deploy.Deployer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
 
-	// Delete the project's Docker network
-	if dockerClientGlobal != nil && project.NetworkID != "" {
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
+		// Preview environments are removed with their containers
+		if existing, err := dataStore.Projects.Get(id); err == nil && existing.ParentID != "" {
+			removePreview(c, deployer, existing)
+			return
+		}
 
-		err := dockerClientGlobal.RemoveNetwork(ctx, project.NetworkID)
+		project, err := dataStore.Projects.Delete(id)
 		if err != nil {
-			fmt.Printf("Warning: Failed to remove network %s: %v\n", project.NetworkID, err)
+			respondStoreError(c, err, "Project not found")
+			return
+		}
+		auth.RemoveProjectMembers(id)
+		if deployments, err := dataStore.Deployments.ListByProject(id); err == nil {
+			for _, d := range deployments {
+				dataStore.Deployments.Delete(d.ID)
+			}
+		}
+		dataStore.Webhooks.Delete(id)
+		if deliveries, err := dataStore.Deliveries.ListByProject(id); err == nil {
+			for _, d := range deliveries {
+				dataStore.Deliveries.Delete(d.ID)
+			}
 		}
-	}
 
-	// Log activity
-	addActivity(&models.Activity{
-		ID:          uuid.New().String()[:8],
-		Type:        "delete",
-		Title:       "Project Deleted",
-		Description: "Project '" + project.Name + "' was deleted",
-		Status:      "success",
-		ProjectID:   id,
-		Timestamp:   time.Now(),
-	})
+		// Delete the project's Docker network
+		if dockerClientGlobal != nil && project.NetworkID != "" {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
 
-	c.JSON(http.StatusOK, gin.H{"message": "Project deleted", "id": id})
+			err := dockerClientGlobal.RemoveNetwork(ctx, project.NetworkID)
+			if err != nil {
+				fmt.Printf("Warning: Failed to remove network %s: %v\n", project.NetworkID, err)
+			}
+		}
+		if err := deployer.RemoveSite(id); err != nil {
+			fmt.Printf("Warning: Failed to remove site of project %s: %v\n", id, err)
+		}
+		deployer.RemovePreviews(id)
+
+		// Log activity
+		addActivity(&models.Activity{
+			ID:          uuid.New().String()[:8],
+			Type:        "delete",
+			Title:       "Project Deleted",
+			Description: "Project '" + project.Name + "' was deleted",
+			Status:      "success",
+			ProjectID:   id,
+			Timestamp:   time.Now(),
+		})
+
+		c.JSON(http.StatusOK, gin.H{"message": "Project deleted", "id": id})
+	}
 }
 
 // GetProjectContainers returns containers for a specific project
@@ -237,12 +315,8 @@ func GetProjectContainers(dockerClient *docker.Client) gin.HandlerFunc {
 		projectID := c.Param("id")
 
 		// Check project exists
-		projectMu.RLock()
-		_, exists := projectStore[projectID]
-		projectMu.RUnlock()
-
-		if !exists {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		if _, err := dataStore.Projects.Get(projectID); err != nil {
+			respondStoreError(c, err, "Project not found")
 			return
 		}
 
@@ -280,12 +354,9 @@ func AddContainerToProject(dockerClient *docker.Client) gin.HandlerFunc {
 		}
 
 		// Check project exists
-		projectMu.RLock()
-		project, exists := projectStore[projectID]
-		projectMu.RUnlock()
-
-		if !exists {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		project, err := dataStore.Projects.Get(projectID)
+		if err != nil {
+			respondStoreError(c, err, "Project not found")
 			return
 		}
 
@@ -298,16 +369,33 @@ func AddContainerToProject(dockerClient *docker.Client) gin.HandlerFunc {
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
-		err := dockerClient.ConnectContainerToNetwork(ctx, req.ContainerID, project.NetworkID)
+		// Members may only attach containers that already belong to the project
+		scope, ok := projectScope(c)
+		if !ok {
+			return
+		}
+		if !scope.All {
+			if owner, err := dockerClient.ContainerProjectID(ctx, req.ContainerID); err != nil || owner != projectID {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Container not found"})
+				return
+			}
+		}
+
+		err = dockerClient.ConnectContainerToNetwork(ctx, req.ContainerID, project.NetworkID)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 
 		// Update project's container list
-		projectMu.Lock()
-		project.Containers = append(project.Containers, req.ContainerID)
-		projectMu.Unlock()
+		_, err = dataStore.Projects.Update(projectID, func(p *models.Project) error {
+			p.Containers = append(p.Containers, req.ContainerID)
+			return nil
+		})
+		if err != nil {
+			respondStoreError(c, err, "Project not found")
+			return
+		}
 
 		c.JSON(http.StatusOK, gin.H{
 			"message":     "Container added to project",
@@ -318,84 +406,58 @@ func AddContainerToProject(dockerClient *docker.Client) gin.HandlerFunc {
 	}
 }
 
-// DeployProject triggers a deployment
-func DeployProject(c *gin.Context) {
-	id := c.Param("id")
-
-	projectMu.Lock()
-	project, exists := projectStore[id]
-	if !exists {
-		projectMu.Unlock()
-		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
-		return
-	}
+// DeployProject queues a deployment: git projects are cloned and built,
+// others run their image, on the project's isolated network
+func DeployProject(deployer *deploy.Deployer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		project, err := dataStore.Projects.Get(id)
+		if err != nil {
+			respondStoreError(c, err, "Project not found")
+			return
+		}
 
-	// Update status
-	project.Status = models.ProjectStatusDeploying
-	now := time.Now()
-	project.LastDeploy = &models.DeployInfo{
-		ID:        uuid.New().String()[:8],
-		Status:    models.DeployStatusBuilding,
-		StartedAt: now,
-		Logs:      []string{"Starting deployment...", fmt.Sprintf("Using network: %s", project.NetworkID)},
-	}
-	project.UpdatedAt = now
-	projectMu.Unlock()
-
-	// Simulate async deployment
-	go func() {
-		time.Sleep(2 * time.Second)
-
-		projectMu.Lock()
-		defer projectMu.Unlock()
-
-		if p, exists := projectStore[id]; exists {
-			finishedAt := time.Now()
-			p.Status = models.ProjectStatusRunning
-			p.LastDeploy.Status = models.DeployStatusSuccess
-			p.LastDeploy.FinishedAt = &finishedAt
-			p.LastDeploy.Duration = int64(finishedAt.Sub(p.LastDeploy.StartedAt).Seconds())
-			p.LastDeploy.Logs = append(p.LastDeploy.Logs,
-				"Building image...",
-				"Pulling base image...",
-				"Installing dependencies...",
-				fmt.Sprintf("Connecting to network: %s", p.NetworkID),
-				"Building application...",
-				"Creating container...",
-				"Starting container...",
-				"✓ Deployment successful!",
-			)
+		deployment, err := deployer.Start(id, c.GetString("user_id"))
+		switch {
+		case errors.Is(err, deploy.ErrNoDocker):
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Docker not available"})
+			return
+		case errors.Is(err, deploy.ErrNothingToBuild):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Project needs a repository URL (git) or an image (docker) to deploy"})
+			return
+		case err != nil:
+			respondStoreError(c, err, "Project not found")
+			return
 		}
-	}()
 
-	// Log activity
-	addActivity(&models.Activity{
-		ID:          uuid.New().String()[:8],
-		Type:        "deploy",
-		Title:       "Deployment Started",
-		Description: "Deployment started for '" + project.Name + "'",
-		Status:      "pending",
-		ProjectID:   id,
-		Timestamp:   now,
-	})
+		// Log activity
+		addActivity(&models.Activity{
+			ID:          uuid.New().String()[:8],
+			Type:        "deploy",
+			Title:       "Deployment Started",
+			Description: startMessage(deployment, "Deployment") + " for '" + project.Name + "'",
+			Status:      "pending",
+			ProjectID:   id,
+			UserID:      c.GetString("user_id"),
+			Timestamp:   deployment.StartedAt,
+		})
 
-	c.JSON(http.StatusOK, gin.H{
-		"message":  "Deployment started",
-		"deployId": project.LastDeploy.ID,
-		"network":  project.NetworkID,
-	})
+		c.JSON(http.StatusAccepted, gin.H{
+			"message":  startMessage(deployment, "Deployment"),
+			"deployId": deployment.ID,
+			"status":   deployment.Status,
+			"network":  project.NetworkID,
+		})
+	}
 }
 
 // GetProjectLogs returns project deployment logs
 func GetProjectLogs(c *gin.Context) {
 	id := c.Param("id")
 
-	projectMu.RLock()
-	project, exists := projectStore[id]
-	projectMu.RUnlock()
-
-	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+	project, err := dataStore.Projects.Get(id)
+	if err != nil {
+		respondStoreError(c, err, "Project not found")
 		return
 	}
 
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/bizino-services/biz-panel-backend/internal/auth"
+	"github.com/bizino-services/biz-panel-backend/internal/docker"
+	"github.com/gin-gonic/gin"
+)
+
+// ContainerProject resolves the :id container to its biz-panel.project label
+func ContainerProject(dockerClient *docker.Client) auth.ProjectLookup {
+	return labelLookup(dockerClient, "id", (*docker.Client).ContainerProjectID)
+}
+
+// ImageProject resolves the :id image to its biz-panel.project label
+func ImageProject(dockerClient *docker.Client) auth.ProjectLookup {
+	return labelLookup(dockerClient, "id", (*docker.Client).ImageProjectID)
+}
+
+// NetworkProject resolves the :id network to its biz-panel.project label
+func NetworkProject(dockerClient *docker.Client) auth.ProjectLookup {
+	return labelLookup(dockerClient, "id", (*docker.Client).NetworkProjectID)
+}
+
+// VolumeProject resolves the :name volume to its biz-panel.project label
+func VolumeProject(dockerClient *docker.Client) auth.ProjectLookup {
+	return labelLookup(dockerClient, "name", (*docker.Client).VolumeProjectID)
+}
+
+func labelLookup(dockerClient *docker.Client, param string, get func(*docker.Client, context.Context, string) (string, error)) auth.ProjectLookup {
+	return func(c *gin.Context) (string, error) {
+		if dockerClient == nil {
+			return "", nil
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return get(dockerClient, ctx, c.Param(param))
+	}
+}
+
+// projectScope returns the caller's project scope, answering the request
+// itself when it cannot be loaded
+func projectScope(c *gin.Context) (auth.ProjectScope, bool) {
+	scope, err := auth.CallerProjectScope(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return scope, false
+	}
+	return scope, true
+}
+
+// checkProjectQuery denies a ?projectId filter naming a project the caller
+// cannot see
+func checkProjectQuery(c *gin.Context, scope auth.ProjectScope, projectID string) bool {
+	if projectID != "" && !scope.Visible(projectID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return false
+	}
+	return true
+}
+
+// requireProjectWrite checks the caller may create resources in projectID.
+// Callers limited to their memberships must name a project.
+func requireProjectWrite(c *gin.Context, projectID string) bool {
+	scope, ok := projectScope(c)
+	if !ok {
+		return false
+	}
+	if scope.All {
+		return true
+	}
+	if projectID == "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "projectId is required"})
+		return false
+	}
+	if !checkProjectQuery(c, scope, projectID) {
+		return false
+	}
+	if !scope.Allows(projectID, auth.ActionWrite) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient project permissions"})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bizino-services/biz-panel-backend/internal/auth"
+	"github.com/bizino-services/biz-panel-backend/internal/models"
+	"github.com/bizino-services/biz-panel-backend/internal/secrets"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Master keys that encrypt project secrets (set from main)
+var keyring *secrets.Keyring
+
+// SetKeyring sets the keyring project secrets are sealed with
+func SetKeyring(k *secrets.Keyring) {
+	keyring = k
+}
+
+// RevealProjectSecrets returns the plaintext of a project's secrets after
+// the caller confirms their password
+func RevealProjectSecrets(c *gin.Context) {
+	var req auth.ReauthRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Password is required"})
+		return
+	}
+	if !auth.Reauthenticate(c, req) {
+		return
+	}
+
+	id := c.Param("id")
+	project, err := dataStore.Projects.Get(id)
+	if err != nil {
+		respondStoreError(c, err, "Project not found")
+		return
+	}
+	env, privateKey, err := keyring.Reveal(project)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	addActivity(&models.Activity{
+		ID:          uuid.New().String()[:8],
+		Type:        "security",
+		Title:       "Secrets Revealed",
+		Description: fmt.Sprintf("%s revealed the secrets of '%s'", c.GetString("username"), project.Name),
+		Status:      "success",
+		ProjectID:   id,
+		UserID:      c.GetString("user_id"),
+		Timestamp:   time.Now(),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"environment": env, "privateKey": privateKey})
+}
+
+// RotateMasterKey encrypts every project secret with a new master key and
+// removes the old one, after the caller confirms their password
+func RotateMasterKey(c *gin.Context) {
+	var req auth.ReauthRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Password is required"})
+		return
+	}
+	if !auth.Reauthenticate(c, req) {
+		return
+	}
+
+	count, err := keyring.Rotate(dataStore.Projects)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":       "Rotation incomplete; the old master key is kept until it succeeds: " + err.Error(),
+			"reencrypted": count,
+		})
+		return
+	}
+
+	addActivity(&models.Activity{
+		ID:          uuid.New().String()[:8],
+		Type:        "security",
+		Title:       "Master Key Rotated",
+		Description: fmt.Sprintf("%d project secrets were encrypted with the new master key %s", count, keyring.KeyID()),
+		Status:      "success",
+		UserID:      c.GetString("user_id"),
+		Timestamp:   time.Now(),
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Master key rotated",
+		"keyId":       keyring.KeyID(),
+		"reencrypted": count,
+	})
+}
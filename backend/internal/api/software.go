@@ -5,9 +5,9 @@ import (
 	"net/http"
 	"os/exec"
 	"strings"
-	"sync"
 	"time"
 
+	"github.com/bizino-services/biz-panel-backend/internal/models"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
@@ -26,21 +26,6 @@ type SoftwareItem struct {
 	AvailableVersions []string `json:"availableVersions"`
 }
 
-// InstallStatus tracks installation progress
-type InstallStatus struct {
-	ID        string    `json:"id"`
-	Software  string    `json:"software"`
-	Status    string    `json:"status"` // pending, installing, success, failed
-	Progress  int       `json:"progress"`
-	Message   string    `json:"message"`
-	StartedAt time.Time `json:"startedAt"`
-}
-
-var (
-	installJobs   = make(map[string]*InstallStatus)
-	installJobsMu sync.RWMutex
-)
-
 // ListSoftware returns available software packages
 func ListSoftware(c *gin.Context) {
 	category := c.Query("category")
@@ -96,7 +81,7 @@ func InstallSoftware(c *gin.Context) {
 
 	// Create install job
 	jobID := uuid.New().String()[:8]
-	job := &InstallStatus{
+	job := &models.InstallJob{
 		ID:        jobID,
 		Software:  id,
 		Status:    "installing",
@@ -105,9 +90,10 @@ func InstallSoftware(c *gin.Context) {
 		StartedAt: time.Now(),
 	}
 
-	installJobsMu.Lock()
-	installJobs[jobID] = job
-	installJobsMu.Unlock()
+	if err := dataStore.InstallJobs.Save(job); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
 	// Run installation in background
 	go func() {
@@ -147,15 +133,13 @@ func InstallSoftware(c *gin.Context) {
 		default:
 			job.Status = "failed"
 			job.Message = "Unknown software: " + id
+			dataStore.InstallJobs.Save(job)
 			return
 		}
 
 		// Run command
 		output, err := cmd.CombinedOutput()
 
-		installJobsMu.Lock()
-		defer installJobsMu.Unlock()
-
 		if err != nil {
 			job.Status = "failed"
 			job.Message = fmt.Sprintf("Installation failed: %s", string(output))
@@ -164,6 +148,7 @@ func InstallSoftware(c *gin.Context) {
 			job.Progress = 100
 			job.Message = fmt.Sprintf("%s installed successfully", id)
 		}
+		dataStore.InstallJobs.Save(job)
 	}()
 
 	c.JSON(http.StatusAccepted, gin.H{
@@ -231,12 +216,9 @@ func UninstallSoftware(c *gin.Context) {
 func GetInstallStatus(c *gin.Context) {
 	jobID := c.Param("jobId")
 
-	installJobsMu.RLock()
-	job, exists := installJobs[jobID]
-	installJobsMu.RUnlock()
-
-	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+	job, err := dataStore.InstallJobs.Get(jobID)
+	if err != nil {
+		respondStoreError(c, err, "Job not found")
 		return
 	}
 
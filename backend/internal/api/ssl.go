@@ -9,7 +9,6 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/bizino-services/biz-panel-backend/internal/models"
@@ -17,37 +16,19 @@ import (
 	"github.com/google/uuid"
 )
 
-// SSLCertificate represents an SSL certificate
-type SSLCertificate struct {
-	ID          string    `json:"id"`
-	Domain      string    `json:"domain"`
-	Issuer      string    `json:"issuer"`
-	Provider    string    `json:"provider"` // letsencrypt, custom, self-signed
-	ExpiresAt   time.Time `json:"expiresAt"`
-	IssuedAt    time.Time `json:"issuedAt"`
-	AutoRenew   bool      `json:"autoRenew"`
-	Status      string    `json:"status"` // valid, expired, pending, error
-	CertPath    string    `json:"certPath"`
-	KeyPath     string    `json:"keyPath"`
-	LastChecked time.Time `json:"lastChecked"`
-}
-
-// Storage for certificates
-var (
-	sslCertificates = make(map[string]*SSLCertificate)
-	sslMu           sync.RWMutex
-)
-
 // Certificate base path
 const certBasePath = "/etc/biz-panel/certs"
 
 // ListSSLCertificates returns all SSL certificates
 func ListSSLCertificates(c *gin.Context) {
-	sslMu.RLock()
-	defer sslMu.RUnlock()
+	stored, err := dataStore.Certificates.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
-	certs := make([]*SSLCertificate, 0, len(sslCertificates))
-	for _, cert := range sslCertificates {
+	certs := make([]*models.SSLCertificate, 0, len(stored))
+	for _, cert := range stored {
 		// Check expiry status
 		if time.Now().After(cert.ExpiresAt) {
 			cert.Status = "expired"
@@ -66,12 +47,9 @@ func ListSSLCertificates(c *gin.Context) {
 func GetSSLCertificate(c *gin.Context) {
 	id := c.Param("id")
 
-	sslMu.RLock()
-	cert, exists := sslCertificates[id]
-	sslMu.RUnlock()
-
-	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "certificate not found"})
+	cert, err := dataStore.Certificates.Get(id)
+	if err != nil {
+		respondStoreError(c, err, "certificate not found")
 		return
 	}
 
@@ -148,7 +126,7 @@ func RequestLetsEncryptCertificate(c *gin.Context) {
 	}
 
 	// Store certificate
-	cert := &SSLCertificate{
+	cert := &models.SSLCertificate{
 		ID:          certID,
 		Domain:      req.Domain,
 		Issuer:      "Let's Encrypt",
@@ -162,9 +140,10 @@ func RequestLetsEncryptCertificate(c *gin.Context) {
 		LastChecked: time.Now(),
 	}
 
-	sslMu.Lock()
-	sslCertificates[certID] = cert
-	sslMu.Unlock()
+	if err := dataStore.Certificates.Save(cert); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
 	// Log activity
 	addActivity(&models.Activity{
@@ -186,12 +165,9 @@ func RequestLetsEncryptCertificate(c *gin.Context) {
 func RenewSSLCertificate(c *gin.Context) {
 	id := c.Param("id")
 
-	sslMu.RLock()
-	cert, exists := sslCertificates[id]
-	sslMu.RUnlock()
-
-	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "certificate not found"})
+	cert, err := dataStore.Certificates.Get(id)
+	if err != nil {
+		respondStoreError(c, err, "certificate not found")
 		return
 	}
 
@@ -217,9 +193,10 @@ func RenewSSLCertificate(c *gin.Context) {
 	cert.LastChecked = time.Now()
 	cert.Status = "valid"
 
-	sslMu.Lock()
-	sslCertificates[id] = cert
-	sslMu.Unlock()
+	if err := dataStore.Certificates.Save(cert); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":   "Certificate renewed",
@@ -270,7 +247,7 @@ func UploadSSLCertificate(c *gin.Context) {
 
 	// Store certificate
 	certID := uuid.New().String()[:8]
-	cert := &SSLCertificate{
+	cert := &models.SSLCertificate{
 		ID:          certID,
 		Domain:      req.Domain,
 		Issuer:      issuer,
@@ -284,9 +261,10 @@ func UploadSSLCertificate(c *gin.Context) {
 		LastChecked: time.Now(),
 	}
 
-	sslMu.Lock()
-	sslCertificates[certID] = cert
-	sslMu.Unlock()
+	if err := dataStore.Certificates.Save(cert); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "Certificate uploaded",
@@ -298,15 +276,9 @@ func UploadSSLCertificate(c *gin.Context) {
 func DeleteSSLCertificate(c *gin.Context) {
 	id := c.Param("id")
 
-	sslMu.Lock()
-	cert, exists := sslCertificates[id]
-	if exists {
-		delete(sslCertificates, id)
-	}
-	sslMu.Unlock()
-
-	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "certificate not found"})
+	cert, err := dataStore.Certificates.Delete(id)
+	if err != nil {
+		respondStoreError(c, err, "certificate not found")
 		return
 	}
 
@@ -324,15 +296,14 @@ func DeleteSSLCertificate(c *gin.Context) {
 
 // CheckSSLExpiry checks all certificates for expiry
 func CheckSSLExpiry(c *gin.Context) {
-	sslMu.RLock()
-	certs := make([]*SSLCertificate, 0)
-	for _, cert := range sslCertificates {
-		certs = append(certs, cert)
+	certs, err := dataStore.Certificates.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
-	sslMu.RUnlock()
 
-	expiring := make([]*SSLCertificate, 0)
-	expired := make([]*SSLCertificate, 0)
+	expiring := make([]*models.SSLCertificate, 0)
+	expired := make([]*models.SSLCertificate, 0)
 
 	for _, cert := range certs {
 		if time.Now().After(cert.ExpiresAt) {
@@ -397,7 +368,7 @@ func GenerateSelfSignedCertificate(c *gin.Context) {
 
 	// Store certificate
 	certID := uuid.New().String()[:8]
-	cert := &SSLCertificate{
+	cert := &models.SSLCertificate{
 		ID:          certID,
 		Domain:      req.Domain,
 		Issuer:      "Self-Signed",
@@ -411,9 +382,10 @@ func GenerateSelfSignedCertificate(c *gin.Context) {
 		LastChecked: time.Now(),
 	}
 
-	sslMu.Lock()
-	sslCertificates[certID] = cert
-	sslMu.Unlock()
+	if err := dataStore.Certificates.Save(cert); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "Self-signed certificate generated",
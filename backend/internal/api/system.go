@@ -0,0 +1,52 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bizino-services/biz-panel-backend/internal/models"
+	"github.com/bizino-services/biz-panel-backend/internal/reconcile"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ReconcileSystem rebuilds panel records from the host and reports drift.
+// Pass ?dryRun=true to only report what would change.
+func ReconcileSystem(reconciler *reconcile.Reconciler) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dryRun := c.Query("dryRun") == "true"
+
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		defer cancel()
+
+		report := reconciler.Run(ctx, dryRun)
+
+		if !dryRun && len(report.Created) > 0 {
+			addActivity(&models.Activity{
+				ID:          uuid.New().String()[:8],
+				Type:        "update",
+				Title:       "State Reconciled",
+				Description: fmt.Sprintf("Re-created %d records from host artifacts", len(report.Created)),
+				Status:      "success",
+				Timestamp:   time.Now(),
+			})
+		}
+
+		c.JSON(http.StatusOK, report)
+	}
+}
+
+// GetReconcileReport returns the result of the last reconciliation
+func GetReconcileReport(reconciler *reconcile.Reconciler) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		report := reconciler.LastReport()
+		if report == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No reconciliation has run yet"})
+			return
+		}
+
+		c.JSON(http.StatusOK, report)
+	}
+}
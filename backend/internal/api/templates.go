@@ -368,6 +368,10 @@ func DeployTemplate(dockerClient *docker.Client) gin.HandlerFunc {
 			return
 		}
 
+		if !requireProjectWrite(c, req.ProjectID) {
+			return
+		}
+
 		if req.Name == "" {
 			req.Name = fmt.Sprintf("%s-%d", template.ID, time.Now().Unix())
 		}
@@ -0,0 +1,282 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bizino-services/biz-panel-backend/internal/deploy"
+	"github.com/bizino-services/biz-panel-backend/internal/models"
+	"github.com/bizino-services/biz-panel-backend/internal/store"
+	"github.com/bizino-services/biz-panel-backend/internal/webhook"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// maxWebhookBody is the largest webhook payload accepted
+const maxWebhookBody = 10 << 20
+
+// maxDeliveries is the number of verified webhook deliveries kept per
+// project
+const maxDeliveries = 50
+
+// maxUnverifiedDeliveries is the number of deliveries with a missing or bad
+// signature kept per project. They are capped apart from the verified ones,
+// so that anyone knowing a project ID can't push those out.
+const maxUnverifiedDeliveries = 20
+
+// webhookPath is where a project's git host sends webhooks
+func webhookPath(projectID string) string {
+	return "/api/webhooks/git/" + projectID
+}
+
+// GetProjectWebhook returns a project's webhook URL and secret
+func GetProjectWebhook(c *gin.Context) {
+	id := c.Param("id")
+	hook, err := dataStore.Webhooks.Get(id)
+	if err != nil {
+		respondStoreError(c, err, "Webhook not configured")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"url":       webhookPath(id),
+		"secret":    hook.Secret,
+		"createdAt": hook.CreatedAt,
+	})
+}
+
+// CreateProjectWebhook generates a new webhook secret for a project,
+// replacing the previous one
+func CreateProjectWebhook(c *gin.Context) {
+	id := c.Param("id")
+	if _, err := dataStore.Projects.Get(id); err != nil {
+		respondStoreError(c, err, "Project not found")
+		return
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+		return
+	}
+	hook := &models.ProjectWebhook{
+		ProjectID: id,
+		Secret:    hex.EncodeToString(buf),
+		CreatedAt: time.Now(),
+	}
+	if err := dataStore.Webhooks.Save(hook); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"url":       webhookPath(id),
+		"secret":    hook.Secret,
+		"createdAt": hook.CreatedAt,
+	})
+}
+
+// DeleteProjectWebhook removes a project's webhook secret; later deliveries
+// are rejected
+func DeleteProjectWebhook(c *gin.Context) {
+	id := c.Param("id")
+	if _, err := dataStore.Webhooks.Delete(id); err != nil {
+		respondStoreError(c, err, "Webhook not configured")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook removed"})
+}
+
+// ListWebhookDeliveries returns a project's recent webhook deliveries
+func ListWebhookDeliveries(c *gin.Context) {
+	deliveries, err := dataStore.Deliveries.ListByProject(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, deliveries)
+}
+
+// GitWebhook receives push webhooks from GitHub, GitLab and Gitea and
+// deploys the pushed commit of projects with AutoDeploy enabled. Pull
+// request events manage the project's preview environments. It is public;
+// requests are authenticated by the project's webhook secret.
+func GitWebhook(deployer *deploy.Deployer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		project, err := dataStore.Projects.Get(id)
+		if err != nil {
+			respondStoreError(c, err, "Project not found")
+			return
+		}
+
+		provider := webhook.Detect(c.Request.Header)
+		delivery := &models.WebhookDelivery{
+			ID:         uuid.New().String()[:8],
+			ProjectID:  id,
+			Provider:   provider,
+			Event:      webhook.Event(provider, c.Request.Header),
+			DeliveryID: webhook.DeliveryID(provider, c.Request.Header),
+			ClientIP:   c.ClientIP(),
+			ReceivedAt: time.Now(),
+		}
+		respond := func(code int, status models.WebhookStatus, reason string) {
+			delivery.Status, delivery.Reason = status, reason
+			recordDelivery(delivery)
+			body := gin.H{"status": status}
+			if reason != "" {
+				body["reason"] = reason
+			}
+			if delivery.DeployID != "" {
+				body["deployId"] = delivery.DeployID
+			}
+			c.JSON(code, body)
+		}
+
+		if provider == "" {
+			respond(http.StatusBadRequest, models.WebhookStatusRejected, webhook.ErrUnknownProvider.Error())
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxWebhookBody+1))
+		if err != nil {
+			respond(http.StatusBadRequest, models.WebhookStatusRejected, "failed to read body")
+			return
+		}
+		if len(body) > maxWebhookBody {
+			respond(http.StatusRequestEntityTooLarge, models.WebhookStatusRejected, "payload too large")
+			return
+		}
+
+		hook, err := dataStore.Webhooks.Get(id)
+		if errors.Is(err, store.ErrNotFound) {
+			respond(http.StatusNotFound, models.WebhookStatusRejected, "no webhook secret is configured for this project")
+			return
+		} else if err != nil {
+			respond(http.StatusInternalServerError, models.WebhookStatusFailed, err.Error())
+			return
+		}
+		if err := webhook.Verify(provider, c.Request.Header, body, hook.Secret); err != nil {
+			respond(http.StatusUnauthorized, models.WebhookStatusRejected, err.Error())
+			return
+		}
+		delivery.Verified = true
+
+		if webhook.IsPing(provider, delivery.Event) {
+			respond(http.StatusOK, models.WebhookStatusIgnored, "ping")
+			return
+		}
+		if webhook.IsPullRequest(provider, delivery.Event) {
+			previewWebhook(deployer, project, delivery, body, respond)
+			return
+		}
+		if !webhook.IsPush(provider, delivery.Event) {
+			respond(http.StatusOK, models.WebhookStatusIgnored, fmt.Sprintf("%q events are not handled", delivery.Event))
+			return
+		}
+		push, err := webhook.ParsePush(body)
+		if err != nil {
+			respond(http.StatusBadRequest, models.WebhookStatusRejected, "malformed push event: "+err.Error())
+			return
+		}
+		delivery.Ref, delivery.CommitSHA = push.Ref, push.SHA
+		delivery.Author, delivery.Message = push.Author, push.Message
+
+		repo := project.Repository
+		branch := ""
+		if repo != nil {
+			branch = repo.Branch
+			if branch == "" {
+				branch = push.DefaultBranch
+			}
+		}
+		switch {
+		case (project.Type != models.ProjectTypeGit && project.Type != models.ProjectTypeStatic) || repo == nil:
+			respond(http.StatusOK, models.WebhookStatusIgnored, "project is not deployed from git")
+			return
+		case !repo.AutoDeploy:
+			respond(http.StatusOK, models.WebhookStatusIgnored, "auto deploy is disabled")
+			return
+		case push.Branch == "":
+			respond(http.StatusOK, models.WebhookStatusIgnored, push.Ref+" is not a branch")
+			return
+		case push.Branch != branch:
+			respond(http.StatusOK, models.WebhookStatusIgnored, fmt.Sprintf("push to %s; the project deploys %s", push.Branch, branch))
+			return
+		case push.Deleted:
+			respond(http.StatusOK, models.WebhookStatusIgnored, "branch was deleted")
+			return
+		}
+
+		commit := deploy.Commit{SHA: push.SHA, Author: push.Author, Message: push.Message}
+		deployment, err := deployer.StartCommit(id, models.DeployTriggerWebhook, commit)
+		if err != nil {
+			code := http.StatusInternalServerError
+			switch {
+			case errors.Is(err, deploy.ErrNoDocker):
+				code = http.StatusServiceUnavailable
+			case errors.Is(err, deploy.ErrNothingToBuild):
+				code = http.StatusBadRequest
+			}
+			respond(code, models.WebhookStatusFailed, err.Error())
+			return
+		}
+		delivery.DeployID = deployment.ID
+
+		addActivity(&models.Activity{
+			ID:          uuid.New().String()[:8],
+			Type:        "deploy",
+			Title:       "Deployment Started",
+			Description: fmt.Sprintf("Push of %s to %s triggered a deployment of '%s'", shortCommit(push.SHA), push.Branch, project.Name),
+			Status:      "pending",
+			ProjectID:   id,
+			Timestamp:   deployment.StartedAt,
+		})
+		reason := ""
+		if deployment.Status == models.DeployStatusQueued {
+			reason = "queued"
+		}
+		respond(http.StatusAccepted, models.WebhookStatusDeployed, reason)
+	}
+}
+
+// recordDelivery stores a webhook delivery and drops the project's oldest
+// verified ones beyond maxDeliveries and unverified ones beyond
+// maxUnverifiedDeliveries
+func recordDelivery(delivery *models.WebhookDelivery) {
+	if err := dataStore.Deliveries.Save(delivery); err != nil {
+		log.Printf("Warning: failed to record webhook delivery %s: %v", delivery.ID, err)
+		return
+	}
+	deliveries, err := dataStore.Deliveries.ListByProject(delivery.ProjectID)
+	if err != nil {
+		return
+	}
+	verified, unverified := 0, 0
+	for _, d := range deliveries {
+		// Deliveries recorded before Verified existed passed unless rejected
+		if d.Verified || d.Status != models.WebhookStatusRejected {
+			verified++
+			if verified > maxDeliveries {
+				dataStore.Deliveries.Delete(d.ID)
+			}
+		} else {
+			unverified++
+			if unverified > maxUnverifiedDeliveries {
+				dataStore.Deliveries.Delete(d.ID)
+			}
+		}
+	}
+}
+
+// shortCommit abbreviates a commit hash for activity messages
+func shortCommit(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
@@ -160,20 +160,20 @@ func CreateWebsiteReal(c *gin.Context) {
 				createdItems = append(createdItems, fmt.Sprintf("Database %s created (user: %s)", dbName, dbUser))
 				
 				// Add to database store
-				databaseMu.Lock()
-				dbID := uuid.New().String()[:8]
 				engine := models.DatabaseMySQL
 				if req.DatabaseType == "postgresql" {
 					engine = models.DatabasePostgreSQL
 				}
-				databaseStore[dbID] = &models.Database{
-					ID:        dbID,
+				err := dataStore.Databases.Save(&models.Database{
+					ID:        uuid.New().String()[:8],
 					Name:      dbName,
 					Engine:    engine,
 					Charset:   "UTF8",
 					CreatedAt: time.Now(),
+				})
+				if err != nil {
+					errors = append(errors, fmt.Sprintf("Failed to record database: %v", err))
 				}
-				databaseMu.Unlock()
 			}
 		}
 	}
@@ -228,9 +228,9 @@ func CreateWebsiteReal(c *gin.Context) {
 		UpdatedAt:    time.Now(),
 	}
 
-	websiteMu.Lock()
-	websiteStore[websiteID] = website
-	websiteMu.Unlock()
+	if err := dataStore.Websites.Save(website); err != nil {
+		errors = append(errors, fmt.Sprintf("Failed to save website: %v", err))
+	}
 
 	// Log activity
 	addActivity(&models.Activity{
@@ -327,15 +327,9 @@ server {
 func DeleteWebsiteReal(c *gin.Context) {
 	id := c.Param("id")
 	
-	websiteMu.Lock()
-	website, exists := websiteStore[id]
-	if exists {
-		delete(websiteStore, id)
-	}
-	websiteMu.Unlock()
-
-	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Website not found"})
+	website, err := dataStore.Websites.Delete(id)
+	if err != nil {
+		respondStoreError(c, err, "Website not found")
 		return
 	}
 
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bizino-services/biz-panel-backend/internal/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// credentialCheckInterval is how often open sockets re-check the caller's session
+const credentialCheckInterval = 30 * time.Second
+
+// Close code sent when the caller's credential expires or is revoked
+const closeAuthExpired = 4001
+
+// Origins allowed to open WebSockets besides the panel's own (set from main)
+var allowedOrigin = func(origin string) bool { return false }
+
+// SetAllowedOrigins sets the check for cross-origin WebSocket upgrades,
+// normally the CORS allowlist
+func SetAllowedOrigins(allowed func(origin string) bool) {
+	allowedOrigin = allowed
+}
+
+// WebSocket upgrader
+var upgrader = websocket.Upgrader{
+	CheckOrigin:  checkOrigin,
+	Subprotocols: []string{auth.WSTicketProtocol},
+}
+
+// checkOrigin allows non-browser clients, same-origin pages and the configured origins
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return allowedOrigin(strings.TrimSuffix(origin, "/"))
+}
+
+// upgradeWebSocket upgrades the request and closes the socket once the
+// caller's token expires or its session is revoked
+func upgradeWebSocket(c *gin.Context) (*websocket.Conn, error) {
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// The gin context is recycled once the handler returns
+	ctx := c.Request.Context()
+	caller := c.Copy()
+
+	go func() {
+		var expired <-chan time.Time
+		if exp := auth.CredentialExpiry(caller); !exp.IsZero() {
+			timer := time.NewTimer(time.Until(exp))
+			defer timer.Stop()
+			expired = timer.C
+		}
+		ticker := time.NewTicker(credentialCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-expired:
+			case <-ticker.C:
+				if auth.CredentialActive(caller) {
+					continue
+				}
+			}
+			msg := websocket.FormatCloseMessage(closeAuthExpired, "authentication expired")
+			conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+			conn.Close()
+			return
+		}
+	}()
+
+	return conn, nil
+}
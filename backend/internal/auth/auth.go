@@ -9,8 +9,11 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/bizino-services/biz-panel-backend/internal/models"
+	"github.com/bizino-services/biz-panel-backend/internal/store"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
@@ -19,9 +22,17 @@ import (
 // Config holds authentication configuration
 type Config struct {
 	JWTSecret     string
-	TokenDuration time.Duration
+	TokenDuration time.Duration // Session (refresh token) lifetime
+	AccessTTL     time.Duration // Access token lifetime
 	AdminUser     string
 	AdminPassHash string
+	Require2FA    bool // Enforce TOTP for enrolled users even if panel settings don't
+	BruteForce    BruteForcePolicy
+	OIDC          OIDCConfig
+	LDAP          LDAPConfig
+
+	// PersistPasswordHash saves a changed admin password hash (optional)
+	PersistPasswordHash func(hash string) error
 }
 
 // User represents a user
@@ -36,6 +47,10 @@ type Claims struct {
 	UserID   string `json:"user_id"`
 	Username string `json:"username"`
 	Role     string `json:"role"`
+	// SessionID ties an access token to a revocable session
+	SessionID string `json:"sid,omitempty"`
+	// TokenType is empty for session tokens
+	TokenType string `json:"typ,omitempty"`
 	jwt.RegisteredClaims
 }
 
@@ -47,28 +62,84 @@ type LoginRequest struct {
 
 // LoginResponse represents login response
 type LoginResponse struct {
-	Token     string `json:"token"`
-	ExpiresAt int64  `json:"expiresAt"`
-	User      User   `json:"user"`
+	Token            string `json:"token"`
+	ExpiresAt        int64  `json:"expiresAt"`
+	RefreshToken     string `json:"refreshToken"`
+	RefreshExpiresAt int64  `json:"refreshExpiresAt"`
+	SessionID        string `json:"sessionId"`
+	User             User   `json:"user"`
+	// Set when 2FA is enforced but the user hasn't enrolled yet
+	TwoFactorSetupRequired bool `json:"twoFactorSetupRequired,omitempty"`
 }
 
-var config Config
+var (
+	config   Config
+	configMu sync.RWMutex
+)
+
+// BootstrapUserID is the ID of the administrator defined in the config file
+const BootstrapUserID = "admin"
+
+// Repositories used by auth (set from main)
+var (
+	users      store.UserRepository
+	twoFactors store.TwoFactorRepository
+	apiTokens  store.APITokenRepository
+	sessions   store.SessionRepository
+	bans       store.BanRepository
+	projects   store.ProjectRepository
+	members    store.ProjectMemberRepository
+	firewall   store.FirewallRepository
+	activities store.ActivityRepository
+	settings   store.SettingsRepository
+)
+
+// SetStore sets the repositories holding accounts, credentials, sessions and settings
+func SetStore(s *store.Store) {
+	users = s.Users
+	twoFactors = s.TwoFactor
+	apiTokens = s.APITokens
+	sessions = s.Sessions
+	bans = s.Bans
+	projects = s.Projects
+	members = s.Members
+	firewall = s.Firewall
+	activities = s.Activities
+	settings = s.Settings
+	loadBans()
+}
 
-// Initialize sets up the auth configuration
+// Initialize sets up the auth configuration. It is safe to call again
+// when the configuration is reloaded.
 func Initialize(cfg Config) {
-	config = cfg
-	if config.JWTSecret == "" {
-		config.JWTSecret = os.Getenv("JWT_SECRET")
-		if config.JWTSecret == "" {
+	if cfg.JWTSecret == "" {
+		cfg.JWTSecret = os.Getenv("JWT_SECRET")
+		if cfg.JWTSecret == "" {
 			// Generate random secret if not provided
 			secret := make([]byte, 32)
 			rand.Read(secret)
-			config.JWTSecret = base64.StdEncoding.EncodeToString(secret)
+			cfg.JWTSecret = base64.StdEncoding.EncodeToString(secret)
 		}
 	}
-	if config.TokenDuration == 0 {
-		config.TokenDuration = 24 * time.Hour
+	if cfg.TokenDuration == 0 {
+		cfg.TokenDuration = 24 * time.Hour
+	}
+	if cfg.AccessTTL == 0 {
+		cfg.AccessTTL = 15 * time.Minute
 	}
+	cfg.OIDC = cfg.OIDC.withDefaults()
+	cfg.LDAP = cfg.LDAP.withDefaults()
+
+	configMu.Lock()
+	config = cfg
+	configMu.Unlock()
+}
+
+// currentConfig returns a snapshot of the auth configuration
+func currentConfig() Config {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return config
 }
 
 // HashPassword hashes a password using bcrypt
@@ -83,23 +154,29 @@ func CheckPassword(password, hash string) bool {
 	return err == nil
 }
 
-// GenerateToken creates a new JWT token
-func GenerateToken(user User) (string, time.Time, error) {
-	expiresAt := time.Now().Add(config.TokenDuration)
-
-	claims := Claims{
-		UserID:   user.ID,
-		Username: user.Username,
-		Role:     user.Role,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expiresAt),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			Issuer:    "biz-panel",
-		},
+// GenerateToken creates a short-lived access token for a session
+func GenerateToken(user User, sessionID string) (string, time.Time, error) {
+	return signToken(Claims{
+		UserID:    user.ID,
+		Username:  user.Username,
+		Role:      user.Role,
+		SessionID: sessionID,
+	}, currentConfig().AccessTTL)
+}
+
+// signToken signs claims with the registered fields filled in
+func signToken(claims Claims, duration time.Duration) (string, time.Time, error) {
+	cfg := currentConfig()
+	expiresAt := time.Now().Add(duration)
+
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		Issuer:    "biz-panel",
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(config.JWTSecret))
+	tokenString, err := token.SignedString([]byte(cfg.JWTSecret))
 	return tokenString, expiresAt, err
 }
 
@@ -109,7 +186,7 @@ func ValidateToken(tokenString string) (*Claims, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return []byte(config.JWTSecret), nil
+		return []byte(currentConfig().JWTSecret), nil
 	})
 
 	if err != nil {
@@ -161,6 +238,17 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		// Browsers can't set headers on WebSocket upgrades; they redeem a ticket instead
+		if ticket := requestTicket(c.Request); ticket != "" {
+			if err := redeemTicket(c, ticket); err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid, expired or already used WebSocket ticket"})
+				c.Abort()
+				return
+			}
+			c.Next()
+			return
+		}
+
 		// Get token from header
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -177,18 +265,62 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		// Personal access tokens carry their own scopes
+		if strings.HasPrefix(parts[1], APITokenPrefix) {
+			token, role, err := authenticateAPIToken(parts[1], c.ClientIP())
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid, expired or revoked API token"})
+				c.Abort()
+				return
+			}
+			c.Set("user_id", token.UserID)
+			c.Set("username", token.Username)
+			c.Set("role", role)
+			c.Set("auth_method", AuthMethodToken)
+			c.Set("token_id", token.ID)
+			c.Set("scopes", tokenScopes(token))
+			if token.ExpiresAt != nil {
+				c.Set("auth_expires_at", *token.ExpiresAt)
+			}
+			c.Next()
+			return
+		}
+
 		// Validate token
 		claims, err := ValidateToken(parts[1])
-		if err != nil {
+		if err != nil || claims.TokenType != "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
 			c.Abort()
 			return
 		}
 
+		// Logout, revocation, password changes and the idle timeout end the session
+		if _, err := validateSession(claims.SessionID); err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired or revoked"})
+			c.Abort()
+			return
+		}
+
+		// Use the stored account so role changes and deletions apply immediately
+		role := claims.Role
+		if claims.UserID != BootstrapUserID {
+			account, err := lookupUser(claims.UserID)
+			if err != nil || account.Disabled {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Account is no longer active"})
+				c.Abort()
+				return
+			}
+			role = account.Role
+		}
+
 		// Set user info in context
 		c.Set("user_id", claims.UserID)
 		c.Set("username", claims.Username)
-		c.Set("role", claims.Role)
+		c.Set("role", role)
+		c.Set("session_id", claims.SessionID)
+		if claims.ExpiresAt != nil {
+			c.Set("auth_expires_at", claims.ExpiresAt.Time)
+		}
 
 		c.Next()
 	}
@@ -202,36 +334,113 @@ func LoginHandler(c *gin.Context) {
 		return
 	}
 
-	// Check credentials against config
-	if !SecureCompare(req.Username, config.AdminUser) {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+	// Locked-out usernames are refused even with the right password
+	if b := activeBan(models.BanKindUsername, req.Username); b != nil {
+		respondBanned(c, b, "This account is temporarily locked")
 		return
 	}
 
-	if !CheckPassword(req.Password, config.AdminPassHash) {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+	user, err := authenticate(req.Username, req.Password)
+	if err != nil {
+		if errors.Is(err, errInvalidCredentials) {
+			recordLoginFailure(c.ClientIP(), req.Username)
+		}
+		respondLoginError(c, err)
 		return
 	}
 
-	// Create user
-	user := User{
-		ID:       "admin",
-		Username: req.Username,
-		Role:     "admin",
+	completeLogin(c, user)
+}
+
+// completeLogin answers a successful first factor with a 2FA challenge or a
+// new session
+func completeLogin(c *gin.Context, user User) {
+	// Failures only reset once the whole login succeeds
+	enrolled := twoFactorEnrolled(user.ID)
+	if !enrolled {
+		recordLoginSuccess(c.ClientIP(), user.Username)
 	}
 
-	// Generate token
-	token, expiresAt, err := GenerateToken(user)
+	// Second step: exchange the challenge and a TOTP code at /api/auth/login/2fa.
+	// Enrolled users always take it; the policy only makes the others enroll.
+	if enrolled {
+		challenge, expiresAt, err := generateChallengeToken(user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
+		}
+		c.JSON(http.StatusOK, TwoFactorChallengeResponse{
+			TwoFactorRequired: true,
+			ChallengeToken:    challenge,
+			ExpiresAt:         expiresAt.Unix(),
+		})
+		return
+	}
+
+	// Start a session
+	resp, err := startSession(c, user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
+	resp.TwoFactorSetupRequired = twoFactorRequired()
 
-	c.JSON(http.StatusOK, LoginResponse{
-		Token:     token,
-		ExpiresAt: expiresAt.Unix(),
-		User:      user,
-	})
+	c.JSON(http.StatusOK, resp)
+}
+
+// authenticate checks credentials against panel accounts, the config admin
+// and finally the LDAP directory
+func authenticate(username, password string) (User, error) {
+	if users != nil {
+		account, err := users.FindByUsername(username)
+		if err == nil {
+			switch account.Provider {
+			case "":
+				if account.Disabled || !CheckPassword(password, account.PasswordHash) {
+					return User{}, errInvalidCredentials
+				}
+				users.Update(account.ID, func(u *models.User) error {
+					now := time.Now()
+					u.LastLoginAt = &now
+					return nil
+				})
+				return User{ID: account.ID, Username: account.Username, Role: account.Role}, nil
+			case ProviderLDAP:
+				if !currentConfig().LDAP.Enabled {
+					return User{}, errInvalidCredentials
+				}
+				return authenticateLDAP(username, password)
+			default:
+				// OIDC accounts have no password; they sign in at their provider
+				return User{}, errInvalidCredentials
+			}
+		}
+		if !errors.Is(err, store.ErrNotFound) {
+			return User{}, err
+		}
+	}
+
+	// Check credentials against config
+	cfg := currentConfig()
+	if SecureCompare(username, cfg.AdminUser) {
+		if !CheckPassword(password, cfg.AdminPassHash) {
+			return User{}, errInvalidCredentials
+		}
+		return User{ID: BootstrapUserID, Username: username, Role: RoleAdmin}, nil
+	}
+
+	if cfg.LDAP.Enabled {
+		return authenticateLDAP(username, password)
+	}
+	return User{}, errInvalidCredentials
+}
+
+// lookupUser loads a panel account by ID
+func lookupUser(id string) (*models.User, error) {
+	if users == nil {
+		return nil, store.ErrNotFound
+	}
+	return users.Get(id)
 }
 
 // GetCurrentUser returns the current authenticated user
@@ -255,14 +464,44 @@ type ChangePasswordRequest struct {
 
 // ChangePasswordHandler handles password change
 func ChangePasswordHandler(c *gin.Context) {
+	if rejectAPIToken(c) {
+		return
+	}
+
 	var req ChangePasswordRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 		return
 	}
 
+	userID := c.GetString("user_id")
+
+	// Panel accounts keep their hash in the store
+	if userID != BootstrapUserID {
+		account, err := lookupUser(userID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		if account.Provider != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Your password is managed by your identity provider"})
+			return
+		}
+		if !CheckPassword(req.CurrentPassword, account.PasswordHash) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Current password is incorrect"})
+			return
+		}
+		if err := setUserPassword(userID, req.NewPassword); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save password: " + err.Error()})
+			return
+		}
+		revokeUserSessions(userID, c.GetString("session_id"), "password changed")
+		c.JSON(http.StatusOK, gin.H{"message": "Password changed successfully"})
+		return
+	}
+
 	// Verify current password
-	if !CheckPassword(req.CurrentPassword, config.AdminPassHash) {
+	if !CheckPassword(req.CurrentPassword, currentConfig().AdminPassHash) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Current password is incorrect"})
 		return
 	}
@@ -274,43 +513,34 @@ func ChangePasswordHandler(c *gin.Context) {
 		return
 	}
 
-	// Update config (in memory)
-	config.AdminPassHash = newHash
-
 	// Persist to config file
-	configPath := os.Getenv("CONFIG_FILE")
-	if configPath == "" {
-		configPath = "/etc/biz-panel/config.yaml"
-	}
-	
-	// Update ADMIN_PASS_HASH environment for child processes
-	os.Setenv("ADMIN_PASS_HASH", newHash)
-
-	// Try to update config file if it exists
-	if _, err := os.Stat(configPath); err == nil {
-		// Read current config
-		data, err := os.ReadFile(configPath)
-		if err == nil {
-			content := string(data)
-			// Simple replacement for password_hash line
-			lines := strings.Split(content, "\n")
-			for i, line := range lines {
-				if strings.Contains(line, "password_hash:") {
-					lines[i] = "  password_hash: " + newHash
-					break
-				}
-			}
-			newContent := strings.Join(lines, "\n")
-			os.WriteFile(configPath, []byte(newContent), 0600)
+	if persist := currentConfig().PersistPasswordHash; persist != nil {
+		if err := persist(newHash); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save password: " + err.Error()})
+			return
 		}
 	}
 
+	// Update config (in memory)
+	configMu.Lock()
+	config.AdminPassHash = newHash
+	configMu.Unlock()
+
+	// Existing sessions were opened with the old password
+	revokeUserSessions(userID, c.GetString("session_id"), "password changed")
+
 	c.JSON(http.StatusOK, gin.H{"message": "Password changed successfully"})
 }
 
 // RegisterRoutes registers auth routes
 func RegisterRoutes(r *gin.RouterGroup) {
 	r.POST("/login", LoginHandler)
+	r.POST("/login/2fa", TwoFactorLoginHandler)
+	r.GET("/providers", ProvidersHandler)
+	r.GET("/oidc/login", OIDCLoginHandler)
+	r.POST("/oidc/callback", OIDCCallbackHandler)
 	r.GET("/me", GetCurrentUser)
 	r.POST("/change-password", ChangePasswordHandler)
+	r.POST("/refresh", RefreshHandler)
+	r.POST("/logout", LogoutHandler)
 }
@@ -0,0 +1,478 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bizino-services/biz-panel-backend/internal/middleware"
+	"github.com/bizino-services/biz-panel-backend/internal/models"
+	"github.com/bizino-services/biz-panel-backend/internal/store"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// BruteForcePolicy tunes login lockouts
+type BruteForcePolicy struct {
+	MaxAttempts int
+	Window      time.Duration
+	Lockout     time.Duration // First lockout, doubled for each repeat
+	MaxLockout  time.Duration
+	Firewall    bool // Add firewall deny rules for locked-out IPs
+}
+
+// defaultBruteForcePolicy is used when Config.BruteForce is left empty
+var defaultBruteForcePolicy = BruteForcePolicy{
+	MaxAttempts: 5,
+	Window:      15 * time.Minute,
+	Lockout:     time.Minute,
+	MaxLockout:  24 * time.Hour,
+}
+
+// CreateBanRequest represents a manual ban
+type CreateBanRequest struct {
+	Kind            string `json:"kind" binding:"required"` // ip, username
+	Value           string `json:"value" binding:"required"`
+	Reason          string `json:"reason"`
+	DurationMinutes int    `json:"durationMinutes"` // 0 = permanent
+	Firewall        bool   `json:"firewall"`        // Also add a firewall deny rule (IP bans only)
+}
+
+// failureCount tracks recent failed logins for one IP or username
+type failureCount struct {
+	count int
+	first time.Time
+}
+
+// bruteForceGuard caches active bans and counts failures in memory
+type bruteForceGuard struct {
+	mu       sync.Mutex
+	failures map[string]*failureCount
+	bans     map[string]*models.Ban
+}
+
+var guard = &bruteForceGuard{
+	failures: make(map[string]*failureCount),
+	bans:     make(map[string]*models.Ban),
+}
+
+// BanID returns the ID of the ban for kind and value ("/" in CIDRs becomes "_"
+// so the ID fits in a URL path)
+func BanID(kind, value string) string {
+	return kind + ":" + strings.ReplaceAll(value, "/", "_")
+}
+
+// bruteForcePolicy returns the configured lockout policy
+func bruteForcePolicy() BruteForcePolicy {
+	policy := currentConfig().BruteForce
+	if policy.MaxAttempts <= 0 {
+		return defaultBruteForcePolicy
+	}
+	return policy
+}
+
+// bruteForceEnabled reports whether SecuritySettings.BruteForceEnabled is set
+func bruteForceEnabled() bool {
+	if settings == nil {
+		return false
+	}
+	s, err := settings.Get()
+	return err == nil && s.Security.BruteForceEnabled
+}
+
+// sweepInterval is how often expired bans lose their firewall rules
+const sweepInterval = time.Minute
+
+var sweepOnce sync.Once
+
+// loadBans fills the cache from the store and starts the expiry sweeper
+func loadBans() {
+	all, err := bans.List()
+	if err != nil {
+		log.Printf("Warning: failed to load bans: %v", err)
+		return
+	}
+
+	guard.mu.Lock()
+	guard.bans = make(map[string]*models.Ban, len(all))
+	for _, b := range all {
+		guard.bans[b.ID] = b
+	}
+	guard.mu.Unlock()
+
+	sweepOnce.Do(func() {
+		go func() {
+			for {
+				sweepBans()
+				time.Sleep(sweepInterval)
+			}
+		}()
+	})
+}
+
+// sweepBans removes firewall rules of expired bans and forgets bans once
+// their escalation memory (MaxLockout after expiry) has passed
+func sweepBans() {
+	now := time.Now()
+	keep := bruteForcePolicy().MaxLockout
+
+	var expired []*models.Ban
+	guard.mu.Lock()
+	for id, b := range guard.bans {
+		if b.Active(now) {
+			continue
+		}
+		if now.Sub(*b.ExpiresAt) > keep {
+			delete(guard.bans, id)
+			expired = append(expired, b)
+		} else if b.FirewallRuleID != "" {
+			expired = append(expired, b)
+		}
+	}
+	guard.mu.Unlock()
+
+	for _, b := range expired {
+		if b.FirewallRuleID != "" {
+			removeFirewallRule(b.FirewallRuleID)
+		}
+		if now.Sub(*b.ExpiresAt) > keep {
+			bans.Delete(b.ID)
+			continue
+		}
+		// Leave a ban that was renewed meanwhile untouched
+		updated, err := bans.Update(b.ID, func(stored *models.Ban) error {
+			if stored.FirewallRuleID == b.FirewallRuleID {
+				stored.FirewallRuleID = ""
+			}
+			return nil
+		})
+		if err == nil {
+			guard.mu.Lock()
+			if guard.bans[b.ID] == b {
+				guard.bans[b.ID] = updated
+			}
+			guard.mu.Unlock()
+		}
+	}
+}
+
+// activeBan returns the ban in force for kind and value, if any.
+// IP bans may be CIDRs, so IPs are matched against every IP ban.
+func activeBan(kind, value string) *models.Ban {
+	now := time.Now()
+	guard.mu.Lock()
+	defer guard.mu.Unlock()
+
+	if b, ok := guard.bans[BanID(kind, value)]; ok && b.Active(now) {
+		return b
+	}
+	if kind != models.BanKindIP {
+		return nil
+	}
+
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return nil
+	}
+	addr = addr.Unmap()
+	for _, b := range guard.bans {
+		if b.Kind != models.BanKindIP || !b.Active(now) || !strings.Contains(b.Value, "/") {
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(b.Value); err == nil && prefix.Contains(addr) {
+			return b
+		}
+	}
+	return nil
+}
+
+// retryAfter returns the seconds until a ban ends (0 for permanent bans)
+func retryAfter(b *models.Ban) int {
+	if b.ExpiresAt == nil {
+		return 0
+	}
+	return int(math.Ceil(time.Until(*b.ExpiresAt).Seconds()))
+}
+
+// respondBanned writes the response for a banned IP or locked username
+func respondBanned(c *gin.Context, b *models.Ban, msg string) {
+	seconds := retryAfter(b)
+	if seconds > 0 {
+		c.Header("Retry-After", fmt.Sprint(seconds))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": msg, "retryAfter": seconds})
+	} else {
+		c.JSON(http.StatusForbidden, gin.H{"error": msg})
+	}
+	c.Abort()
+}
+
+// BanMiddleware rejects requests from banned IPs
+func BanMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if b := activeBan(models.BanKindIP, c.ClientIP()); b != nil {
+			respondBanned(c, b, "Your IP address is temporarily blocked")
+			return
+		}
+		c.Next()
+	}
+}
+
+// recordLoginFailure counts a failed login and locks out the IP or username
+// when it crosses the policy threshold
+func recordLoginFailure(ip, username string) {
+	if bans == nil || !bruteForceEnabled() {
+		return
+	}
+	policy := bruteForcePolicy()
+	now := time.Now()
+
+	var lockouts []*models.Ban
+	guard.mu.Lock()
+	for _, target := range [][2]string{{models.BanKindIP, ip}, {models.BanKindUsername, username}} {
+		if target[1] == "" {
+			continue
+		}
+		id := BanID(target[0], target[1])
+		f, ok := guard.failures[id]
+		if !ok || now.Sub(f.first) > policy.Window {
+			f = &failureCount{first: now}
+			guard.failures[id] = f
+		}
+		f.count++
+		if f.count < policy.MaxAttempts {
+			continue
+		}
+		delete(guard.failures, id)
+		if b, ok := guard.bans[id]; ok && b.Active(now) {
+			continue
+		}
+		lockouts = append(lockouts, nextLockout(target[0], target[1], f.count, policy, now))
+	}
+	guard.pruneFailuresLocked(now, policy.Window)
+	guard.mu.Unlock()
+
+	for _, b := range lockouts {
+		if err := saveBan(b, policy.Firewall && b.Kind == models.BanKindIP); err != nil {
+			log.Printf("Warning: failed to save ban %s: %v", b.ID, err)
+			continue
+		}
+		recordSecurityActivity("Login Locked Out",
+			fmt.Sprintf("%s %s locked out for %s after %d failed logins", b.Kind, b.Value, time.Until(*b.ExpiresAt).Round(time.Second), b.Failures))
+	}
+}
+
+// nextLockout builds an automatic ban, doubling the duration for each repeat.
+// Callers hold guard.mu.
+func nextLockout(kind, value string, failures int, policy BruteForcePolicy, now time.Time) *models.Ban {
+	level := 0
+	if prev, ok := guard.bans[BanID(kind, value)]; ok && prev.Automatic {
+		level = prev.Level + 1
+	}
+
+	duration := policy.MaxLockout
+	if level < 32 {
+		if d := policy.Lockout << level; d > 0 && d < policy.MaxLockout {
+			duration = d
+		}
+	}
+	expiresAt := now.Add(duration)
+
+	return &models.Ban{
+		ID:        BanID(kind, value),
+		Kind:      kind,
+		Value:     value,
+		Reason:    "Too many failed login attempts",
+		Automatic: true,
+		Level:     level,
+		Failures:  failures,
+		CreatedBy: "system",
+		CreatedAt: now,
+		ExpiresAt: &expiresAt,
+	}
+}
+
+// pruneFailuresLocked drops failure counters older than the window
+func (g *bruteForceGuard) pruneFailuresLocked(now time.Time, window time.Duration) {
+	for id, f := range g.failures {
+		if now.Sub(f.first) > window {
+			delete(g.failures, id)
+		}
+	}
+}
+
+// recordLoginSuccess clears failure counters after a successful login
+func recordLoginSuccess(ip, username string) {
+	guard.mu.Lock()
+	delete(guard.failures, BanID(models.BanKindIP, ip))
+	delete(guard.failures, BanID(models.BanKindUsername, username))
+	guard.mu.Unlock()
+}
+
+// saveBan persists a ban, optionally adding a firewall deny rule, and caches it
+func saveBan(b *models.Ban, addFirewallRule bool) error {
+	// Replace the deny rule left by an earlier ban of the same address
+	if existing, err := bans.Get(b.ID); err == nil && existing.FirewallRuleID != "" {
+		removeFirewallRule(existing.FirewallRuleID)
+	}
+
+	if addFirewallRule && firewall != nil {
+		rule := &models.FirewallRule{
+			ID:          uuid.New().String()[:8],
+			Protocol:    "both",
+			Source:      b.Value,
+			Action:      "deny",
+			Description: "Biz-Panel ban: " + b.Reason,
+			Enabled:     true,
+		}
+		if err := firewall.Save(rule); err != nil {
+			return err
+		}
+		b.FirewallRuleID = rule.ID
+	}
+
+	if err := bans.Save(b); err != nil {
+		return err
+	}
+
+	guard.mu.Lock()
+	guard.bans[b.ID] = b
+	guard.mu.Unlock()
+	return nil
+}
+
+// removeFirewallRule deletes a deny rule added for a ban
+func removeFirewallRule(id string) {
+	if firewall == nil {
+		return
+	}
+	if _, err := firewall.Delete(id); err != nil && !errors.Is(err, store.ErrNotFound) {
+		log.Printf("Warning: failed to remove firewall rule %s: %v", id, err)
+	}
+}
+
+// recordSecurityActivity adds a security entry to the activity log
+func recordSecurityActivity(title, description string) {
+	if activities == nil {
+		return
+	}
+	activities.Append(&models.Activity{
+		ID:          uuid.New().String()[:8],
+		Type:        "security",
+		Title:       title,
+		Description: description,
+		Status:      "failed",
+		Timestamp:   time.Now(),
+	})
+}
+
+// ListBans returns active bans (?all=true includes expired automatic bans)
+func ListBans(c *gin.Context) {
+	all := c.Query("all") == "true"
+	now := time.Now()
+
+	guard.mu.Lock()
+	result := make([]*models.Ban, 0, len(guard.bans))
+	for _, b := range guard.bans {
+		if all || b.Active(now) {
+			copied := *b
+			result = append(result, &copied)
+		}
+	}
+	guard.mu.Unlock()
+
+	c.JSON(http.StatusOK, gin.H{"bans": result, "enabled": bruteForceEnabled()})
+}
+
+// CreateBan bans an IP, CIDR or username
+func CreateBan(c *gin.Context) {
+	var req CreateBanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	value := strings.TrimSpace(req.Value)
+	switch req.Kind {
+	case models.BanKindIP:
+		prefixes, err := middleware.ParseAllowlist([]string{value})
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if addr, err := netip.ParseAddr(c.ClientIP()); err == nil && prefixes[0].Contains(addr.Unmap()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot ban your own IP address"})
+			return
+		}
+		// Store single addresses without a prefix length so they match the cache key
+		if prefixes[0].IsSingleIP() {
+			value = prefixes[0].Addr().String()
+		} else {
+			value = prefixes[0].String()
+		}
+	case models.BanKindUsername:
+		if value == c.GetString("username") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot ban your own username"})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "kind must be ip or username"})
+		return
+	}
+
+	reason := req.Reason
+	if reason == "" {
+		reason = "Banned by administrator"
+	}
+
+	now := time.Now()
+	ban := &models.Ban{
+		ID:        BanID(req.Kind, value),
+		Kind:      req.Kind,
+		Value:     value,
+		Reason:    reason,
+		CreatedBy: c.GetString("username"),
+		CreatedAt: now,
+	}
+	if req.DurationMinutes > 0 {
+		expiresAt := now.Add(time.Duration(req.DurationMinutes) * time.Minute)
+		ban.ExpiresAt = &expiresAt
+	}
+
+	if err := saveBan(ban, req.Firewall && req.Kind == models.BanKindIP); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, ban)
+}
+
+// DeleteBan lifts a ban and removes its firewall rule
+func DeleteBan(c *gin.Context) {
+	id := c.Param("id")
+
+	ban, err := bans.Delete(id)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Ban not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if ban.FirewallRuleID != "" {
+		removeFirewallRule(ban.FirewallRuleID)
+	}
+
+	guard.mu.Lock()
+	delete(guard.bans, id)
+	delete(guard.failures, id)
+	guard.mu.Unlock()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Ban lifted", "id": id})
+}
@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bizino-services/biz-panel-backend/internal/models"
+	"github.com/bizino-services/biz-panel-backend/internal/store"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// External identity providers
+const (
+	ProviderOIDC = "oidc"
+	ProviderLDAP = "ldap"
+)
+
+var (
+	errInvalidCredentials = errors.New("invalid credentials")
+	errNoRole             = errors.New("no panel role is mapped to the user's groups")
+	errAccountConflict    = errors.New("username belongs to another account")
+	errProviderDown       = errors.New("identity provider unavailable")
+)
+
+// ExternalIdentity is a user as reported by an identity provider
+type ExternalIdentity struct {
+	Provider string
+	Subject  string // Stable ID at the provider: OIDC sub or LDAP DN
+	Username string
+	Email    string
+	Groups   []string
+}
+
+// RoleMapping assigns panel roles from identity provider groups
+type RoleMapping struct {
+	Groups  map[string]string // Group name or DN -> role
+	Default string            // Role for users in no mapped group; empty refuses them
+}
+
+// Resolve returns the most privileged role mapped from groups
+func (m RoleMapping) Resolve(groups []string) (string, bool) {
+	best := -1
+	for group, role := range m.Groups {
+		rank := roleRank(role)
+		if rank < 0 {
+			log.Printf("Warning: ignoring unknown role %q mapped from group %q", role, group)
+			continue
+		}
+		for _, g := range groups {
+			if strings.EqualFold(g, group) && (best < 0 || rank < best) {
+				best = rank
+			}
+		}
+	}
+	if best >= 0 {
+		return Roles()[best], true
+	}
+	if IsValidRole(m.Default) {
+		return m.Default, true
+	}
+	return "", false
+}
+
+// roleRank orders built-in roles from most (0) to least privileged; -1 if unknown
+func roleRank(role string) int {
+	for i, r := range Roles() {
+		if r == role {
+			return i
+		}
+	}
+	return -1
+}
+
+// provisionExternalUser finds or creates the panel account linked to an
+// external identity. The role is synced from the groups on every login.
+func provisionExternalUser(identity *ExternalIdentity, mapping RoleMapping) (User, error) {
+	role, ok := mapping.Resolve(identity.Groups)
+	if !ok {
+		return User{}, errNoRole
+	}
+
+	now := time.Now()
+	account, err := users.FindByExternalID(identity.Provider, identity.Subject)
+	if err == nil {
+		if account.Disabled {
+			return User{}, errInvalidCredentials
+		}
+		account, err = users.Update(account.ID, func(u *models.User) error {
+			if identity.Email != "" {
+				u.Email = identity.Email
+			}
+			u.Role = role
+			u.LastLoginAt = &now
+			u.UpdatedAt = now
+			return nil
+		})
+		if err != nil {
+			return User{}, err
+		}
+		return User{ID: account.ID, Username: account.Username, Role: account.Role}, nil
+	}
+	if !errors.Is(err, store.ErrNotFound) {
+		return User{}, err
+	}
+
+	// Just-in-time provisioning; never take over an existing username
+	taken, err := usernameTaken(identity.Username)
+	if err != nil {
+		return User{}, err
+	}
+	if taken {
+		return User{}, errAccountConflict
+	}
+
+	account = &models.User{
+		ID:          uuid.New().String()[:8],
+		Username:    identity.Username,
+		Email:       identity.Email,
+		Role:        role,
+		Provider:    identity.Provider,
+		ExternalID:  identity.Subject,
+		LastLoginAt: &now,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := users.Save(account); err != nil {
+		return User{}, err
+	}
+	log.Printf("Provisioned %s user %s with role %s", identity.Provider, account.Username, role)
+
+	return User{ID: account.ID, Username: account.Username, Role: account.Role}, nil
+}
+
+// respondLoginError writes the response for a failed external login
+func respondLoginError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errInvalidCredentials):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+	case errors.Is(err, errNoRole):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Your account is not in a group with access to this panel"})
+	case errors.Is(err, errAccountConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": "Username already belongs to another panel account"})
+	case errors.Is(err, errProviderDown):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Identity provider unavailable"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// ProvidersHandler lists the login methods shown on the login page
+func ProvidersHandler(c *gin.Context) {
+	cfg := currentConfig()
+	oidcInfo := gin.H{"enabled": cfg.OIDC.Enabled}
+	if cfg.OIDC.Enabled {
+		name := cfg.OIDC.DisplayName
+		if name == "" {
+			name = "Single sign-on"
+		}
+		oidcInfo["displayName"] = name
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"local": true,
+		"ldap":  gin.H{"enabled": cfg.LDAP.Enabled},
+		"oidc":  oidcInfo,
+	})
+}
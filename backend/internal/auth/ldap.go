@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"crypto/tls"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/bizino-services/biz-panel-backend/internal/ldap"
+)
+
+// LDAPConfig configures password logins against an LDAP directory
+type LDAPConfig struct {
+	Enabled            bool
+	URL                string // ldap://host:389 or ldaps://host:636
+	StartTLS           bool
+	InsecureSkipVerify bool
+	BindDN             string // Service account for searches; empty binds anonymously
+	BindPassword       string
+	UserBaseDN         string
+	UserFilter         string // {username} is replaced, e.g. (uid={username})
+	UsernameAttribute  string
+	EmailAttribute     string
+	GroupBaseDN        string // Empty relies on the user's memberOf attribute only
+	GroupFilter        string // {dn} and {username} are replaced
+	GroupNameAttribute string
+	Timeout            time.Duration
+	Roles              RoleMapping
+}
+
+// withDefaults fills in attributes and filters for a typical OpenLDAP schema
+func (c LDAPConfig) withDefaults() LDAPConfig {
+	if c.UserFilter == "" {
+		c.UserFilter = "(uid={username})"
+	}
+	if c.UsernameAttribute == "" {
+		c.UsernameAttribute = "uid"
+	}
+	if c.EmailAttribute == "" {
+		c.EmailAttribute = "mail"
+	}
+	if c.GroupFilter == "" {
+		c.GroupFilter = "(|(member={dn})(uniqueMember={dn})(memberUid={username}))"
+	}
+	if c.GroupNameAttribute == "" {
+		c.GroupNameAttribute = "cn"
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 10 * time.Second
+	}
+	return c
+}
+
+// authenticateLDAP binds as the user and provisions the matching panel account
+func authenticateLDAP(username, password string) (User, error) {
+	cfg := currentConfig().LDAP
+	identity, err := lookupLDAPIdentity(cfg, username, password)
+	if err != nil {
+		return User{}, err
+	}
+	if SecureCompare(identity.Username, currentConfig().AdminUser) {
+		return User{}, errAccountConflict
+	}
+	return provisionExternalUser(identity, cfg.Roles)
+}
+
+// lookupLDAPIdentity finds the user's entry, verifies the password with a
+// bind as that entry and collects the user's groups
+func lookupLDAPIdentity(cfg LDAPConfig, username, password string) (*ExternalIdentity, error) {
+	if username == "" || password == "" {
+		return nil, errInvalidCredentials
+	}
+
+	conn, err := ldap.Dial(cfg.URL, ldap.Options{
+		Timeout:   cfg.Timeout,
+		TLSConfig: &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify},
+	})
+	if err != nil {
+		log.Printf("LDAP: connect to %s failed: %v", cfg.URL, err)
+		return nil, errProviderDown
+	}
+	defer conn.Close()
+
+	if cfg.StartTLS {
+		if err := conn.StartTLS(); err != nil {
+			log.Printf("LDAP: StartTLS failed: %v", err)
+			return nil, errProviderDown
+		}
+	}
+	if err := bindService(conn, cfg); err != nil {
+		return nil, err
+	}
+
+	entries, err := conn.Search(ldap.SearchRequest{
+		BaseDN:     cfg.UserBaseDN,
+		Scope:      ldap.ScopeWholeSubtree,
+		Filter:     strings.ReplaceAll(cfg.UserFilter, "{username}", ldap.EscapeFilter(username)),
+		Attributes: []string{cfg.UsernameAttribute, cfg.EmailAttribute, "memberOf"},
+		SizeLimit:  2,
+	})
+	if err != nil {
+		log.Printf("LDAP: user search failed: %v", err)
+		return nil, errProviderDown
+	}
+	if len(entries) != 1 {
+		return nil, errInvalidCredentials
+	}
+	entry := entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if errors.Is(err, ldap.ErrInvalidCredentials) {
+			return nil, errInvalidCredentials
+		}
+		log.Printf("LDAP: bind as %s failed: %v", entry.DN, err)
+		return nil, errProviderDown
+	}
+
+	identity := &ExternalIdentity{
+		Provider: ProviderLDAP,
+		Subject:  entry.DN,
+		Username: entry.Get(cfg.UsernameAttribute),
+		Email:    entry.Get(cfg.EmailAttribute),
+	}
+	if identity.Username == "" {
+		identity.Username = username
+	}
+	for _, dn := range entry.Values("memberOf") {
+		identity.Groups = append(identity.Groups, groupNames(dn, "")...)
+	}
+
+	if cfg.GroupBaseDN != "" {
+		// Search groups with the service account; users often can't read them
+		if err := bindService(conn, cfg); err != nil {
+			return nil, err
+		}
+		filter := strings.NewReplacer(
+			"{dn}", ldap.EscapeFilter(entry.DN),
+			"{username}", ldap.EscapeFilter(identity.Username),
+		).Replace(cfg.GroupFilter)
+		groups, err := conn.Search(ldap.SearchRequest{
+			BaseDN:     cfg.GroupBaseDN,
+			Scope:      ldap.ScopeWholeSubtree,
+			Filter:     filter,
+			Attributes: []string{cfg.GroupNameAttribute},
+			SizeLimit:  1000,
+		})
+		if err != nil {
+			log.Printf("LDAP: group search failed: %v", err)
+			return nil, errProviderDown
+		}
+		for _, g := range groups {
+			identity.Groups = append(identity.Groups, groupNames(g.DN, g.Get(cfg.GroupNameAttribute))...)
+		}
+	}
+
+	return identity, nil
+}
+
+// bindService binds as the configured service account, if any
+func bindService(conn *ldap.Conn, cfg LDAPConfig) error {
+	if cfg.BindDN == "" {
+		return nil
+	}
+	if err := conn.Bind(cfg.BindDN, cfg.BindPassword); err != nil {
+		log.Printf("LDAP: service account bind failed: %v", err)
+		return errProviderDown
+	}
+	return nil
+}
+
+// groupNames returns the names a group can be mapped by: its DN and its
+// name attribute, or the value of the DN's first RDN
+func groupNames(dn, name string) []string {
+	if name == "" {
+		first, _, _ := strings.Cut(dn, ",")
+		if _, value, ok := strings.Cut(first, "="); ok {
+			name = strings.TrimSpace(value)
+		}
+	}
+	if name == "" || strings.EqualFold(name, dn) {
+		return []string{dn}
+	}
+	return []string{dn, name}
+}
@@ -0,0 +1,348 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/bizino-services/biz-panel-backend/internal/models"
+	"github.com/bizino-services/biz-panel-backend/internal/store"
+	"github.com/gin-gonic/gin"
+)
+
+// Project roles, granted per project through memberships
+const (
+	ProjectRoleMaintainer = "maintainer"
+	ProjectRoleDeveloper  = "developer"
+	ProjectRoleViewer     = "viewer"
+)
+
+// ActionManage covers managing a project's members and deleting it. It is
+// only granted by project roles.
+const ActionManage = "manage"
+
+// projectRoleActions lists what each project role may do inside its project.
+// The caller's global role still applies, so a global viewer made a project
+// developer can only read.
+var projectRoleActions = map[string][]string{
+	ProjectRoleMaintainer: {ActionRead, ActionWrite, ActionDeploy, ActionManage},
+	ProjectRoleDeveloper:  {ActionRead, ActionWrite, ActionDeploy},
+	ProjectRoleViewer:     {ActionRead},
+}
+
+// unscopedRoles see every project without memberships
+var unscopedRoles = map[string]bool{RoleAdmin: true, RoleOperator: true}
+
+// ProjectRoles returns the names of the project roles
+func ProjectRoles() []string {
+	return []string{ProjectRoleMaintainer, ProjectRoleDeveloper, ProjectRoleViewer}
+}
+
+// IsValidProjectRole reports whether role is a project role
+func IsValidProjectRole(role string) bool {
+	_, ok := projectRoleActions[role]
+	return ok
+}
+
+// ProjectScope describes which projects a caller can reach
+type ProjectScope struct {
+	All   bool              // Admins and operators reach every project
+	Roles map[string]string // Project ID -> project role
+}
+
+// Allows reports whether the scope permits action on projectID. Resources
+// without a project are only reachable with an unscoped role.
+func (s ProjectScope) Allows(projectID, action string) bool {
+	if s.All {
+		return true
+	}
+	role, ok := s.Roles[projectID]
+	if !ok {
+		return false
+	}
+	for _, a := range projectRoleActions[role] {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// Visible reports whether the scope can see projectID
+func (s ProjectScope) Visible(projectID string) bool {
+	return s.Allows(projectID, ActionRead)
+}
+
+// CallerProjectScope returns the projects the authenticated caller can reach
+func CallerProjectScope(c *gin.Context) (ProjectScope, error) {
+	if cached, ok := c.Get("project_scope"); ok {
+		return cached.(ProjectScope), nil
+	}
+
+	scope := ProjectScope{All: unscopedRoles[c.GetString("role")]}
+	if !scope.All {
+		scope.Roles = make(map[string]string)
+		if members != nil {
+			memberships, err := members.ListByUser(c.GetString("user_id"))
+			if err != nil {
+				return scope, err
+			}
+			for _, m := range memberships {
+				scope.Roles[m.ProjectID] = m.Role
+			}
+		}
+		// Preview environments are reachable with the role on their parent
+		if len(scope.Roles) > 0 && projects != nil {
+			all, err := projects.List()
+			if err != nil {
+				return scope, err
+			}
+			for _, p := range all {
+				if role, ok := scope.Roles[p.ParentID]; ok && p.ParentID != "" {
+					scope.Roles[p.ID] = role
+				}
+			}
+		}
+	}
+
+	c.Set("project_scope", scope)
+	return scope, nil
+}
+
+// ProjectLookup resolves the project a request targets
+type ProjectLookup func(c *gin.Context) (string, error)
+
+// ProjectParam reads the project ID from the :id route parameter
+func ProjectParam(c *gin.Context) (string, error) {
+	return c.Param("id"), nil
+}
+
+// RequireProject checks the caller's project role allows the request: GET
+// needs read, everything else write. Unreachable projects answer notFound so
+// their existence is not revealed.
+func RequireProject(lookup ProjectLookup, notFound string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		checkProjectAccess(c, lookup, actionForMethod(c.Request.Method), notFound)
+	}
+}
+
+// RequireProjectAction checks the caller's project role allows action
+func RequireProjectAction(action string, lookup ProjectLookup, notFound string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		checkProjectAccess(c, lookup, action, notFound)
+	}
+}
+
+func checkProjectAccess(c *gin.Context, lookup ProjectLookup, action, notFound string) {
+	scope, err := CallerProjectScope(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Abort()
+		return
+	}
+	if scope.All {
+		c.Next()
+		return
+	}
+
+	projectID, err := lookup(c)
+	if err != nil || !scope.Visible(projectID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": notFound})
+		c.Abort()
+		return
+	}
+	if !scope.Allows(projectID, action) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":       "Insufficient project permissions",
+			"projectRole": scope.Roles[projectID],
+		})
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// GrantProjectRole makes userID a member of projectID with role
+func GrantProjectRole(projectID, userID, role, addedBy string) (*models.ProjectMember, error) {
+	now := time.Now()
+	member := &models.ProjectMember{
+		ID:        projectID + ":" + userID,
+		ProjectID: projectID,
+		UserID:    userID,
+		Role:      role,
+		AddedBy:   addedBy,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if existing, err := members.Get(member.ID); err == nil {
+		member.CreatedAt = existing.CreatedAt
+	}
+	if err := members.Save(member); err != nil {
+		return nil, err
+	}
+	return member, nil
+}
+
+// RemoveProjectMembers deletes every membership of a project
+func RemoveProjectMembers(projectID string) {
+	list, err := members.ListByProject(projectID)
+	if err != nil {
+		log.Printf("Warning: failed to list members of project %s: %v", projectID, err)
+		return
+	}
+	for _, m := range list {
+		members.Delete(m.ID)
+	}
+}
+
+// removeUserMemberships deletes every membership of a user
+func removeUserMemberships(userID string) {
+	list, err := members.ListByUser(userID)
+	if err != nil {
+		log.Printf("Warning: failed to list memberships of user %s: %v", userID, err)
+		return
+	}
+	for _, m := range list {
+		members.Delete(m.ID)
+	}
+}
+
+// ProjectMemberInfo is a membership with the member's account details
+type ProjectMemberInfo struct {
+	*models.ProjectMember
+	Username   string `json:"username"`
+	GlobalRole string `json:"globalRole"`
+}
+
+// ProjectMemberRequest adds a member or changes a member's role
+type ProjectMemberRequest struct {
+	UserID   string `json:"userId"`
+	Username string `json:"username"`
+	Role     string `json:"role" binding:"required"`
+}
+
+// ListProjectMembers returns the members of a project
+func ListProjectMembers(c *gin.Context) {
+	projectID := c.Param("id")
+	if _, err := projects.Get(projectID); err != nil {
+		respondProjectError(c, err)
+		return
+	}
+
+	list, err := members.ListByProject(projectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	result := make([]ProjectMemberInfo, 0, len(list))
+	for _, m := range list {
+		info := ProjectMemberInfo{ProjectMember: m}
+		if account, err := lookupUser(m.UserID); err == nil {
+			info.Username = account.Username
+			info.GlobalRole = account.Role
+		}
+		result = append(result, info)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Username < result[j].Username })
+
+	c.JSON(http.StatusOK, result)
+}
+
+// AddProjectMember adds a user to a project, or changes their role if they
+// are already a member
+func AddProjectMember(c *gin.Context) {
+	projectID := c.Param("id")
+	if _, err := projects.Get(projectID); err != nil {
+		respondProjectError(c, err)
+		return
+	}
+
+	var req ProjectMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !IsValidProjectRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project role", "roles": ProjectRoles()})
+		return
+	}
+
+	var account *models.User
+	var err error
+	switch {
+	case req.UserID != "":
+		account, err = lookupUser(req.UserID)
+	case req.Username != "":
+		account, err = users.FindByUsername(req.Username)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "userId or username is required"})
+		return
+	}
+	if err != nil {
+		respondUserError(c, err)
+		return
+	}
+
+	member, err := GrantProjectRole(projectID, account.ID, req.Role, c.GetString("username"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, ProjectMemberInfo{ProjectMember: member, Username: account.Username, GlobalRole: account.Role})
+}
+
+// UpdateProjectMember changes a member's project role
+func UpdateProjectMember(c *gin.Context) {
+	var req ProjectMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !IsValidProjectRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project role", "roles": ProjectRoles()})
+		return
+	}
+
+	member, err := members.Update(c.Param("id")+":"+c.Param("userId"), func(m *models.ProjectMember) error {
+		m.Role = req.Role
+		m.UpdatedAt = time.Now()
+		return nil
+	})
+	if err != nil {
+		respondMemberError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, member)
+}
+
+// RemoveProjectMember removes a user from a project
+func RemoveProjectMember(c *gin.Context) {
+	member, err := members.Delete(c.Param("id") + ":" + c.Param("userId"))
+	if err != nil {
+		respondMemberError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed", "userId": member.UserID})
+}
+
+func respondProjectError(c *gin.Context, err error) {
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+func respondMemberError(c *gin.Context, err error) {
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
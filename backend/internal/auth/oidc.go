@@ -0,0 +1,264 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bizino-services/biz-panel-backend/internal/models"
+	"github.com/bizino-services/biz-panel-backend/internal/oidc"
+	"github.com/gin-gonic/gin"
+)
+
+// OIDCConfig configures single sign-on through an OpenID Connect provider
+type OIDCConfig struct {
+	Enabled       bool
+	DisplayName   string // Shown on the login button
+	Issuer        string
+	ClientID      string
+	ClientSecret  string // Optional for public clients
+	RedirectURL   string // Panel page that posts code and state to /api/auth/oidc/callback
+	Scopes        []string
+	UsernameClaim string
+	GroupsClaim   string
+	Roles         RoleMapping
+}
+
+// withDefaults fills in the standard scopes and claim names
+func (c OIDCConfig) withDefaults() OIDCConfig {
+	if len(c.Scopes) == 0 {
+		c.Scopes = []string{"openid", "profile", "email", "groups"}
+	}
+	if c.UsernameClaim == "" {
+		c.UsernameClaim = "preferred_username"
+	}
+	if c.GroupsClaim == "" {
+		c.GroupsClaim = "groups"
+	}
+	return c
+}
+
+// oidcLoginTTL is how long a started login may take at the provider
+const oidcLoginTTL = 10 * time.Minute
+
+// maxPendingOIDCLogins bounds the logins awaiting a callback
+const maxPendingOIDCLogins = 1000
+
+// oidcStateCookie binds a started login to the browser that started it, so
+// a callback link from someone else's login is refused
+const (
+	oidcStateCookie     = "biz_panel_oidc_state"
+	oidcStateCookiePath = "/api/auth/oidc"
+)
+
+// pendingOIDCLogin holds the secrets of a started login, keyed by state
+type pendingOIDCLogin struct {
+	verifier  string
+	nonce     string
+	expiresAt time.Time
+}
+
+// oidcState caches the discovered provider and the pending logins
+var oidcState = struct {
+	mu       sync.Mutex
+	key      string
+	provider *oidc.Provider
+	pending  map[string]pendingOIDCLogin
+}{pending: make(map[string]pendingOIDCLogin)}
+
+// OIDCCallbackRequest carries the parameters the provider redirected with
+type OIDCCallbackRequest struct {
+	Code  string `json:"code" binding:"required"`
+	State string `json:"state" binding:"required"`
+}
+
+// oidcProvider returns the provider for the current configuration,
+// running discovery again after the configuration changed
+func oidcProvider(ctx context.Context, cfg OIDCConfig) (*oidc.Provider, error) {
+	key := strings.Join([]string{cfg.Issuer, cfg.ClientID, cfg.ClientSecret, cfg.RedirectURL, strings.Join(cfg.Scopes, " ")}, "\n")
+
+	oidcState.mu.Lock()
+	if oidcState.provider != nil && oidcState.key == key {
+		p := oidcState.provider
+		oidcState.mu.Unlock()
+		return p, nil
+	}
+	oidcState.mu.Unlock()
+
+	p, err := oidc.Discover(ctx, oidc.Config{
+		Issuer:       cfg.Issuer,
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		RedirectURL:  cfg.RedirectURL,
+		Scopes:       cfg.Scopes,
+	})
+	if err != nil {
+		log.Printf("OIDC: %v", err)
+		return nil, errProviderDown
+	}
+
+	oidcState.mu.Lock()
+	oidcState.key = key
+	oidcState.provider = p
+	oidcState.mu.Unlock()
+	return p, nil
+}
+
+// OIDCLoginHandler starts an authorization code flow with PKCE and returns
+// the provider URL the browser should open
+func OIDCLoginHandler(c *gin.Context) {
+	cfg := currentConfig().OIDC
+	if !cfg.Enabled {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not enabled"})
+		return
+	}
+
+	provider, err := oidcProvider(c.Request.Context(), cfg)
+	if err != nil {
+		respondLoginError(c, err)
+		return
+	}
+
+	state, err1 := oidc.RandomString(32)
+	nonce, err2 := oidc.RandomString(32)
+	verifier, challenge, err3 := oidc.NewPKCE()
+	if err1 != nil || err2 != nil || err3 != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+
+	now := time.Now()
+	oidcState.mu.Lock()
+	for s, p := range oidcState.pending {
+		if now.After(p.expiresAt) {
+			delete(oidcState.pending, s)
+		}
+	}
+	if len(oidcState.pending) >= maxPendingOIDCLogins {
+		oidcState.mu.Unlock()
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Too many logins in progress, try again later"})
+		return
+	}
+	oidcState.pending[state] = pendingOIDCLogin{verifier: verifier, nonce: nonce, expiresAt: now.Add(oidcLoginTTL)}
+	oidcState.mu.Unlock()
+
+	setOIDCStateCookie(c, state, int(oidcLoginTTL/time.Second))
+
+	c.JSON(http.StatusOK, gin.H{
+		"authorizationUrl": provider.AuthCodeURL(state, nonce, challenge),
+		"state":            state,
+		"expiresAt":        now.Add(oidcLoginTTL).Unix(),
+	})
+}
+
+// OIDCCallbackHandler finishes the flow: it exchanges the code, verifies the
+// ID token, maps groups to a role and signs the user in
+func OIDCCallbackHandler(c *gin.Context) {
+	cfg := currentConfig().OIDC
+	if !cfg.Enabled {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not enabled"})
+		return
+	}
+
+	var req OIDCCallbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	// The state must come from a login this browser started
+	cookie, err := c.Cookie(oidcStateCookie)
+	setOIDCStateCookie(c, "", -1)
+	if err != nil || !SecureCompare(cookie, req.State) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Login was started in another browser, please start again"})
+		return
+	}
+
+	// Each state is good for one attempt
+	oidcState.mu.Lock()
+	pending, ok := oidcState.pending[req.State]
+	delete(oidcState.pending, req.State)
+	oidcState.mu.Unlock()
+	if !ok || time.Now().After(pending.expiresAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Login expired or already used, please start again"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	provider, err := oidcProvider(ctx, cfg)
+	if err != nil {
+		respondLoginError(c, err)
+		return
+	}
+
+	token, err := provider.Exchange(ctx, req.Code, pending.verifier)
+	if err != nil {
+		log.Printf("OIDC: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "The identity provider rejected the login"})
+		return
+	}
+	claims, err := provider.VerifyIDToken(ctx, token.IDToken, pending.nonce)
+	if err != nil {
+		log.Printf("OIDC: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid identity token"})
+		return
+	}
+
+	// Some providers only release groups through the userinfo endpoint
+	if _, ok := claims[cfg.GroupsClaim]; !ok && provider.HasUserInfo() && token.AccessToken != "" {
+		info, err := provider.UserInfo(ctx, token.AccessToken)
+		if err != nil {
+			log.Printf("OIDC: %v", err)
+		} else if info.String("sub") == claims.String("sub") {
+			for k, v := range info {
+				if _, exists := claims[k]; !exists {
+					claims[k] = v
+				}
+			}
+		}
+	}
+
+	identity := oidcIdentity(cfg, claims)
+	if SecureCompare(identity.Username, currentConfig().AdminUser) {
+		respondLoginError(c, errAccountConflict)
+		return
+	}
+	if b := activeBan(models.BanKindUsername, identity.Username); b != nil {
+		respondBanned(c, b, "This account is temporarily locked")
+		return
+	}
+
+	user, err := provisionExternalUser(identity, cfg.Roles)
+	if err != nil {
+		respondLoginError(c, err)
+		return
+	}
+	completeLogin(c, user)
+}
+
+// setOIDCStateCookie stores the state of a started login in an HttpOnly
+// cookie; a negative maxAge removes it
+func setOIDCStateCookie(c *gin.Context, state string, maxAge int) {
+	secure := c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, maxAge, oidcStateCookiePath, "", secure, true)
+}
+
+// oidcIdentity reads the user from verified claims
+func oidcIdentity(cfg OIDCConfig, claims oidc.Claims) *ExternalIdentity {
+	identity := &ExternalIdentity{
+		Provider: ProviderOIDC,
+		Subject:  claims.String("sub"),
+		Email:    claims.String("email"),
+		Groups:   claims.Strings(cfg.GroupsClaim),
+	}
+	for _, claim := range []string{cfg.UsernameClaim, "preferred_username", "email", "sub"} {
+		if identity.Username = claims.String(claim); identity.Username != "" {
+			break
+		}
+	}
+	return identity
+}
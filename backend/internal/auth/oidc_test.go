@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// startOIDCLogin runs the login handler against a provider that only
+// serves discovery, returning the state and the cookie the browser got
+func startOIDCLogin(t *testing.T, router *gin.Engine) (string, *http.Cookie) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/auth/oidc/login", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("login returned %d: %s", w.Code, w.Body)
+	}
+	var body struct {
+		AuthorizationURL string `json:"authorizationUrl"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	u, err := url.Parse(body.AuthorizationURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cookie *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == oidcStateCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil || !cookie.HttpOnly || cookie.Path != oidcStateCookiePath {
+		t.Fatalf("login set no HttpOnly state cookie: %+v", cookie)
+	}
+	return u.Query().Get("state"), cookie
+}
+
+func TestOIDCCallbackRequiresStateCookie(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var provider *httptest.Server
+	provider = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]string{
+				"issuer":                 provider.URL,
+				"authorization_endpoint": provider.URL + "/authorize",
+				"token_endpoint":         provider.URL + "/token",
+				"jwks_uri":               provider.URL + "/jwks",
+			})
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		}
+	}))
+	defer provider.Close()
+
+	Initialize(Config{OIDC: OIDCConfig{
+		Enabled:     true,
+		Issuer:      provider.URL,
+		ClientID:    "biz-panel",
+		RedirectURL: "https://panel.example.org/login/callback",
+	}})
+	router := gin.New()
+	router.GET("/api/auth/oidc/login", OIDCLoginHandler)
+	router.POST("/api/auth/oidc/callback", OIDCCallbackHandler)
+
+	callback := func(state string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/oidc/callback",
+			strings.NewReader(`{"code":"code","state":"`+state+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// A callback link from a login someone else started carries their
+	// state, which this browser holds no cookie for
+	attackerState, _ := startOIDCLogin(t, router)
+	if w := callback(attackerState, nil); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "another browser") {
+		t.Fatalf("callback without cookie returned %d: %s", w.Code, w.Body)
+	}
+	_, victimCookie := startOIDCLogin(t, router)
+	if w := callback(attackerState, victimCookie); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "another browser") {
+		t.Fatalf("callback with another login's cookie returned %d: %s", w.Code, w.Body)
+	}
+
+	// The browser that started the login gets past the state check to the
+	// code exchange, which this provider refuses
+	state, cookie := startOIDCLogin(t, router)
+	w := callback(state, cookie)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("callback with the matching cookie returned %d: %s", w.Code, w.Body)
+	}
+	cleared := false
+	for _, c := range w.Result().Cookies() {
+		cleared = cleared || (c.Name == oidcStateCookie && c.MaxAge < 0)
+	}
+	if !cleared {
+		t.Fatal("callback did not clear the state cookie")
+	}
+}
@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Built-in roles
+const (
+	RoleAdmin     = "admin"
+	RoleOperator  = "operator"
+	RoleDeveloper = "developer"
+	RoleViewer    = "viewer"
+)
+
+// Permission is a "resource:action" pair. The action "*" grants every action
+// on the resource, "write" grants every action except "read", and the
+// permission "*" grants everything.
+type Permission string
+
+// Actions
+const (
+	ActionRead   = "read"
+	ActionWrite  = "write"
+	ActionDeploy = "deploy"
+)
+
+// Resources map to the route groups registered in main
+const (
+	ResourceMetrics    = "metrics"
+	ResourceProjects   = "projects"
+	ResourceDocker     = "docker"
+	ResourceWebsites   = "websites"
+	ResourceDatabases  = "databases"
+	ResourceCrons      = "crons"
+	ResourceFirewall   = "firewall"
+	ResourceSettings   = "settings"
+	ResourceActivities = "activities"
+	ResourceSystem     = "system"
+	ResourceFiles      = "files"
+	ResourceLogs       = "logs"
+	ResourceTerminal   = "terminal"
+	ResourceTemplates  = "templates"
+	ResourceSSL        = "ssl"
+	ResourceSoftware   = "software"
+	ResourcePHP        = "php"
+	ResourceServices   = "services"
+	ResourceUsers      = "users"
+	ResourceSecurity   = "security"
+	ResourceAudit      = "audit"
+)
+
+// rolePermissions lists what each built-in role may do
+var rolePermissions = map[string][]Permission{
+	RoleAdmin: {"*"},
+	RoleOperator: {
+		"metrics:*", "projects:*", "docker:*", "websites:*", "databases:*",
+		"crons:*", "firewall:*", "activities:*", "system:*", "files:*",
+		"logs:*", "terminal:*", "templates:*", "ssl:*", "software:*",
+		"php:*", "services:*", "settings:read", "security:*",
+	},
+	RoleDeveloper: {
+		"metrics:read", "projects:*", "docker:*", "templates:*", "crons:*",
+		"logs:read", "activities:read", "websites:read",
+		"databases:read", "ssl:read", "services:read", "php:read",
+	},
+	RoleViewer: {
+		"metrics:read", "projects:read", "docker:read", "websites:read",
+		"databases:read", "crons:read", "firewall:read", "activities:read",
+		"logs:read", "templates:read", "ssl:read", "software:read",
+		"php:read", "services:read",
+	},
+}
+
+// Roles returns the names of the built-in roles
+func Roles() []string {
+	return []string{RoleAdmin, RoleOperator, RoleDeveloper, RoleViewer}
+}
+
+// Resources returns every resource a permission can name
+func Resources() []string {
+	return []string{
+		ResourceMetrics, ResourceProjects, ResourceDocker, ResourceWebsites,
+		ResourceDatabases, ResourceCrons, ResourceFirewall, ResourceSettings,
+		ResourceActivities, ResourceSystem, ResourceFiles, ResourceLogs,
+		ResourceTerminal, ResourceTemplates, ResourceSSL, ResourceSoftware,
+		ResourcePHP, ResourceServices, ResourceUsers, ResourceSecurity,
+		ResourceAudit,
+	}
+}
+
+// IsValidRole reports whether role is a built-in role
+func IsValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// NewPermission builds a permission from a resource and an action
+func NewPermission(resource, action string) Permission {
+	return Permission(resource + ":" + action)
+}
+
+// IsValidPermission reports whether perm names a known resource and action
+func IsValidPermission(perm Permission) bool {
+	if perm == "*" {
+		return true
+	}
+	resource, action, ok := strings.Cut(string(perm), ":")
+	if !ok || !isResource(resource) {
+		return false
+	}
+	switch action {
+	case "*", ActionRead, ActionWrite, ActionDeploy:
+		return true
+	}
+	return false
+}
+
+func isResource(resource string) bool {
+	for _, r := range Resources() {
+		if r == resource {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPermission reports whether role grants perm
+func HasPermission(role string, perm Permission) bool {
+	return grantsAny(rolePermissions[role], perm)
+}
+
+// grantsAny reports whether any of the granted permissions covers perm
+func grantsAny(granted []Permission, perm Permission) bool {
+	resource, action, _ := strings.Cut(string(perm), ":")
+	for _, g := range granted {
+		switch g {
+		case "*", perm, Permission(resource + ":*"):
+			return true
+		case Permission(resource + ":" + ActionWrite):
+			if action != ActionRead {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// actionForMethod maps an HTTP method to the action it performs
+func actionForMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return ActionRead
+	default:
+		return ActionWrite
+	}
+}
+
+// RequireAccess checks the caller may use resource: GET requests need
+// "resource:read", everything else "resource:write"
+func RequireAccess(resource string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		checkPermission(c, NewPermission(resource, actionForMethod(c.Request.Method)))
+	}
+}
+
+// RequirePermission checks the caller holds perm regardless of method
+func RequirePermission(perm Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		checkPermission(c, perm)
+	}
+}
+
+func checkPermission(c *gin.Context, perm Permission) {
+	role := c.GetString("role")
+	allowed := HasPermission(role, perm)
+
+	// API tokens are further limited to their scopes
+	if scopes, ok := c.Get("scopes"); ok && allowed {
+		allowed = grantsAny(scopes.([]Permission), perm)
+	}
+
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":      "Insufficient permissions",
+			"permission": perm,
+		})
+		c.Abort()
+		return
+	}
+	c.Next()
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/bizino-services/biz-panel-backend/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// ReauthRequest confirms the caller's identity before a sensitive action
+type ReauthRequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code"` // TOTP or recovery code; required once 2FA is enrolled
+}
+
+// Reauthenticate checks that an interactive caller entered their password
+// again, and a second factor if they enrolled one. Otherwise it responds
+// and returns false.
+func Reauthenticate(c *gin.Context, req ReauthRequest) bool {
+	if rejectAPIToken(c) {
+		return false
+	}
+
+	// Failures count towards the account's login lockout, so a stolen
+	// session can't be used to guess the password
+	username := c.GetString("username")
+	if b := activeBan(models.BanKindUsername, username); b != nil {
+		respondBanned(c, b, "This account is temporarily locked")
+		return false
+	}
+
+	userID := c.GetString("user_id")
+	ok := false
+	if userID == BootstrapUserID {
+		ok = CheckPassword(req.Password, currentConfig().AdminPassHash)
+	} else {
+		account, err := lookupUser(userID)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+			return false
+		}
+		switch account.Provider {
+		case "":
+			ok = !account.Disabled && CheckPassword(req.Password, account.PasswordHash)
+		case ProviderLDAP:
+			cfg := currentConfig().LDAP
+			if cfg.Enabled {
+				_, err := lookupLDAPIdentity(cfg, account.Username, req.Password)
+				ok = err == nil
+			}
+		default:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Accounts that sign in with single sign-on can't confirm this action with a password"})
+			return false
+		}
+	}
+	if !ok {
+		recordLoginFailure("", username)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Password is incorrect"})
+		return false
+	}
+
+	if twoFactorEnrolled(userID) {
+		if req.Code == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Two-factor code required", "twoFactorRequired": true})
+			return false
+		}
+		if err := verifySecondFactor(userID, req.Code); err != nil {
+			recordLoginFailure("", username)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid two-factor code"})
+			return false
+		}
+	}
+	recordLoginSuccess("", username)
+	return true
+}
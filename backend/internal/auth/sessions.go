@@ -0,0 +1,341 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/bizino-services/biz-panel-backend/internal/models"
+	"github.com/bizino-services/biz-panel-backend/internal/store"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RefreshTokenPrefix starts every refresh token
+const RefreshTokenPrefix = "bzr_"
+
+// sessionTouchInterval limits how often LastSeenAt is written back to the store
+const sessionTouchInterval = 30 * time.Second
+
+// revokedSessionRetention is how long revoked sessions stay listed
+const revokedSessionRetention = 7 * 24 * time.Hour
+
+var (
+	errSessionInvalid = errors.New("session expired or revoked")
+	errRefreshReused  = errors.New("refresh token reused")
+)
+
+// RefreshRequest exchanges a refresh token for a new token pair
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
+
+// SessionInfo is the API view of a session
+type SessionInfo struct {
+	models.Session
+	Current bool `json:"current"`
+}
+
+// idleTimeout returns SecuritySettings.SessionTimeout (minutes); 0 disables it
+func idleTimeout() time.Duration {
+	if settings == nil {
+		return 0
+	}
+	s, err := settings.Get()
+	if err != nil || s.Security.SessionTimeout <= 0 {
+		return 0
+	}
+	return time.Duration(s.Security.SessionTimeout) * time.Minute
+}
+
+// generateRefreshToken returns a new random refresh token
+func generateRefreshToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return RefreshTokenPrefix + hex.EncodeToString(buf), nil
+}
+
+// startSession records a new session and issues its first token pair
+func startSession(c *gin.Context, user User) (*LoginResponse, error) {
+	refresh, err := generateRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := &models.Session{
+		ID:               uuid.New().String()[:8],
+		UserID:           user.ID,
+		Username:         user.Username,
+		Role:             user.Role,
+		RefreshTokenHash: hashToken(refresh),
+		UserAgent:        c.Request.UserAgent(),
+		IP:               c.ClientIP(),
+		CreatedAt:        now,
+		LastSeenAt:       now,
+		ExpiresAt:        now.Add(currentConfig().TokenDuration),
+	}
+	if err := sessions.Save(session); err != nil {
+		return nil, err
+	}
+	pruneSessions()
+
+	// Attribute the login in the audit log
+	c.Set("user_id", user.ID)
+	c.Set("username", user.Username)
+	c.Set("role", user.Role)
+	c.Set("session_id", session.ID)
+
+	return issueTokens(user, session, refresh)
+}
+
+// issueTokens signs an access token for session and pairs it with refresh
+func issueTokens(user User, session *models.Session, refresh string) (*LoginResponse, error) {
+	token, expiresAt, err := GenerateToken(user, session.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResponse{
+		Token:            token,
+		ExpiresAt:        expiresAt.Unix(),
+		RefreshToken:     refresh,
+		RefreshExpiresAt: session.ExpiresAt.Unix(),
+		SessionID:        session.ID,
+		User:             user,
+	}, nil
+}
+
+// sessionUsable reports whether a session is still within its lifetime and idle timeout
+func sessionUsable(s *models.Session, now time.Time) error {
+	if s.RevokedAt != nil || now.After(s.ExpiresAt) {
+		return errSessionInvalid
+	}
+	if idle := idleTimeout(); idle > 0 && now.Sub(s.LastSeenAt) > idle {
+		revokeSession(s.ID, "idle timeout")
+		return errSessionInvalid
+	}
+	return nil
+}
+
+// validateSession checks the session behind an access token and records activity
+func validateSession(id string) (*models.Session, error) {
+	if sessions == nil {
+		return nil, errSessionInvalid
+	}
+	session, err := sessions.Get(id)
+	if err != nil {
+		return nil, errSessionInvalid
+	}
+
+	now := time.Now()
+	if err := sessionUsable(session, now); err != nil {
+		return nil, err
+	}
+
+	if now.Sub(session.LastSeenAt) > sessionTouchInterval {
+		sessions.Update(id, func(s *models.Session) error {
+			s.LastSeenAt = now
+			return nil
+		})
+	}
+	return session, nil
+}
+
+// revokeSession marks one session revoked
+func revokeSession(id, reason string) error {
+	_, err := sessions.Update(id, func(s *models.Session) error {
+		if s.RevokedAt == nil {
+			now := time.Now()
+			s.RevokedAt = &now
+			s.RevokeReason = reason
+		}
+		return nil
+	})
+	return err
+}
+
+// revokeUserSessions revokes every active session of userID except exceptID
+func revokeUserSessions(userID, exceptID, reason string) (int, error) {
+	if sessions == nil {
+		return 0, nil
+	}
+	all, err := sessions.List()
+	if err != nil {
+		return 0, err
+	}
+
+	revoked := 0
+	for _, s := range all {
+		if s.UserID != userID || s.ID == exceptID || s.RevokedAt != nil {
+			continue
+		}
+		if err := revokeSession(s.ID, reason); err != nil {
+			return revoked, err
+		}
+		revoked++
+	}
+	return revoked, nil
+}
+
+// pruneSessions deletes expired sessions and old revoked ones
+func pruneSessions() {
+	all, err := sessions.List()
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	for _, s := range all {
+		if now.After(s.ExpiresAt) || (s.RevokedAt != nil && now.Sub(*s.RevokedAt) > revokedSessionRetention) {
+			sessions.Delete(s.ID)
+		}
+	}
+}
+
+// RefreshHandler rotates a refresh token and issues a new access token
+func RefreshHandler(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	resp, err := refreshSession(req.RefreshToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// refreshSession validates and rotates a refresh token
+func refreshSession(refresh string) (*LoginResponse, error) {
+	if sessions == nil {
+		return nil, errSessionInvalid
+	}
+	hash := hashToken(refresh)
+	session, err := sessions.FindByRefreshHash(hash)
+	if err != nil {
+		return nil, errSessionInvalid
+	}
+
+	// A rotated-out token being presented again means it was copied: end the session
+	if session.PreviousRefreshHash == hash {
+		revokeSession(session.ID, "refresh token reuse")
+		return nil, errRefreshReused
+	}
+	if err := sessionUsable(session, time.Now()); err != nil {
+		return nil, err
+	}
+
+	// Pick up role changes and disabled accounts
+	user := User{ID: session.UserID, Username: session.Username, Role: RoleAdmin}
+	if session.UserID != BootstrapUserID {
+		account, err := lookupUser(session.UserID)
+		if err != nil || account.Disabled {
+			revokeSession(session.ID, "account inactive")
+			return nil, errSessionInvalid
+		}
+		user.Role = account.Role
+	}
+
+	next, err := generateRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	session, err = sessions.Update(session.ID, func(s *models.Session) error {
+		if s.RefreshTokenHash != hash {
+			return errRefreshReused
+		}
+		s.PreviousRefreshHash = s.RefreshTokenHash
+		s.RefreshTokenHash = hashToken(next)
+		s.Role = user.Role
+		s.LastSeenAt = time.Now()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return issueTokens(user, session, next)
+}
+
+// LogoutHandler revokes the current session
+func LogoutHandler(c *gin.Context) {
+	sessionID := c.GetString("session_id")
+	if sessionID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Not signed in with a session"})
+		return
+	}
+
+	if err := revokeSession(sessionID, "logout"); err != nil && !errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
+
+// ListSessions returns the caller's sessions, most recently active first
+func ListSessions(c *gin.Context) {
+	all, err := sessions.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := c.GetString("user_id")
+	current := c.GetString("session_id")
+	result := make([]SessionInfo, 0)
+	for _, s := range all {
+		if s.UserID != userID {
+			continue
+		}
+		s.RefreshTokenHash = ""
+		s.PreviousRefreshHash = ""
+		result = append(result, SessionInfo{Session: *s, Current: s.ID == current})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].LastSeenAt.After(result[j].LastSeenAt)
+	})
+
+	c.JSON(http.StatusOK, result)
+}
+
+// RevokeSessionHandler revokes one of the caller's sessions
+func RevokeSessionHandler(c *gin.Context) {
+	id := c.Param("id")
+	session, err := sessions.Get(id)
+	if err != nil || session.UserID != c.GetString("user_id") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+
+	if err := revokeSession(id, "revoked by user"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked", "id": id})
+}
+
+// RevokeAllSessions signs the caller out everywhere (?keepCurrent=true spares this session)
+func RevokeAllSessions(c *gin.Context) {
+	except := ""
+	if c.Query("keepCurrent") == "true" {
+		except = c.GetString("session_id")
+	}
+
+	count, err := revokeUserSessions(c.GetString("user_id"), except, "revoked all sessions")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Sessions revoked", "revoked": count})
+}
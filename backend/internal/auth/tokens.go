@@ -0,0 +1,220 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bizino-services/biz-panel-backend/internal/models"
+	"github.com/bizino-services/biz-panel-backend/internal/store"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// APITokenPrefix starts every personal access token so it can be told apart from a JWT
+const APITokenPrefix = "bzp_"
+
+// AuthMethodToken is stored as "auth_method" for requests using an API token
+const AuthMethodToken = "token"
+
+// lastUsedInterval limits how often token usage is written back to the store
+const lastUsedInterval = time.Minute
+
+var errTokenInvalid = errors.New("invalid API token")
+
+// CreateAPITokenRequest represents a request to create an API token
+type CreateAPITokenRequest struct {
+	Name          string   `json:"name" binding:"required"`
+	Scopes        []string `json:"scopes" binding:"required,min=1"`
+	ExpiresInDays int      `json:"expiresInDays"` // 0 = never expires
+}
+
+// CreateAPITokenResponse includes the plaintext token, shown only once
+type CreateAPITokenResponse struct {
+	Token    string           `json:"token"`
+	APIToken *models.APIToken `json:"apiToken"`
+}
+
+// hashToken returns the stored form of an API or refresh token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// generateAPIToken returns a new random token
+func generateAPIToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return APITokenPrefix + hex.EncodeToString(buf), nil
+}
+
+// authenticateAPIToken resolves a token to its owner, role and scopes
+func authenticateAPIToken(raw, clientIP string) (*models.APIToken, string, error) {
+	if apiTokens == nil {
+		return nil, "", errTokenInvalid
+	}
+
+	token, err := apiTokens.FindByHash(hashToken(raw))
+	if err != nil {
+		return nil, "", errTokenInvalid
+	}
+	now := time.Now()
+	if token.RevokedAt != nil || (token.ExpiresAt != nil && now.After(*token.ExpiresAt)) {
+		return nil, "", errTokenInvalid
+	}
+
+	// The token acts with the owner's current role
+	role := RoleAdmin
+	if token.UserID != BootstrapUserID {
+		account, err := lookupUser(token.UserID)
+		if err != nil || account.Disabled {
+			return nil, "", errTokenInvalid
+		}
+		role = account.Role
+	}
+
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > lastUsedInterval || token.LastUsedIP != clientIP {
+		apiTokens.Update(token.ID, func(t *models.APIToken) error {
+			t.LastUsedAt = &now
+			t.LastUsedIP = clientIP
+			return nil
+		})
+	}
+
+	return token, role, nil
+}
+
+// tokenScopes converts stored scopes to permissions
+func tokenScopes(token *models.APIToken) []Permission {
+	scopes := make([]Permission, len(token.Scopes))
+	for i, s := range token.Scopes {
+		scopes[i] = Permission(s)
+	}
+	return scopes
+}
+
+// rejectAPIToken stops token-authenticated requests from managing credentials
+func rejectAPIToken(c *gin.Context) bool {
+	if c.GetString("auth_method") == AuthMethodToken {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This action requires an interactive login"})
+		return true
+	}
+	return false
+}
+
+// ListAPITokens returns the caller's tokens; admins may pass ?all=true
+func ListAPITokens(c *gin.Context) {
+	tokens, err := apiTokens.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	all := c.Query("all") == "true" && c.GetString("role") == RoleAdmin
+	userID := c.GetString("user_id")
+
+	result := make([]*models.APIToken, 0)
+	for _, t := range tokens {
+		if all || t.UserID == userID {
+			t.TokenHash = ""
+			result = append(result, t)
+		}
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// CreateAPIToken issues a token limited to scopes the caller already holds
+func CreateAPIToken(c *gin.Context) {
+	if rejectAPIToken(c) {
+		return
+	}
+
+	var req CreateAPITokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	role := c.GetString("role")
+	for i, s := range req.Scopes {
+		scope := Permission(strings.TrimSpace(s))
+		if !IsValidPermission(scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid scope: " + s})
+			return
+		}
+		if !grantsAny(rolePermissions[role], scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Your role does not grant scope: " + s})
+			return
+		}
+		req.Scopes[i] = string(scope)
+	}
+
+	raw, err := generateAPIToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	now := time.Now()
+	token := &models.APIToken{
+		ID:        uuid.New().String()[:8],
+		Name:      req.Name,
+		UserID:    c.GetString("user_id"),
+		Username:  c.GetString("username"),
+		Prefix:    raw[:len(APITokenPrefix)+8],
+		TokenHash: hashToken(raw),
+		Scopes:    req.Scopes,
+		CreatedAt: now,
+	}
+	if req.ExpiresInDays > 0 {
+		expiresAt := now.AddDate(0, 0, req.ExpiresInDays)
+		token.ExpiresAt = &expiresAt
+	}
+
+	if err := apiTokens.Save(token); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	token.TokenHash = ""
+	c.JSON(http.StatusCreated, CreateAPITokenResponse{Token: raw, APIToken: token})
+}
+
+// RevokeAPIToken revokes one of the caller's tokens (admins may revoke any)
+func RevokeAPIToken(c *gin.Context) {
+	if rejectAPIToken(c) {
+		return
+	}
+
+	id := c.Param("id")
+	userID := c.GetString("user_id")
+	isAdmin := c.GetString("role") == RoleAdmin
+
+	token, err := apiTokens.Update(id, func(t *models.APIToken) error {
+		if t.UserID != userID && !isAdmin {
+			return store.ErrNotFound
+		}
+		if t.RevokedAt == nil {
+			now := time.Now()
+			t.RevokedAt = &now
+		}
+		return nil
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Token revoked", "id": token.ID})
+}
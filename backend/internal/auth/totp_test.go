@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bizino-services/biz-panel-backend/internal/models"
+	"github.com/bizino-services/biz-panel-backend/internal/store"
+)
+
+// rfcSecret is the shared secret of the RFC 4226 and RFC 6238 test vectors
+var rfcSecret = totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestHOTPVectors(t *testing.T) {
+	// RFC 4226 Appendix D
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for counter, code := range want {
+		got, err := totpCode(rfcSecret, int64(counter))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != code {
+			t.Errorf("HOTP(%d) = %s, want %s", counter, got, code)
+		}
+	}
+}
+
+func TestTOTPVectors(t *testing.T) {
+	// RFC 6238 Appendix B (SHA-1), cut to the six digits apps show
+	for unix, code := range map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	} {
+		at := time.Unix(unix, 0)
+		got, err := totpCode(rfcSecret, totpStep(at))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != code {
+			t.Errorf("TOTP at %d = %s, want %s", unix, got, code)
+		}
+		if step, ok := ValidateTOTP(rfcSecret, code, at); !ok || step != totpStep(at) {
+			t.Errorf("ValidateTOTP rejected the code at %d", unix)
+		}
+	}
+}
+
+func TestValidateTOTPWindow(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := totpStep(now)
+	for offset, accepted := range map[int64]bool{-2: false, -1: true, 0: true, 1: true, 2: false} {
+		code, _ := totpCode(rfcSecret, current+offset)
+		step, ok := ValidateTOTP(rfcSecret, code, now)
+		if ok != accepted {
+			t.Errorf("code of step %+d accepted = %v, want %v", offset, ok, accepted)
+		}
+		if ok && step != current+offset {
+			t.Errorf("code of step %+d matched step %+d", offset, step-current)
+		}
+	}
+
+	code, _ := totpCode(rfcSecret, current)
+	if _, ok := ValidateTOTP(rfcSecret, code[:3]+" "+code[3:], now); !ok {
+		t.Error("code with a space rejected")
+	}
+	for _, bad := range []string{"", "12345", "1234567", "abcdef"} {
+		if _, ok := ValidateTOTP(rfcSecret, bad, now); ok {
+			t.Errorf("ValidateTOTP accepted %q", bad)
+		}
+	}
+}
+
+func TestVerifySecondFactor(t *testing.T) {
+	SetStore(store.NewMemory())
+	defer SetStore(store.NewMemory())
+
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := twoFactors.Save(&models.TwoFactor{UserID: "u1", Secret: secret, Enabled: true, RecoveryCodes: hashes}); err != nil {
+		t.Fatal(err)
+	}
+	codeAt := func(offset int64) string {
+		code, _ := totpCode(secret, totpStep(time.Now())+offset)
+		return code
+	}
+
+	previous, current := codeAt(-1), codeAt(0)
+	if err := verifySecondFactor("u1", current); err != nil {
+		t.Fatalf("current code rejected: %v", err)
+	}
+	// An accepted code, or one from an earlier step, can't be used again
+	if err := verifySecondFactor("u1", current); err == nil {
+		t.Fatal("replayed code accepted")
+	}
+	if err := verifySecondFactor("u1", previous); err == nil {
+		t.Fatal("code older than the last accepted one accepted")
+	}
+	if err := verifySecondFactor("u1", codeAt(1)); err != nil {
+		t.Fatalf("code of the next step rejected: %v", err)
+	}
+
+	// Recovery codes are accepted once, with or without the dash
+	if err := verifySecondFactor("u1", codes[0]); err != nil {
+		t.Fatalf("recovery code rejected: %v", err)
+	}
+	if err := verifySecondFactor("u1", codes[0]); err == nil {
+		t.Fatal("used recovery code accepted again")
+	}
+	if err := verifySecondFactor("u1", strings.ReplaceAll(codes[1], "-", "")); err != nil {
+		t.Fatalf("recovery code without the dash rejected: %v", err)
+	}
+
+	if err := verifySecondFactor("nobody", codeAt(0)); err == nil {
+		t.Fatal("code accepted for a user without 2FA")
+	}
+	twoFactors.Save(&models.TwoFactor{UserID: "u2", PendingSecret: secret})
+	if err := verifySecondFactor("u2", codeAt(0)); err == nil {
+		t.Fatal("code accepted for an unfinished enrollment")
+	}
+}
@@ -0,0 +1,344 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bizino-services/biz-panel-backend/internal/models"
+	"github.com/bizino-services/biz-panel-backend/internal/store"
+	"github.com/gin-gonic/gin"
+)
+
+// challengeDuration is how long a user has to enter their code after the password step
+const challengeDuration = 5 * time.Minute
+
+// recoveryCodeCount is the number of recovery codes issued per enrollment
+const recoveryCodeCount = 10
+
+// tokenTypeChallenge marks a JWT that only proves the password step passed
+const tokenTypeChallenge = "2fa_challenge"
+
+var errInvalidCode = errors.New("invalid two-factor code")
+
+// TwoFactorChallengeResponse is returned by login when a second factor is needed
+type TwoFactorChallengeResponse struct {
+	TwoFactorRequired bool   `json:"twoFactorRequired"`
+	ChallengeToken    string `json:"challengeToken"`
+	ExpiresAt         int64  `json:"expiresAt"`
+}
+
+// TwoFactorLoginRequest exchanges a challenge token and a code for a session
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challengeToken" binding:"required"`
+	Code           string `json:"code" binding:"required"` // TOTP or recovery code
+}
+
+// TwoFactorCodeRequest carries a TOTP code
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// DisableTwoFactorRequest requires both the password and a current code
+type DisableTwoFactorRequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+// twoFactorRequired reports whether the config file or panel settings enforce 2FA
+func twoFactorRequired() bool {
+	if currentConfig().Require2FA {
+		return true
+	}
+	if settings == nil {
+		return false
+	}
+	s, err := settings.Get()
+	return err == nil && s.Security.TwoFactorEnabled
+}
+
+// twoFactorEnrolled reports whether userID has a verified authenticator
+func twoFactorEnrolled(userID string) bool {
+	if twoFactors == nil {
+		return false
+	}
+	tf, err := twoFactors.Get(userID)
+	return err == nil && tf.Enabled
+}
+
+// generateChallengeToken issues a short-lived token for the second login step
+func generateChallengeToken(user User) (string, time.Time, error) {
+	return signToken(Claims{
+		UserID:    user.ID,
+		Username:  user.Username,
+		Role:      user.Role,
+		TokenType: tokenTypeChallenge,
+	}, challengeDuration)
+}
+
+// verifySecondFactor accepts a TOTP code or consumes a recovery code
+func verifySecondFactor(userID, code string) error {
+	if twoFactors == nil {
+		return errInvalidCode
+	}
+
+	_, err := twoFactors.Update(userID, func(tf *models.TwoFactor) error {
+		if !tf.Enabled {
+			return errInvalidCode
+		}
+		if step, ok := ValidateTOTP(tf.Secret, code, time.Now()); ok {
+			if step <= tf.LastUsedStep {
+				return errInvalidCode
+			}
+			tf.LastUsedStep = step
+			tf.UpdatedAt = time.Now()
+			return nil
+		}
+
+		hash := hashRecoveryCode(code)
+		for i, stored := range tf.RecoveryCodes {
+			if SecureCompare(hash, stored) {
+				tf.RecoveryCodes = append(tf.RecoveryCodes[:i], tf.RecoveryCodes[i+1:]...)
+				tf.UpdatedAt = time.Now()
+				return nil
+			}
+		}
+		return errInvalidCode
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		return errInvalidCode
+	}
+	return err
+}
+
+// generateRecoveryCodes returns plaintext codes and their hashes
+func generateRecoveryCodes() ([]string, []string, error) {
+	const alphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		buf := make([]byte, 10)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		for j := range buf {
+			buf[j] = alphabet[int(buf[j])%len(alphabet)]
+		}
+		codes[i] = string(buf[:5]) + "-" + string(buf[5:])
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode normalizes and hashes a recovery code
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// TwoFactorLoginHandler completes a login that was challenged for a second factor
+func TwoFactorLoginHandler(c *gin.Context) {
+	var req TwoFactorLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	claims, err := ValidateToken(req.ChallengeToken)
+	if err != nil || claims.TokenType != tokenTypeChallenge {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired challenge"})
+		return
+	}
+
+	if b := activeBan(models.BanKindUsername, claims.Username); b != nil {
+		respondBanned(c, b, "This account is temporarily locked")
+		return
+	}
+	if err := verifySecondFactor(claims.UserID, req.Code); err != nil {
+		recordLoginFailure(c.ClientIP(), claims.Username)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid two-factor code"})
+		return
+	}
+	recordLoginSuccess(c.ClientIP(), claims.Username)
+
+	user := User{ID: claims.UserID, Username: claims.Username, Role: claims.Role}
+	resp, err := startSession(c, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// GetTwoFactorStatus returns the caller's enrollment state
+func GetTwoFactorStatus(c *gin.Context) {
+	status := gin.H{"enabled": false, "required": twoFactorRequired(), "recoveryCodesRemaining": 0}
+	if twoFactors != nil {
+		if tf, err := twoFactors.Get(c.GetString("user_id")); err == nil {
+			status["enabled"] = tf.Enabled
+			status["recoveryCodesRemaining"] = len(tf.RecoveryCodes)
+			status["enabledAt"] = tf.EnabledAt
+		}
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// SetupTwoFactor starts enrollment by generating a new pending secret
+func SetupTwoFactor(c *gin.Context) {
+	if rejectAPIToken(c) {
+		return
+	}
+
+	userID := c.GetString("user_id")
+	if twoFactorEnrolled(userID) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+		return
+	}
+
+	if err := twoFactors.Save(&models.TwoFactor{
+		UserID:        userID,
+		PendingSecret: secret,
+		UpdatedAt:     time.Now(),
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":     secret,
+		"otpauthUrl": TOTPURI(c.GetString("username"), secret),
+		"period":     totpPeriod,
+		"digits":     totpDigits,
+	})
+}
+
+// VerifyTwoFactor confirms enrollment with the first code and returns recovery codes
+func VerifyTwoFactor(c *gin.Context) {
+	if rejectAPIToken(c) {
+		return
+	}
+
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+		return
+	}
+
+	_, err = twoFactors.Update(c.GetString("user_id"), func(tf *models.TwoFactor) error {
+		if tf.PendingSecret == "" {
+			return store.ErrNotFound
+		}
+		step, ok := ValidateTOTP(tf.PendingSecret, req.Code, time.Now())
+		if !ok {
+			return errInvalidCode
+		}
+		now := time.Now()
+		tf.Secret = tf.PendingSecret
+		tf.PendingSecret = ""
+		tf.Enabled = true
+		tf.RecoveryCodes = hashes
+		tf.LastUsedStep = step
+		tf.EnabledAt = &now
+		tf.UpdatedAt = now
+		return nil
+	})
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Start two-factor setup first"})
+		return
+	case errors.Is(err, errInvalidCode):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid two-factor code"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Two-factor authentication enabled",
+		"recoveryCodes": codes,
+	})
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes after checking a TOTP code
+func RegenerateRecoveryCodes(c *gin.Context) {
+	if rejectAPIToken(c) {
+		return
+	}
+
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	userID := c.GetString("user_id")
+	if err := verifySecondFactor(userID, req.Code); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid two-factor code"})
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+		return
+	}
+
+	if _, err := twoFactors.Update(userID, func(tf *models.TwoFactor) error {
+		tf.RecoveryCodes = hashes
+		tf.UpdatedAt = time.Now()
+		return nil
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
+}
+
+// DisableTwoFactor removes the caller's enrollment
+func DisableTwoFactor(c *gin.Context) {
+	if rejectAPIToken(c) {
+		return
+	}
+
+	var req DisableTwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	userID := c.GetString("user_id")
+	if !twoFactorEnrolled(userID) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+	if !Reauthenticate(c, ReauthRequest{Password: req.Password, Code: req.Code}) {
+		return
+	}
+
+	if _, err := twoFactors.Delete(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
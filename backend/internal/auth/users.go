@@ -0,0 +1,291 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bizino-services/biz-panel-backend/internal/models"
+	"github.com/bizino-services/biz-panel-backend/internal/store"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+var errExternalPassword = errors.New("the password of this account is managed by its identity provider")
+
+// UserInfo is the API view of an account (never includes the password hash)
+type UserInfo struct {
+	ID          string     `json:"id"`
+	Username    string     `json:"username"`
+	Email       string     `json:"email,omitempty"`
+	Role        string     `json:"role"`
+	Provider    string     `json:"provider,omitempty"` // oidc, ldap; empty for local accounts
+	Disabled    bool       `json:"disabled"`
+	Builtin     bool       `json:"builtin"` // Defined in the config file
+	LastLoginAt *time.Time `json:"lastLoginAt,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+}
+
+// CreateUserRequest represents a request to create an account
+type CreateUserRequest struct {
+	Username string `json:"username" binding:"required,min=3"`
+	Email    string `json:"email"`
+	Password string `json:"password" binding:"required,min=8"`
+	Role     string `json:"role" binding:"required"`
+}
+
+// UpdateUserRequest represents a request to update an account
+type UpdateUserRequest struct {
+	Email    *string `json:"email"`
+	Password string  `json:"password" binding:"omitempty,min=8"`
+	Role     string  `json:"role"`
+	Disabled *bool   `json:"disabled"`
+}
+
+func toUserInfo(u *models.User) UserInfo {
+	return UserInfo{
+		ID:          u.ID,
+		Username:    u.Username,
+		Email:       u.Email,
+		Role:        u.Role,
+		Provider:    u.Provider,
+		Disabled:    u.Disabled,
+		LastLoginAt: u.LastLoginAt,
+		CreatedAt:   u.CreatedAt,
+		UpdatedAt:   u.UpdatedAt,
+	}
+}
+
+func bootstrapUserInfo() UserInfo {
+	return UserInfo{
+		ID:       BootstrapUserID,
+		Username: currentConfig().AdminUser,
+		Role:     RoleAdmin,
+		Builtin:  true,
+	}
+}
+
+// usernameTaken reports whether username belongs to another account
+func usernameTaken(username string) (bool, error) {
+	if SecureCompare(username, currentConfig().AdminUser) {
+		return true, nil
+	}
+	_, err := users.FindByUsername(username)
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// setUserPassword hashes and stores a new password for an account
+func setUserPassword(id, password string) error {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	_, err = users.Update(id, func(u *models.User) error {
+		u.PasswordHash = hash
+		u.UpdatedAt = time.Now()
+		return nil
+	})
+	return err
+}
+
+// ListUsers returns all accounts, including the config administrator
+func ListUsers(c *gin.Context) {
+	accounts, err := users.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	result := make([]UserInfo, 0, len(accounts)+1)
+	result = append(result, bootstrapUserInfo())
+	for _, u := range accounts {
+		result = append(result, toUserInfo(u))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"users": result, "roles": Roles()})
+}
+
+// GetUser returns a single account
+func GetUser(c *gin.Context) {
+	id := c.Param("id")
+	if id == BootstrapUserID {
+		c.JSON(http.StatusOK, bootstrapUserInfo())
+		return
+	}
+
+	account, err := users.Get(id)
+	if err != nil {
+		respondUserError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, toUserInfo(account))
+}
+
+// CreateUser creates an account with a bcrypt-hashed password
+func CreateUser(c *gin.Context) {
+	if rejectAPIToken(c) {
+		return
+	}
+
+	var req CreateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	req.Username = strings.TrimSpace(req.Username)
+	if !IsValidRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role", "roles": Roles()})
+		return
+	}
+
+	taken, err := usernameTaken(req.Username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if taken {
+		c.JSON(http.StatusConflict, gin.H{"error": "Username already exists"})
+		return
+	}
+
+	hash, err := HashPassword(req.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	now := time.Now()
+	account := &models.User{
+		ID:           uuid.New().String()[:8],
+		Username:     req.Username,
+		Email:        req.Email,
+		PasswordHash: hash,
+		Role:         req.Role,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+
+	if err := users.Save(account); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, toUserInfo(account))
+}
+
+// UpdateUser changes an account's role, email, password or disabled flag
+func UpdateUser(c *gin.Context) {
+	if rejectAPIToken(c) {
+		return
+	}
+
+	id := c.Param("id")
+	if id == BootstrapUserID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The built-in administrator is managed in the config file"})
+		return
+	}
+
+	var req UpdateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Role != "" && !IsValidRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role", "roles": Roles()})
+		return
+	}
+
+	// Don't let admins lock themselves out
+	if id == c.GetString("user_id") {
+		if (req.Role != "" && req.Role != RoleAdmin && c.GetString("role") == RoleAdmin) || (req.Disabled != nil && *req.Disabled) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot demote or disable your own account"})
+			return
+		}
+	}
+
+	var hash string
+	if req.Password != "" {
+		var err error
+		if hash, err = HashPassword(req.Password); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+			return
+		}
+	}
+
+	account, err := users.Update(id, func(u *models.User) error {
+		if hash != "" && u.Provider != "" {
+			return errExternalPassword
+		}
+		if req.Email != nil {
+			u.Email = *req.Email
+		}
+		if req.Role != "" {
+			u.Role = req.Role
+		}
+		if req.Disabled != nil {
+			u.Disabled = *req.Disabled
+		}
+		if hash != "" {
+			u.PasswordHash = hash
+		}
+		u.UpdatedAt = time.Now()
+		return nil
+	})
+	if errors.Is(err, errExternalPassword) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		respondUserError(c, err)
+		return
+	}
+
+	// A password reset or disabling the account signs the user out everywhere
+	if hash != "" || account.Disabled {
+		revokeUserSessions(id, "", "account updated by administrator")
+	}
+
+	c.JSON(http.StatusOK, toUserInfo(account))
+}
+
+// DeleteUser removes an account
+func DeleteUser(c *gin.Context) {
+	if rejectAPIToken(c) {
+		return
+	}
+
+	id := c.Param("id")
+	if id == BootstrapUserID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The built-in administrator is managed in the config file"})
+		return
+	}
+	if id == c.GetString("user_id") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot delete your own account"})
+		return
+	}
+
+	if _, err := users.Delete(id); err != nil {
+		respondUserError(c, err)
+		return
+	}
+	revokeUserSessions(id, "", "account deleted")
+	removeUserMemberships(id)
+
+	c.JSON(http.StatusOK, gin.H{"message": "User deleted", "id": id})
+}
+
+func respondUserError(c *gin.Context, err error) {
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// WSTicketPrefix starts every WebSocket ticket
+const WSTicketPrefix = "bzw_"
+
+// WSTicketProtocol is the subprotocol the panel speaks over WebSockets. A
+// browser passes its ticket as a second subprotocol, WSTicketProtocol + "." + ticket.
+const WSTicketProtocol = "biz-panel"
+
+// wsTicketTTL is how long a ticket may wait before the socket is opened
+const wsTicketTTL = 30 * time.Second
+
+// maxWSTickets bounds the tickets awaiting redemption
+const maxWSTickets = 10000
+
+var errTicketInvalid = errors.New("invalid WebSocket ticket")
+
+// wsTicket is a single-use grant to open one WebSocket as the issuing caller
+type wsTicket struct {
+	userID     string
+	username   string
+	sessionID  string
+	tokenID    string
+	authMethod string
+	scopes     []Permission
+	clientIP   string
+	authExpiry time.Time // When the credential the ticket was issued for expires
+	expiresAt  time.Time
+}
+
+// wsTickets holds issued tickets, keyed by ticket
+var wsTickets = struct {
+	mu      sync.Mutex
+	pending map[string]*wsTicket
+}{pending: make(map[string]*wsTicket)}
+
+// CreateWSTicket issues a short-lived ticket for opening a WebSocket
+func CreateWSTicket(c *gin.Context) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create ticket"})
+		return
+	}
+	raw := WSTicketPrefix + hex.EncodeToString(buf)
+
+	now := time.Now()
+	ticket := &wsTicket{
+		userID:     c.GetString("user_id"),
+		username:   c.GetString("username"),
+		sessionID:  c.GetString("session_id"),
+		tokenID:    c.GetString("token_id"),
+		authMethod: c.GetString("auth_method"),
+		clientIP:   c.ClientIP(),
+		authExpiry: CredentialExpiry(c),
+		expiresAt:  now.Add(wsTicketTTL),
+	}
+	if scopes, ok := c.Get("scopes"); ok {
+		ticket.scopes, _ = scopes.([]Permission)
+	}
+	if !ticket.authExpiry.IsZero() && ticket.authExpiry.Before(ticket.expiresAt) {
+		ticket.expiresAt = ticket.authExpiry
+	}
+
+	wsTickets.mu.Lock()
+	for t, p := range wsTickets.pending {
+		if now.After(p.expiresAt) {
+			delete(wsTickets.pending, t)
+		}
+	}
+	if len(wsTickets.pending) >= maxWSTickets {
+		wsTickets.mu.Unlock()
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Too many tickets outstanding, try again later"})
+		return
+	}
+	wsTickets.pending[raw] = ticket
+	wsTickets.mu.Unlock()
+
+	c.JSON(http.StatusCreated, gin.H{
+		"ticket":    raw,
+		"protocol":  WSTicketProtocol,
+		"expiresAt": ticket.expiresAt.Unix(),
+	})
+}
+
+// requestTicket returns the ticket in the ?ticket= parameter or the
+// Sec-WebSocket-Protocol header of an upgrade request
+func requestTicket(r *http.Request) string {
+	if !websocket.IsWebSocketUpgrade(r) {
+		return ""
+	}
+	if t := r.URL.Query().Get("ticket"); t != "" {
+		return t
+	}
+	for _, p := range websocket.Subprotocols(r) {
+		if t, ok := strings.CutPrefix(p, WSTicketProtocol+"."); ok {
+			return t
+		}
+	}
+	return ""
+}
+
+// redeemTicket consumes a ticket and sets the caller it was issued to
+func redeemTicket(c *gin.Context, raw string) error {
+	wsTickets.mu.Lock()
+	ticket, ok := wsTickets.pending[raw]
+	delete(wsTickets.pending, raw)
+	wsTickets.mu.Unlock()
+
+	if !ok || time.Now().After(ticket.expiresAt) || ticket.clientIP != c.ClientIP() {
+		return errTicketInvalid
+	}
+
+	// The credential may have been revoked since the ticket was issued
+	role, err := credentialRole(ticket.userID, ticket.sessionID, ticket.tokenID)
+	if err != nil {
+		return errTicketInvalid
+	}
+
+	c.Set("user_id", ticket.userID)
+	c.Set("username", ticket.username)
+	c.Set("role", role)
+	if ticket.sessionID != "" {
+		c.Set("session_id", ticket.sessionID)
+	}
+	if ticket.authMethod != "" {
+		c.Set("auth_method", ticket.authMethod)
+		c.Set("token_id", ticket.tokenID)
+		c.Set("scopes", ticket.scopes)
+	}
+	if !ticket.authExpiry.IsZero() {
+		c.Set("auth_expires_at", ticket.authExpiry)
+	}
+	return nil
+}
+
+// credentialRole checks that the session or API token behind a request is
+// still usable and returns the owner's current role
+func credentialRole(userID, sessionID, tokenID string) (string, error) {
+	if tokenID != "" {
+		if apiTokens == nil {
+			return "", errTokenInvalid
+		}
+		token, err := apiTokens.Get(tokenID)
+		if err != nil || token.RevokedAt != nil || (token.ExpiresAt != nil && time.Now().After(*token.ExpiresAt)) {
+			return "", errTokenInvalid
+		}
+	} else if _, err := validateSession(sessionID); err != nil {
+		return "", err
+	}
+
+	if userID == BootstrapUserID {
+		return RoleAdmin, nil
+	}
+	account, err := lookupUser(userID)
+	if err != nil || account.Disabled {
+		return "", errSessionInvalid
+	}
+	return account.Role, nil
+}
+
+// CredentialExpiry returns when the credential of an authenticated request
+// expires; zero for API tokens without an expiry
+func CredentialExpiry(c *gin.Context) time.Time {
+	return c.GetTime("auth_expires_at")
+}
+
+// CredentialActive reports whether the caller's session or API token is
+// still valid and the account still has the role it was authorized with.
+// Long-lived connections such as WebSockets poll it.
+func CredentialActive(c *gin.Context) bool {
+	if exp := CredentialExpiry(c); !exp.IsZero() && time.Now().After(exp) {
+		return false
+	}
+	role, err := credentialRole(c.GetString("user_id"), c.GetString("session_id"), c.GetString("token_id"))
+	return err == nil && role == c.GetString("role")
+}
@@ -0,0 +1,220 @@
+// Package buildpack detects how to build repositories that have no
+// Dockerfile and generates one for them.
+package buildpack
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Runtimes a plan can use
+const (
+	RuntimeNode   = "node"
+	RuntimePHP    = "php"
+	RuntimeGo     = "go"
+	RuntimePython = "python"
+	RuntimeStatic = "static"
+)
+
+// DockerfileName is the name of the generated Dockerfile in the checkout
+const DockerfileName = ".biz-panel.Dockerfile"
+
+var (
+	ErrNotDetected    = errors.New("the repository has no Dockerfile and isn't a recognised Go, PHP, Node.js, Python or static site")
+	ErrInvalidCommand = errors.New("invalid build command")
+)
+
+// Overrides replace the detected commands; empty fields keep them
+type Overrides struct {
+	Install string
+	Build   string
+	Start   string
+}
+
+// Plan describes how a repository is built and run
+type Plan struct {
+	Runtime    string `json:"runtime"`
+	Version    string `json:"version,omitempty"` // Of the runtime's base image
+	Install    string `json:"install,omitempty"`
+	Build      string `json:"build,omitempty"`
+	Start      string `json:"start"`
+	Port       uint16 `json:"port"`
+	Dockerfile string `json:"dockerfile"` // Generated from the fields above
+
+	docroot string // PHP document root within the app
+}
+
+// detectors are tried in order; the first to recognise the repository wins.
+// Go and PHP come first since their repositories often carry a package.json
+// for frontend tooling.
+var detectors = []func(dir string) (*Plan, error){
+	detectGo,
+	detectPHP,
+	detectNode,
+	detectPython,
+	detectStatic,
+}
+
+// Detect inspects a checkout and plans its build, applying the overrides
+func Detect(dir string, overrides Overrides) (*Plan, error) {
+	for _, cmd := range []string{overrides.Install, overrides.Build, overrides.Start} {
+		if strings.ContainsAny(cmd, "\r\n") {
+			return nil, fmt.Errorf("%w: commands must be a single line", ErrInvalidCommand)
+		}
+	}
+
+	var plan *Plan
+	for _, detect := range detectors {
+		p, err := detect(dir)
+		if err != nil {
+			return nil, err
+		}
+		if p != nil {
+			plan = p
+			break
+		}
+	}
+	if plan == nil {
+		return nil, ErrNotDetected
+	}
+
+	if overrides.Install != "" {
+		plan.Install = overrides.Install
+	}
+	if overrides.Build != "" {
+		plan.Build = overrides.Build
+	}
+	if overrides.Start != "" {
+		plan.Start = overrides.Start
+	}
+	if plan.Runtime == RuntimeStatic && (plan.Install != "" || plan.Build != "") {
+		return nil, fmt.Errorf("%w: static sites are served as they are and have no install or build step", ErrInvalidCommand)
+	}
+	if plan.Start == "" {
+		return nil, fmt.Errorf("%w: couldn't tell how to start this %s repository; set a start command", ErrInvalidCommand, Name(plan.Runtime))
+	}
+
+	plan.Dockerfile = plan.render()
+	return plan, nil
+}
+
+// Name returns the display name of a runtime
+func Name(runtime string) string {
+	switch runtime {
+	case RuntimeNode:
+		return "Node.js"
+	case RuntimePHP:
+		return "PHP"
+	case RuntimeGo:
+		return "Go"
+	case RuntimePython:
+		return "Python"
+	case RuntimeStatic:
+		return "static site"
+	}
+	return runtime
+}
+
+// render writes the plan as a multi-stage Dockerfile: dependencies are
+// installed and the app built in a build stage, whose result is copied to a
+// slimmer runtime image
+func (p *Plan) render() string {
+	var b strings.Builder
+	line := func(format string, args ...interface{}) {
+		fmt.Fprintf(&b, format+"\n", args...)
+	}
+	run := func(cmd string) {
+		if cmd != "" {
+			line("RUN %s", cmd)
+		}
+	}
+
+	line("# Generated by biz-panel: %s", strings.TrimSpace(Name(p.Runtime)+" "+p.Version))
+	switch p.Runtime {
+	case RuntimeNode:
+		image := "node:" + p.Version + "-alpine"
+		line("FROM %s AS build", image)
+		line("WORKDIR /app")
+		line("COPY . .")
+		run(p.Install)
+		run(p.Build)
+		line("")
+		line("FROM %s", image)
+		line("WORKDIR /app")
+		line("ENV NODE_ENV=production PORT=%d", p.Port)
+		line("COPY --from=build /app ./")
+
+	case RuntimePHP:
+		line("FROM composer:2 AS build")
+		line("WORKDIR /app")
+		line("COPY . .")
+		run(p.Install)
+		run(p.Build)
+		line("")
+		line("FROM php:%s-apache", p.Version)
+		if p.docroot != "" {
+			line("ENV APACHE_DOCUMENT_ROOT=/var/www/html/%s", p.docroot)
+			line("RUN sed -ri -e 's!/var/www/html!${APACHE_DOCUMENT_ROOT}!g' /etc/apache2/sites-available/*.conf")
+		}
+		line("RUN a2enmod rewrite")
+		line("COPY --from=build --chown=www-data:www-data /app /var/www/html")
+
+	case RuntimeGo:
+		line("FROM golang:%s-alpine AS build", p.Version)
+		line("WORKDIR /src")
+		line("COPY . .")
+		run(p.Install)
+		run(p.Build)
+		line("")
+		line("FROM alpine:3.20")
+		line("RUN apk add --no-cache ca-certificates tzdata")
+		line("WORKDIR /app")
+		line("ENV PORT=%d", p.Port)
+		line("COPY --from=build /out/ /app/")
+
+	case RuntimePython:
+		image := "python:" + p.Version + "-slim"
+		line("FROM %s AS build", image)
+		line("WORKDIR /app")
+		line("RUN python -m venv /venv")
+		line("ENV PATH=/venv/bin:$PATH")
+		line("COPY . .")
+		run(p.Install)
+		run(p.Build)
+		line("")
+		line("FROM %s", image)
+		line("WORKDIR /app")
+		line("ENV PATH=/venv/bin:$PATH PYTHONUNBUFFERED=1 PORT=%d", p.Port)
+		line("COPY --from=build /venv /venv")
+		line("COPY --from=build /app /app")
+
+	case RuntimeStatic:
+		line("FROM nginx:alpine")
+		line("COPY . /usr/share/nginx/html")
+	}
+
+	line("EXPOSE %d", p.Port)
+	// exec lets the app receive stop signals instead of the shell
+	cmd, _ := json.Marshal([]string{"/bin/sh", "-c", "exec " + p.Start})
+	line("CMD %s", cmd)
+	return b.String()
+}
+
+// exists reports whether a file exists in the checkout
+func exists(dir, name string) bool {
+	_, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name)))
+	return err == nil
+}
+
+// readFile reads a file of the checkout, returning "" if it doesn't exist
+func readFile(dir, name string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	return string(data), err
+}
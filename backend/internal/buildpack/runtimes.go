@@ -0,0 +1,266 @@
+package buildpack
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Default versions of the runtime base images
+const (
+	defaultNodeVersion   = "22"
+	defaultPHPVersion    = "8.3"
+	defaultGoVersion     = "1.24"
+	defaultPythonVersion = "3.12"
+)
+
+var (
+	majorVersion      = regexp.MustCompile(`\d+`)
+	minorVersion      = regexp.MustCompile(`\d+\.\d+`)
+	goDirective       = regexp.MustCompile(`(?m)^go\s+(\d+\.\d+)`)
+	moduleDirective   = regexp.MustCompile(`(?m)^module\s+(\S+)`)
+	packageMainClause = regexp.MustCompile(`(?m)^package\s+main\b`)
+)
+
+// detectGo plans a Go module, building its main package into a static
+// binary
+func detectGo(dir string) (*Plan, error) {
+	mod, err := readFile(dir, "go.mod")
+	if err != nil || mod == "" {
+		return nil, err
+	}
+
+	plan := &Plan{Runtime: RuntimeGo, Version: defaultGoVersion, Port: 8080}
+	if m := goDirective.FindStringSubmatch(mod); m != nil {
+		plan.Version = m[1]
+	}
+	pkg, err := goMainPackage(dir, mod)
+	if err != nil {
+		return nil, err
+	}
+	plan.Install = "go mod download"
+	plan.Build = "CGO_ENABLED=0 go build -trimpath -ldflags='-s -w' -o /out/app " + pkg
+	plan.Start = "/app/app"
+	return plan, nil
+}
+
+// goMainPackage finds the package to build: the module root if it is a
+// main package, else the command under cmd/ named like the module or the
+// first one
+func goMainPackage(dir, mod string) (string, error) {
+	isMain := func(pkgDir string) (bool, error) {
+		files, err := filepath.Glob(filepath.Join(pkgDir, "*.go"))
+		if err != nil {
+			return false, err
+		}
+		for _, file := range files {
+			if strings.HasSuffix(file, "_test.go") {
+				continue
+			}
+			src, err := os.ReadFile(file)
+			if err != nil {
+				return false, err
+			}
+			if packageMainClause.Match(src) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	if ok, err := isMain(dir); ok || err != nil {
+		return ".", err
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, "cmd"))
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	var commands []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		if ok, err := isMain(filepath.Join(dir, "cmd", e.Name())); err != nil {
+			return "", err
+		} else if ok {
+			commands = append(commands, e.Name())
+		}
+	}
+	if len(commands) == 0 {
+		return ".", nil
+	}
+	sort.Strings(commands)
+	name := commands[0]
+	if m := moduleDirective.FindStringSubmatch(mod); m != nil {
+		for _, c := range commands {
+			if c == path.Base(m[1]) {
+				name = c
+			}
+		}
+	}
+	return "./cmd/" + name, nil
+}
+
+// detectPHP plans a Composer project served by Apache, from public/ if the
+// app has a front controller there
+func detectPHP(dir string) (*Plan, error) {
+	data, err := readFile(dir, "composer.json")
+	if err != nil || data == "" {
+		return nil, err
+	}
+	var composer struct {
+		Require map[string]string `json:"require"`
+	}
+	if err := json.Unmarshal([]byte(data), &composer); err != nil {
+		return nil, fmt.Errorf("composer.json: %w", err)
+	}
+
+	plan := &Plan{Runtime: RuntimePHP, Version: defaultPHPVersion, Port: 80}
+	if v := minorVersion.FindString(composer.Require["php"]); v != "" {
+		plan.Version = v
+	}
+	if exists(dir, "public/index.php") {
+		plan.docroot = "public"
+	}
+	// The build stage has none of the app's extensions; the runtime image checks them
+	plan.Install = "composer install --no-dev --optimize-autoloader --no-interaction --ignore-platform-reqs"
+	plan.Start = "apache2-foreground"
+	return plan, nil
+}
+
+// detectNode plans a Node.js app, using the package manager its lock file
+// belongs to and its build and start scripts
+func detectNode(dir string) (*Plan, error) {
+	data, err := readFile(dir, "package.json")
+	if err != nil || data == "" {
+		return nil, err
+	}
+	var pkg struct {
+		Main    string            `json:"main"`
+		Scripts map[string]string `json:"scripts"`
+		Engines struct {
+			Node string `json:"node"`
+		} `json:"engines"`
+	}
+	if err := json.Unmarshal([]byte(data), &pkg); err != nil {
+		return nil, fmt.Errorf("package.json: %w", err)
+	}
+
+	plan := &Plan{Runtime: RuntimeNode, Version: defaultNodeVersion, Port: 3000}
+	if v := majorVersion.FindString(pkg.Engines.Node); v != "" {
+		plan.Version = v
+	}
+
+	pm := "npm"
+	switch {
+	case exists(dir, "pnpm-lock.yaml"):
+		pm = "pnpm"
+		plan.Install = "corepack enable && pnpm install --frozen-lockfile"
+	case exists(dir, "yarn.lock"):
+		pm = "yarn"
+		plan.Install = "corepack enable && yarn install"
+	case exists(dir, "package-lock.json"), exists(dir, "npm-shrinkwrap.json"):
+		plan.Install = "npm ci"
+	default:
+		plan.Install = "npm install"
+	}
+	if pkg.Scripts["build"] != "" {
+		plan.Build = pm + " run build"
+	}
+
+	switch {
+	case pkg.Scripts["start"] != "":
+		// npm runs the script whichever package manager installed the app
+		plan.Start = "npm start"
+	case pkg.Main != "" && exists(dir, pkg.Main):
+		plan.Start = "node " + pkg.Main
+	default:
+		for _, file := range []string{"server.js", "index.js", "app.js"} {
+			if exists(dir, file) {
+				plan.Start = "node " + file
+				break
+			}
+		}
+	}
+	return plan, nil
+}
+
+// detectPython plans a Python app installed into a virtualenv. Django,
+// and apps with an app object served by uvicorn or gunicorn, are recognised.
+func detectPython(dir string) (*Plan, error) {
+	requirements, err := readFile(dir, "requirements.txt")
+	if err != nil {
+		return nil, err
+	}
+	pyproject, err := readFile(dir, "pyproject.toml")
+	if err != nil {
+		return nil, err
+	}
+	if requirements == "" && pyproject == "" {
+		return nil, nil
+	}
+
+	plan := &Plan{Runtime: RuntimePython, Version: defaultPythonVersion, Port: 8000}
+	if v, err := readFile(dir, ".python-version"); err == nil {
+		if v := minorVersion.FindString(v); v != "" {
+			plan.Version = v
+		}
+	}
+	var install []string
+	if requirements != "" {
+		install = append(install, "pip install --no-cache-dir -r requirements.txt")
+	}
+	if pyproject != "" {
+		install = append(install, "pip install --no-cache-dir .")
+	}
+	plan.Install = strings.Join(install, " && ")
+
+	deps := strings.ToLower(requirements + "\n" + pyproject)
+	uses := func(dep string) bool {
+		return regexp.MustCompile(`(?m)(^|["'\s])` + dep + `\b`).MatchString(deps)
+	}
+	bind := fmt.Sprintf("0.0.0.0:%d", plan.Port)
+
+	if exists(dir, "manage.py") {
+		matches, err := filepath.Glob(filepath.Join(dir, "*", "wsgi.py"))
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) > 0 && uses("gunicorn") {
+			module := filepath.Base(filepath.Dir(matches[0])) + ".wsgi"
+			plan.Start = "gunicorn --bind " + bind + " " + module
+		} else {
+			plan.Start = "python manage.py runserver " + bind
+		}
+		return plan, nil
+	}
+	for _, file := range []string{"main.py", "app.py", "wsgi.py", "asgi.py"} {
+		if !exists(dir, file) {
+			continue
+		}
+		module := strings.TrimSuffix(file, ".py")
+		switch {
+		case uses("uvicorn"):
+			plan.Start = fmt.Sprintf("uvicorn %s:app --host 0.0.0.0 --port %d", module, plan.Port)
+		case uses("gunicorn"):
+			plan.Start = "gunicorn --bind " + bind + " " + module + ":app"
+		default:
+			plan.Start = "python " + file
+		}
+		break
+	}
+	return plan, nil
+}
+
+// detectStatic serves a repository with an index.html as it is
+func detectStatic(dir string) (*Plan, error) {
+	if !exists(dir, "index.html") {
+		return nil, nil
+	}
+	return &Plan{Runtime: RuntimeStatic, Start: "nginx -g 'daemon off;'", Port: 80}, nil
+}
@@ -0,0 +1,592 @@
+// Package compose parses the subset of the Compose file format that
+// biz-panel can run: services, named volumes, dependencies and healthchecks.
+package compose
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Dependency conditions
+const (
+	ConditionStarted               = "service_started"
+	ConditionHealthy               = "service_healthy"
+	ConditionCompletedSuccessfully = "service_completed_successfully"
+)
+
+// Project is a parsed compose file
+type Project struct {
+	Services map[string]*Service `yaml:"services"`
+	Volumes  map[string]*Volume  `yaml:"volumes"`
+}
+
+// Volume is a top-level named volume
+type Volume struct {
+	External bool   `yaml:"external"`
+	Name     string `yaml:"name"`
+	Labels   Labels `yaml:"labels"`
+}
+
+// Service is a single service definition
+type Service struct {
+	Image       string       `yaml:"image"`
+	Build       *Build       `yaml:"build"`
+	Command     ShellCommand `yaml:"command"`
+	Entrypoint  ShellCommand `yaml:"entrypoint"`
+	Environment Environment  `yaml:"environment"`
+	EnvFile     StringList   `yaml:"env_file"`
+	Ports       []Port       `yaml:"ports"`
+	Volumes     []Mount      `yaml:"volumes"`
+	DependsOn   Dependencies `yaml:"depends_on"`
+	Healthcheck *Healthcheck `yaml:"healthcheck"`
+	Labels      Labels       `yaml:"labels"`
+	Restart     string       `yaml:"restart"`
+	User        string       `yaml:"user"`
+	WorkingDir  string       `yaml:"working_dir"`
+}
+
+// Build is a service's build section
+type Build struct {
+	Context    string `yaml:"context"`
+	Dockerfile string `yaml:"dockerfile"`
+	Args       Labels `yaml:"args"`
+}
+
+// UnmarshalYAML accepts "build: ./dir" as well as the long form
+func (b *Build) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		b.Context = node.Value
+		return nil
+	}
+	type plain Build
+	return node.Decode((*plain)(b))
+}
+
+// Port is a published port
+type Port struct {
+	HostIP    string
+	Published string // Host port; empty = any free port
+	Target    uint16
+	Protocol  string
+}
+
+// UnmarshalYAML accepts "[ip:][host:]container[/proto]" and the long form
+func (p *Port) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.MappingNode {
+		var long struct {
+			Target    uint16 `yaml:"target"`
+			Published string `yaml:"published"`
+			HostIP    string `yaml:"host_ip"`
+			Protocol  string `yaml:"protocol"`
+		}
+		if err := node.Decode(&long); err != nil {
+			return err
+		}
+		if long.Target == 0 {
+			return fmt.Errorf("line %d: port has no target", node.Line)
+		}
+		*p = Port{HostIP: long.HostIP, Published: long.Published, Target: long.Target, Protocol: long.Protocol}
+	} else {
+		spec := node.Value
+		proto := ""
+		if i := strings.LastIndex(spec, "/"); i >= 0 {
+			spec, proto = spec[:i], spec[i+1:]
+		}
+		var ip, published, target string
+		// The host IP may itself contain colons (IPv6), so split from the right
+		if i := strings.LastIndex(spec, ":"); i >= 0 {
+			target = spec[i+1:]
+			published = spec[:i]
+			if j := strings.LastIndex(published, ":"); j >= 0 {
+				ip, published = strings.Trim(published[:j], "[]"), published[j+1:]
+			}
+		} else {
+			target = spec
+		}
+		n, err := strconv.ParseUint(target, 10, 16)
+		if err != nil || n == 0 {
+			return fmt.Errorf("line %d: invalid port %q (ranges are not supported)", node.Line, node.Value)
+		}
+		*p = Port{HostIP: ip, Published: published, Target: uint16(n), Protocol: proto}
+	}
+
+	if p.Protocol == "" {
+		p.Protocol = "tcp"
+	}
+	if p.Protocol != "tcp" && p.Protocol != "udp" && p.Protocol != "sctp" {
+		return fmt.Errorf("line %d: invalid port protocol %q", node.Line, p.Protocol)
+	}
+	if p.Published != "" {
+		if n, err := strconv.ParseUint(p.Published, 10, 16); err != nil || n == 0 {
+			return fmt.Errorf("line %d: invalid published port %q", node.Line, p.Published)
+		}
+	}
+	return nil
+}
+
+// Mount types
+const (
+	MountVolume = "volume"
+	MountBind   = "bind"
+)
+
+// Mount is a service volume. Source is empty for anonymous volumes.
+type Mount struct {
+	Type     string
+	Source   string
+	Target   string
+	ReadOnly bool
+}
+
+// UnmarshalYAML accepts "[source:]target[:mode]" and the long form
+func (m *Mount) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.MappingNode {
+		var long struct {
+			Type     string `yaml:"type"`
+			Source   string `yaml:"source"`
+			Target   string `yaml:"target"`
+			ReadOnly bool   `yaml:"read_only"`
+		}
+		if err := node.Decode(&long); err != nil {
+			return err
+		}
+		*m = Mount{Type: long.Type, Source: long.Source, Target: long.Target, ReadOnly: long.ReadOnly}
+		if m.Type == "" {
+			m.Type = MountVolume
+		}
+	} else {
+		parts := strings.Split(node.Value, ":")
+		switch len(parts) {
+		case 1:
+			m.Target = parts[0]
+		case 2, 3:
+			m.Source, m.Target = parts[0], parts[1]
+			if len(parts) == 3 {
+				for _, opt := range strings.Split(parts[2], ",") {
+					switch opt {
+					case "ro":
+						m.ReadOnly = true
+					case "rw", "z", "Z":
+					default:
+						return fmt.Errorf("line %d: unsupported volume option %q", node.Line, opt)
+					}
+				}
+			}
+		default:
+			return fmt.Errorf("line %d: invalid volume %q", node.Line, node.Value)
+		}
+		m.Type = MountVolume
+		if isPath(m.Source) {
+			m.Type = MountBind
+		}
+	}
+
+	if m.Type != MountVolume && m.Type != MountBind {
+		return fmt.Errorf("line %d: unsupported volume type %q", node.Line, m.Type)
+	}
+	if !strings.HasPrefix(m.Target, "/") {
+		return fmt.Errorf("line %d: volume target %q must be an absolute path", node.Line, m.Target)
+	}
+	if m.Type == MountBind && m.Source == "" {
+		return fmt.Errorf("line %d: bind mount has no source", node.Line)
+	}
+	return nil
+}
+
+// isPath reports whether a short-syntax volume source is a host path
+func isPath(source string) bool {
+	return strings.HasPrefix(source, "/") || strings.HasPrefix(source, ".") || strings.HasPrefix(source, "~")
+}
+
+// Dependency is a depends_on entry
+type Dependency struct {
+	Condition string `yaml:"condition"`
+}
+
+// Dependencies maps service names to the condition to wait for
+type Dependencies map[string]Dependency
+
+// UnmarshalYAML accepts a list of services or a map with conditions
+func (d *Dependencies) UnmarshalYAML(node *yaml.Node) error {
+	deps := make(Dependencies)
+	if node.Kind == yaml.SequenceNode {
+		var names []string
+		if err := node.Decode(&names); err != nil {
+			return err
+		}
+		for _, name := range names {
+			deps[name] = Dependency{Condition: ConditionStarted}
+		}
+	} else {
+		var m map[string]Dependency
+		if err := node.Decode(&m); err != nil {
+			return err
+		}
+		for name, dep := range m {
+			switch dep.Condition {
+			case "":
+				dep.Condition = ConditionStarted
+			case ConditionStarted, ConditionHealthy, ConditionCompletedSuccessfully:
+			default:
+				return fmt.Errorf("line %d: unknown condition %q for %s", node.Line, dep.Condition, name)
+			}
+			deps[name] = dep
+		}
+	}
+	*d = deps
+	return nil
+}
+
+// Healthcheck is a service's healthcheck. Test is in Docker's form, e.g.
+// ["CMD-SHELL", "curl -f http://localhost"].
+type Healthcheck struct {
+	Test        []string
+	Interval    time.Duration
+	Timeout     time.Duration
+	StartPeriod time.Duration
+	Retries     int
+	Disable     bool
+}
+
+// UnmarshalYAML parses test in string or list form and duration strings
+func (h *Healthcheck) UnmarshalYAML(node *yaml.Node) error {
+	var raw struct {
+		Test        StringList `yaml:"test"`
+		Interval    string     `yaml:"interval"`
+		Timeout     string     `yaml:"timeout"`
+		StartPeriod string     `yaml:"start_period"`
+		Retries     int        `yaml:"retries"`
+		Disable     bool       `yaml:"disable"`
+	}
+	if err := node.Decode(&raw); err != nil {
+		return err
+	}
+
+	*h = Healthcheck{Test: raw.Test, Retries: raw.Retries, Disable: raw.Disable}
+	if len(h.Test) == 1 && h.Test[0] != "NONE" {
+		h.Test = []string{"CMD-SHELL", h.Test[0]}
+	}
+	if len(h.Test) > 0 && h.Test[0] == "NONE" {
+		h.Disable = true
+	}
+	if len(h.Test) > 0 && h.Test[0] != "NONE" && h.Test[0] != "CMD" && h.Test[0] != "CMD-SHELL" {
+		return fmt.Errorf("line %d: healthcheck test must start with CMD, CMD-SHELL or NONE", node.Line)
+	}
+
+	for _, d := range []struct {
+		value string
+		out   *time.Duration
+	}{{raw.Interval, &h.Interval}, {raw.Timeout, &h.Timeout}, {raw.StartPeriod, &h.StartPeriod}} {
+		if d.value == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil {
+			return fmt.Errorf("line %d: invalid healthcheck duration %q", node.Line, d.value)
+		}
+		*d.out = v
+	}
+	return nil
+}
+
+// StringList accepts a single string or a list of strings
+type StringList []string
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (l *StringList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*l = StringList{node.Value}
+		return nil
+	}
+	var list []string
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// ShellCommand accepts a list, or a string split into words like a shell would
+type ShellCommand []string
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (c *ShellCommand) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		words, err := splitWords(node.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		*c = words
+		return nil
+	}
+	var list []string
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	*c = list
+	return nil
+}
+
+// splitWords splits s on whitespace, honouring quotes and backslashes
+func splitWords(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+
+	for _, r := range s {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote in %q", s)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// Labels accepts a map or a list of "key=value" entries
+type Labels map[string]string
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (l *Labels) UnmarshalYAML(node *yaml.Node) error {
+	labels := make(Labels)
+	if node.Kind == yaml.SequenceNode {
+		var list []string
+		if err := node.Decode(&list); err != nil {
+			return err
+		}
+		for _, entry := range list {
+			k, v, _ := strings.Cut(entry, "=")
+			labels[k] = v
+		}
+	} else {
+		var m map[string]string
+		if err := node.Decode(&m); err != nil {
+			return err
+		}
+		for k, v := range m {
+			labels[k] = v
+		}
+	}
+	*l = labels
+	return nil
+}
+
+// Environment maps variable names to values. A nil value means the
+// variable is taken from the interpolation environment, if set there.
+type Environment map[string]*string
+
+// UnmarshalYAML accepts a map or a list of "KEY=value" / "KEY" entries
+func (e *Environment) UnmarshalYAML(node *yaml.Node) error {
+	env := make(Environment)
+	if node.Kind == yaml.SequenceNode {
+		var list []string
+		if err := node.Decode(&list); err != nil {
+			return err
+		}
+		for _, entry := range list {
+			k, v, ok := strings.Cut(entry, "=")
+			if ok {
+				env[k] = &v
+			} else {
+				env[k] = nil
+			}
+		}
+	} else if err := node.Decode((*map[string]*string)(&env)); err != nil {
+		return err
+	}
+	*e = env
+	return nil
+}
+
+// Parse interpolates variables from lookup into the values of a compose
+// file, decodes it and validates the result
+func Parse(data []byte, lookup func(string) (string, bool)) (*Project, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("invalid compose file: %w", err)
+	}
+	if root.Kind == 0 {
+		return nil, fmt.Errorf("compose file is empty")
+	}
+	if err := interpolateNode(&root, lookup); err != nil {
+		return nil, err
+	}
+
+	var p Project
+	if err := root.Decode(&p); err != nil {
+		return nil, fmt.Errorf("invalid compose file: %w", err)
+	}
+
+	// Variables listed without a value are passed through from lookup
+	for _, svc := range p.Services {
+		if svc == nil {
+			continue
+		}
+		for k, v := range svc.Environment {
+			if v != nil {
+				continue
+			}
+			if value, ok := lookup(k); ok {
+				svc.Environment[k] = &value
+			} else {
+				delete(svc.Environment, k)
+			}
+		}
+	}
+
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// interpolateNode substitutes variables in every scalar value below node.
+// Mapping keys are left alone.
+func interpolateNode(node *yaml.Node, lookup func(string) (string, bool)) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		value, err := Interpolate(node.Value, lookup)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		node.Value = value
+	case yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			if err := interpolateNode(node.Content[i], lookup); err != nil {
+				return err
+			}
+		}
+	default:
+		for _, child := range node.Content {
+			if err := interpolateNode(child, lookup); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validate checks service definitions and their dependencies
+func (p *Project) validate() error {
+	if len(p.Services) == 0 {
+		return fmt.Errorf("compose file defines no services")
+	}
+	for name, svc := range p.Services {
+		if !validServiceName(name) {
+			return fmt.Errorf("invalid service name %q", name)
+		}
+		if svc == nil || (svc.Image == "" && svc.Build == nil) {
+			return fmt.Errorf("service %s needs an image or a build section", name)
+		}
+		for _, m := range svc.Volumes {
+			if m.Type != MountVolume || m.Source == "" {
+				continue
+			}
+			if _, ok := p.Volumes[m.Source]; !ok {
+				return fmt.Errorf("service %s uses undefined volume %s", name, m.Source)
+			}
+		}
+	}
+	_, err := p.Order()
+	return err
+}
+
+// validServiceName allows the characters Docker accepts in container names
+func validServiceName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		ok := (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') ||
+			(i > 0 && (r == '-' || r == '_' || r == '.'))
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Order returns service names so that every service comes after the
+// services it depends on. Ties are broken alphabetically.
+func (p *Project) Order() ([]string, error) {
+	names := make([]string, 0, len(p.Services))
+	for name := range p.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[string]int, len(names))
+	order := make([]string, 0, len(names))
+
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case done:
+			return nil
+		case visiting:
+			return fmt.Errorf("dependency cycle: %s", strings.Join(append(path, name), " -> "))
+		}
+		state[name] = visiting
+
+		deps := make([]string, 0, len(p.Services[name].DependsOn))
+		for dep := range p.Services[name].DependsOn {
+			deps = append(deps, dep)
+		}
+		sort.Strings(deps)
+		for _, dep := range deps {
+			if _, ok := p.Services[dep]; !ok {
+				return fmt.Errorf("service %s depends on undefined service %s", name, dep)
+			}
+			if err := visit(dep, append(path, name)); err != nil {
+				return err
+			}
+		}
+
+		state[name] = done
+		order = append(order, name)
+		return nil
+	}
+
+	for _, name := range names {
+		if err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
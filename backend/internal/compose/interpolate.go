@@ -0,0 +1,179 @@
+package compose
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Interpolate substitutes $VAR, ${VAR}, ${VAR:-default}, ${VAR-default},
+// ${VAR:?error} and ${VAR?error} in s. $$ is a literal dollar sign.
+func Interpolate(s string, lookup func(string) (string, bool)) (string, error) {
+	if !strings.Contains(s, "$") {
+		return s, nil
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+1 == len(s) {
+			b.WriteByte('$')
+			break
+		}
+
+		switch next := s[i+1]; {
+		case next == '$':
+			b.WriteByte('$')
+			i++
+		case next == '{':
+			end := closingBrace(s[i+2:])
+			if end < 0 {
+				return "", fmt.Errorf("unterminated variable in %q", s)
+			}
+			value, err := expand(s[i+2:i+2+end], lookup)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(value)
+			i += 2 + end
+		case isNameStart(next):
+			j := i + 1
+			for j < len(s) && isNameChar(s[j]) {
+				j++
+			}
+			value, _ := lookup(s[i+1 : j])
+			b.WriteString(value)
+			i = j - 1
+		default:
+			b.WriteByte('$')
+		}
+	}
+	return b.String(), nil
+}
+
+// closingBrace returns the index of the brace ending a ${ whose inside
+// starts s, skipping the variables nested in a default or error message
+func closingBrace(s string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '$' && i+1 < len(s) && s[i+1] == '$':
+			i++
+		case s[i] == '$' && i+1 < len(s) && s[i+1] == '{':
+			depth++
+			i++
+		case s[i] == '}':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return -1
+}
+
+// expand resolves the inside of ${...}
+func expand(expr string, lookup func(string) (string, bool)) (string, error) {
+	i := 0
+	for i < len(expr) && isNameChar(expr[i]) {
+		i++
+	}
+	name, op, arg := expr[:i], "", ""
+	for _, candidate := range []string{":-", ":?", "-", "?"} {
+		if strings.HasPrefix(expr[i:], candidate) {
+			op, arg = candidate, expr[i+len(candidate):]
+			break
+		}
+	}
+	if !validName(name) || (op == "" && i != len(expr)) {
+		return "", fmt.Errorf("invalid variable name %q", name)
+	}
+
+	value, set := lookup(name)
+	switch op {
+	case ":-":
+		if value == "" {
+			return Interpolate(arg, lookup)
+		}
+	case "-":
+		if !set {
+			return Interpolate(arg, lookup)
+		}
+	case ":?":
+		if value == "" {
+			return "", fmt.Errorf("required variable %s is missing a value: %s", name, arg)
+		}
+	case "?":
+		if !set {
+			return "", fmt.Errorf("required variable %s is missing: %s", name, arg)
+		}
+	}
+	return value, nil
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNameChar(c byte) bool {
+	return isNameStart(c) || (c >= '0' && c <= '9')
+}
+
+func validName(name string) bool {
+	if name == "" || !isNameStart(name[0]) {
+		return false
+	}
+	for i := 1; i < len(name); i++ {
+		if !isNameChar(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// ReadEnvFile reads KEY=value lines from an env file
+func ReadEnvFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseEnvFile(f)
+}
+
+// ParseEnvFile parses KEY=value lines. Blank lines and # comments are
+// skipped, an "export " prefix is allowed and quoted values are unquoted.
+func ParseEnvFile(r io.Reader) (map[string]string, error) {
+	env := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !validName(key) {
+			return nil, fmt.Errorf("line %d: expected KEY=value", n)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			if value[0] == '"' {
+				value = strings.NewReplacer(`\n`, "\n", `\"`, `"`, `\\`, `\`).Replace(value[1 : len(value)-1])
+			} else {
+				value = value[1 : len(value)-1]
+			}
+		} else if i := strings.Index(value, " #"); i >= 0 {
+			value = strings.TrimSpace(value[:i])
+		}
+		env[key] = value
+	}
+	return env, scanner.Err()
+}
@@ -0,0 +1,98 @@
+package compose
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInterpolate(t *testing.T) {
+	env := map[string]string{"NAME": "web", "PORT": "8080", "EMPTY": ""}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	for in, want := range map[string]string{
+		"plain":                         "plain",
+		"$NAME":                         "web",
+		"${NAME}-1":                     "web-1",
+		"$NAME.$PORT":                   "web.8080",
+		"$$NAME":                        "$NAME",
+		"cost $5":                       "cost $5",
+		"trailing $":                    "trailing $",
+		"$MISSING":                      "",
+		"${MISSING:-3000}":              "3000",
+		"${EMPTY:-default}":             "default",
+		"${EMPTY-default}":              "",
+		"${MISSING-default}":            "default",
+		"${PORT:-3000}":                 "8080",
+		"${MISSING:-${PORT}}":           "8080",
+		"${MISSING:-${ALSO:-x}}/y":      "x/y",
+		"${MISSING:-a${NAME}b}-${PORT}": "awebb-8080",
+		"${MISSING:-$${NAME}}":          "${NAME}",
+		"${EMPTY?unused}":               "",
+	} {
+		got, err := Interpolate(in, lookup)
+		if err != nil {
+			t.Errorf("Interpolate(%q): %v", in, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("Interpolate(%q) = %q, want %q", in, got, want)
+		}
+	}
+
+	for in, want := range map[string]string{
+		"${NAME":               "unterminated",
+		"${MISSING:-${PORT}":   "unterminated",
+		"${1BAD}":              "invalid variable name",
+		"${NAME!}":             "invalid variable name",
+		"${MISSING:?set it}":   "set it",
+		"${EMPTY:?not empty}":  "not empty",
+		"${MISSING?required}":  "required",
+		"${MISSING:-${X:?no}}": "no",
+	} {
+		if _, err := Interpolate(in, lookup); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Interpolate(%q) error = %v, want %q", in, err, want)
+		}
+	}
+}
+
+func TestParseEnvFile(t *testing.T) {
+	env, err := ParseEnvFile(strings.NewReader(`
+# comment
+PLAIN=value
+export EXPORTED=yes
+  SPACED = padded  
+DOUBLE="a \"quoted\" # value\nnext"
+SINGLE='kept \n as is'
+COMMENTED=value # trailing comment
+HASH=a#b
+EMPTY=
+EQUALS=a=b
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"PLAIN":     "value",
+		"EXPORTED":  "yes",
+		"SPACED":    "padded",
+		"DOUBLE":    "a \"quoted\" # value\nnext",
+		"SINGLE":    `kept \n as is`,
+		"COMMENTED": "value",
+		"HASH":      "a#b",
+		"EMPTY":     "",
+		"EQUALS":    "a=b",
+	}
+	if !reflect.DeepEqual(env, want) {
+		t.Fatalf("ParseEnvFile = %#v, want %#v", env, want)
+	}
+
+	for _, data := range []string{"NOVALUE", "1ABC=x", "BAD-NAME=x", "=x"} {
+		if _, err := ParseEnvFile(strings.NewReader(data)); err == nil {
+			t.Errorf("ParseEnvFile(%q) succeeded, want an error", data)
+		}
+	}
+}
@@ -0,0 +1,502 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultPath is where the installer writes the panel configuration
+const DefaultPath = "/etc/biz-panel/config.yaml"
+
+// Config is the typed form of config.yaml
+type Config struct {
+	Server    ServerConfig    `yaml:"server"`
+	Database  DatabaseConfig  `yaml:"database"`
+	Auth      AuthConfig      `yaml:"auth"`
+	Admin     AdminConfig     `yaml:"admin"`
+	Security  SecurityConfig  `yaml:"security"`
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+	Deploy    DeployConfig    `yaml:"deploy"`
+	Proxy     ProxyConfig     `yaml:"proxy"`
+	Secrets   SecretsConfig   `yaml:"secrets"`
+	Logging   LoggingConfig   `yaml:"logging"`
+	Features  FeaturesConfig  `yaml:"features"`
+}
+
+// ServerConfig holds listener and CORS settings
+type ServerConfig struct {
+	Host        string   `yaml:"host"`
+	Port        int      `yaml:"port"`
+	PanelPort   int      `yaml:"panel_port"`
+	CORSOrigins []string `yaml:"cors_origins"`
+	// TrustedProxies may set X-Forwarded-For / X-Real-IP (restart required)
+	TrustedProxies []string `yaml:"trusted_proxies"`
+}
+
+// DatabaseConfig holds the embedded store location
+type DatabaseConfig struct {
+	Driver string `yaml:"driver"`
+	Path   string `yaml:"path"`
+}
+
+// AuthConfig holds token settings
+type AuthConfig struct {
+	JWTSecret      string        `yaml:"jwt_secret"`
+	SessionTimeout int           `yaml:"session_timeout"`  // Seconds; lifetime of a refresh token
+	AccessTokenTTL time.Duration `yaml:"access_token_ttl"` // Lifetime of a JWT access token
+	OIDC           OIDCConfig    `yaml:"oidc"`
+	LDAP           LDAPConfig    `yaml:"ldap"`
+}
+
+// OIDCConfig enables single sign-on through an OpenID Connect provider
+type OIDCConfig struct {
+	Enabled       bool              `yaml:"enabled"`
+	DisplayName   string            `yaml:"display_name"`
+	Issuer        string            `yaml:"issuer"`
+	ClientID      string            `yaml:"client_id"`
+	ClientSecret  string            `yaml:"client_secret"`
+	RedirectURL   string            `yaml:"redirect_url"` // Panel page receiving code and state
+	Scopes        []string          `yaml:"scopes"`
+	UsernameClaim string            `yaml:"username_claim"`
+	GroupsClaim   string            `yaml:"groups_claim"`
+	RoleMapping   map[string]string `yaml:"role_mapping"` // Group -> panel role
+	DefaultRole   string            `yaml:"default_role"` // Empty refuses users in no mapped group
+}
+
+// LDAPConfig enables password logins against an LDAP directory
+type LDAPConfig struct {
+	Enabled            bool              `yaml:"enabled"`
+	URL                string            `yaml:"url"` // ldap:// or ldaps://
+	StartTLS           bool              `yaml:"start_tls"`
+	InsecureSkipVerify bool              `yaml:"insecure_skip_verify"`
+	BindDN             string            `yaml:"bind_dn"`
+	BindPassword       string            `yaml:"bind_password"`
+	UserBaseDN         string            `yaml:"user_base_dn"`
+	UserFilter         string            `yaml:"user_filter"` // {username} is replaced
+	UsernameAttribute  string            `yaml:"username_attribute"`
+	EmailAttribute     string            `yaml:"email_attribute"`
+	GroupBaseDN        string            `yaml:"group_base_dn"`
+	GroupFilter        string            `yaml:"group_filter"` // {dn} and {username} are replaced
+	GroupNameAttribute string            `yaml:"group_name_attribute"`
+	Timeout            time.Duration     `yaml:"timeout"`
+	RoleMapping        map[string]string `yaml:"role_mapping"` // Group name or DN -> panel role
+	DefaultRole        string            `yaml:"default_role"`
+}
+
+// panelRoles are the built-in roles a group may be mapped to
+var panelRoles = map[string]bool{"admin": true, "operator": true, "developer": true, "viewer": true}
+
+// AdminConfig holds the bootstrap administrator credentials
+type AdminConfig struct {
+	Username     string `yaml:"username"`
+	PasswordHash string `yaml:"password_hash"`
+}
+
+// SecurityConfig holds access restrictions
+type SecurityConfig struct {
+	AllowedIPs []string         `yaml:"allowed_ips"`
+	Enable2FA  bool             `yaml:"enable_2fa"`
+	BruteForce BruteForceConfig `yaml:"brute_force"`
+}
+
+// BruteForceConfig tunes login lockouts (enabled by SecuritySettings.BruteForceEnabled)
+type BruteForceConfig struct {
+	MaxAttempts int           `yaml:"max_attempts"` // Failures within window before a lockout
+	Window      time.Duration `yaml:"window"`
+	Lockout     time.Duration `yaml:"lockout"`     // First lockout, doubled for each repeat
+	MaxLockout  time.Duration `yaml:"max_lockout"` // Cap for the backoff
+	Firewall    bool          `yaml:"firewall"`    // Add deny rules for locked-out IPs
+}
+
+// RateLimitConfig holds the request budgets per route group
+type RateLimitConfig struct {
+	Login    RateLimit                  `yaml:"login"`
+	API      RateLimit                  `yaml:"api"`      // Protected routes without a policy of their own
+	Policies map[string]RateLimitPolicy `yaml:"policies"` // Named policies for route prefixes
+	Exempt   []string                   `yaml:"exempt"`   // IPs and CIDRs that are never limited
+}
+
+// RateLimit is a token bucket refilled with requests every window
+type RateLimit struct {
+	Requests int           `yaml:"requests"`
+	Window   time.Duration `yaml:"window"`
+	Burst    int           `yaml:"burst"` // Requests allowed at once; 0 = requests
+	Key      string        `yaml:"key"`   // ip, user or token
+}
+
+// RateLimitPolicy is a rate limit for requests under route prefixes
+type RateLimitPolicy struct {
+	RateLimit `yaml:",inline"`
+	Routes    []string `yaml:"routes"` // Path prefixes, e.g. /api/files
+}
+
+// rateLimitKeys are the valid rate_limit key values
+var rateLimitKeys = map[string]bool{"": true, "ip": true, "user": true, "token": true}
+
+// DeployConfig controls how many deployments run at once, how much
+// deployment history is kept per project and how blue/green deployments
+// hand over
+type DeployConfig struct {
+	MaxConcurrent   int           `yaml:"max_concurrent"`   // Deployments running at once across projects; others are queued
+	KeepImages      int           `yaml:"keep_images"`      // Successful deployments whose images are kept for rollback
+	KeepDeployments int           `yaml:"keep_deployments"` // Deployment records kept, logs included
+	DrainTimeout    time.Duration `yaml:"drain_timeout"`    // Time the old blue/green container keeps running after the switch
+}
+
+// ProxyConfig locates the nginx site configs that route project domains
+type ProxyConfig struct {
+	SitesDir   string `yaml:"sites_dir"`
+	EnabledDir string `yaml:"enabled_dir"`
+}
+
+// SecretsConfig locates the master key that encrypts project secrets. Keep
+// it out of the database's backups; the secrets can't be read without it.
+type SecretsConfig struct {
+	KeyFile string `yaml:"key_file"` // Created with a new key if missing
+}
+
+// LoggingConfig holds log output settings
+type LoggingConfig struct {
+	Level      string `yaml:"level"`
+	Path       string `yaml:"path"`
+	MaxSize    string `yaml:"max_size"`
+	MaxBackups int    `yaml:"max_backups"`
+}
+
+// FeaturesConfig toggles panel modules
+type FeaturesConfig struct {
+	Docker     bool `yaml:"docker"`
+	Websites   bool `yaml:"websites"`
+	Databases  bool `yaml:"databases"`
+	Firewall   bool `yaml:"firewall"`
+	SSL        bool `yaml:"ssl"`
+	Monitoring bool `yaml:"monitoring"`
+}
+
+// Default returns the configuration used when no file exists
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Host:      "0.0.0.0",
+			Port:      8080,
+			PanelPort: 8888,
+			CORSOrigins: []string{
+				"http://localhost:5173",
+				"http://localhost:5174",
+				"http://localhost:8888",
+				"http://127.0.0.1:5173",
+				"http://127.0.0.1:5174",
+				"http://127.0.0.1:8888",
+			},
+		},
+		Database: DatabaseConfig{
+			Driver: "bolt",
+			Path:   "/var/lib/biz-panel/db/biz-panel.db",
+		},
+		Auth: AuthConfig{
+			JWTSecret:      "biz-panel-default-secret-change-in-production",
+			SessionTimeout: 86400,
+			AccessTokenTTL: 15 * time.Minute,
+		},
+		Admin: AdminConfig{
+			Username: "admin",
+			// Default password hash for "admin123" - CHANGE IN PRODUCTION
+			PasswordHash: "$2a$10$JpCkpb4PGX4QmbEaqLO6RulAsCF4.hkiI557ujaLzuHUP4Shc/ht6",
+		},
+		Security: SecurityConfig{
+			BruteForce: BruteForceConfig{
+				MaxAttempts: 5,
+				Window:      15 * time.Minute,
+				Lockout:     time.Minute,
+				MaxLockout:  24 * time.Hour,
+			},
+		},
+		RateLimit: RateLimitConfig{
+			Login: RateLimit{Requests: 5, Window: time.Minute, Key: "ip"},
+			API:   RateLimit{Requests: 100, Window: time.Minute, Key: "user"},
+			Policies: map[string]RateLimitPolicy{
+				// The dashboard polls metrics and the file manager lists directories often
+				"metrics": {RateLimit{Requests: 300, Window: time.Minute, Burst: 60, Key: "user"}, []string{"/api/metrics"}},
+				"files":   {RateLimit{Requests: 600, Window: time.Minute, Burst: 120, Key: "user"}, []string{"/api/files"}},
+			},
+		},
+		Deploy: DeployConfig{
+			MaxConcurrent:   2,
+			KeepImages:      5,
+			KeepDeployments: 50,
+			DrainTimeout:    10 * time.Second,
+		},
+		Proxy: ProxyConfig{
+			SitesDir:   "/etc/nginx/sites-available",
+			EnabledDir: "/etc/nginx/sites-enabled",
+		},
+		Secrets: SecretsConfig{
+			KeyFile: "/etc/biz-panel/master.key",
+		},
+		Logging: LoggingConfig{
+			Level: "info",
+		},
+		Features: FeaturesConfig{
+			Docker:     true,
+			Websites:   true,
+			Databases:  true,
+			Firewall:   true,
+			SSL:        true,
+			Monitoring: true,
+		},
+	}
+}
+
+// readFile parses the YAML file on top of the defaults.
+// A missing file is not an error; the defaults are returned.
+func readFile(path string) (*Config, error) {
+	cfg := Default()
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// applyEnv overrides file values with environment variables
+func (c *Config) applyEnv() error {
+	if v := os.Getenv("JWT_SECRET"); v != "" {
+		c.Auth.JWTSecret = v
+	}
+	if v := os.Getenv("ADMIN_USER"); v != "" {
+		c.Admin.Username = v
+	}
+	if v := os.Getenv("ADMIN_PASS_HASH"); v != "" {
+		c.Admin.PasswordHash = v
+	}
+	if v := os.Getenv("CORS_ORIGINS"); v != "" {
+		for _, origin := range strings.Split(v, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				c.Server.CORSOrigins = append(c.Server.CORSOrigins, origin)
+			}
+		}
+	}
+	if v := os.Getenv("PORT"); v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid PORT %q: %w", v, err)
+		}
+		c.Server.Port = port
+	}
+	if v := os.Getenv("DB_PATH"); v != "" {
+		c.Database.Path = v
+	}
+	if v := os.Getenv("MASTER_KEY_FILE"); v != "" {
+		c.Secrets.KeyFile = v
+	}
+	return nil
+}
+
+// Validate checks the configuration for values the server cannot run with
+func (c *Config) Validate() error {
+	var problems []string
+
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		problems = append(problems, fmt.Sprintf("server.port %d is out of range", c.Server.Port))
+	}
+	for _, origin := range c.Server.CORSOrigins {
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("server.cors_origins entry %q is not an http(s) origin", origin))
+		}
+	}
+	for _, proxy := range c.Server.TrustedProxies {
+		if !validIPOrCIDR(proxy) {
+			problems = append(problems, fmt.Sprintf("server.trusted_proxies entry %q is not an IP or CIDR", proxy))
+		}
+	}
+	for _, entry := range c.Security.AllowedIPs {
+		if !validIPOrCIDR(entry) {
+			problems = append(problems, fmt.Sprintf("security.allowed_ips entry %q is not an IP or CIDR", entry))
+		}
+	}
+	if bf := c.Security.BruteForce; bf.MaxAttempts <= 0 || bf.Window <= 0 || bf.Lockout <= 0 || bf.MaxLockout < bf.Lockout {
+		problems = append(problems, "security.brute_force needs positive max_attempts, window and lockout, and max_lockout >= lockout")
+	}
+	if c.Database.Path == "" {
+		problems = append(problems, "database.path is required")
+	}
+	if len(c.Auth.JWTSecret) < 16 {
+		problems = append(problems, "auth.jwt_secret must be at least 16 characters")
+	}
+	if c.Auth.SessionTimeout <= 0 {
+		problems = append(problems, "auth.session_timeout must be positive")
+	}
+	if c.Auth.AccessTokenTTL <= 0 {
+		problems = append(problems, "auth.access_token_ttl must be positive")
+	}
+	if o := c.Auth.OIDC; o.Enabled {
+		if u, err := url.Parse(o.Issuer); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, "auth.oidc.issuer must be an http(s) URL")
+		}
+		if u, err := url.Parse(o.RedirectURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, "auth.oidc.redirect_url must be an http(s) URL")
+		}
+		if o.ClientID == "" {
+			problems = append(problems, "auth.oidc.client_id is required")
+		}
+		problems = append(problems, roleMappingProblems("auth.oidc", o.RoleMapping, o.DefaultRole)...)
+	}
+	if l := c.Auth.LDAP; l.Enabled {
+		if u, err := url.Parse(l.URL); err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+			problems = append(problems, "auth.ldap.url must be an ldap:// or ldaps:// URL")
+		}
+		if l.StartTLS && strings.HasPrefix(l.URL, "ldaps:") {
+			problems = append(problems, "auth.ldap.start_tls cannot be combined with ldaps://")
+		}
+		if l.UserBaseDN == "" {
+			problems = append(problems, "auth.ldap.user_base_dn is required")
+		}
+		if l.UserFilter != "" && !strings.Contains(l.UserFilter, "{username}") {
+			problems = append(problems, "auth.ldap.user_filter must contain {username}")
+		}
+		problems = append(problems, roleMappingProblems("auth.ldap", l.RoleMapping, l.DefaultRole)...)
+	}
+	if c.Admin.Username == "" {
+		problems = append(problems, "admin.username is required")
+	}
+	if c.Admin.PasswordHash == "" {
+		problems = append(problems, "admin.password_hash is required")
+	}
+	limits := map[string]RateLimit{"login": c.RateLimit.Login, "api": c.RateLimit.API}
+	for name, policy := range c.RateLimit.Policies {
+		if name == "login" || name == "api" {
+			problems = append(problems, fmt.Sprintf("rate_limit.policies.%s clashes with rate_limit.%s", name, name))
+			continue
+		}
+		limits["policies."+name] = policy.RateLimit
+		if len(policy.Routes) == 0 {
+			problems = append(problems, fmt.Sprintf("rate_limit.policies.%s needs at least one route", name))
+		}
+		for _, route := range policy.Routes {
+			if !strings.HasPrefix(route, "/api/") {
+				problems = append(problems, fmt.Sprintf("rate_limit.policies.%s route %q must start with /api/", name, route))
+			}
+		}
+	}
+	for name, limit := range limits {
+		if limit.Requests <= 0 || limit.Window <= 0 || limit.Burst < 0 {
+			problems = append(problems, fmt.Sprintf("rate_limit.%s needs positive requests and window", name))
+		}
+		if !rateLimitKeys[limit.Key] {
+			problems = append(problems, fmt.Sprintf("rate_limit.%s key must be ip, user or token", name))
+		}
+	}
+	for _, entry := range c.RateLimit.Exempt {
+		if !validIPOrCIDR(entry) {
+			problems = append(problems, fmt.Sprintf("rate_limit.exempt entry %q is not an IP or CIDR", entry))
+		}
+	}
+	if c.Deploy.KeepImages < 1 || c.Deploy.KeepDeployments < c.Deploy.KeepImages {
+		problems = append(problems, "deploy.keep_images must be at least 1 and deploy.keep_deployments at least keep_images")
+	}
+	if c.Deploy.MaxConcurrent < 1 {
+		problems = append(problems, "deploy.max_concurrent must be at least 1")
+	}
+	if c.Deploy.DrainTimeout < 0 {
+		problems = append(problems, "deploy.drain_timeout can't be negative")
+	}
+	if c.Secrets.KeyFile == "" {
+		problems = append(problems, "secrets.key_file is required")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// roleMappingProblems checks that groups map to built-in roles
+func roleMappingProblems(section string, mapping map[string]string, defaultRole string) []string {
+	var problems []string
+	for group, role := range mapping {
+		if !panelRoles[role] {
+			problems = append(problems, fmt.Sprintf("%s.role_mapping maps %q to unknown role %q", section, group, role))
+		}
+	}
+	if defaultRole != "" && !panelRoles[defaultRole] {
+		problems = append(problems, fmt.Sprintf("%s.default_role %q is not a role", section, defaultRole))
+	}
+	if len(mapping) == 0 && defaultRole == "" {
+		problems = append(problems, section+" needs a role_mapping or a default_role")
+	}
+	return problems
+}
+
+// validIPOrCIDR reports whether s is an IPv4/IPv6 address or prefix
+func validIPOrCIDR(s string) bool {
+	if _, err := netip.ParsePrefix(s); err == nil {
+		return true
+	}
+	_, err := netip.ParseAddr(s)
+	return err == nil
+}
+
+// TokenDuration returns the session timeout as a duration
+func (c *Config) TokenDuration() time.Duration {
+	return time.Duration(c.Auth.SessionTimeout) * time.Second
+}
+
+// ListenAddr returns the address the API server binds to
+func (c *Config) ListenAddr() string {
+	return fmt.Sprintf("%s:%d", c.Server.Host, c.Server.Port)
+}
+
+// writeFile atomically replaces path with the YAML form of cfg
+func writeFile(path string, cfg *Config) error {
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".config-*.yaml")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(append([]byte("# Biz-Panel Configuration\n"), data...)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
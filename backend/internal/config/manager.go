@@ -0,0 +1,142 @@
+package config
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// Manager owns the loaded configuration and keeps it in sync with the file
+type Manager struct {
+	path string
+
+	mu        sync.RWMutex
+	file      *Config // as read from disk, written back by Update
+	current   *Config // file merged with environment overrides
+	listeners []func(*Config)
+}
+
+// Load reads, merges and validates the configuration at path
+func Load(path string) (*Manager, error) {
+	m := &Manager{path: path}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Path returns the config file location
+func (m *Manager) Path() string {
+	return m.path
+}
+
+// Current returns the active configuration. Callers must not modify it.
+func (m *Manager) Current() *Config {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.current
+}
+
+// OnReload registers a function called with the new configuration after
+// every successful reload or update
+func (m *Manager) OnReload(fn func(*Config)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.listeners = append(m.listeners, fn)
+}
+
+// Reload re-reads the file. On error the previous configuration stays active.
+func (m *Manager) Reload() error {
+	if err := m.load(); err != nil {
+		return err
+	}
+	m.notify()
+	return nil
+}
+
+// Update applies fn to the file configuration, validates the result and
+// writes it back atomically before activating it
+func (m *Manager) Update(fn func(*Config)) error {
+	m.mu.Lock()
+	file := clone(m.file)
+	fn(file)
+
+	current := clone(file)
+	if err := current.applyEnv(); err != nil {
+		m.mu.Unlock()
+		return err
+	}
+	if err := current.Validate(); err != nil {
+		m.mu.Unlock()
+		return err
+	}
+	if err := writeFile(m.path, file); err != nil {
+		m.mu.Unlock()
+		return err
+	}
+
+	m.file = file
+	m.current = current
+	m.mu.Unlock()
+
+	m.notify()
+	return nil
+}
+
+// WatchSignals reloads the configuration whenever the process receives SIGHUP
+func (m *Manager) WatchSignals() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+
+	go func() {
+		for range sigs {
+			if err := m.Reload(); err != nil {
+				log.Printf("Config reload failed, keeping previous config: %v", err)
+				continue
+			}
+			log.Printf("Config reloaded from %s", m.path)
+		}
+	}()
+}
+
+func (m *Manager) load() error {
+	file, err := readFile(m.path)
+	if err != nil {
+		return err
+	}
+
+	current := clone(file)
+	if err := current.applyEnv(); err != nil {
+		return err
+	}
+	if err := current.Validate(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.file = file
+	m.current = current
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *Manager) notify() {
+	m.mu.RLock()
+	cfg := m.current
+	listeners := append([]func(*Config){}, m.listeners...)
+	m.mu.RUnlock()
+
+	for _, fn := range listeners {
+		fn(cfg)
+	}
+}
+
+// clone returns a copy of cfg that shares no slices with it
+func clone(cfg *Config) *Config {
+	c := *cfg
+	c.Server.CORSOrigins = append([]string(nil), cfg.Server.CORSOrigins...)
+	c.Security.AllowedIPs = append([]string(nil), cfg.Security.AllowedIPs...)
+	return &c
+}
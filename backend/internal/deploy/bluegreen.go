@@ -0,0 +1,331 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bizino-services/biz-panel-backend/internal/docker"
+	"github.com/bizino-services/biz-panel-backend/internal/models"
+	"github.com/bizino-services/biz-panel-backend/internal/proxy"
+)
+
+// DefaultDrainTimeout is how long the previous container of a blue/green
+// deployment keeps running after traffic moved to the new one
+const DefaultDrainTimeout = 10 * time.Second
+
+// Health check defaults
+const (
+	defaultCheckInterval = 2 * time.Second
+	defaultCheckTimeout  = 5 * time.Second
+	defaultStartTimeout  = 60 * time.Second
+)
+
+// ErrInvalidStrategy is returned for unusable deploy strategy settings
+var ErrInvalidStrategy = errors.New("invalid deploy settings")
+
+// healthClient runs HTTP health checks; redirects count as responses
+var healthClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+// SetDrainTimeout sets how long previous blue/green containers keep running
+// after the switch
+func (d *Deployer) SetDrainTimeout(timeout time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.drainTimeout = timeout
+}
+
+// ValidateStrategy checks a project's deploy strategy and health check, and
+// the settings of static sites
+func ValidateStrategy(p *models.Project) error {
+	switch p.Strategy {
+	case "", models.DeployStrategyRecreate, models.DeployStrategyBlueGreen:
+	default:
+		return fmt.Errorf("%w: unknown strategy %q", ErrInvalidStrategy, p.Strategy)
+	}
+
+	if hc := p.HealthCheck; hc != nil {
+		switch hc.Type {
+		case "http", "tcp", "docker":
+		default:
+			return fmt.Errorf("%w: health check type must be http, tcp or docker", ErrInvalidStrategy)
+		}
+		if hc.Path != "" && !strings.HasPrefix(hc.Path, "/") {
+			return fmt.Errorf("%w: health check path must start with /", ErrInvalidStrategy)
+		}
+		if hc.ExpectedStatus != 0 && (hc.ExpectedStatus < 100 || hc.ExpectedStatus > 599) {
+			return fmt.Errorf("%w: health check expected status must be an HTTP status", ErrInvalidStrategy)
+		}
+		if hc.Interval < 0 || hc.Timeout < 0 || hc.StartTimeout < 0 {
+			return fmt.Errorf("%w: health check durations can't be negative", ErrInvalidStrategy)
+		}
+	}
+
+	if p.Previews != nil && p.Previews.TTL < 0 {
+		return fmt.Errorf("%w: preview lifetime can't be negative", ErrInvalidStrategy)
+	}
+
+	if p.Type == models.ProjectTypeStatic {
+		return validateStatic(p)
+	}
+	if p.Strategy != models.DeployStrategyBlueGreen {
+		return nil
+	}
+	if IsCompose(p) {
+		return fmt.Errorf("%w: blue/green deploys aren't supported for compose projects", ErrInvalidStrategy)
+	}
+	if !proxy.ValidDomain(p.Domain) {
+		return fmt.Errorf("%w: blue/green deploys need a domain to route", ErrInvalidStrategy)
+	}
+	if appPort(p) == 0 {
+		return fmt.Errorf("%w: blue/green deploys need a TCP container port", ErrInvalidStrategy)
+	}
+	return nil
+}
+
+// appPort is the container port a blue/green project's domain routes to
+func appPort(p *models.Project) uint16 {
+	if p.Docker != nil {
+		for _, port := range p.Docker.Ports {
+			if port.Container != 0 && (port.Protocol == "" || port.Protocol == "tcp") {
+				return port.Container
+			}
+		}
+	}
+	if p.HealthCheck != nil {
+		return p.HealthCheck.Port
+	}
+	return 0
+}
+
+// blueGreenOptions publishes the container's TCP ports on loopback only, on
+// ports picked by Docker, so that two versions can run at once
+func blueGreenOptions(project *models.Project, image, deployID string) docker.CreateContainerOptions {
+	opts := containerOptions(project, image, deployID)
+	opts.Ports = nil
+
+	seen := make(map[uint16]bool)
+	publish := func(port uint16) {
+		if port != 0 && !seen[port] {
+			seen[port] = true
+			opts.Ports = append(opts.Ports, fmt.Sprintf("127.0.0.1::%d/tcp", port))
+		}
+	}
+	publish(appPort(project))
+	if project.HealthCheck != nil {
+		publish(project.HealthCheck.Port)
+	}
+	return opts
+}
+
+// replaceBlueGreen starts the new container next to the previous one, waits
+// for it to pass its health check and moves the project's domain to it. The
+// previous container serves until then and is drained and removed afterwards.
+// If anything fails before the switch, only the new container is removed.
+func (d *Deployer) replaceBlueGreen(ctx context.Context, project *models.Project, image, deployID string, log *Logger) (*result, error) {
+	if d.Proxy == nil {
+		return nil, errors.New("no reverse proxy is configured for blue/green deploys")
+	}
+	previous, err := d.deployedContainers(ctx, project.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+	kept := &result{keptPrevious: len(previous) > 0}
+
+	log.Phase(PhaseStart)
+	log.Printf("Creating container from %s next to the running version", image)
+	containerID, err := d.Docker.CreateContainer(ctx, blueGreenOptions(project, image, deployID))
+	if err != nil {
+		return kept, err
+	}
+	abort := func(err error) (*result, error) {
+		d.Docker.RemoveContainer(context.WithoutCancel(ctx), containerID, true)
+		if kept.keptPrevious {
+			log.Printf("Aborted; the previous version keeps serving")
+		}
+		return kept, err
+	}
+
+	log.Printf("Starting container %s", shortID(containerID))
+	if err := d.Docker.StartContainer(ctx, containerID); err != nil {
+		return abort(err)
+	}
+	stop := d.followStartup(containerID, "", log)
+	log.Phase(PhaseHealthcheck)
+	err = d.waitHealthy(ctx, project, containerID, log)
+	stop()
+	if err != nil {
+		return abort(fmt.Errorf("health check failed: %w", err))
+	}
+
+	upstream, err := d.Docker.PublishedAddress(ctx, containerID, appPort(project))
+	if err != nil {
+		return abort(err)
+	}
+	log.Printf("Routing %s to %s", project.Domain, upstream)
+	site := proxy.Site{Name: project.ID, Domain: project.Domain, Upstream: upstream, SSL: project.SSL}
+	if err := d.Proxy.Route(site); err != nil {
+		return abort(fmt.Errorf("failed to switch upstream: %w", err))
+	}
+
+	// Past the switch the deployment completes; cancelling only cuts the drain short
+	cancelled := ctx.Done()
+	ctx = context.WithoutCancel(ctx)
+	if len(previous) > 0 {
+		d.mu.Lock()
+		drain := d.drainTimeout
+		d.mu.Unlock()
+		log.Printf("Draining previous version for %s", drain)
+		select {
+		case <-time.After(drain):
+		case <-cancelled:
+		}
+	}
+	for _, id := range previous {
+		log.Printf("Stopping previous container %s", shortID(id))
+		if err := d.Docker.StopContainer(ctx, id); err != nil {
+			log.Printf("Warning: failed to stop %s: %v", shortID(id), err)
+		}
+		if err := d.Docker.RemoveContainer(ctx, id, true); err != nil {
+			log.Printf("Warning: failed to remove previous container %s: %v", shortID(id), err)
+		}
+	}
+	if err := d.Docker.RenameContainer(ctx, containerID, containerName(project.ID)); err != nil {
+		log.Printf("Warning: failed to rename container: %v", err)
+	}
+
+	return &result{containers: []string{shortID(containerID)}, removed: previous}, nil
+}
+
+// waitHealthy runs the project's health check against a new container until
+// it passes, the container exits or the start timeout runs out. Without a
+// configured check, the image's HEALTHCHECK is used if it has one and a TCP
+// connect otherwise.
+func (d *Deployer) waitHealthy(ctx context.Context, project *models.Project, containerID string, log *Logger) error {
+	var hc models.HealthCheck
+	if project.HealthCheck != nil {
+		hc = *project.HealthCheck
+	}
+	if hc.Port == 0 {
+		hc.Port = appPort(project)
+	}
+	interval := seconds(hc.Interval, defaultCheckInterval)
+	timeout := seconds(hc.Timeout, defaultCheckTimeout)
+	startTimeout := seconds(hc.StartTimeout, defaultStartTimeout)
+
+	ctx, cancel := context.WithTimeout(ctx, startTimeout)
+	defer cancel()
+
+	var last error
+	for {
+		state, err := d.Docker.InspectState(ctx, containerID)
+		if ctx.Err() != nil {
+			return fmt.Errorf("not healthy within %s: %v", startTimeout, last)
+		}
+		if err != nil {
+			return err
+		}
+		if !state.Running || state.Restarting {
+			return fmt.Errorf("container exited with code %d", state.ExitCode)
+		}
+
+		if hc.Type == "" {
+			hc.Type = "tcp"
+			if state.Health != "" {
+				hc.Type = "docker"
+			}
+		}
+		if last == nil {
+			log.Printf("Waiting for %s health check", hc.Type)
+		}
+
+		var failure error
+		switch hc.Type {
+		case "docker":
+			switch state.Health {
+			case "healthy":
+			case "unhealthy":
+				return errors.New("the container's HEALTHCHECK reports unhealthy")
+			case "":
+				return errors.New("the image has no HEALTHCHECK")
+			default:
+				failure = errors.New("health is " + state.Health)
+			}
+		default:
+			addr, err := d.Docker.PublishedAddress(ctx, containerID, hc.Port)
+			if err != nil {
+				return err
+			}
+			failure = probe(ctx, hc, addr, project.Domain, timeout)
+		}
+		if failure == nil {
+			log.Printf("✓ Health check passed")
+			return nil
+		}
+		if last == nil || last.Error() != failure.Error() {
+			log.Printf("  %v", failure)
+		}
+		last = failure
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(interval):
+		}
+	}
+}
+
+// probe runs one HTTP or TCP check against addr. HTTP checks send the
+// project's domain as Host header.
+func probe(ctx context.Context, hc models.HealthCheck, addr, host string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if hc.Type == "tcp" {
+		conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	path := hc.Path
+	if path == "" {
+		path = "/"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+path, nil)
+	if err != nil {
+		return err
+	}
+	if host != "" {
+		req.Host = host
+	}
+	resp, err := healthClient.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	if hc.ExpectedStatus != 0 && resp.StatusCode != hc.ExpectedStatus {
+		return fmt.Errorf("%s returned HTTP %d, expected %d", path, resp.StatusCode, hc.ExpectedStatus)
+	}
+	if hc.ExpectedStatus == 0 && (resp.StatusCode < 200 || resp.StatusCode >= 400) {
+		return fmt.Errorf("%s returned HTTP %d", path, resp.StatusCode)
+	}
+	return nil
+}
+
+// seconds converts a setting in seconds, using def for 0
+func seconds(value int, def time.Duration) time.Duration {
+	if value <= 0 {
+		return def
+	}
+	return time.Duration(value) * time.Second
+}
@@ -0,0 +1,86 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/bizino-services/biz-panel-backend/internal/buildpack"
+	"github.com/bizino-services/biz-panel-backend/internal/models"
+)
+
+// ErrNotBuilt is returned when asking how a project that runs an image or
+// a compose file is built
+var ErrNotBuilt = errors.New("only git projects without a compose file are built from their repository")
+
+// buildPlan returns the Dockerfile a checkout is built with. Without a
+// configured Dockerfile and without one in the repository, the build is
+// detected and plan describes the Dockerfile to generate.
+func buildPlan(project *models.Project, src string) (dockerfile string, plan *buildpack.Plan, err error) {
+	var overrides buildpack.Overrides
+	if cfg := project.Docker; cfg != nil {
+		if cfg.Dockerfile != "" {
+			return cfg.Dockerfile, nil, nil
+		}
+		overrides = buildpack.Overrides{Install: cfg.InstallCommand, Build: cfg.BuildCommand, Start: cfg.StartCommand}
+	}
+	if _, err := os.Stat(filepath.Join(src, "Dockerfile")); err == nil {
+		return "Dockerfile", nil, nil
+	}
+
+	plan, err = buildpack.Detect(src, overrides)
+	if err != nil {
+		return "", nil, err
+	}
+	return buildpack.DockerfileName, plan, nil
+}
+
+// logPlan shows a detected build before it runs
+func logPlan(plan *buildpack.Plan, project *models.Project, log *Logger) {
+	name := buildpack.Name(plan.Runtime)
+	if plan.Version != "" {
+		name += " " + plan.Version
+	}
+	log.Printf("No Dockerfile found; detected %s", name)
+	for _, step := range []struct{ name, cmd string }{
+		{"install", plan.Install},
+		{"build", plan.Build},
+		{"start", plan.Start},
+	} {
+		if step.cmd != "" {
+			log.Printf("  %-8s %s", step.name+":", step.cmd)
+		}
+	}
+	log.Printf("  %-8s %d", "port:", plan.Port)
+
+	if project.Docker == nil || len(project.Docker.Ports) == 0 {
+		log.Printf("The app listens on port %d; add a port mapping to reach it", plan.Port)
+	}
+}
+
+// BuildPlan clones a git project's repository and tells how its image
+// would be built: with the Dockerfile returned, or with the detected plan
+// when the repository has none
+func (d *Deployer) BuildPlan(ctx context.Context, projectID string) (dockerfile string, plan *buildpack.Plan, err error) {
+	project, err := d.Store.Projects.Get(projectID)
+	if err != nil {
+		return "", nil, err
+	}
+	if project.Type != models.ProjectTypeGit || IsCompose(project) {
+		return "", nil, ErrNotBuilt
+	}
+	if err := deployable(project); err != nil {
+		return "", nil, err
+	}
+	if err := d.Secrets.Open(project); err != nil {
+		return "", nil, err
+	}
+
+	dir, src, err := d.checkoutProject(ctx, project, "", "", nil)
+	if err != nil {
+		return "", nil, err
+	}
+	defer os.RemoveAll(dir)
+	return buildPlan(project, src)
+}
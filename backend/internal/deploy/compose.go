@@ -0,0 +1,598 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bizino-services/biz-panel-backend/internal/compose"
+	"github.com/bizino-services/biz-panel-backend/internal/docker"
+	"github.com/bizino-services/biz-panel-backend/internal/models"
+)
+
+// Labels set on compose containers and volumes
+const (
+	ServiceLabel = "biz-panel.service"
+	VolumeLabel  = "biz-panel.volume"
+)
+
+// dependencyTimeout bounds the wait for a dependency to become healthy or
+// to complete
+const dependencyTimeout = 5 * time.Minute
+
+// dependencyPoll is how often a dependency's state is checked
+const dependencyPoll = 2 * time.Second
+
+// IsCompose reports whether a project is defined by a compose file
+func IsCompose(p *models.Project) bool {
+	return p.Docker != nil && strings.TrimSpace(p.Docker.DockerCompose) != ""
+}
+
+// composeFile is a parsed compose file and where it was read from
+type composeFile struct {
+	project *compose.Project
+	root    string // Repository checkout; empty for inline files
+	dir     string // Directory holding the compose file; empty for inline files
+	data    string
+	cleanup func()
+}
+
+// loadCompose reads and parses a project's compose file. For git projects
+// DockerCompose is a path in the repository, which is cloned first; it is
+// otherwise the file itself. Project environment variables take precedence
+// over the .env file next to the compose file. For a rollback, the file and
+// commit of the deployment rolled back to are used.
+func (d *Deployer) loadCompose(ctx context.Context, project *models.Project, deployID string, from *models.Deployment, log *Logger) (*composeFile, error) {
+	cf := &composeFile{cleanup: func() {}}
+	data := []byte(project.Docker.DockerCompose)
+	if from != nil {
+		data = []byte(from.ComposeFile)
+	}
+
+	if project.Type == models.ProjectTypeGit && !strings.Contains(project.Docker.DockerCompose, "\n") {
+		dir, src, err := d.checkoutProject(ctx, project, deployID, pinnedRevision(project, deployID), log)
+		if err != nil {
+			return nil, err
+		}
+		cf.cleanup = func() { os.RemoveAll(dir) }
+
+		file, err := within(src, filepath.Join(src, filepath.FromSlash(strings.TrimSpace(project.Docker.DockerCompose))))
+		if err == nil {
+			data, err = os.ReadFile(file)
+		}
+		if err != nil {
+			cf.cleanup()
+			return nil, fmt.Errorf("failed to read compose file: %w", err)
+		}
+		cf.root, cf.dir = src, filepath.Dir(file)
+		log.Printf("Using compose file %s", strings.TrimSpace(project.Docker.DockerCompose))
+	}
+
+	dotenv := map[string]string{}
+	if cf.dir != "" {
+		path, err := within(cf.root, filepath.Join(cf.dir, ".env"))
+		var env map[string]string
+		if err == nil {
+			env, err = compose.ReadEnvFile(path)
+		}
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			cf.cleanup()
+			return nil, fmt.Errorf(".env: %w", err)
+		}
+		if env != nil {
+			dotenv = env
+		}
+	}
+	lookup := func(name string) (string, bool) {
+		if v, ok := project.Environment[name]; ok {
+			return v, true
+		}
+		v, ok := dotenv[name]
+		return v, ok
+	}
+
+	p, err := compose.Parse(data, lookup)
+	if err != nil {
+		cf.cleanup()
+		return nil, err
+	}
+	cf.project = p
+	cf.data = string(data)
+	return cf, nil
+}
+
+// serviceEnv merges a service's env_file entries and environment into a
+// sorted KEY=value list
+func (cf *composeFile) serviceEnv(name string, svc *compose.Service) ([]string, error) {
+	env := make(map[string]string)
+	for _, f := range svc.EnvFile {
+		if cf.dir == "" {
+			return nil, fmt.Errorf("service %s: env_file needs a compose file in the repository", name)
+		}
+		path, err := within(cf.root, filepath.Join(cf.dir, filepath.FromSlash(f)))
+		if err != nil {
+			return nil, fmt.Errorf("service %s: env_file %s: %w", name, f, err)
+		}
+		values, err := compose.ReadEnvFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("service %s: env_file %s: %w", name, f, err)
+		}
+		for k, v := range values {
+			env[k] = v
+		}
+	}
+	for k, v := range svc.Environment {
+		if v != nil {
+			env[k] = *v
+		}
+	}
+
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	list := make([]string, 0, len(keys))
+	for _, k := range keys {
+		list = append(list, k+"="+env[k])
+	}
+	return list, nil
+}
+
+// serviceDeploy tracks a service container started by a deployment
+type serviceDeploy struct {
+	name        string
+	containerID string
+	previous    []string
+}
+
+// deployCompose builds the compose file's images and replaces each
+// service's container in dependency order. If any service fails, every new
+// container is removed and the previous ones are started again.
+func (d *Deployer) deployCompose(ctx context.Context, project *models.Project, deployID string, from *models.Deployment, log *Logger) (*result, error) {
+	if project.Type == models.ProjectTypeGit {
+		d.setStatus(project.ID, deployID, models.ProjectStatusBuilding, models.DeployStatusBuilding)
+	}
+	cf, err := d.loadCompose(ctx, project, deployID, from, log)
+	if err != nil {
+		return nil, err
+	}
+	defer cf.cleanup()
+
+	order, err := cf.project.Order()
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Compose services: %s", strings.Join(order, ", "))
+
+	images := make(map[string]string, len(order))
+	if from != nil {
+		for _, img := range from.Images {
+			images[img.Service] = img.ImageID
+		}
+	}
+	building := false
+	for _, name := range order {
+		svc := cf.project.Services[name]
+		if from != nil {
+			if images[name] == "" {
+				return nil, fmt.Errorf("deployment %s has no image for service %s", from.ID, name)
+			}
+			continue
+		}
+		if svc.Build == nil {
+			images[name] = svc.Image
+			continue
+		}
+		if !building {
+			log.Phase(PhaseBuild)
+			building = true
+		}
+		tag, err := d.buildService(ctx, project, cf, name, svc, deployID, log)
+		if err != nil {
+			return nil, err
+		}
+		images[name] = tag
+	}
+
+	// Images are recorded even if the deployment fails, so retention can remove them
+	defer func() {
+		if from != nil {
+			d.recordImages(deployID, from.Images, cf.data)
+			return
+		}
+		deployed := make([]models.DeployedImage, 0, len(order))
+		for _, name := range order {
+			deployed = append(deployed, d.deployedImage(ctx, name, images[name]))
+		}
+		d.recordImages(deployID, deployed, cf.data)
+	}()
+
+	d.setStatus(project.ID, deployID, models.ProjectStatusDeploying, models.DeployStatusDeploying)
+
+	pulling := false
+	for _, name := range order {
+		if from != nil || cf.project.Services[name].Build != nil {
+			continue
+		}
+		if _, err := d.Docker.ImageID(ctx, images[name]); err == nil {
+			continue
+		}
+		if !pulling {
+			log.Phase(PhasePull)
+			pulling = true
+		}
+		log.Printf("Pulling image %s for %s", images[name], name)
+		if err := d.Docker.PullImage(ctx, images[name], log.Line); err != nil {
+			return nil, fmt.Errorf("service %s: %w", name, err)
+		}
+	}
+
+	log.Phase(PhaseStart)
+	volumes, err := d.composeVolumes(ctx, project, cf.project, log)
+	if err != nil {
+		return nil, err
+	}
+
+	// Containers of earlier deployments, by service. Containers whose
+	// service is no longer defined are removed once the deployment succeeds.
+	existing, err := d.Docker.ListContainers(ctx, project.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+	previous := make(map[string][]string)
+	var orphans []string
+	for _, c := range existing {
+		if c.Labels[DeployLabel] == "" {
+			continue
+		}
+		if _, ok := cf.project.Services[c.Labels[ServiceLabel]]; ok {
+			previous[c.Labels[ServiceLabel]] = append(previous[c.Labels[ServiceLabel]], c.ID)
+		} else {
+			orphans = append(orphans, c.ID)
+		}
+	}
+
+	var started []*serviceDeploy
+	var following []func()
+	stopFollowing := func() {
+		for _, stop := range following {
+			stop()
+		}
+		following = nil
+	}
+	rollback := func(err error) (*result, error) {
+		stopFollowing()
+		// Recovery must finish even if the deployment was cancelled
+		ctx := context.WithoutCancel(ctx)
+		kept := false
+		for i := len(started) - 1; i >= 0; i-- {
+			s := started[i]
+			d.Docker.RemoveContainer(ctx, s.containerID, true)
+			for _, id := range s.previous {
+				log.Printf("Restarting previous container %s of %s", shortID(id), s.name)
+				d.Docker.StartContainer(ctx, id)
+			}
+		}
+		for _, ids := range previous {
+			kept = kept || len(ids) > 0
+		}
+		return &result{keptPrevious: kept}, err
+	}
+
+	// Services that others wait on to complete are expected to exit
+	oneShot := make(map[string]bool)
+	for _, name := range order {
+		for dep, cond := range cf.project.Services[name].DependsOn {
+			if cond.Condition == compose.ConditionCompletedSuccessfully {
+				oneShot[dep] = true
+			}
+		}
+	}
+
+	containerIDs := make(map[string]string, len(order))
+	for _, name := range order {
+		svc := cf.project.Services[name]
+
+		deps := make([]string, 0, len(svc.DependsOn))
+		for dep := range svc.DependsOn {
+			deps = append(deps, dep)
+		}
+		sort.Strings(deps)
+		for _, dep := range deps {
+			if err := d.waitForDependency(ctx, containerIDs[dep], dep, svc.DependsOn[dep].Condition, log); err != nil {
+				return rollback(fmt.Errorf("service %s: %w", name, err))
+			}
+		}
+
+		opts, err := d.serviceOptions(ctx, project, cf, name, svc, images[name], deployID, volumes)
+		if err != nil {
+			return rollback(err)
+		}
+		log.Printf("Creating container for %s from %s", name, images[name])
+		id, err := d.Docker.CreateContainer(ctx, opts)
+		if err != nil {
+			return rollback(fmt.Errorf("service %s: %w", name, err))
+		}
+		s := &serviceDeploy{name: name, containerID: id, previous: previous[name]}
+		started = append(started, s)
+		containerIDs[name] = id
+
+		// Host ports can only be bound by one container at a time
+		for _, old := range s.previous {
+			if err := d.Docker.StopContainer(ctx, old); err != nil {
+				log.Printf("Warning: failed to stop %s: %v", shortID(old), err)
+			}
+		}
+		log.Printf("Starting %s (%s)", name, shortID(id))
+		if err := d.Docker.StartContainer(ctx, id); err != nil {
+			return rollback(fmt.Errorf("service %s: %w", name, err))
+		}
+		following = append(following, d.followStartup(id, name+" | ", log))
+	}
+
+	log.Phase(PhaseHealthcheck)
+	log.Printf("Checking that the services stay up for %s", startupGrace)
+	err = sleep(ctx, startupGrace)
+	stopFollowing()
+	if err != nil {
+		return rollback(err)
+	}
+	for _, s := range started {
+		state, err := d.Docker.InspectState(ctx, s.containerID)
+		if err != nil {
+			return rollback(fmt.Errorf("service %s: %w", s.name, err))
+		}
+		if state.Running && !state.Restarting {
+			continue
+		}
+		if oneShot[s.name] && state.Status == "exited" && state.ExitCode == 0 {
+			continue
+		}
+		return rollback(fmt.Errorf("service %s exited during startup (exit code %d)", s.name, state.ExitCode))
+	}
+
+	res := &result{}
+	for _, s := range started {
+		for _, id := range s.previous {
+			if err := d.Docker.RemoveContainer(ctx, id, true); err != nil {
+				log.Printf("Warning: failed to remove previous container %s: %v", shortID(id), err)
+			}
+		}
+		if err := d.Docker.RenameContainer(ctx, s.containerID, serviceContainerName(project.ID, s.name)); err != nil {
+			log.Printf("Warning: failed to rename container of %s: %v", s.name, err)
+		}
+		res.containers = append(res.containers, shortID(s.containerID))
+		res.removed = append(res.removed, s.previous...)
+	}
+	for _, id := range orphans {
+		log.Printf("Removing orphan container %s", shortID(id))
+		if err := d.Docker.RemoveContainer(ctx, id, true); err != nil {
+			log.Printf("Warning: failed to remove orphan container %s: %v", shortID(id), err)
+		}
+	}
+	res.removed = append(res.removed, orphans...)
+	return res, nil
+}
+
+// buildService builds the image of a service with a build section
+func (d *Deployer) buildService(ctx context.Context, project *models.Project, cf *composeFile, name string, svc *compose.Service, deployID string, log *Logger) (string, error) {
+	if cf.root == "" {
+		return "", fmt.Errorf("service %s has a build section, which needs a compose file in the repository", name)
+	}
+	contextDir := svc.Build.Context
+	if contextDir == "" {
+		contextDir = "."
+	}
+	dir, err := within(cf.root, filepath.Join(cf.dir, filepath.FromSlash(contextDir)))
+	if err != nil {
+		return "", fmt.Errorf("service %s: build context: %w", name, err)
+	}
+
+	opts := docker.BuildOptions{
+		ContextDir: dir,
+		Dockerfile: svc.Build.Dockerfile,
+		Tag:        "biz-panel/" + strings.ToLower(project.ID+"-"+name) + ":" + deployID,
+		BuildArgs:  svc.Build.Args,
+		Labels:     map[string]string{docker.ProjectLabel: project.ID, DeployLabel: deployID, ManagedLabel: "true"},
+	}
+	log.Printf("Building image %s for %s", opts.Tag, name)
+	if err := d.Docker.BuildImage(ctx, opts, log.Line); err != nil {
+		return "", fmt.Errorf("service %s: build failed: %w", name, err)
+	}
+	return opts.Tag, nil
+}
+
+// composeVolumes creates the compose file's named volumes and returns the
+// Docker name of each. External volumes are used as they are.
+func (d *Deployer) composeVolumes(ctx context.Context, project *models.Project, p *compose.Project, log *Logger) (map[string]string, error) {
+	names := make(map[string]string, len(p.Volumes))
+	for key, v := range p.Volumes {
+		if v == nil {
+			v = &compose.Volume{}
+		}
+		if v.External {
+			names[key] = key
+			if v.Name != "" {
+				names[key] = v.Name
+			}
+			continue
+		}
+
+		name := volumeName(project.ID, key)
+		if v.Name != "" {
+			name = v.Name
+		}
+		labels := map[string]string{VolumeLabel: key}
+		for k, val := range v.Labels {
+			labels[k] = val
+		}
+		if _, err := d.Docker.CreateVolume(ctx, name, project.ID, labels); err != nil {
+			return nil, fmt.Errorf("failed to create volume %s: %w", name, err)
+		}
+		log.Printf("Using volume %s", name)
+		names[key] = name
+	}
+	return names, nil
+}
+
+// serviceOptions builds the container definition of a compose service
+func (d *Deployer) serviceOptions(ctx context.Context, project *models.Project, cf *composeFile, name string, svc *compose.Service, image, deployID string, volumes map[string]string) (docker.CreateContainerOptions, error) {
+	opts := docker.CreateContainerOptions{
+		Name:       serviceContainerName(project.ID, name) + "-" + deployID,
+		Image:      image,
+		Network:    project.NetworkID,
+		Isolated:   true,
+		Aliases:    []string{name},
+		Cmd:        svc.Command,
+		Entrypoint: svc.Entrypoint,
+		Restart:    svc.Restart,
+		User:       svc.User,
+		WorkingDir: svc.WorkingDir,
+		NanoCPUs:   int64(project.Resources.CPULimit * 1e9),
+		Memory:     project.Resources.MemoryLimit,
+		Labels:     map[string]string{},
+	}
+
+	env, err := cf.serviceEnv(name, svc)
+	if err != nil {
+		return opts, err
+	}
+	opts.Environment = env
+
+	for _, p := range svc.Ports {
+		spec := fmt.Sprintf("%d/%s", p.Target, p.Protocol)
+		if p.Published != "" {
+			spec = p.Published + ":" + spec
+			if p.HostIP != "" {
+				spec = p.HostIP + ":" + spec
+			}
+		}
+		opts.Ports = append(opts.Ports, spec)
+	}
+
+	for i, m := range svc.Volumes {
+		var source string
+		switch {
+		case m.Type == compose.MountBind:
+			path, err := d.bindSource(project.ID, m.Source)
+			if err != nil {
+				return opts, fmt.Errorf("service %s: %w", name, err)
+			}
+			source = path
+		case m.Source == "":
+			// Anonymous volumes get a stable name so data survives redeploys
+			source = volumeName(project.ID, fmt.Sprintf("%s_%d", name, i))
+			if _, err := d.Docker.CreateVolume(ctx, source, project.ID, map[string]string{VolumeLabel: source}); err != nil {
+				return opts, fmt.Errorf("failed to create volume %s: %w", source, err)
+			}
+		default:
+			source = volumes[m.Source]
+		}
+		spec := source + ":" + m.Target
+		if m.ReadOnly {
+			spec += ":ro"
+		}
+		opts.Volumes = append(opts.Volumes, spec)
+	}
+
+	if hc := svc.Healthcheck; hc != nil {
+		opts.Healthcheck = &docker.Healthcheck{
+			Test:        hc.Test,
+			Interval:    hc.Interval,
+			Timeout:     hc.Timeout,
+			StartPeriod: hc.StartPeriod,
+			Retries:     hc.Retries,
+		}
+		if hc.Disable {
+			opts.Healthcheck = &docker.Healthcheck{Test: []string{"NONE"}}
+		}
+	}
+
+	for k, v := range svc.Labels {
+		opts.Labels[k] = v
+	}
+	// Ownership labels can't be overridden by the service's own labels
+	opts.Labels[docker.ProjectLabel] = project.ID
+	opts.Labels["biz-panel.project.name"] = project.Name
+	opts.Labels[DeployLabel] = deployID
+	opts.Labels[ManagedLabel] = "true"
+	opts.Labels[ServiceLabel] = name
+	return opts, nil
+}
+
+// waitForDependency blocks until a started dependency meets condition
+func (d *Deployer) waitForDependency(ctx context.Context, containerID, name, condition string, log *Logger) error {
+	if condition == compose.ConditionStarted {
+		return nil
+	}
+	log.Printf("Waiting for %s (%s)", name, condition)
+
+	deadline := time.Now().Add(dependencyTimeout)
+	for {
+		state, err := d.Docker.InspectState(ctx, containerID)
+		if err != nil {
+			return err
+		}
+		switch condition {
+		case compose.ConditionHealthy:
+			switch {
+			case state.Health == "healthy":
+				return nil
+			case state.Health == "unhealthy":
+				return fmt.Errorf("dependency %s is unhealthy", name)
+			case state.Health == "" && state.Running:
+				return fmt.Errorf("dependency %s has no healthcheck", name)
+			case !state.Running && !state.Restarting:
+				return fmt.Errorf("dependency %s exited with code %d", name, state.ExitCode)
+			}
+		case compose.ConditionCompletedSuccessfully:
+			if state.Status == "exited" || state.Status == "dead" {
+				if state.ExitCode != 0 {
+					return fmt.Errorf("dependency %s exited with code %d", name, state.ExitCode)
+				}
+				return nil
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %s", name)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(dependencyPoll):
+		}
+	}
+}
+
+// within resolves path, following symlinks, and checks that it stays in root
+func within(root, path string) (string, error) {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	if real != realRoot && !strings.HasPrefix(real, realRoot+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside the repository", path)
+	}
+	return real, nil
+}
+
+// serviceContainerName is the name of a compose service's running container
+func serviceContainerName(projectID, service string) string {
+	return containerName(projectID) + "-" + service
+}
+
+// volumeName is the Docker name of a compose volume
+func volumeName(projectID, volume string) string {
+	return containerName(projectID) + "_" + volume
+}
@@ -0,0 +1,758 @@
+// Package deploy builds projects and runs them as containers on their
+// isolated project network.
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bizino-services/biz-panel-backend/internal/docker"
+	"github.com/bizino-services/biz-panel-backend/internal/models"
+	"github.com/bizino-services/biz-panel-backend/internal/proxy"
+	"github.com/bizino-services/biz-panel-backend/internal/secrets"
+	"github.com/bizino-services/biz-panel-backend/internal/store"
+	"github.com/google/uuid"
+)
+
+// DefaultWorkDir is where repositories are checked out for builds
+const DefaultWorkDir = "/var/lib/biz-panel/builds"
+
+// DefaultDataDir holds a directory per project that its bind mounts are
+// confined to
+const DefaultDataDir = "/var/lib/biz-panel/data"
+
+// Labels set on the containers and images a deployment creates
+const (
+	DeployLabel  = "biz-panel.deploy"
+	ManagedLabel = "biz-panel.managed"
+)
+
+// deployTimeout bounds a whole deployment, build included
+const deployTimeout = 30 * time.Minute
+
+// startupGrace is how long a new container must stay up before the
+// previous one is removed
+const startupGrace = 5 * time.Second
+
+var (
+	ErrInProgress     = errors.New("a deployment or service operation is already running for this project")
+	ErrNoDocker       = errors.New("docker not available")
+	ErrNothingToBuild = errors.New("project has nothing to deploy")
+)
+
+// Deployer runs deployments, one at a time per project and at most
+// maxConcurrent at once; the others wait in its queue
+type Deployer struct {
+	Store     *store.Store
+	Docker    *docker.Client   // nil when Docker is unavailable
+	Proxy     *proxy.Nginx     // Routes the domains of blue/green projects and serves static sites
+	Secrets   *secrets.Keyring // Decrypts project secrets when they are injected
+	WorkDir   string
+	DataDir   string
+	StaticDir string
+
+	mu              sync.Mutex
+	running         map[string]string                  // Project ID -> deploy ID or operation name
+	streams         map[string]*Stream                 // Deploy ID -> live output
+	queue           []*job                             // Waiting deployments, oldest first
+	cancels         map[string]context.CancelCauseFunc // Deploy ID -> aborts a running deployment
+	active          int                                // Running deployments
+	maxConcurrent   int
+	keepImages      int
+	keepDeployments int
+	drainTimeout    time.Duration
+	previewMu       sync.Mutex // Serializes creating preview environments
+}
+
+// New creates a deployer using the default work directory
+func New(s *store.Store, dockerClient *docker.Client) *Deployer {
+	return &Deployer{
+		Store:     s,
+		Docker:    dockerClient,
+		WorkDir:   DefaultWorkDir,
+		DataDir:   DefaultDataDir,
+		StaticDir: DefaultStaticDir,
+		running:   make(map[string]string),
+		streams:   make(map[string]*Stream),
+		cancels:   make(map[string]context.CancelCauseFunc),
+
+		maxConcurrent:   DefaultMaxConcurrent,
+		keepImages:      DefaultKeepImages,
+		keepDeployments: DefaultKeepDeployments,
+		drainTimeout:    DefaultDrainTimeout,
+	}
+}
+
+// request describes a deployment to start
+type request struct {
+	userID  string
+	trigger string             // manual, rollback or webhook
+	from    *models.Deployment // Deployment whose images a rollback runs again
+	commit  *Commit            // Commit to build instead of the branch head
+}
+
+// Start queues a new deployment of a project; it runs in the background
+// once the project and a deploy slot are free
+func (d *Deployer) Start(projectID, userID string) (*models.Deployment, error) {
+	return d.start(projectID, request{userID: userID, trigger: models.DeployTriggerManual})
+}
+
+// StartCommit deploys a specific commit of a git project, e.g. the one a
+// push webhook announced
+func (d *Deployer) StartCommit(projectID, trigger string, commit Commit) (*models.Deployment, error) {
+	return d.start(projectID, request{trigger: trigger, commit: &commit})
+}
+
+// start records a deployment and queues it. A rollback runs the images of
+// req.from again; other deployments build or pull as usual and supersede
+// the project's waiting ones.
+func (d *Deployer) start(projectID string, req request) (*models.Deployment, error) {
+	if d.Docker == nil {
+		return nil, ErrNoDocker
+	}
+	project, err := d.Store.Projects.Get(projectID)
+	if err != nil {
+		return nil, err
+	}
+	if err := deployable(project); err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	j := &job{projectID: projectID, deployID: uuid.New().String()[:8], req: req}
+	deployment := &models.Deployment{
+		ID:          j.deployID,
+		ProjectID:   projectID,
+		Status:      models.DeployStatusQueued,
+		StartedAt:   time.Now(),
+		Trigger:     req.trigger,
+		TriggeredBy: req.userID,
+	}
+	j.logs = []string{"Starting deployment..."}
+	if from := req.from; from != nil {
+		j.logs = []string{"Rolling back to deployment " + from.ID + "..."}
+		deployment.CommitSHA = from.CommitSHA
+		deployment.Author = from.Author
+		deployment.Message = from.Message
+		deployment.RollbackOf = from.ID
+	}
+	if commit := req.commit; commit != nil {
+		j.logs = []string{"Starting deployment of commit " + shortSHA(commit.SHA) + "..."}
+		deployment.CommitSHA = commit.SHA
+		deployment.Author = commit.Author
+		deployment.Message = commit.Message
+	}
+	if req.from == nil {
+		d.supersede(projectID, j.deployID)
+	}
+	if !d.canBegin(projectID) {
+		j.logs = append(j.logs, "Queued until the project's running deployment or a deploy slot is done")
+	}
+	deployment.Logs = j.logs
+	if err := d.Store.Deployments.Save(deployment); err != nil {
+		return nil, err
+	}
+
+	stream := newStream()
+	for _, line := range j.logs {
+		stream.publish("", line, false)
+	}
+	d.streams[j.deployID] = stream
+	d.queue = append(d.queue, j)
+	d.dispatch()
+
+	if dep, err := d.Store.Deployments.Get(j.deployID); err == nil {
+		deployment = dep
+	}
+	return deployment, nil
+}
+
+// deployable checks that a project has something to build or run
+func deployable(p *models.Project) error {
+	built := p.Type == models.ProjectTypeGit || p.Type == models.ProjectTypeStatic
+	if built && (p.Repository == nil || p.Repository.URL == "") {
+		return ErrNothingToBuild
+	}
+	if !built && !IsCompose(p) && (p.Docker == nil || p.Docker.Image == "") {
+		return ErrNothingToBuild
+	}
+	return nil
+}
+
+// Running reports whether a deployment of the project is in progress
+func (d *Deployer) Running(projectID string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, ok := d.running[projectID]
+	return ok
+}
+
+// run performs a deployment that begin started and records its outcome
+func (d *Deployer) run(ctx context.Context, j *job) {
+	projectID, deployID, from := j.projectID, j.deployID, j.req.from
+	defer func() {
+		d.mu.Lock()
+		delete(d.running, projectID)
+		delete(d.cancels, deployID)
+		d.active--
+		d.dispatch()
+		d.mu.Unlock()
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, deployTimeout)
+	defer cancel()
+
+	d.mu.Lock()
+	stream := d.streams[deployID]
+	d.mu.Unlock()
+
+	logger := newLogger(d.Store, stream, projectID, deployID)
+	result, err := d.deploy(ctx, projectID, deployID, from, logger)
+	cancelled := err != nil && errors.Is(context.Cause(ctx), ErrCancelled)
+	switch {
+	case cancelled:
+		logger.Printf("✗ Deployment cancelled")
+		d.removePartial(projectID, deployID, logger)
+	case err != nil:
+		logger.Printf("✗ Deployment failed: %v", err)
+	default:
+		logger.Printf("✓ Deployment successful!")
+	}
+	logger.Flush()
+
+	var name string
+	project, _ := d.Store.Projects.Update(projectID, func(p *models.Project) error {
+		name = p.Name
+		if p.LastDeploy == nil || p.LastDeploy.ID != deployID {
+			return nil
+		}
+		finishedAt := time.Now()
+		p.LastDeploy.FinishedAt = &finishedAt
+		p.LastDeploy.Duration = int64(finishedAt.Sub(p.LastDeploy.StartedAt).Seconds())
+		p.UpdatedAt = finishedAt
+		if err != nil {
+			p.Status = models.ProjectStatusFailed
+			p.LastDeploy.Status = models.DeployStatusFailed
+			if cancelled {
+				p.Status = j.previousStatus
+				p.LastDeploy.Status = models.DeployStatusCancelled
+			}
+			if result != nil && result.keptPrevious {
+				// The previous container is still serving
+				p.Status = models.ProjectStatusRunning
+			}
+			return nil
+		}
+		p.Status = models.ProjectStatusRunning
+		p.LastDeploy.Status = models.DeployStatusSuccess
+		p.Containers = replaceIDs(p.Containers, result.removed, result.containers)
+		return nil
+	})
+	if project != nil && project.LastDeploy != nil && project.LastDeploy.ID == deployID {
+		d.finishRecord(project.LastDeploy)
+	}
+	switch {
+	case cancelled:
+		// Cancel records the activity, with the user who cancelled
+		d.closeStream(deployID, models.DeployStatusCancelled)
+		return
+	case err != nil:
+		d.closeStream(deployID, models.DeployStatusFailed)
+	default:
+		d.closeStream(deployID, models.DeployStatusSuccess)
+		d.prune(context.WithoutCancel(ctx), projectID)
+	}
+
+	activity := &models.Activity{
+		ID:          uuid.New().String()[:8],
+		Type:        "deploy",
+		Title:       "Deployment Succeeded",
+		Description: fmt.Sprintf("Deployment %s of '%s' is live", deployID, name),
+		Status:      "success",
+		ProjectID:   projectID,
+		Timestamp:   time.Now(),
+	}
+	if err != nil {
+		activity.Title = "Deployment Failed"
+		activity.Description = fmt.Sprintf("Deployment %s of '%s' failed: %v", deployID, name, err)
+		activity.Status = "failed"
+	}
+	if err := d.Store.Activities.Append(activity); err != nil {
+		log.Printf("Warning: failed to record activity %q: %v", activity.Title, err)
+	}
+}
+
+// result describes the containers a deployment changed
+type result struct {
+	containers   []string
+	removed      []string
+	keptPrevious bool // The deployment failed but the previous container still runs
+}
+
+// deploy builds or pulls the image and replaces the project's container
+func (d *Deployer) deploy(ctx context.Context, projectID, deployID string, from *models.Deployment, log *Logger) (*result, error) {
+	project, err := d.Store.Projects.Get(projectID)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.Secrets.Open(project); err != nil {
+		return nil, err
+	}
+	if err := ValidateStrategy(project); err != nil {
+		return nil, err
+	}
+	if from != nil && (project.Type == models.ProjectTypeStatic) != (from.Release != "") {
+		return nil, fmt.Errorf("deployment %s was made before the project switched to or from a static site", from.ID)
+	}
+	if project.Type == models.ProjectTypeStatic {
+		return d.deployStatic(ctx, project, deployID, from, log)
+	}
+
+	if project.NetworkID == "" {
+		networkID, err := d.Docker.CreateProjectNetwork(ctx, project.ID, project.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create project network: %w", err)
+		}
+		project.NetworkID = networkID
+		d.Store.Projects.Update(projectID, func(p *models.Project) error {
+			p.NetworkID = networkID
+			return nil
+		})
+	}
+	log.Printf("Using network: %s", shortID(project.NetworkID))
+
+	if from != nil && IsCompose(project) != (from.Images[0].Service != "") {
+		return nil, fmt.Errorf("deployment %s was made before the project switched to or from a compose file", from.ID)
+	}
+	if IsCompose(project) {
+		return d.deployCompose(ctx, project, deployID, from, log)
+	}
+
+	if err := d.resolveVolumes(ctx, project); err != nil {
+		return nil, err
+	}
+
+	var image string
+	if from != nil {
+		image = from.Images[0].ImageID
+		log.Printf("Using image %s (%s) from deployment %s", from.Images[0].Image, shortImageID(image), from.ID)
+	} else {
+		image, err = d.prepareImage(ctx, project, deployID, log)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	d.setStatus(projectID, deployID, models.ProjectStatusDeploying, models.DeployStatusDeploying)
+	replace := d.replaceContainer
+	if project.Strategy == models.DeployStrategyBlueGreen {
+		replace = d.replaceBlueGreen
+	}
+	res, err := replace(ctx, project, image, deployID, log)
+	if from != nil {
+		d.recordImages(deployID, from.Images, "")
+	} else {
+		d.recordImages(deployID, []models.DeployedImage{d.deployedImage(ctx, "", image)}, "")
+	}
+	return res, err
+}
+
+// prepareImage returns the image to run, building it from the repository
+// for git projects
+func (d *Deployer) prepareImage(ctx context.Context, project *models.Project, deployID string, log *Logger) (string, error) {
+	switch project.Type {
+	case models.ProjectTypeGit:
+	default:
+		image := project.Docker.Image
+		log.Phase(PhasePull)
+		if _, err := d.Docker.ImageID(ctx, image); err == nil {
+			log.Printf("Using image %s", image)
+			return image, nil
+		}
+		log.Printf("Pulling image %s", image)
+		if err := d.Docker.PullImage(ctx, image, log.Line); err != nil {
+			return "", err
+		}
+		return image, nil
+	}
+
+	d.setStatus(project.ID, deployID, models.ProjectStatusBuilding, models.DeployStatusBuilding)
+
+	dir, src, err := d.checkoutProject(ctx, project, deployID, pinnedRevision(project, deployID), log)
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	opts := docker.BuildOptions{
+		ContextDir: src,
+		Tag:        imageTag(project.ID, deployID),
+		Labels:     map[string]string{docker.ProjectLabel: project.ID, DeployLabel: deployID, ManagedLabel: "true"},
+	}
+	if project.Docker != nil {
+		opts.BuildArgs = project.Docker.BuildArgs
+	}
+	log.Phase(PhaseBuild)
+	dockerfile, plan, err := buildPlan(project, src)
+	if err != nil {
+		return "", err
+	}
+	if plan != nil {
+		logPlan(plan, project, log)
+		if err := os.WriteFile(filepath.Join(src, dockerfile), []byte(plan.Dockerfile), 0644); err != nil {
+			return "", err
+		}
+	}
+	opts.Dockerfile = dockerfile
+	log.Printf("Building image %s", opts.Tag)
+	if err := d.Docker.BuildImage(ctx, opts, log.Line); err != nil {
+		return "", fmt.Errorf("build failed: %w", err)
+	}
+	return opts.Tag, nil
+}
+
+// checkoutProject clones the project's repository into a new build
+// directory and records the commit on the deployment. The caller removes dir;
+// the checkout is in src. A non-empty revision pins the commit.
+func (d *Deployer) checkoutProject(ctx context.Context, project *models.Project, deployID, revision string, log *Logger) (dir, src string, err error) {
+	if err := os.MkdirAll(d.WorkDir, 0700); err != nil {
+		return "", "", err
+	}
+	dir, err = os.MkdirTemp(d.WorkDir, project.ID+"-")
+	if err != nil {
+		return "", "", err
+	}
+
+	log.Phase(PhaseClone)
+	src = filepath.Join(dir, "src")
+	commit, err := d.checkout(ctx, project.Repository, revision, src, log)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", "", err
+	}
+	d.Store.Projects.Update(project.ID, func(p *models.Project) error {
+		if deployID != "" && p.LastDeploy != nil && p.LastDeploy.ID == deployID {
+			p.LastDeploy.CommitSHA = commit.SHA
+			p.LastDeploy.Author = commit.Author
+			p.LastDeploy.Message = commit.Message
+		}
+		return nil
+	})
+	return dir, src, nil
+}
+
+// replaceContainer starts the new container and removes the previous one
+// once the new one has stayed up. If the new container doesn't start or the
+// deployment is cancelled, the previous one is started again.
+func (d *Deployer) replaceContainer(ctx context.Context, project *models.Project, image, deployID string, log *Logger) (*result, error) {
+	previous, err := d.deployedContainers(ctx, project.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	log.Phase(PhaseStart)
+	log.Printf("Creating container from %s", image)
+	containerID, err := d.Docker.CreateContainer(ctx, containerOptions(project, image, deployID))
+	if err != nil {
+		return nil, err
+	}
+
+	// Host ports can only be bound by one container at a time
+	for _, id := range previous {
+		log.Printf("Stopping previous container %s", shortID(id))
+		if err := d.Docker.StopContainer(ctx, id); err != nil {
+			log.Printf("Warning: failed to stop %s: %v", shortID(id), err)
+		}
+	}
+
+	log.Printf("Starting container %s", shortID(containerID))
+	err = d.Docker.StartContainer(ctx, containerID)
+	if err == nil {
+		stop := d.followStartup(containerID, "", log)
+		log.Phase(PhaseHealthcheck)
+		log.Printf("Checking that the container stays up for %s", startupGrace)
+		err = sleep(ctx, startupGrace)
+		var running bool
+		if err == nil {
+			if running, err = d.Docker.ContainerRunning(ctx, containerID); err == nil && !running {
+				err = errors.New("container exited during startup")
+			}
+		}
+		stop()
+	}
+	if err != nil {
+		// Recovery must finish even if the deployment was cancelled
+		ctx := context.WithoutCancel(ctx)
+		d.Docker.RemoveContainer(ctx, containerID, true)
+		for _, id := range previous {
+			log.Printf("Restarting previous container %s", shortID(id))
+			d.Docker.StartContainer(ctx, id)
+		}
+		return &result{keptPrevious: len(previous) > 0}, err
+	}
+
+	for _, id := range previous {
+		if err := d.Docker.RemoveContainer(ctx, id, true); err != nil {
+			log.Printf("Warning: failed to remove previous container %s: %v", shortID(id), err)
+		}
+	}
+	if err := d.Docker.RenameContainer(ctx, containerID, containerName(project.ID)); err != nil {
+		log.Printf("Warning: failed to rename container: %v", err)
+	}
+
+	return &result{containers: []string{shortID(containerID)}, removed: previous}, nil
+}
+
+// followStartup copies a new container's output to the deployment log until
+// stop is called. prefix labels the lines, e.g. with a compose service.
+func (d *Deployer) followStartup(containerID, prefix string, log *Logger) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := d.Docker.FollowLogs(ctx, containerID, func(line string) {
+			log.Line("  " + prefix + line)
+		})
+		if err != nil {
+			log.Printf("Warning: failed to follow output of %s: %v", shortID(containerID), err)
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// deployedContainers returns the IDs of containers created by earlier deployments
+func (d *Deployer) deployedContainers(ctx context.Context, projectID string) ([]string, error) {
+	containers, err := d.Docker.ListContainers(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, c := range containers {
+		if c.Labels[DeployLabel] != "" {
+			ids = append(ids, c.ID)
+		}
+	}
+	return ids, nil
+}
+
+// pinnedRevision is the commit a deployment was started for, by a webhook
+// or a rollback; empty builds the branch head
+func pinnedRevision(project *models.Project, deployID string) string {
+	if deployID != "" && project.LastDeploy != nil && project.LastDeploy.ID == deployID {
+		return project.LastDeploy.CommitSHA
+	}
+	return ""
+}
+
+// setStatus moves the project and its current deployment to a new stage
+func (d *Deployer) setStatus(projectID, deployID string, status models.ProjectStatus, deployStatus models.DeployStatus) {
+	d.Store.Projects.Update(projectID, func(p *models.Project) error {
+		if p.LastDeploy != nil && p.LastDeploy.ID == deployID {
+			p.Status = status
+			p.LastDeploy.Status = deployStatus
+		}
+		return nil
+	})
+}
+
+// containerOptions builds the container definition of a project
+func containerOptions(project *models.Project, image, deployID string) docker.CreateContainerOptions {
+	opts := docker.CreateContainerOptions{
+		Name:        containerName(project.ID) + "-" + deployID,
+		Image:       image,
+		Network:     project.NetworkID,
+		Isolated:    true,
+		NanoCPUs:    int64(project.Resources.CPULimit * 1e9),
+		Memory:      project.Resources.MemoryLimit,
+		Labels:      map[string]string{},
+		Environment: environment(project),
+	}
+
+	if cfg := project.Docker; cfg != nil {
+		for _, p := range cfg.Ports {
+			if p.Container == 0 {
+				continue
+			}
+			proto := p.Protocol
+			if proto == "" {
+				proto = "tcp"
+			}
+			opts.Ports = append(opts.Ports, fmt.Sprintf("%d:%d/%s", p.Host, p.Container, proto))
+		}
+		for _, v := range cfg.Volumes {
+			if v.Source != "" && v.Target != "" {
+				opts.Volumes = append(opts.Volumes, v.Source+":"+v.Target)
+			}
+		}
+		for k, v := range cfg.Labels {
+			opts.Labels[k] = v
+		}
+		opts.Cmd = cfg.Command
+		opts.Entrypoint = cfg.Entrypoint
+	}
+
+	// Ownership labels can't be overridden by the project's own labels
+	opts.Labels[docker.ProjectLabel] = project.ID
+	opts.Labels["biz-panel.project.name"] = project.Name
+	opts.Labels[DeployLabel] = deployID
+	opts.Labels[ManagedLabel] = "true"
+	return opts
+}
+
+// namedVolume matches the names Docker accepts for named volumes
+var namedVolume = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// resolveVolumes replaces the host path sources of a project's volumes with
+// their location in the project's data directory. Named volumes of other
+// projects are not mounted; missing ones are created for the project.
+func (d *Deployer) resolveVolumes(ctx context.Context, project *models.Project) error {
+	if project.Docker == nil {
+		return nil
+	}
+	for i, v := range project.Docker.Volumes {
+		if v.Source == "" || v.Target == "" {
+			continue
+		}
+		if v.Type == "bind" || !namedVolume.MatchString(v.Source) {
+			path, err := d.bindSource(project.ID, v.Source)
+			if err != nil {
+				return err
+			}
+			project.Docker.Volumes[i].Source = path
+			continue
+		}
+
+		owner, err := d.Docker.VolumeProjectID(ctx, v.Source)
+		switch {
+		case docker.IsNotFound(err):
+			if _, err := d.Docker.CreateVolume(ctx, v.Source, project.ID, nil); err != nil {
+				return fmt.Errorf("failed to create volume %s: %w", v.Source, err)
+			}
+		case err != nil:
+			return fmt.Errorf("failed to inspect volume %s: %w", v.Source, err)
+		case owner != "" && owner != project.ID:
+			return fmt.Errorf("volume %s belongs to another project", v.Source)
+		}
+	}
+	return nil
+}
+
+// bindSource resolves a bind mount source inside the project's data
+// directory. Relative paths live there, since checkouts are removed after a
+// build; absolute paths must point into it.
+func (d *Deployer) bindSource(projectID, source string) (string, error) {
+	if strings.HasPrefix(source, "~") {
+		return "", fmt.Errorf("bind mount %s: home directory paths are not supported", source)
+	}
+	if strings.Contains(source, ":") {
+		return "", fmt.Errorf("bind mount %s: paths with a colon are not supported", source)
+	}
+
+	root := filepath.Join(d.DataDir, projectID)
+	path := filepath.Clean(source)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	if !inDir(root, path) {
+		return "", fmt.Errorf("bind mount %s is outside the project data directory %s", source, root)
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return "", err
+	}
+
+	// Containers write to the directory, so they could plant a symlink out of it
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	if !inDir(realRoot, real) {
+		return "", fmt.Errorf("bind mount %s leads outside the project data directory", source)
+	}
+	return real, nil
+}
+
+// inDir reports whether path is dir or lies below it
+func inDir(dir, path string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+// environment returns a project's variables as KEY=value, sorted by key
+func environment(project *models.Project) []string {
+	keys := make([]string, 0, len(project.Environment))
+	for k := range project.Environment {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	env := make([]string, 0, len(keys))
+	for _, k := range keys {
+		env = append(env, k+"="+project.Environment[k])
+	}
+	return env
+}
+
+// containerName is the name of a project's running container
+func containerName(projectID string) string {
+	return "biz-panel-" + projectID
+}
+
+// imageTag is the tag of the image built for a deployment
+func imageTag(projectID, deployID string) string {
+	return "biz-panel/" + strings.ToLower(projectID) + ":" + deployID
+}
+
+// replaceIDs removes the removed containers from ids and adds added
+func replaceIDs(ids, removed, added []string) []string {
+	result := make([]string, 0, len(ids)+len(added))
+	for _, id := range ids {
+		if !containsID(removed, id) && !containsID(added, id) {
+			result = append(result, id)
+		}
+	}
+	return append(result, added...)
+}
+
+// containsID reports whether ids holds id, in full or abbreviated form
+func containsID(ids []string, id string) bool {
+	for _, other := range ids {
+		if strings.HasPrefix(other, id) || strings.HasPrefix(id, other) {
+			return true
+		}
+	}
+	return false
+}
+
+// sleep waits for duration, returning early with an error if ctx ends
+func sleep(ctx context.Context, duration time.Duration) error {
+	select {
+	case <-time.After(duration):
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
+
+// shortID abbreviates a Docker ID
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
@@ -0,0 +1,134 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bizino-services/biz-panel-backend/internal/models"
+)
+
+// commitSHA matches the full or abbreviated commit hashes checkouts can be
+// pinned to
+var commitSHA = regexp.MustCompile(`^[0-9a-fA-F]{7,64}$`)
+
+// Commit describes the revision a deployment was built from
+type Commit struct {
+	SHA     string
+	Author  string
+	Message string
+}
+
+// checkout clones repo's branch into dir and returns the commit checked out.
+// A non-empty revision pins the checkout to that commit.
+func (d *Deployer) checkout(ctx context.Context, repo *models.GitRepository, revision, dir string, log *Logger) (*Commit, error) {
+	if repo == nil || repo.URL == "" {
+		return nil, fmt.Errorf("project has no repository URL")
+	}
+
+	args := []string{"clone", "--depth", "1", "--single-branch", "--no-tags"}
+	if repo.Branch != "" {
+		args = append(args, "--branch", repo.Branch)
+	}
+	args = append(args, "--", repo.URL, dir)
+
+	env := []string{
+		"GIT_TERMINAL_PROMPT=0",
+		// ext:: and file:// could run commands or read the host's repositories
+		"GIT_ALLOW_PROTOCOL=https:http:ssh:git",
+	}
+	if repo.PrivateKey != "" {
+		keyDir, err := os.MkdirTemp(d.WorkDir, "key-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(keyDir)
+
+		keyFile := filepath.Join(keyDir, "id")
+		key := strings.TrimSpace(repo.PrivateKey) + "\n"
+		if err := os.WriteFile(keyFile, []byte(key), 0600); err != nil {
+			return nil, err
+		}
+		// Host keys are trusted on first use and pinned in the work directory
+		env = append(env, fmt.Sprintf("GIT_SSH_COMMAND=ssh -i '%s' -o IdentitiesOnly=yes -o BatchMode=yes -o StrictHostKeyChecking=accept-new -o UserKnownHostsFile='%s'",
+			keyFile, filepath.Join(d.WorkDir, "known_hosts")))
+	}
+
+	branch := repo.Branch
+	if branch == "" {
+		branch = "default branch"
+	}
+	log.Printf("Cloning %s (%s)", redactURL(repo.URL), branch)
+
+	if out, err := d.git(ctx, env, args...); err != nil {
+		return nil, fmt.Errorf("git clone failed: %s", redactOutput(out, repo.URL))
+	}
+	if revision != "" {
+		if !commitSHA.MatchString(revision) {
+			return nil, fmt.Errorf("invalid commit %q", revision)
+		}
+		// The shallow clone only has the branch head, so fetch the commit itself
+		if out, err := d.git(ctx, env, "-C", dir, "fetch", "--depth", "1", "--", "origin", revision); err != nil {
+			return nil, fmt.Errorf("failed to fetch commit %s: %s", shortSHA(revision), redactOutput(out, repo.URL))
+		}
+		if out, err := d.git(ctx, nil, "-C", dir, "checkout", "--quiet", "--detach", "FETCH_HEAD"); err != nil {
+			return nil, fmt.Errorf("failed to check out commit %s: %s", shortSHA(revision), out)
+		}
+	}
+
+	out, err := d.git(ctx, nil, "-C", dir, "log", "-1", "--format=%H%x1f%an <%ae>%x1f%s")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commit: %s", out)
+	}
+	parts := strings.SplitN(out, "\x1f", 3)
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("unexpected git log output %q", out)
+	}
+	commit := &Commit{SHA: parts[0], Author: parts[1], Message: parts[2]}
+	log.Printf("Checked out %s: %s", shortSHA(commit.SHA), commit.Message)
+	return commit, nil
+}
+
+// git runs a git command and returns its trimmed combined output
+func (d *Deployer) git(ctx context.Context, env []string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Env = append(os.Environ(), env...)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+	return strings.TrimSpace(out.String()), err
+}
+
+// redactURL hides the password or token in a repository URL
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	return u.Redacted()
+}
+
+// redactOutput removes repository credentials from git output
+func redactOutput(out, rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil && u.User != nil {
+		if password, ok := u.User.Password(); ok && password != "" {
+			out = strings.ReplaceAll(out, password, "***")
+		}
+	}
+	return out
+}
+
+// shortSHA abbreviates a commit hash
+func shortSHA(sha string) string {
+	if len(sha) > 12 {
+		return sha[:12]
+	}
+	return sha
+}
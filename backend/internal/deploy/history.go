@@ -0,0 +1,218 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bizino-services/biz-panel-backend/internal/docker"
+	"github.com/bizino-services/biz-panel-backend/internal/models"
+	"github.com/bizino-services/biz-panel-backend/internal/store"
+)
+
+// Default retention of deployment history
+const (
+	DefaultKeepImages      = 5
+	DefaultKeepDeployments = 50
+)
+
+// ErrNotRollbackable is returned for deployments whose images are gone or
+// that never succeeded
+var ErrNotRollbackable = errors.New("deployment can't be rolled back to")
+
+// SetRetention sets how many successful deployments per project keep their
+// images, and how many deployment records are kept
+func (d *Deployer) SetRetention(keepImages, keepDeployments int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.keepImages, d.keepDeployments = keepImages, keepDeployments
+}
+
+// History returns a project's deployments, newest first, without logs
+func (d *Deployer) History(projectID string) ([]*models.Deployment, error) {
+	if _, err := d.Store.Projects.Get(projectID); err != nil {
+		return nil, err
+	}
+	deployments, err := d.Store.Deployments.ListByProject(projectID)
+	if err != nil {
+		return nil, err
+	}
+	for _, dep := range deployments {
+		dep.Logs = nil
+		dep.ComposeFile = ""
+	}
+	return deployments, nil
+}
+
+// Deployment returns one of a project's deployments. A deployment that is
+// still running reports the live status and logs of the project.
+func (d *Deployer) Deployment(projectID, deployID string) (*models.Deployment, error) {
+	dep, err := d.Store.Deployments.Get(deployID)
+	if err != nil {
+		return nil, err
+	}
+	if dep.ProjectID != projectID {
+		return nil, store.ErrNotFound
+	}
+	if dep.FinishedAt == nil {
+		if project, err := d.Store.Projects.Get(projectID); err == nil && project.LastDeploy != nil && project.LastDeploy.ID == deployID {
+			dep.Status = project.LastDeploy.Status
+			dep.Logs = project.LastDeploy.Logs
+			dep.CommitSHA = project.LastDeploy.CommitSHA
+			dep.Author = project.LastDeploy.Author
+			dep.Message = project.LastDeploy.Message
+		}
+	}
+	return dep, nil
+}
+
+// Rollback redeploys the images of an earlier successful deployment
+// without building them again. Static projects switch back to the
+// deployment's release folder.
+func (d *Deployer) Rollback(projectID, deployID, userID string) (*models.Deployment, error) {
+	target, err := d.Store.Deployments.Get(deployID)
+	if err != nil {
+		return nil, err
+	}
+	if target.ProjectID != projectID {
+		return nil, store.ErrNotFound
+	}
+	if target.Status != models.DeployStatusSuccess || target.Pruned || (len(target.Images) == 0 && target.Release == "") {
+		return nil, ErrNotRollbackable
+	}
+	if d.Docker == nil {
+		return nil, ErrNoDocker
+	}
+	if target.Release != "" {
+		if _, err := os.Stat(target.Release); err != nil {
+			return nil, fmt.Errorf("%w: release %s is no longer available", ErrNotRollbackable, filepath.Base(target.Release))
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	for _, img := range target.Images {
+		if img.ImageID == "" {
+			return nil, fmt.Errorf("%w: the image of %s was not recorded", ErrNotRollbackable, img.Image)
+		}
+		if _, err := d.Docker.ImageID(ctx, img.ImageID); err != nil {
+			return nil, fmt.Errorf("%w: image %s is no longer available", ErrNotRollbackable, img.Image)
+		}
+	}
+	return d.start(projectID, request{userID: userID, trigger: models.DeployTriggerRollback, from: target})
+}
+
+// deployedImage describes the local image a service runs
+func (d *Deployer) deployedImage(ctx context.Context, service, ref string) models.DeployedImage {
+	img := models.DeployedImage{Service: service, Image: ref}
+	if id, err := d.Docker.ImageID(ctx, ref); err == nil {
+		img.ImageID = id
+	}
+	return img
+}
+
+// recordImages stores the images a deployment ran, and the compose file
+// for compose projects
+func (d *Deployer) recordImages(deployID string, images []models.DeployedImage, composeFile string) {
+	_, err := d.Store.Deployments.Update(deployID, func(dep *models.Deployment) error {
+		dep.Images = images
+		dep.ComposeFile = composeFile
+		return nil
+	})
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		log.Printf("Warning: failed to record images of deployment %s: %v", deployID, err)
+	}
+}
+
+// finishRecord copies the outcome of a finished deployment to its record
+func (d *Deployer) finishRecord(info *models.DeployInfo) {
+	_, err := d.Store.Deployments.Update(info.ID, func(dep *models.Deployment) error {
+		dep.Status = info.Status
+		dep.FinishedAt = info.FinishedAt
+		dep.Duration = info.Duration
+		dep.Logs = info.Logs
+		dep.CommitSHA = info.CommitSHA
+		dep.Author = info.Author
+		dep.Message = info.Message
+		return nil
+	})
+	if err != nil {
+		log.Printf("Warning: failed to record deployment %s: %v", info.ID, err)
+	}
+}
+
+// prune applies the retention settings to a project's history. Images
+// built for deployments other than the newest successful ones are removed,
+// unless a kept deployment runs them too, as are their static releases
+// unless served, and the oldest records are deleted.
+func (d *Deployer) prune(ctx context.Context, projectID string) {
+	d.mu.Lock()
+	keepImages, keepDeployments := d.keepImages, d.keepDeployments
+	d.mu.Unlock()
+
+	deployments, err := d.Store.Deployments.ListByProject(projectID)
+	if err != nil {
+		log.Printf("Warning: failed to list deployments of project %s: %v", projectID, err)
+		return
+	}
+
+	kept := make(map[string]bool) // Deployment IDs
+	inUse := make(map[string]bool)
+	for _, dep := range deployments {
+		if len(kept) == keepImages {
+			break
+		}
+		if dep.Status == models.DeployStatusSuccess && !dep.Pruned {
+			kept[dep.ID] = true
+			for _, img := range dep.Images {
+				inUse[img.ImageID] = true
+			}
+			if dep.Release != "" {
+				inUse[dep.Release] = true
+			}
+		}
+	}
+
+	for i, dep := range deployments {
+		if dep.FinishedAt == nil {
+			continue // Still running
+		}
+		if !kept[dep.ID] && !dep.Pruned {
+			for _, img := range dep.Images {
+				// Only images the panel built are removed; pulled ones may be shared
+				if img.ImageID == "" || inUse[img.ImageID] || !strings.HasPrefix(img.Image, "biz-panel/") {
+					continue
+				}
+				if err := d.Docker.RemoveImage(ctx, img.Image, false); err != nil && !docker.IsNotFound(err) {
+					log.Printf("Warning: failed to remove image %s: %v", img.Image, err)
+				}
+			}
+			if dep.Release != "" && !inUse[dep.Release] && !d.isCurrent(projectID, dep.Release) {
+				if err := os.RemoveAll(dep.Release); err != nil {
+					log.Printf("Warning: failed to remove release %s: %v", dep.Release, err)
+				}
+			}
+			if len(dep.Images) > 0 || dep.Release != "" {
+				d.Store.Deployments.Update(dep.ID, func(dep *models.Deployment) error {
+					dep.Pruned = true
+					return nil
+				})
+			}
+		}
+		if i >= keepDeployments {
+			if _, err := d.Store.Deployments.Delete(dep.ID); err != nil {
+				log.Printf("Warning: failed to delete deployment %s: %v", dep.ID, err)
+			}
+		}
+	}
+}
+
+// shortImageID abbreviates an image ID for logs
+func shortImageID(id string) string {
+	return shortID(strings.TrimPrefix(id, "sha256:"))
+}
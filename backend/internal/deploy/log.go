@@ -0,0 +1,109 @@
+package deploy
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bizino-services/biz-panel-backend/internal/models"
+	"github.com/bizino-services/biz-panel-backend/internal/store"
+)
+
+// maxLogLines is the number of log lines kept per deployment
+const maxLogLines = 2000
+
+// logFlushInterval limits how often build output is written to the store
+const logFlushInterval = time.Second
+
+// phaseMarker starts the log line that marks a new phase
+const phaseMarker = "==> "
+
+// Logger collects a deployment's output, publishes it to the deployment's
+// stream and writes it to the project's LastDeploy in batches
+type Logger struct {
+	store     *store.Store
+	stream    *Stream // nil when nobody can follow the deployment
+	projectID string
+	deployID  string
+
+	mu        sync.Mutex
+	phase     string
+	pending   []string
+	lastFlush time.Time
+}
+
+func newLogger(s *store.Store, stream *Stream, projectID, deployID string) *Logger {
+	return &Logger{store: s, stream: stream, projectID: projectID, deployID: deployID}
+}
+
+// Phase marks the start of a new phase of the deployment
+func (l *Logger) Phase(phase string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.phase = phase
+	l.addLocked(phaseMarker+phase, true)
+}
+
+// Printf adds a formatted line
+func (l *Logger) Printf(format string, args ...interface{}) {
+	l.Line(fmt.Sprintf(format, args...))
+}
+
+// Line adds a line, flushing if the last flush is old enough. Lines sent
+// to a nil Logger are discarded.
+func (l *Logger) Line(line string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.addLocked(line, false)
+}
+
+func (l *Logger) addLocked(line string, marker bool) {
+	if l.stream != nil {
+		l.stream.publish(l.phase, line, marker)
+	}
+	l.pending = append(l.pending, line)
+	if time.Since(l.lastFlush) >= logFlushInterval {
+		l.flushLocked()
+	}
+}
+
+// Flush writes pending lines to the store
+func (l *Logger) Flush() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.flushLocked()
+}
+
+func (l *Logger) flushLocked() {
+	l.lastFlush = time.Now()
+	if len(l.pending) == 0 {
+		return
+	}
+	lines := l.pending
+	l.pending = nil
+
+	_, err := l.store.Projects.Update(l.projectID, func(p *models.Project) error {
+		if p.LastDeploy == nil || p.LastDeploy.ID != l.deployID {
+			return nil
+		}
+		logs := append(p.LastDeploy.Logs, lines...)
+		if len(logs) > maxLogLines {
+			logs = append([]string{"... earlier output truncated"}, logs[len(logs)-maxLogLines+1:]...)
+		}
+		p.LastDeploy.Logs = logs
+		return nil
+	})
+	if err != nil {
+		log.Printf("Warning: failed to write deploy log for project %s: %v", l.projectID, err)
+	}
+}
@@ -0,0 +1,390 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/bizino-services/biz-panel-backend/internal/docker"
+	"github.com/bizino-services/biz-panel-backend/internal/models"
+	"github.com/bizino-services/biz-panel-backend/internal/proxy"
+	"github.com/bizino-services/biz-panel-backend/internal/store"
+	"github.com/google/uuid"
+)
+
+// DefaultPreviewTTL is how long a preview environment lives after its pull
+// request was last updated, unless the project sets its own
+const DefaultPreviewTTL = 72 * time.Hour
+
+var (
+	ErrPreviewsDisabled   = errors.New("preview environments are disabled for this project")
+	ErrPreviewUnsupported = errors.New("project can't have preview environments")
+	ErrNotPreview         = errors.New("project is not a preview environment")
+)
+
+// previewable checks that a project can deploy its pull requests to
+// preview environments
+func previewable(p *models.Project) error {
+	switch {
+	case p.Previews == nil || !p.Previews.Enabled:
+		return ErrPreviewsDisabled
+	case p.ParentID != "":
+		return fmt.Errorf("%w: it is a preview environment itself", ErrPreviewUnsupported)
+	case (p.Type != models.ProjectTypeGit && p.Type != models.ProjectTypeStatic) || p.Repository == nil:
+		return fmt.Errorf("%w: it is not deployed from git", ErrPreviewUnsupported)
+	case IsCompose(p):
+		return fmt.Errorf("%w: compose projects have no single container to route", ErrPreviewUnsupported)
+	case !proxy.ValidDomain(p.Domain):
+		return fmt.Errorf("%w: previews are served on subdomains of the project's domain", ErrPreviewUnsupported)
+	case p.Type != models.ProjectTypeStatic && appPort(p) == 0:
+		return fmt.Errorf("%w: previews need a TCP container port to route their subdomain to", ErrPreviewUnsupported)
+	}
+	return nil
+}
+
+// previewTTL is how long a project's previews live after their last update
+func previewTTL(p *models.Project) time.Duration {
+	if p.Previews != nil && p.Previews.TTL > 0 {
+		return time.Duration(p.Previews.TTL) * time.Hour
+	}
+	return DefaultPreviewTTL
+}
+
+// previewDomain is the subdomain a pull request's preview is served on
+func previewDomain(p *models.Project, number int) string {
+	return fmt.Sprintf("pr-%d.%s", number, p.Domain)
+}
+
+// previewEnvironment copies the project's variables for a preview, leaving
+// out secrets and the excluded ones, and applies the preview overrides
+func previewEnvironment(p *models.Project) map[string]string {
+	skip := make(map[string]bool)
+	for _, name := range p.Secrets {
+		skip[name] = true
+	}
+	if p.Previews != nil {
+		for _, name := range p.Previews.ExcludeEnv {
+			skip[name] = true
+		}
+	}
+
+	env := make(map[string]string)
+	for k, v := range p.Environment {
+		if !skip[k] {
+			env[k] = v
+		}
+	}
+	if p.Previews != nil {
+		for k, v := range p.Previews.Environment {
+			env[k] = v
+		}
+	}
+	return env
+}
+
+// applyPreview configures a preview environment from its parent project.
+// The configuration is copied again on every update, so changes to the
+// parent reach its open previews with their next deployment.
+func applyPreview(child, parent *models.Project, info models.PreviewInfo) {
+	child.Name = fmt.Sprintf("%s PR #%d", parent.Name, info.Number)
+	child.Description = info.Title
+	child.Type = parent.Type
+	child.ParentID = parent.ID
+	child.Preview = &info
+
+	repo := *parent.Repository
+	repo.Branch = info.Branch
+	repo.AutoDeploy = false
+	child.Repository = &repo
+	if parent.Docker != nil {
+		cfg := *parent.Docker
+		child.Docker = &cfg
+	}
+	if parent.Static != nil {
+		cfg := *parent.Static
+		child.Static = &cfg
+	}
+	if parent.HealthCheck != nil {
+		hc := *parent.HealthCheck
+		child.HealthCheck = &hc
+	}
+	child.Environment = previewEnvironment(parent)
+	child.Domain = previewDomain(parent, info.Number)
+	child.SSL = parent.SSL
+	child.Resources.CPULimit = parent.Resources.CPULimit
+	child.Resources.MemoryLimit = parent.Resources.MemoryLimit
+
+	// Previews run next to nothing else on their subdomain, so they are
+	// routed by nginx like blue/green projects instead of publishing ports
+	child.Strategy = models.DeployStrategyBlueGreen
+	if parent.Type == models.ProjectTypeStatic {
+		child.Strategy = ""
+	}
+}
+
+// findPreview returns a project's preview environment of a pull request
+func (d *Deployer) findPreview(parentID string, number int) (*models.Project, error) {
+	previews, err := d.Previews(parentID)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range previews {
+		if p.Preview.Number == number {
+			return p, nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+// Previews returns a project's preview environments by pull request number
+func (d *Deployer) Previews(parentID string) ([]*models.Project, error) {
+	projects, err := d.Store.Projects.List()
+	if err != nil {
+		return nil, err
+	}
+	previews := make([]*models.Project, 0)
+	for _, p := range projects {
+		if p.ParentID == parentID && p.Preview != nil {
+			previews = append(previews, p)
+		}
+	}
+	sort.Slice(previews, func(i, j int) bool { return previews[i].Preview.Number < previews[j].Preview.Number })
+	return previews, nil
+}
+
+// Preview deploys the head commit of a pull request to its preview
+// environment, creating the environment on the first call. The returned
+// deployment is nil when that commit is already deployed or deploying;
+// the preview's lifetime is extended either way.
+func (d *Deployer) Preview(parentID string, info models.PreviewInfo, commit Commit) (*models.Project, *models.Deployment, error) {
+	// The parent's encrypted secrets are copied, so a key rotation waits
+	// until they are saved with the preview
+	var child *models.Project
+	err := d.Secrets.Hold(func() error {
+		parent, err := d.Store.Projects.Get(parentID)
+		if err != nil {
+			return err
+		}
+		if err := previewable(parent); err != nil {
+			return err
+		}
+		if d.Docker == nil {
+			return ErrNoDocker
+		}
+		info.ExpiresAt = time.Now().Add(previewTTL(parent))
+		info.Closed = false
+
+		d.previewMu.Lock()
+		defer d.previewMu.Unlock()
+		child, err = d.findPreview(parentID, info.Number)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			now := time.Now()
+			child = &models.Project{
+				ID:         uuid.New().String()[:8],
+				Status:     models.ProjectStatusIdle,
+				Containers: []string{},
+				CreatedAt:  now,
+				UpdatedAt:  now,
+			}
+			applyPreview(child, parent, info)
+			return d.Store.Projects.Save(child)
+		case err == nil:
+			child, err = d.Store.Projects.Update(child.ID, func(p *models.Project) error {
+				applyPreview(p, parent, info)
+				p.UpdatedAt = time.Now()
+				return nil
+			})
+		}
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if last := child.LastDeploy; last != nil && last.CommitSHA == commit.SHA && commit.SHA != "" {
+		switch last.Status {
+		case models.DeployStatusFailed, models.DeployStatusCancelled:
+		default:
+			return child, nil, nil
+		}
+	}
+	deployment, err := d.StartCommit(child.ID, models.DeployTriggerWebhook, commit)
+	if err != nil {
+		return child, nil, err
+	}
+	return child, deployment, nil
+}
+
+// ClosePreview removes the preview environment of a closed pull request
+func (d *Deployer) ClosePreview(parentID string, number int) (*models.Project, bool, error) {
+	child, err := d.findPreview(parentID, number)
+	if err != nil {
+		return nil, false, err
+	}
+	removed, err := d.RemovePreview(child.ID)
+	return child, removed, err
+}
+
+// RemovePreview destroys a preview environment. A running deployment of it
+// is cancelled first and the preview is destroyed by the reaper once that
+// has stopped, so removed reports whether it is gone already.
+func (d *Deployer) RemovePreview(id string) (removed bool, err error) {
+	project, err := d.Store.Projects.Update(id, func(p *models.Project) error {
+		if p.ParentID == "" || p.Preview == nil {
+			return ErrNotPreview
+		}
+		p.Preview.Closed = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	if d.cancelAll(id) {
+		return false, nil
+	}
+	return true, d.destroyPreview(project)
+}
+
+// RemovePreviews destroys a project's preview environments, e.g. when the
+// project is deleted
+func (d *Deployer) RemovePreviews(parentID string) {
+	previews, err := d.Previews(parentID)
+	if err != nil {
+		log.Printf("Warning: failed to list previews of project %s: %v", parentID, err)
+		return
+	}
+	for _, p := range previews {
+		if _, err := d.RemovePreview(p.ID); err != nil {
+			log.Printf("Warning: failed to remove preview %s: %v", p.ID, err)
+		}
+	}
+}
+
+// cancelAll cancels a project's waiting deployments and aborts its running
+// one. It reports whether something still runs for the project.
+func (d *Deployer) cancelAll(projectID string) (running bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	kept := d.queue[:0]
+	for _, j := range d.queue {
+		if j.projectID == projectID {
+			d.finishQueued(j, models.DeployStatusCancelled, "✗ Deployment cancelled: the preview environment was closed")
+			continue
+		}
+		kept = append(kept, j)
+	}
+	d.queue = kept
+
+	current, ok := d.running[projectID]
+	if cancel, found := d.cancels[current]; ok && found {
+		cancel(ErrCancelled)
+	}
+	return ok
+}
+
+// destroyPreview removes a preview environment's containers, images, site
+// and records
+func (d *Deployer) destroyPreview(project *models.Project) error {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+
+	deployments, err := d.Store.Deployments.ListByProject(project.ID)
+	if err != nil {
+		return err
+	}
+	if d.Docker != nil {
+		containers, err := d.Docker.ListContainers(ctx, project.ID)
+		if err != nil {
+			return fmt.Errorf("failed to list containers: %w", err)
+		}
+		for _, c := range containers {
+			if err := d.Docker.RemoveContainer(ctx, c.ID, true); err != nil && !docker.IsNotFound(err) {
+				return fmt.Errorf("failed to remove container %s: %w", shortID(c.ID), err)
+			}
+		}
+		for _, dep := range deployments {
+			images, err := d.Docker.ImagesWithLabel(ctx, DeployLabel, dep.ID)
+			if err != nil {
+				log.Printf("Warning: failed to list images of deployment %s: %v", dep.ID, err)
+			}
+			for _, id := range images {
+				if err := d.Docker.RemoveImage(ctx, id, true); err != nil && !docker.IsNotFound(err) {
+					log.Printf("Warning: failed to remove image %s: %v", shortImageID(id), err)
+				}
+			}
+		}
+		if project.NetworkID != "" {
+			if err := d.Docker.RemoveNetwork(ctx, project.NetworkID); err != nil && !docker.IsNotFound(err) {
+				log.Printf("Warning: failed to remove network %s: %v", project.NetworkID, err)
+			}
+		}
+	}
+	if err := d.RemoveSite(project.ID); err != nil {
+		return fmt.Errorf("failed to remove site: %w", err)
+	}
+
+	for _, dep := range deployments {
+		d.Store.Deployments.Delete(dep.ID)
+	}
+	if _, err := d.Store.Projects.Delete(project.ID); err != nil && !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+
+	activity := &models.Activity{
+		ID:          uuid.New().String()[:8],
+		Type:        "delete",
+		Title:       "Preview Removed",
+		Description: fmt.Sprintf("Preview environment '%s' was removed", project.Name),
+		Status:      "success",
+		ProjectID:   project.ParentID,
+		Timestamp:   time.Now(),
+	}
+	if err := d.Store.Activities.Append(activity); err != nil {
+		log.Printf("Warning: failed to record activity %q: %v", activity.Title, err)
+	}
+	return nil
+}
+
+// ExpirePreviews destroys preview environments that were closed, outlived
+// their TTL or lost their parent project. Ones still deploying are
+// cancelled and destroyed on a later call.
+func (d *Deployer) ExpirePreviews() {
+	projects, err := d.Store.Projects.List()
+	if err != nil {
+		log.Printf("Warning: failed to list projects: %v", err)
+		return
+	}
+	parents := make(map[string]bool, len(projects))
+	for _, p := range projects {
+		parents[p.ID] = true
+	}
+
+	now := time.Now()
+	for _, p := range projects {
+		if p.ParentID == "" || p.Preview == nil {
+			continue
+		}
+		if !p.Preview.Closed && now.Before(p.Preview.ExpiresAt) && parents[p.ParentID] {
+			continue
+		}
+		if _, err := d.RemovePreview(p.ID); err != nil {
+			log.Printf("Warning: failed to remove preview %s: %v", p.ID, err)
+		}
+	}
+}
+
+// ReapPreviews runs ExpirePreviews every interval in the background
+func (d *Deployer) ReapPreviews(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			d.ExpirePreviews()
+		}
+	}()
+}
@@ -0,0 +1,232 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bizino-services/biz-panel-backend/internal/docker"
+	"github.com/bizino-services/biz-panel-backend/internal/models"
+	"github.com/bizino-services/biz-panel-backend/internal/store"
+)
+
+// DefaultMaxConcurrent is how many deployments run at once across projects
+const DefaultMaxConcurrent = 2
+
+// cleanupTimeout bounds removing what a cancelled deployment left behind
+const cleanupTimeout = 2 * time.Minute
+
+var (
+	ErrCancelled      = errors.New("deployment was cancelled")
+	ErrNotCancellable = errors.New("deployment has already finished")
+)
+
+// job is a queued deployment
+type job struct {
+	projectID      string
+	deployID       string
+	req            request
+	logs           []string             // First lines of the deployment log
+	previousStatus models.ProjectStatus // Restored if the deployment is cancelled
+}
+
+// SetConcurrency sets how many deployments may run at once
+func (d *Deployer) SetConcurrency(max int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.maxConcurrent = max
+	d.dispatch()
+}
+
+// canBegin reports whether a deployment of the project could start now.
+// d.mu must be held.
+func (d *Deployer) canBegin(projectID string) bool {
+	if _, busy := d.running[projectID]; busy || d.active >= d.maxConcurrent {
+		return false
+	}
+	for _, j := range d.queue {
+		if j.projectID == projectID {
+			return false
+		}
+	}
+	return true
+}
+
+// dispatch starts waiting deployments, oldest first, while deploy slots are
+// free. Projects with a running deployment or service operation are
+// skipped. d.mu must be held.
+func (d *Deployer) dispatch() {
+	for i := 0; i < len(d.queue) && d.active < d.maxConcurrent; {
+		j := d.queue[i]
+		if _, busy := d.running[j.projectID]; busy {
+			i++
+			continue
+		}
+		d.queue = append(d.queue[:i], d.queue[i+1:]...)
+		d.begin(j)
+	}
+}
+
+// begin makes a queued deployment the project's LastDeploy and runs it.
+// d.mu must be held.
+func (d *Deployer) begin(j *job) {
+	now := time.Now()
+	_, err := d.Store.Projects.Update(j.projectID, func(p *models.Project) error {
+		if err := deployable(p); err != nil {
+			return err
+		}
+		j.previousStatus = p.Status
+		p.Status = models.ProjectStatusBuilding
+		p.LastDeploy = &models.DeployInfo{
+			ID:        j.deployID,
+			Status:    models.DeployStatusPending,
+			StartedAt: now,
+			Logs:      j.logs,
+		}
+		if from := j.req.from; from != nil {
+			p.LastDeploy.CommitSHA = from.CommitSHA
+			p.LastDeploy.Author = from.Author
+			p.LastDeploy.Message = from.Message
+		}
+		if commit := j.req.commit; commit != nil {
+			p.LastDeploy.CommitSHA = commit.SHA
+			p.LastDeploy.Author = commit.Author
+			p.LastDeploy.Message = commit.Message
+		}
+		p.UpdatedAt = now
+		return nil
+	})
+	if err != nil {
+		d.finishQueued(j, models.DeployStatusFailed, "✗ Deployment failed: "+err.Error())
+		return
+	}
+	d.Store.Deployments.Update(j.deployID, func(dep *models.Deployment) error {
+		dep.Status = models.DeployStatusPending
+		dep.StartedAt = now
+		return nil
+	})
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	d.running[j.projectID] = j.deployID
+	d.cancels[j.deployID] = cancel
+	d.active++
+	go func() {
+		defer cancel(nil)
+		d.run(ctx, j)
+	}()
+}
+
+// supersede cancels the project's waiting deployments in favour of a newer
+// one. Rollbacks run what they were asked to and are left alone. d.mu must
+// be held.
+func (d *Deployer) supersede(projectID, newer string) {
+	kept := d.queue[:0]
+	for _, j := range d.queue {
+		if j.projectID != projectID || j.req.from != nil {
+			kept = append(kept, j)
+			continue
+		}
+		d.finishQueued(j, models.DeployStatusCancelled, "✗ Superseded by deployment "+newer)
+	}
+	d.queue = kept
+}
+
+// finishQueued ends a deployment that never started. d.mu must be held.
+func (d *Deployer) finishQueued(j *job, status models.DeployStatus, line string) {
+	now := time.Now()
+	_, err := d.Store.Deployments.Update(j.deployID, func(dep *models.Deployment) error {
+		dep.Status = status
+		dep.FinishedAt = &now
+		dep.Logs = append(dep.Logs, line)
+		return nil
+	})
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		log.Printf("Warning: failed to record deployment %s: %v", j.deployID, err)
+	}
+	if stream := d.streams[j.deployID]; stream != nil {
+		stream.publish("", line, false)
+	}
+	d.closeStreamLocked(j.deployID, status)
+}
+
+// Cancel stops one of a project's deployments. A waiting deployment is
+// cancelled at once; a running one is aborted through its context and
+// removes what it created, so running reports that it is still stopping.
+func (d *Deployer) Cancel(projectID, deployID string) (running bool, err error) {
+	dep, err := d.Store.Deployments.Get(deployID)
+	if err != nil {
+		return false, err
+	}
+	if dep.ProjectID != projectID {
+		return false, store.ErrNotFound
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if cancel, ok := d.cancels[deployID]; ok {
+		cancel(ErrCancelled)
+		return true, nil
+	}
+	for i, j := range d.queue {
+		if j.deployID == deployID {
+			d.queue = append(d.queue[:i], d.queue[i+1:]...)
+			d.finishQueued(j, models.DeployStatusCancelled, "✗ Deployment cancelled before it started")
+			return false, nil
+		}
+	}
+
+	switch dep.Status {
+	case models.DeployStatusSuccess, models.DeployStatusFailed, models.DeployStatusCancelled:
+		return false, ErrNotCancellable
+	}
+	// Interrupted by a restart of the panel; nothing runs it anymore
+	d.finishQueued(&job{projectID: projectID, deployID: deployID}, models.DeployStatusCancelled, "✗ Deployment cancelled")
+	return false, nil
+}
+
+// removePartial removes the containers, images and release folder a
+// cancelled deployment created. It runs after the deployment restored the
+// previous containers.
+func (d *Deployer) removePartial(projectID, deployID string, log *Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+
+	containers, err := d.Docker.ListContainers(ctx, projectID)
+	if err != nil {
+		log.Printf("Warning: failed to list containers: %v", err)
+	}
+	for _, c := range containers {
+		if c.Labels[DeployLabel] != deployID {
+			continue
+		}
+		log.Printf("Removing container %s", shortID(c.ID))
+		if err := d.Docker.RemoveContainer(ctx, c.ID, true); err != nil && !docker.IsNotFound(err) {
+			log.Printf("Warning: failed to remove container %s: %v", shortID(c.ID), err)
+		}
+	}
+
+	images, err := d.Docker.ImagesWithLabel(ctx, DeployLabel, deployID)
+	if err != nil {
+		log.Printf("Warning: failed to list images: %v", err)
+	}
+	for _, id := range images {
+		log.Printf("Removing image %s", shortImageID(id))
+		if err := d.Docker.RemoveImage(ctx, id, true); err != nil && !docker.IsNotFound(err) {
+			log.Printf("Warning: failed to remove image %s: %v", shortImageID(id), err)
+		}
+	}
+
+	release := filepath.Join(d.releasesDir(projectID), deployID)
+	if !d.isCurrent(projectID, release) {
+		os.RemoveAll(release + ".tmp")
+		if _, err := os.Stat(release); err == nil {
+			log.Printf("Removing release %s", deployID)
+			if err := os.RemoveAll(release); err != nil {
+				log.Printf("Warning: failed to remove release %s: %v", deployID, err)
+			}
+		}
+	}
+}
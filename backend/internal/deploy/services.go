@@ -0,0 +1,223 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/bizino-services/biz-panel-backend/internal/docker"
+	"github.com/bizino-services/biz-panel-backend/internal/models"
+)
+
+// serviceOpTimeout bounds down, pull and restart operations
+const serviceOpTimeout = 10 * time.Minute
+
+var (
+	ErrNotCompose      = errors.New("project is not defined by a compose file")
+	ErrServiceNotFound = errors.New("service not found")
+)
+
+// Service is the state of a compose service's container
+type Service struct {
+	Name        string               `json:"name"`
+	ContainerID string               `json:"containerId"`
+	Image       string               `json:"image"`
+	State       string               `json:"state"`
+	Status      string               `json:"status"`
+	DeployID    string               `json:"deployId"`
+	Ports       []docker.PortMapping `json:"ports"`
+}
+
+// acquire marks an operation on a project as running
+func (d *Deployer) acquire(projectID, op string) error {
+	if d.Docker == nil {
+		return ErrNoDocker
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, busy := d.running[projectID]; busy {
+		return ErrInProgress
+	}
+	d.running[projectID] = op
+	return nil
+}
+
+// release ends an operation started with acquire and lets deployments
+// that waited for it begin
+func (d *Deployer) release(projectID string) {
+	d.mu.Lock()
+	delete(d.running, projectID)
+	d.dispatch()
+	d.mu.Unlock()
+}
+
+// Services lists the service containers of a project
+func (d *Deployer) Services(ctx context.Context, projectID string) ([]Service, error) {
+	if d.Docker == nil {
+		return nil, ErrNoDocker
+	}
+	containers, err := d.Docker.ListContainers(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	services := make([]Service, 0, len(containers))
+	for _, c := range containers {
+		if c.Labels[ServiceLabel] == "" {
+			continue
+		}
+		services = append(services, Service{
+			Name:        c.Labels[ServiceLabel],
+			ContainerID: c.ID,
+			Image:       c.Image,
+			State:       c.State,
+			Status:      c.Status,
+			DeployID:    c.Labels[DeployLabel],
+			Ports:       c.Ports,
+		})
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	return services, nil
+}
+
+// Up deploys a compose project
+func (d *Deployer) Up(projectID, userID string) (*models.Deployment, error) {
+	project, err := d.Store.Projects.Get(projectID)
+	if err != nil {
+		return nil, err
+	}
+	if !IsCompose(project) {
+		return nil, ErrNotCompose
+	}
+	return d.Start(projectID, userID)
+}
+
+// Down stops and removes the containers deployed for a project. Volumes
+// created from the compose file are removed as well if removeVolumes is set.
+func (d *Deployer) Down(projectID string, removeVolumes bool) ([]string, error) {
+	if _, err := d.Store.Projects.Get(projectID); err != nil {
+		return nil, err
+	}
+	if err := d.acquire(projectID, "down"); err != nil {
+		return nil, err
+	}
+	defer d.release(projectID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), serviceOpTimeout)
+	defer cancel()
+
+	ids, err := d.deployedContainers(ctx, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+	var removed []string
+	for _, id := range ids {
+		d.Docker.StopContainer(ctx, id)
+		if err := d.Docker.RemoveContainer(ctx, id, true); err != nil {
+			return removed, fmt.Errorf("failed to remove container %s: %w", id, err)
+		}
+		removed = append(removed, id)
+	}
+
+	if removeVolumes {
+		volumes, err := d.Docker.ListVolumes(ctx, projectID)
+		if err != nil {
+			return removed, fmt.Errorf("failed to list volumes: %w", err)
+		}
+		for _, v := range volumes {
+			if v.Labels[VolumeLabel] == "" {
+				continue
+			}
+			if err := d.Docker.RemoveVolume(ctx, v.Name, false); err != nil {
+				return removed, fmt.Errorf("failed to remove volume %s: %w", v.Name, err)
+			}
+		}
+	}
+
+	_, err = d.Store.Projects.Update(projectID, func(p *models.Project) error {
+		p.Status = models.ProjectStatusStopped
+		p.Containers = replaceIDs(p.Containers, removed, nil)
+		p.UpdatedAt = time.Now()
+		return nil
+	})
+	return removed, err
+}
+
+// Pull pulls the images of a compose project's services. Services that
+// are built from the repository are skipped.
+func (d *Deployer) Pull(projectID string) ([]string, error) {
+	project, err := d.Store.Projects.Get(projectID)
+	if err != nil {
+		return nil, err
+	}
+	if !IsCompose(project) {
+		return nil, ErrNotCompose
+	}
+	if err := d.Secrets.Open(project); err != nil {
+		return nil, err
+	}
+	if err := d.acquire(projectID, "pull"); err != nil {
+		return nil, err
+	}
+	defer d.release(projectID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), serviceOpTimeout)
+	defer cancel()
+
+	cf, err := d.loadCompose(ctx, project, "", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer cf.cleanup()
+
+	order, err := cf.project.Order()
+	if err != nil {
+		return nil, err
+	}
+	pulled := make([]string, 0, len(order))
+	seen := make(map[string]bool)
+	for _, name := range order {
+		image := cf.project.Services[name].Image
+		if cf.project.Services[name].Build != nil || image == "" || seen[image] {
+			continue
+		}
+		seen[image] = true
+		if err := d.Docker.PullImage(ctx, image, func(string) {}); err != nil {
+			return pulled, err
+		}
+		pulled = append(pulled, image)
+	}
+	return pulled, nil
+}
+
+// RestartService restarts the containers of one of a project's services
+func (d *Deployer) RestartService(projectID, service string) error {
+	if err := d.acquire(projectID, "restart"); err != nil {
+		return err
+	}
+	defer d.release(projectID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), serviceOpTimeout)
+	defer cancel()
+
+	containers, err := d.Docker.ListContainers(ctx, projectID)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, c := range containers {
+		if c.Labels[ServiceLabel] != service {
+			continue
+		}
+		found = true
+		if err := d.Docker.RestartContainer(ctx, c.ID); err != nil {
+			return fmt.Errorf("failed to restart %s: %w", c.ID, err)
+		}
+	}
+	if !found {
+		return ErrServiceNotFound
+	}
+	return nil
+}
@@ -0,0 +1,285 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bizino-services/biz-panel-backend/internal/docker"
+	"github.com/bizino-services/biz-panel-backend/internal/models"
+	"github.com/bizino-services/biz-panel-backend/internal/proxy"
+)
+
+// DefaultStaticDir is where the releases of static projects are kept
+const DefaultStaticDir = "/var/lib/biz-panel/static"
+
+// defaultStaticBuildImage runs the build commands of static projects
+const defaultStaticBuildImage = "node:22-alpine"
+
+// staticBuildDir is where the checkout is mounted in the build container
+const staticBuildDir = "/src"
+
+// validateStatic checks a static project's domain and that its output
+// directory is inside its repository
+func validateStatic(p *models.Project) error {
+	if p.Strategy == models.DeployStrategyBlueGreen {
+		return fmt.Errorf("%w: static sites switch releases atomically and don't use blue/green deploys", ErrInvalidStrategy)
+	}
+	if p.Domain != "" && !proxy.ValidDomain(p.Domain) {
+		return fmt.Errorf("%w: invalid domain %q", ErrInvalidStrategy, p.Domain)
+	}
+	if cfg := p.Static; cfg != nil {
+		if path.IsAbs(cfg.OutputDir) || strings.HasPrefix(path.Clean(cfg.OutputDir)+"/", "../") {
+			return fmt.Errorf("%w: the output directory must be a path within the repository", ErrInvalidStrategy)
+		}
+	}
+	return nil
+}
+
+// releasesDir holds a static project's release folders, one per deployment
+func (d *Deployer) releasesDir(projectID string) string {
+	return filepath.Join(d.StaticDir, projectID, "releases")
+}
+
+// currentLink points at the release nginx serves
+func (d *Deployer) currentLink(projectID string) string {
+	return filepath.Join(d.StaticDir, projectID, "current")
+}
+
+// deployStatic builds a static project into a new release folder, switches
+// the project's current link to it and has nginx serve that link on the
+// project's domain. A rollback switches back to an earlier release.
+func (d *Deployer) deployStatic(ctx context.Context, project *models.Project, deployID string, from *models.Deployment, log *Logger) (*result, error) {
+	if d.Proxy == nil {
+		return nil, errors.New("no reverse proxy is configured to serve static sites")
+	}
+	if project.Domain == "" {
+		return nil, errors.New("static sites need a domain to be served on")
+	}
+
+	var release string
+	if from != nil {
+		release = from.Release
+		log.Printf("Using release %s from deployment %s", filepath.Base(release), from.ID)
+	} else {
+		var err error
+		if release, err = d.buildRelease(ctx, project, deployID, log); err != nil {
+			return nil, err
+		}
+	}
+	d.recordRelease(deployID, release)
+
+	d.setStatus(project.ID, deployID, models.ProjectStatusDeploying, models.DeployStatusDeploying)
+	log.Phase(PhaseStart)
+	link := d.currentLink(project.ID)
+	previous, _ := os.Readlink(link)
+	if err := activate(link, release); err != nil {
+		return &result{keptPrevious: previous != ""}, fmt.Errorf("failed to activate release: %w", err)
+	}
+	log.Printf("Activated release %s", filepath.Base(release))
+
+	site := proxy.Site{Name: project.ID, Domain: project.Domain, Root: link, SSL: project.SSL}
+	if project.Static != nil {
+		site.SPA = project.Static.SPA
+	}
+	if err := d.Proxy.Route(site); err != nil {
+		if previous != "" {
+			log.Printf("Switching back to release %s", filepath.Base(previous))
+			activate(link, previous)
+		}
+		return &result{keptPrevious: previous != ""}, fmt.Errorf("failed to configure nginx: %w", err)
+	}
+	log.Printf("Serving %s from %s", project.Domain, link)
+	return &result{}, nil
+}
+
+// buildRelease checks out the repository, runs the build command if there
+// is one and copies the output directory into the deployment's release
+// folder
+func (d *Deployer) buildRelease(ctx context.Context, project *models.Project, deployID string, log *Logger) (string, error) {
+	d.setStatus(project.ID, deployID, models.ProjectStatusBuilding, models.DeployStatusBuilding)
+
+	dir, src, err := d.checkoutProject(ctx, project, deployID, pinnedRevision(project, deployID), log)
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := project.Static
+	if cfg == nil {
+		cfg = &models.StaticConfig{}
+	}
+	if cfg.BuildCommand != "" {
+		log.Phase(PhaseBuild)
+		if err := d.runStaticBuild(ctx, project, cfg, src, deployID, log); err != nil {
+			return "", err
+		}
+	}
+
+	output, err := within(src, filepath.Join(src, filepath.FromSlash(cfg.OutputDir)))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("output directory %q was not found", cfg.OutputDir)
+	} else if err != nil {
+		return "", fmt.Errorf("output directory %q: %w", cfg.OutputDir, err)
+	}
+	if info, err := os.Stat(output); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("output directory %q is not a directory", cfg.OutputDir)
+	}
+
+	releases := d.releasesDir(project.ID)
+	if err := os.MkdirAll(releases, 0755); err != nil {
+		return "", err
+	}
+	release := filepath.Join(releases, deployID)
+	tmp := release + ".tmp"
+	os.RemoveAll(tmp)
+	if err := copyTree(output, tmp, log); err != nil {
+		os.RemoveAll(tmp)
+		return "", fmt.Errorf("failed to copy the site: %w", err)
+	}
+	if err := os.Rename(tmp, release); err != nil {
+		os.RemoveAll(tmp)
+		return "", err
+	}
+	if _, err := os.Stat(filepath.Join(release, "index.html")); err != nil {
+		log.Printf("Warning: the release has no index.html")
+	}
+	log.Printf("Created release %s", deployID)
+	return release, nil
+}
+
+// runStaticBuild runs the build command in a throwaway container with the
+// checkout mounted as its working directory
+func (d *Deployer) runStaticBuild(ctx context.Context, project *models.Project, cfg *models.StaticConfig, src, deployID string, log *Logger) error {
+	image := cfg.BuildImage
+	if image == "" {
+		image = defaultStaticBuildImage
+	}
+	if _, err := d.Docker.ImageID(ctx, image); err != nil {
+		log.Printf("Pulling image %s", image)
+		if err := d.Docker.PullImage(ctx, image, log.Line); err != nil {
+			return err
+		}
+	}
+
+	id, err := d.Docker.CreateContainer(ctx, docker.CreateContainerOptions{
+		Name:        containerName(project.ID) + "-build-" + deployID,
+		Image:       image,
+		Volumes:     []string{src + ":" + staticBuildDir},
+		WorkingDir:  staticBuildDir,
+		Entrypoint:  []string{"/bin/sh", "-c"},
+		Cmd:         []string{cfg.BuildCommand},
+		Environment: environment(project),
+		NanoCPUs:    int64(project.Resources.CPULimit * 1e9),
+		Memory:      project.Resources.MemoryLimit,
+		Restart:     "no",
+		Labels:      map[string]string{docker.ProjectLabel: project.ID, DeployLabel: deployID, ManagedLabel: "true"},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create build container: %w", err)
+	}
+	defer d.Docker.RemoveContainer(context.WithoutCancel(ctx), id, true)
+
+	log.Printf("Running %q in %s", cfg.BuildCommand, image)
+	if err := d.Docker.StartContainer(ctx, id); err != nil {
+		return fmt.Errorf("failed to start build container: %w", err)
+	}
+	if err := d.Docker.FollowLogs(ctx, id, func(line string) { log.Line("  " + line) }); err != nil && ctx.Err() == nil {
+		log.Printf("Warning: failed to follow build output: %v", err)
+	}
+	code, err := d.Docker.WaitContainer(ctx, id)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return fmt.Errorf("build command exited with code %d", code)
+	}
+	return nil
+}
+
+// isCurrent reports whether release is the one a static project serves
+func (d *Deployer) isCurrent(projectID, release string) bool {
+	current, err := os.Readlink(d.currentLink(projectID))
+	return err == nil && current == release
+}
+
+// recordRelease stores the release folder a static deployment serves
+func (d *Deployer) recordRelease(deployID, release string) {
+	d.Store.Deployments.Update(deployID, func(dep *models.Deployment) error {
+		dep.Release = release
+		return nil
+	})
+}
+
+// activate points link at target by renaming a new link over it, so nginx
+// serves either the old or the new release, never a mix
+func activate(link, target string) error {
+	tmp := link + ".tmp"
+	os.Remove(tmp)
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, link); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// copyTree copies the files below src to dst, readable by nginx. Symlinks
+// are skipped since they could expose files outside the site.
+func copyTree(src, dst string, log *Logger) error {
+	return filepath.Walk(src, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, file)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case info.IsDir() && info.Name() == ".git":
+			return filepath.SkipDir
+		case info.IsDir():
+			return os.MkdirAll(target, 0755)
+		case info.Mode()&os.ModeSymlink != 0:
+			log.Printf("Skipping symlink %s", filepath.ToSlash(rel))
+			return nil
+		case !info.Mode().IsRegular():
+			return nil
+		}
+
+		in, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm()|0644)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+}
+
+// RemoveSite stops serving a project's domain from nginx and removes the
+// releases of static projects, e.g. when the project is deleted
+func (d *Deployer) RemoveSite(projectID string) error {
+	if d.Proxy != nil {
+		if err := d.Proxy.Remove(projectID); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(filepath.Join(d.StaticDir, projectID))
+}
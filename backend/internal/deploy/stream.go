@@ -0,0 +1,169 @@
+package deploy
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bizino-services/biz-panel-backend/internal/models"
+	"github.com/bizino-services/biz-panel-backend/internal/store"
+)
+
+// Phases of a deployment, marked in its log
+const (
+	PhaseClone       = "clone"
+	PhaseBuild       = "build"
+	PhasePull        = "pull"
+	PhaseStart       = "start"
+	PhaseHealthcheck = "healthcheck"
+)
+
+// streamRetention is how long the live output of a finished deployment
+// stays available; later subscribers get the stored logs
+const streamRetention = 10 * time.Minute
+
+// subscriberBuffer is how many entries a subscriber may fall behind before
+// it is dropped
+const subscriberBuffer = 256
+
+// LogEntry is a line of deployment output
+type LogEntry struct {
+	Seq    int        `json:"seq"`
+	Time   *time.Time `json:"time,omitempty"` // Unknown for stored logs
+	Phase  string     `json:"phase,omitempty"`
+	Text   string     `json:"text"`
+	Marker bool       `json:"marker,omitempty"` // The line starts Phase
+}
+
+// Stream fans the output of a running deployment out to subscribers
+type Stream struct {
+	mu      sync.Mutex
+	entries []LogEntry
+	seq     int
+	subs    map[chan LogEntry]struct{}
+	status  models.DeployStatus // Set once the deployment finished
+}
+
+func newStream() *Stream {
+	return &Stream{subs: make(map[chan LogEntry]struct{})}
+}
+
+// Subscribe returns the output so far and a channel of the entries that
+// follow. The channel is closed when the deployment finishes, or when the
+// subscriber falls too far behind. cancel releases the subscription.
+func (s *Stream) Subscribe() (backlog []LogEntry, entries <-chan LogEntry, cancel func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	backlog = append([]LogEntry(nil), s.entries...)
+	ch := make(chan LogEntry, subscriberBuffer)
+	if s.status != "" {
+		close(ch)
+		return backlog, ch, func() {}
+	}
+	s.subs[ch] = struct{}{}
+	return backlog, ch, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.subs[ch]; ok {
+			delete(s.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Status returns the outcome of the deployment, or "" while it runs
+func (s *Stream) Status() models.DeployStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+// publish adds an entry and sends it to the subscribers
+func (s *Stream) publish(phase, text string, marker bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status != "" {
+		return
+	}
+
+	s.seq++
+	now := time.Now()
+	entry := LogEntry{Seq: s.seq, Time: &now, Phase: phase, Text: text, Marker: marker}
+	s.entries = append(s.entries, entry)
+	if len(s.entries) > maxLogLines {
+		s.entries = s.entries[len(s.entries)-maxLogLines:]
+	}
+	for ch := range s.subs {
+		select {
+		case ch <- entry:
+		default:
+			// Too slow; the subscriber reconnects and gets the backlog
+			delete(s.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// finish records the outcome and ends every subscription
+func (s *Stream) finish(status models.DeployStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+	for ch := range s.subs {
+		close(ch)
+	}
+	s.subs = nil
+}
+
+// StoredEntries turns a stored deployment log back into entries, recovering
+// the phases from their markers. Stored lines have no timestamps.
+func StoredEntries(lines []string) []LogEntry {
+	entries := make([]LogEntry, 0, len(lines))
+	phase := ""
+	for i, line := range lines {
+		next, marker := strings.CutPrefix(line, phaseMarker)
+		if marker {
+			phase = next
+		}
+		entries = append(entries, LogEntry{Seq: i + 1, Phase: phase, Text: line, Marker: marker})
+	}
+	return entries
+}
+
+// LogStream returns the live output of one of a project's deployments. It
+// is nil once the deployment finished longer than streamRetention ago; its
+// stored logs are complete then.
+func (d *Deployer) LogStream(projectID, deployID string) (*Stream, error) {
+	dep, err := d.Store.Deployments.Get(deployID)
+	if err != nil {
+		return nil, err
+	}
+	if dep.ProjectID != projectID {
+		return nil, store.ErrNotFound
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.streams[deployID], nil
+}
+
+// closeStream ends a deployment's stream and forgets it after streamRetention
+func (d *Deployer) closeStream(deployID string, status models.DeployStatus) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.closeStreamLocked(deployID, status)
+}
+
+// closeStreamLocked is closeStream with d.mu held
+func (d *Deployer) closeStreamLocked(deployID string, status models.DeployStatus) {
+	stream := d.streams[deployID]
+	if stream == nil {
+		return
+	}
+	stream.finish(status)
+	time.AfterFunc(streamRetention, func() {
+		d.mu.Lock()
+		delete(d.streams, deployID)
+		d.mu.Unlock()
+	})
+}
@@ -0,0 +1,245 @@
+package docker
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+)
+
+// BuildOptions describes an image build from a directory on the host
+type BuildOptions struct {
+	ContextDir string
+	Dockerfile string // Relative to ContextDir; empty = Dockerfile
+	Tag        string
+	BuildArgs  map[string]string
+	Labels     map[string]string
+}
+
+// buildMessage is one line of the build output stream
+type buildMessage struct {
+	Stream string `json:"stream"`
+	Status string `json:"status"`
+	Error  string `json:"error"`
+}
+
+// BuildImage builds an image from opts.ContextDir, passing every output
+// line to logLine. .dockerignore is honoured and .git is never sent.
+func (c *Client) BuildImage(ctx context.Context, opts BuildOptions, logLine func(string)) error {
+	dockerfile := opts.Dockerfile
+	if dockerfile == "" {
+		dockerfile = "Dockerfile"
+	}
+	dockerfile = path.Clean(filepath.ToSlash(dockerfile))
+	if path.IsAbs(dockerfile) || dockerfile == ".." || strings.HasPrefix(dockerfile, "../") {
+		return fmt.Errorf("dockerfile %q is outside the build context", opts.Dockerfile)
+	}
+	if _, err := os.Stat(filepath.Join(opts.ContextDir, filepath.FromSlash(dockerfile))); err != nil {
+		return fmt.Errorf("dockerfile %s not found in repository", dockerfile)
+	}
+
+	ignore, err := readDockerignore(opts.ContextDir)
+	if err != nil {
+		return err
+	}
+
+	// Stream the context to the daemon as it is archived
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeContext(pw, opts.ContextDir, ignore, dockerfile))
+	}()
+	defer pr.Close()
+
+	args := make(map[string]*string, len(opts.BuildArgs))
+	for k, v := range opts.BuildArgs {
+		v := v
+		args[k] = &v
+	}
+
+	resp, err := c.cli.ImageBuild(ctx, pr, types.ImageBuildOptions{
+		Tags:        []string{opts.Tag},
+		Dockerfile:  dockerfile,
+		BuildArgs:   args,
+		Labels:      opts.Labels,
+		Remove:      true,
+		ForceRemove: true,
+		PullParent:  true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to start build: %w", err)
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg buildMessage
+		if err := dec.Decode(&msg); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("failed to read build output: %w", err)
+		}
+		if msg.Error != "" {
+			return errors.New(msg.Error)
+		}
+		for _, text := range []string{msg.Stream, msg.Status} {
+			for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+				if line = strings.TrimRight(line, "\r"); line != "" {
+					logLine(line)
+				}
+			}
+		}
+	}
+}
+
+// PullImage pulls an image, passing layer status changes to logLine.
+// Download progress updates are not logged.
+func (c *Client) PullImage(ctx context.Context, image string, logLine func(string)) error {
+	reader, err := c.cli.ImagePull(ctx, image, types.ImagePullOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to pull %s: %w", image, err)
+	}
+	defer reader.Close()
+
+	dec := json.NewDecoder(reader)
+	for {
+		var msg struct {
+			ID       string `json:"id"`
+			Status   string `json:"status"`
+			Progress string `json:"progress"`
+			Error    string `json:"error"`
+		}
+		if err := dec.Decode(&msg); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("failed to read pull output: %w", err)
+		}
+		if msg.Error != "" {
+			return fmt.Errorf("failed to pull %s: %s", image, msg.Error)
+		}
+		if msg.Status == "" || msg.Progress != "" {
+			continue
+		}
+		if msg.ID != "" {
+			logLine(msg.ID + ": " + msg.Status)
+		} else {
+			logLine(msg.Status)
+		}
+	}
+}
+
+// ignorePattern is a .dockerignore line
+type ignorePattern struct {
+	pattern string
+	exclude bool // false for "!" exceptions
+}
+
+// readDockerignore parses the context's .dockerignore, if any
+func readDockerignore(dir string) ([]ignorePattern, error) {
+	f, err := os.Open(filepath.Join(dir, ".dockerignore"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var patterns []ignorePattern
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p := ignorePattern{exclude: true}
+		if strings.HasPrefix(line, "!") {
+			p.exclude = false
+			line = strings.TrimSpace(line[1:])
+		}
+		p.pattern = path.Clean(strings.TrimPrefix(filepath.ToSlash(line), "/"))
+		patterns = append(patterns, p)
+	}
+	return patterns, scanner.Err()
+}
+
+// ignored reports whether rel (slash-separated) is excluded. A pattern also
+// matches everything below a matching directory; the last match wins.
+func ignored(rel string, patterns []ignorePattern) bool {
+	excluded := false
+	for _, p := range patterns {
+		for candidate := rel; candidate != "."; candidate = path.Dir(candidate) {
+			if ok, _ := path.Match(p.pattern, candidate); ok {
+				excluded = p.exclude
+				break
+			}
+		}
+	}
+	return excluded
+}
+
+// writeContext archives dir as a build context. The Dockerfile and
+// .dockerignore are always included, as the daemon needs them.
+func writeContext(w io.Writer, dir string, ignore []ignorePattern, dockerfile string) error {
+	tw := tar.NewWriter(w)
+
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if rel == ".git" {
+			return filepath.SkipDir
+		}
+		// Ignored directories are still walked, since exceptions may match files below
+		if rel != dockerfile && rel != ".dockerignore" && ignored(rel, ignore) {
+			return nil
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(file); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = rel
+		hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
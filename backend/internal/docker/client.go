@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
+	"strconv"
 	"strings"
 	"time"
 
@@ -12,11 +14,15 @@ import (
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/api/types/volume"
 	"github.com/docker/docker/client"
 	"github.com/docker/go-connections/nat"
 )
 
+// ProjectLabel marks containers, images, networks and volumes with the project they belong to
+const ProjectLabel = "biz-panel.project"
+
 // Client wraps Docker client with project-aware operations
 type Client struct {
 	cli *client.Client
@@ -102,6 +108,7 @@ type ImageInfo struct {
 	Size       int64    `json:"size"`
 	Created    int64    `json:"created"`
 	Containers int64    `json:"containers"`
+	ProjectID  string   `json:"projectId"`
 }
 
 // NetworkInfo represents Docker network
@@ -233,16 +240,44 @@ func (c *Client) GetContainer(ctx context.Context, id string) (*ContainerInfo, e
 	return info, nil
 }
 
+// ContainerProjectID returns the project label of a container
+func (c *Client) ContainerProjectID(ctx context.Context, id string) (string, error) {
+	ctr, err := c.cli.ContainerInspect(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	return ctr.Config.Labels[ProjectLabel], nil
+}
+
 // CreateContainerOptions contains options for creating a container
 type CreateContainerOptions struct {
 	Name        string
 	Image       string
-	Ports       []string          // e.g., "8080:80"
-	Volumes     []string          // e.g., "vol-name:/data"
-	Environment []string          // e.g., "KEY=value"
+	Ports       []string // e.g., "8080:80", "127.0.0.1:5353:53/udp" or "80" for any host port
+	Volumes     []string // e.g., "vol-name:/data" or "/host/path:/data:ro"
+	Environment []string // e.g., "KEY=value"
 	Labels      map[string]string
 	Network     string
+	Aliases     []string // DNS names on Network
 	Cmd         []string
+	Entrypoint  []string
+	Isolated    bool   // Attach to Network only, instead of the default bridge as well
+	NanoCPUs    int64  // CPU limit in 1e-9 cores; 0 = unlimited
+	Memory      int64  // Memory limit in bytes; 0 = unlimited
+	Restart     string // Restart policy; empty = unless-stopped
+	User        string
+	WorkingDir  string
+	Healthcheck *Healthcheck
+}
+
+// Healthcheck overrides the image's healthcheck. Test is in Docker's form,
+// e.g. ["CMD-SHELL", "curl -f http://localhost"], or ["NONE"] to disable it.
+type Healthcheck struct {
+	Test        []string
+	Interval    time.Duration
+	Timeout     time.Duration
+	StartPeriod time.Duration
+	Retries     int
 }
 
 // CreateContainer creates a new container
@@ -265,15 +300,22 @@ func (c *Client) CreateContainer(ctx context.Context, opts CreateContainerOption
 	portBindings := make(nat.PortMap)
 
 	for _, portMapping := range opts.Ports {
-		parts := strings.Split(portMapping, ":")
-		if len(parts) == 2 {
-			hostPort := parts[0]
-			containerPort := nat.Port(parts[1] + "/tcp")
-			exposedPorts[containerPort] = struct{}{}
-			portBindings[containerPort] = []nat.PortBinding{
-				{HostIP: "0.0.0.0", HostPort: hostPort},
+		proto := "tcp"
+		if i := strings.LastIndex(portMapping, "/"); i >= 0 {
+			portMapping, proto = portMapping[:i], portMapping[i+1:]
+		}
+		hostIP, hostPort, port := "0.0.0.0", "", portMapping
+		if i := strings.LastIndex(portMapping, ":"); i >= 0 {
+			hostPort, port = portMapping[:i], portMapping[i+1:]
+			if j := strings.LastIndex(hostPort, ":"); j >= 0 {
+				hostIP, hostPort = strings.Trim(hostPort[:j], "[]"), hostPort[j+1:]
 			}
 		}
+		containerPort := nat.Port(port + "/" + proto)
+		exposedPorts[containerPort] = struct{}{}
+		portBindings[containerPort] = append(portBindings[containerPort], nat.PortBinding{
+			HostIP: hostIP, HostPort: hostPort,
+		})
 	}
 
 	// Parse volume mounts
@@ -286,9 +328,10 @@ func (c *Client) CreateContainer(ctx context.Context, opts CreateContainerOption
 				mountType = mount.TypeBind
 			}
 			mounts = append(mounts, mount.Mount{
-				Type:   mountType,
-				Source: parts[0],
-				Target: parts[1],
+				Type:     mountType,
+				Source:   parts[0],
+				Target:   parts[1],
+				ReadOnly: len(parts) > 2 && parts[2] == "ro",
 			})
 		}
 	}
@@ -299,29 +342,54 @@ func (c *Client) CreateContainer(ctx context.Context, opts CreateContainerOption
 		Env:          opts.Environment,
 		ExposedPorts: exposedPorts,
 		Labels:       opts.Labels,
+		User:         opts.User,
+		WorkingDir:   opts.WorkingDir,
 	}
 
+	if opts.Healthcheck != nil {
+		config.Healthcheck = &container.HealthConfig{
+			Test:        opts.Healthcheck.Test,
+			Interval:    opts.Healthcheck.Interval,
+			Timeout:     opts.Healthcheck.Timeout,
+			StartPeriod: opts.Healthcheck.StartPeriod,
+			Retries:     opts.Healthcheck.Retries,
+		}
+	}
 	if len(opts.Cmd) > 0 {
 		config.Cmd = opts.Cmd
 	}
+	if len(opts.Entrypoint) > 0 {
+		config.Entrypoint = opts.Entrypoint
+	}
 
 	hostConfig := &container.HostConfig{
-		PortBindings: portBindings,
-		Mounts:       mounts,
-		RestartPolicy: container.RestartPolicy{
-			Name: "unless-stopped",
+		PortBindings:  portBindings,
+		Mounts:        mounts,
+		RestartPolicy: restartPolicy(opts.Restart),
+		Resources: container.Resources{
+			NanoCPUs: opts.NanoCPUs,
+			Memory:   opts.Memory,
 		},
 	}
+	var networking *network.NetworkingConfig
+	if opts.Isolated && opts.Network != "" {
+		hostConfig.NetworkMode = container.NetworkMode(opts.Network)
+		networking = &network.NetworkingConfig{
+			EndpointsConfig: map[string]*network.EndpointSettings{
+				opts.Network: {Aliases: opts.Aliases},
+			},
+		}
+	}
 
 	// Create the container
-	resp, err := c.cli.ContainerCreate(ctx, config, hostConfig, nil, nil, opts.Name)
+	resp, err := c.cli.ContainerCreate(ctx, config, hostConfig, networking, nil, opts.Name)
 	if err != nil {
 		return "", fmt.Errorf("failed to create container: %w", err)
 	}
 
 	// Connect to network if specified
-	if opts.Network != "" {
-		if err := c.cli.NetworkConnect(ctx, opts.Network, resp.ID, nil); err != nil {
+	if opts.Network != "" && !opts.Isolated {
+		if err := c.cli.NetworkConnect(ctx, opts.Network, resp.ID, &network.EndpointSettings{Aliases: opts.Aliases}); err != nil {
 			// Non-fatal, log warning
 			fmt.Printf("Warning: failed to connect to network %s: %v\n", opts.Network, err)
 		}
@@ -330,6 +398,19 @@ func (c *Client) CreateContainer(ctx context.Context, opts CreateContainerOption
 	return resp.ID, nil
 }
 
+// restartPolicy converts a compose-style restart value, e.g. "on-failure:3"
+func restartPolicy(value string) container.RestartPolicy {
+	if value == "" {
+		return container.RestartPolicy{Name: container.RestartPolicyUnlessStopped}
+	}
+	name, retries, _ := strings.Cut(value, ":")
+	policy := container.RestartPolicy{Name: container.RestartPolicyMode(name)}
+	if n, err := strconv.Atoi(retries); err == nil {
+		policy.MaximumRetryCount = n
+	}
+	return policy
+}
+
 // StartContainer starts a container
 func (c *Client) StartContainer(ctx context.Context, id string) error {
 	return c.cli.ContainerStart(ctx, id, container.StartOptions{})
@@ -352,6 +433,83 @@ func (c *Client) RemoveContainer(ctx context.Context, id string, force bool) err
 	return c.cli.ContainerRemove(ctx, id, container.RemoveOptions{Force: force})
 }
 
+// RenameContainer renames a container
+func (c *Client) RenameContainer(ctx context.Context, id, name string) error {
+	return c.cli.ContainerRename(ctx, id, name)
+}
+
+// ContainerRunning reports whether a container is up and not restarting
+func (c *Client) ContainerRunning(ctx context.Context, id string) (bool, error) {
+	ctr, err := c.cli.ContainerInspect(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	return ctr.State.Running && !ctr.State.Restarting, nil
+}
+
+// WaitContainer waits for a container to stop and returns its exit code
+func (c *Client) WaitContainer(ctx context.Context, id string) (int64, error) {
+	statusCh, errCh := c.cli.ContainerWait(ctx, id, container.WaitConditionNotRunning)
+	select {
+	case status := <-statusCh:
+		if status.Error != nil {
+			return status.StatusCode, fmt.Errorf("%s", status.Error.Message)
+		}
+		return status.StatusCode, nil
+	case err := <-errCh:
+		return 0, err
+	}
+}
+
+// ContainerState is the runtime state of a container
+type ContainerState struct {
+	Status     string // created, running, exited, ...
+	Running    bool
+	Restarting bool
+	ExitCode   int
+	Health     string // starting, healthy or unhealthy; empty without a healthcheck
+}
+
+// InspectState returns the runtime state of a container
+func (c *Client) InspectState(ctx context.Context, id string) (*ContainerState, error) {
+	ctr, err := c.cli.ContainerInspect(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	state := &ContainerState{
+		Status:     ctr.State.Status,
+		Running:    ctr.State.Running,
+		Restarting: ctr.State.Restarting,
+		ExitCode:   ctr.State.ExitCode,
+	}
+	if ctr.State.Health != nil {
+		state.Health = ctr.State.Health.Status
+	}
+	return state, nil
+}
+
+// PublishedAddress returns the host address a container's TCP port is
+// published on, e.g. "127.0.0.1:49153"
+func (c *Client) PublishedAddress(ctx context.Context, id string, port uint16) (string, error) {
+	ctr, err := c.cli.ContainerInspect(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if ctr.NetworkSettings != nil {
+		for _, binding := range ctr.NetworkSettings.Ports[nat.Port(fmt.Sprintf("%d/tcp", port))] {
+			if binding.HostPort == "" {
+				continue
+			}
+			hostIP := binding.HostIP
+			if hostIP == "" || hostIP == "0.0.0.0" {
+				hostIP = "127.0.0.1"
+			}
+			return net.JoinHostPort(hostIP, binding.HostPort), nil
+		}
+	}
+	return "", fmt.Errorf("port %d/tcp of container %s is not published", port, id)
+}
+
 // GetContainerLogs gets container logs
 func (c *Client) GetContainerLogs(ctx context.Context, id string, tail int) (string, error) {
 	opts := container.LogsOptions{
@@ -460,6 +618,7 @@ func (c *Client) ListImages(ctx context.Context) ([]ImageInfo, error) {
 			Size:       img.Size,
 			Created:    img.Created,
 			Containers: img.Containers,
+			ProjectID:  img.Labels[ProjectLabel],
 		})
 	}
 
@@ -472,6 +631,48 @@ func (c *Client) RemoveImage(ctx context.Context, id string, force bool) error {
 	return err
 }
 
+// ImageProjectID returns the project label of an image
+func (c *Client) ImageProjectID(ctx context.Context, id string) (string, error) {
+	img, _, err := c.cli.ImageInspectWithRaw(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if img.Config == nil {
+		return "", nil
+	}
+	return img.Config.Labels[ProjectLabel], nil
+}
+
+// IsNotFound reports whether err means the container, image or volume doesn't exist
+func IsNotFound(err error) bool {
+	return client.IsErrNotFound(err)
+}
+
+// ImageID returns the ID of a local image
+func (c *Client) ImageID(ctx context.Context, ref string) (string, error) {
+	img, _, err := c.cli.ImageInspectWithRaw(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	return img.ID, nil
+}
+
+// ImagesWithLabel returns the IDs of local images with a label set to value
+func (c *Client) ImagesWithLabel(ctx context.Context, key, value string) ([]string, error) {
+	images, err := c.cli.ImageList(ctx, types.ImageListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", key+"="+value)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(images))
+	for _, img := range images {
+		ids = append(ids, img.ID)
+	}
+	return ids, nil
+}
+
 // ListNetworks lists all networks, optionally filtered by project
 func (c *Client) ListNetworks(ctx context.Context, projectID string) ([]NetworkInfo, error) {
 	opts := types.NetworkListOptions{}
@@ -539,6 +740,15 @@ func (c *Client) RemoveNetwork(ctx context.Context, id string) error {
 	return c.cli.NetworkRemove(ctx, id)
 }
 
+// NetworkProjectID returns the project label of a network
+func (c *Client) NetworkProjectID(ctx context.Context, id string) (string, error) {
+	net, err := c.cli.NetworkInspect(ctx, id, types.NetworkInspectOptions{})
+	if err != nil {
+		return "", err
+	}
+	return net.Labels[ProjectLabel], nil
+}
+
 // ConnectContainerToNetwork connects a container to a network
 func (c *Client) ConnectContainerToNetwork(ctx context.Context, containerID, networkID string) error {
 	return c.cli.NetworkConnect(ctx, networkID, containerID, nil)
@@ -609,6 +819,15 @@ func (c *Client) CreateVolume(ctx context.Context, name string, projectID string
 	}, nil
 }
 
+// VolumeProjectID returns the project label of a volume
+func (c *Client) VolumeProjectID(ctx context.Context, name string) (string, error) {
+	vol, err := c.cli.VolumeInspect(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	return vol.Labels[ProjectLabel], nil
+}
+
 // RemoveVolume removes a volume
 func (c *Client) RemoveVolume(ctx context.Context, name string, force bool) error {
 	return c.cli.VolumeRemove(ctx, name, force)
@@ -0,0 +1,59 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// FollowLogs passes a container's output to logLine line by line, from the
+// container's start until it stops or ctx ends
+func (c *Client) FollowLogs(ctx context.Context, id string, logLine func(string)) error {
+	reader, err := c.cli.ContainerLogs(ctx, id, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     true,
+	})
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	w := &lineWriter{logLine: logLine}
+	_, err = stdcopy.StdCopy(w, w, reader)
+	w.flush()
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// lineWriter splits written output into lines
+type lineWriter struct {
+	logLine func(string)
+	buf     bytes.Buffer
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	for {
+		i := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if i < 0 {
+			break
+		}
+		line := string(w.buf.Next(i + 1))
+		w.logLine(strings.TrimRight(line, "\r\n"))
+	}
+	return len(p), nil
+}
+
+// flush passes on a last line without a newline
+func (w *lineWriter) flush() {
+	if w.buf.Len() > 0 {
+		w.logLine(strings.TrimRight(w.buf.String(), "\r\n"))
+		w.buf.Reset()
+	}
+}
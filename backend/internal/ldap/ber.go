@@ -0,0 +1,212 @@
+package ldap
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// BER classes and the constructed bit
+const (
+	classUniversal   = 0x00
+	classApplication = 0x40
+	classContext     = 0x80
+	constructed      = 0x20
+)
+
+// Universal tags used by LDAP
+const (
+	tagBoolean     = 0x01
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagEnumerated  = 0x0a
+	tagSequence    = 0x10
+	tagSet         = 0x11
+)
+
+// maxPacketSize bounds a single LDAP message read from the server
+const maxPacketSize = 16 << 20
+
+// packet is a decoded BER element. Primitive elements keep their content in
+// data, constructed elements their decoded children.
+type packet struct {
+	tag      byte // Full identifier octet: class | constructed | number
+	data     []byte
+	children []*packet
+}
+
+func newPrimitive(tag byte, data []byte) *packet {
+	return &packet{tag: tag, data: data}
+}
+
+func newConstructed(tag byte, children ...*packet) *packet {
+	return &packet{tag: tag | constructed, children: children}
+}
+
+func newSequence(children ...*packet) *packet {
+	return newConstructed(classUniversal|tagSequence, children...)
+}
+
+func newString(s string) *packet {
+	return newPrimitive(classUniversal|tagOctetString, []byte(s))
+}
+
+func newInteger(tag byte, v int64) *packet {
+	// Minimal two's complement encoding
+	var buf []byte
+	for {
+		buf = append([]byte{byte(v)}, buf...)
+		if (v >= -128 && v < 128) || len(buf) == 8 {
+			break
+		}
+		v >>= 8
+	}
+	return newPrimitive(tag, buf)
+}
+
+func newBoolean(v bool) *packet {
+	if v {
+		return newPrimitive(classUniversal|tagBoolean, []byte{0xff})
+	}
+	return newPrimitive(classUniversal|tagBoolean, []byte{0x00})
+}
+
+func (p *packet) append(children ...*packet) *packet {
+	p.children = append(p.children, children...)
+	return p
+}
+
+// bytes encodes the element
+func (p *packet) bytes() []byte {
+	content := p.data
+	if p.tag&constructed != 0 {
+		content = nil
+		for _, child := range p.children {
+			content = append(content, child.bytes()...)
+		}
+	}
+	return append(append([]byte{p.tag}, encodeLength(len(content))...), content...)
+}
+
+func encodeLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+	var buf []byte
+	for ; n > 0; n >>= 8 {
+		buf = append([]byte{byte(n)}, buf...)
+	}
+	return append([]byte{0x80 | byte(len(buf))}, buf...)
+}
+
+// number returns the tag number without class and constructed bits
+func (p *packet) number() byte {
+	return p.tag & 0x1f
+}
+
+// class returns the tag class
+func (p *packet) class() byte {
+	return p.tag & 0xc0
+}
+
+// str returns the content of a primitive element as a string
+func (p *packet) str() string {
+	return string(p.data)
+}
+
+// int returns the content of an INTEGER or ENUMERATED element
+func (p *packet) int() int64 {
+	var v int64
+	for i, b := range p.data {
+		if i == 0 && b&0x80 != 0 {
+			v = -1
+		}
+		v = v<<8 | int64(b)
+	}
+	return v
+}
+
+// child returns the i-th child or an error when the message is too short
+func (p *packet) child(i int) (*packet, error) {
+	if i >= len(p.children) {
+		return nil, errors.New("ldap: malformed response")
+	}
+	return p.children[i], nil
+}
+
+// readPacket reads one BER element
+func readPacket(r *bufio.Reader) (*packet, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if tag&0x1f == 0x1f {
+		return nil, errors.New("ldap: multi-byte tags are not supported")
+	}
+
+	length, err := readLength(r)
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	if length > maxPacketSize {
+		return nil, fmt.Errorf("ldap: message of %d bytes is too large", length)
+	}
+
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return nil, err
+	}
+	return decodePacket(tag, content)
+}
+
+func readLength(r *bufio.Reader) (int, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if first < 0x80 {
+		return int(first), nil
+	}
+	n := int(first & 0x7f)
+	if n == 0 || n > 4 {
+		return 0, errors.New("ldap: unsupported length encoding")
+	}
+	length := 0
+	for i := 0; i < n; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		length = length<<8 | int(b)
+	}
+	return length, nil
+}
+
+// decodePacket decodes content, recursing into constructed elements
+func decodePacket(tag byte, content []byte) (*packet, error) {
+	p := &packet{tag: tag}
+	if tag&constructed == 0 {
+		p.data = content
+		return p, nil
+	}
+
+	r := bufio.NewReader(bytes.NewReader(content))
+	for {
+		child, err := readPacket(r)
+		if err == io.EOF {
+			return p, nil
+		}
+		if err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, errors.New("ldap: truncated element")
+			}
+			return nil, err
+		}
+		p.children = append(p.children, child)
+	}
+}
@@ -0,0 +1,395 @@
+// Package ldap is a small LDAPv3 client covering what panel logins need:
+// simple binds, StartTLS and subtree searches.
+package ldap
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Protocol operation tags (RFC 4511 section 4.2 onwards)
+const (
+	opBindRequest      = 0
+	opBindResponse     = 1
+	opUnbindRequest    = 2
+	opSearchRequest    = 3
+	opSearchEntry      = 4
+	opSearchDone       = 5
+	opSearchReference  = 19
+	opExtendedRequest  = 23
+	opExtendedResponse = 24
+)
+
+// Result codes
+const (
+	resultSuccess      = 0
+	resultSizeExceeded = 4
+	resultInvalidCreds = 49
+)
+
+// oidStartTLS names the StartTLS extended operation
+const oidStartTLS = "1.3.6.1.4.1.1466.20037"
+
+// Search defaults: entries returned and seconds the server may spend
+const (
+	defaultSearchLimit   = 100
+	defaultSearchSeconds = 10
+)
+
+// Search scopes
+const (
+	ScopeBaseObject   = 0
+	ScopeSingleLevel  = 1
+	ScopeWholeSubtree = 2
+)
+
+// ErrInvalidCredentials is returned by Bind for a wrong DN or password
+var ErrInvalidCredentials = errors.New("ldap: invalid credentials")
+
+// ResultError is a non-success LDAP result
+type ResultError struct {
+	Code    int64
+	Message string
+}
+
+func (e *ResultError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("ldap: result code %d", e.Code)
+	}
+	return fmt.Sprintf("ldap: result code %d: %s", e.Code, e.Message)
+}
+
+// Options tune a connection
+type Options struct {
+	Timeout   time.Duration // Dial and per-operation timeout
+	TLSConfig *tls.Config   // Used for ldaps:// and StartTLS
+}
+
+// Entry is a search result
+type Entry struct {
+	DN         string
+	Attributes map[string][]string // Keyed by lower-case attribute name
+}
+
+// Get returns the first value of attr, or ""
+func (e *Entry) Get(attr string) string {
+	if values := e.Attributes[strings.ToLower(attr)]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Values returns every value of attr
+func (e *Entry) Values(attr string) []string {
+	return e.Attributes[strings.ToLower(attr)]
+}
+
+// SearchRequest describes a search
+type SearchRequest struct {
+	BaseDN     string
+	Scope      int
+	Filter     string
+	Attributes []string
+	SizeLimit  int // 0 uses a default of 100
+}
+
+// Conn is a connection to an LDAP server. Operations are serialized.
+type Conn struct {
+	mu      sync.Mutex
+	conn    net.Conn
+	reader  *bufio.Reader
+	nextID  int64
+	timeout time.Duration
+	tls     *tls.Config
+	host    string
+}
+
+// Dial connects to an ldap:// or ldaps:// URL
+func Dial(rawURL string, opts Options) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("ldap: invalid URL %q", rawURL)
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+
+	host := u.Hostname()
+	port := u.Port()
+	dialer := &net.Dialer{Timeout: opts.Timeout}
+
+	var conn net.Conn
+	switch u.Scheme {
+	case "ldap":
+		if port == "" {
+			port = "389"
+		}
+		conn, err = dialer.Dial("tcp", net.JoinHostPort(host, port))
+	case "ldaps":
+		if port == "" {
+			port = "636"
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", net.JoinHostPort(host, port), tlsConfig(opts.TLSConfig, host))
+	default:
+		return nil, fmt.Errorf("ldap: unsupported scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Conn{
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		timeout: opts.Timeout,
+		tls:     opts.TLSConfig,
+		host:    host,
+	}, nil
+}
+
+func tlsConfig(cfg *tls.Config, host string) *tls.Config {
+	if cfg == nil {
+		cfg = &tls.Config{}
+	} else {
+		cfg = cfg.Clone()
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = host
+	}
+	if cfg.MinVersion == 0 {
+		cfg.MinVersion = tls.VersionTLS12
+	}
+	return cfg
+}
+
+// Close sends an unbind and closes the connection
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	c.nextID++
+	msg := newSequence(newInteger(classUniversal|tagInteger, c.nextID), newPrimitive(classApplication|opUnbindRequest, nil))
+	c.conn.Write(msg.bytes())
+	return c.conn.Close()
+}
+
+// StartTLS upgrades the connection to TLS
+func (c *Conn) StartTLS() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	req := newConstructed(classApplication|opExtendedRequest, newPrimitive(classContext|0, []byte(oidStartTLS)))
+	resp, err := c.roundTrip(req, opExtendedResponse)
+	if err != nil {
+		return err
+	}
+	if err := resultError(resp); err != nil {
+		return err
+	}
+
+	tlsConn := tls.Client(c.conn, tlsConfig(c.tls, c.host))
+	tlsConn.SetDeadline(time.Now().Add(c.timeout))
+	if err := tlsConn.Handshake(); err != nil {
+		return fmt.Errorf("ldap: StartTLS handshake failed: %w", err)
+	}
+	c.conn = tlsConn
+	c.reader = bufio.NewReader(tlsConn)
+	return nil
+}
+
+// Bind authenticates with a DN and password. An empty password is refused,
+// since servers treat it as an unauthenticated bind that always succeeds.
+func (c *Conn) Bind(dn, password string) error {
+	if password == "" {
+		return ErrInvalidCredentials
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	req := newConstructed(classApplication|opBindRequest,
+		newInteger(classUniversal|tagInteger, 3),
+		newString(dn),
+		newPrimitive(classContext|0, []byte(password)),
+	)
+	resp, err := c.roundTrip(req, opBindResponse)
+	if err != nil {
+		return err
+	}
+	if err := resultError(resp); err != nil {
+		var re *ResultError
+		if errors.As(err, &re) && re.Code == resultInvalidCreds {
+			return ErrInvalidCredentials
+		}
+		return err
+	}
+	return nil
+}
+
+// Search runs a search and collects the returned entries
+func (c *Conn) Search(req SearchRequest) ([]*Entry, error) {
+	filter, err := compileFilter(req.Filter)
+	if err != nil {
+		return nil, err
+	}
+	if req.SizeLimit <= 0 {
+		req.SizeLimit = defaultSearchLimit
+	}
+
+	attrs := newSequence()
+	for _, a := range req.Attributes {
+		attrs.append(newString(a))
+	}
+	op := newConstructed(classApplication|opSearchRequest,
+		newString(req.BaseDN),
+		newInteger(classUniversal|tagEnumerated, int64(req.Scope)),
+		newInteger(classUniversal|tagEnumerated, 0), // neverDerefAliases
+		newInteger(classUniversal|tagInteger, int64(req.SizeLimit)),
+		newInteger(classUniversal|tagInteger, defaultSearchSeconds),
+		newBoolean(false),
+		filter,
+		attrs,
+	)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	id, err := c.send(op)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*Entry
+	for {
+		resp, err := c.receive(id)
+		if err != nil {
+			return nil, err
+		}
+		switch resp.number() {
+		case opSearchEntry:
+			entry, err := parseEntry(resp)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		case opSearchReference:
+			// Referrals are not followed
+		case opSearchDone:
+			if err := resultError(resp); err != nil {
+				var re *ResultError
+				if errors.As(err, &re) && re.Code == resultSizeExceeded {
+					return entries, nil
+				}
+				return nil, err
+			}
+			return entries, nil
+		default:
+			return nil, fmt.Errorf("ldap: unexpected response %d to search", resp.number())
+		}
+	}
+}
+
+// roundTrip sends op and waits for a single response of the expected type.
+// Callers hold c.mu.
+func (c *Conn) roundTrip(op *packet, expect byte) (*packet, error) {
+	id, err := c.send(op)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.receive(id)
+	if err != nil {
+		return nil, err
+	}
+	if resp.number() != expect {
+		return nil, fmt.Errorf("ldap: unexpected response %d, expected %d", resp.number(), expect)
+	}
+	return resp, nil
+}
+
+// send writes op in a new message and returns its message ID
+func (c *Conn) send(op *packet) (int64, error) {
+	c.nextID++
+	msg := newSequence(newInteger(classUniversal|tagInteger, c.nextID), op)
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	if _, err := c.conn.Write(msg.bytes()); err != nil {
+		return 0, err
+	}
+	return c.nextID, nil
+}
+
+// receive reads the next message for id and returns its protocol op
+func (c *Conn) receive(id int64) (*packet, error) {
+	for {
+		msg, err := readPacket(c.reader)
+		if err != nil {
+			return nil, err
+		}
+		msgID, err := msg.child(0)
+		if err != nil {
+			return nil, err
+		}
+		op, err := msg.child(1)
+		if err != nil {
+			return nil, err
+		}
+		// Unsolicited notifications (ID 0) mean the server is closing the connection
+		if msgID.int() == 0 {
+			return nil, resultError(op)
+		}
+		if msgID.int() == id && op.class() == classApplication {
+			return op, nil
+		}
+	}
+}
+
+// resultError converts an LDAPResult to an error (nil for success)
+func resultError(op *packet) error {
+	code, err := op.child(0)
+	if err != nil {
+		return err
+	}
+	if code.int() == resultSuccess {
+		return nil
+	}
+	re := &ResultError{Code: code.int()}
+	if msg, err := op.child(2); err == nil {
+		re.Message = msg.str()
+	}
+	return re
+}
+
+// parseEntry converts a SearchResultEntry
+func parseEntry(op *packet) (*Entry, error) {
+	dn, err := op.child(0)
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := op.child(1)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &Entry{DN: dn.str(), Attributes: make(map[string][]string, len(attrs.children))}
+	for _, attr := range attrs.children {
+		name, err := attr.child(0)
+		if err != nil {
+			return nil, err
+		}
+		vals, err := attr.child(1)
+		if err != nil {
+			return nil, err
+		}
+		key := strings.ToLower(name.str())
+		for _, v := range vals.children {
+			entry.Attributes[key] = append(entry.Attributes[key], v.str())
+		}
+	}
+	return entry, nil
+}
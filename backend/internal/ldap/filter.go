@@ -0,0 +1,180 @@
+package ldap
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Filter choice tags (RFC 4511 section 4.5.1)
+const (
+	filterAnd            = 0
+	filterOr             = 1
+	filterNot            = 2
+	filterEqualityMatch  = 3
+	filterSubstrings     = 4
+	filterGreaterOrEqual = 5
+	filterLessOrEqual    = 6
+	filterPresent        = 7
+	filterApproxMatch    = 8
+)
+
+// EscapeFilter escapes a value for use inside a search filter (RFC 4515)
+func EscapeFilter(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '*', '(', ')', '\\', 0:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// compileFilter parses a string filter such as (&(objectClass=person)(uid=jo))
+func compileFilter(filter string) (*packet, error) {
+	filter = strings.TrimSpace(filter)
+	if filter == "" {
+		return nil, errors.New("ldap: empty filter")
+	}
+	if filter[0] != '(' {
+		filter = "(" + filter + ")"
+	}
+	p, rest, err := parseFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("ldap: unexpected %q after filter", rest)
+	}
+	return p, nil
+}
+
+// parseFilter parses one parenthesized filter and returns the remaining input
+func parseFilter(s string) (*packet, string, error) {
+	if len(s) < 2 || s[0] != '(' {
+		return nil, "", fmt.Errorf("ldap: filter must start with '(' at %q", s)
+	}
+	s = s[1:]
+
+	switch s[0] {
+	case '&', '|':
+		tag := byte(filterAnd)
+		if s[0] == '|' {
+			tag = filterOr
+		}
+		set := newConstructed(classContext | tag)
+		s = s[1:]
+		for len(s) > 0 && s[0] == '(' {
+			child, rest, err := parseFilter(s)
+			if err != nil {
+				return nil, "", err
+			}
+			set.append(child)
+			s = rest
+		}
+		if len(s) == 0 || s[0] != ')' {
+			return nil, "", errors.New("ldap: unterminated filter")
+		}
+		return set, s[1:], nil
+	case '!':
+		child, rest, err := parseFilter(s[1:])
+		if err != nil {
+			return nil, "", err
+		}
+		if len(rest) == 0 || rest[0] != ')' {
+			return nil, "", errors.New("ldap: unterminated filter")
+		}
+		return newConstructed(classContext|filterNot, child), rest[1:], nil
+	}
+
+	end := strings.IndexByte(s, ')')
+	if end < 0 {
+		return nil, "", errors.New("ldap: unterminated filter")
+	}
+	item, rest := s[:end], s[end+1:]
+	p, err := parseItem(item)
+	return p, rest, err
+}
+
+// parseItem parses attr=value, attr>=value, attr<=value, attr~=value,
+// attr=* and substring patterns
+func parseItem(item string) (*packet, error) {
+	eq := strings.IndexByte(item, '=')
+	if eq <= 0 {
+		return nil, fmt.Errorf("ldap: invalid filter item %q", item)
+	}
+	attr, value := item[:eq], item[eq+1:]
+
+	tag := byte(filterEqualityMatch)
+	switch attr[len(attr)-1] {
+	case '>':
+		tag, attr = filterGreaterOrEqual, attr[:len(attr)-1]
+	case '<':
+		tag, attr = filterLessOrEqual, attr[:len(attr)-1]
+	case '~':
+		tag, attr = filterApproxMatch, attr[:len(attr)-1]
+	}
+	if attr == "" {
+		return nil, fmt.Errorf("ldap: invalid filter item %q", item)
+	}
+
+	if tag == filterEqualityMatch && value == "*" {
+		return newPrimitive(classContext|filterPresent, []byte(attr)), nil
+	}
+
+	if tag == filterEqualityMatch && strings.Contains(value, "*") {
+		parts := strings.Split(value, "*")
+		subs := newSequence()
+		for i, part := range parts {
+			if part == "" {
+				continue
+			}
+			decoded, err := unescapeFilter(part)
+			if err != nil {
+				return nil, err
+			}
+			kind := byte(1) // any
+			if i == 0 {
+				kind = 0 // initial
+			} else if i == len(parts)-1 {
+				kind = 2 // final
+			}
+			subs.append(newPrimitive(classContext|kind, []byte(decoded)))
+		}
+		return newConstructed(classContext|filterSubstrings, newString(attr), subs), nil
+	}
+
+	decoded, err := unescapeFilter(value)
+	if err != nil {
+		return nil, err
+	}
+	return newConstructed(classContext|tag, newString(attr), newString(decoded)), nil
+}
+
+// unescapeFilter decodes \XX escapes in a filter value
+func unescapeFilter(s string) (string, error) {
+	if !strings.Contains(s, "\\") {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+3 > len(s) {
+			return "", fmt.Errorf("ldap: invalid escape in %q", s)
+		}
+		decoded, err := hex.DecodeString(s[i+1 : i+3])
+		if err != nil {
+			return "", fmt.Errorf("ldap: invalid escape in %q", s)
+		}
+		b.Write(decoded)
+		i += 2
+	}
+	return b.String(), nil
+}
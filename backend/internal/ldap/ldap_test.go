@@ -0,0 +1,330 @@
+package ldap
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// directory is an in-process LDAP server holding a few entries
+type directory struct {
+	passwords map[string]string // DN -> password
+	entries   []*Entry
+
+	mu      sync.Mutex
+	filters []*packet // Filters of the searches received, in order
+}
+
+func newDirectory() *directory {
+	return &directory{
+		passwords: map[string]string{
+			"cn=svc,dc=example,dc=org":              "svc-secret",
+			"uid=alice,ou=people,dc=example,dc=org": "alice-secret",
+		},
+		entries: []*Entry{
+			{DN: "uid=alice,ou=people,dc=example,dc=org", Attributes: map[string][]string{
+				"uid": {"alice"}, "mail": {"alice@example.org"}, "memberof": {"cn=devs,ou=groups,dc=example,dc=org"},
+			}},
+			{DN: "uid=bob,ou=people,dc=example,dc=org", Attributes: map[string][]string{
+				"uid": {"bob"}, "mail": {"bob@example.org"},
+			}},
+			{DN: "cn=admins,ou=groups,dc=example,dc=org", Attributes: map[string][]string{
+				"cn": {"admins"}, "member": {"uid=alice,ou=people,dc=example,dc=org"},
+			}},
+			{DN: "cn=ops,ou=groups,dc=example,dc=org", Attributes: map[string][]string{
+				"cn": {"ops"}, "memberuid": {"bob"},
+			}},
+		},
+	}
+}
+
+// serve accepts connections until the test ends and returns the server URL
+func (d *directory) serve(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go d.handle(conn)
+		}
+	}()
+	return "ldap://" + ln.Addr().String()
+}
+
+func (d *directory) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		msg, err := readPacket(r)
+		if err != nil {
+			return
+		}
+		id, op := msg.children[0].int(), msg.children[1]
+		reply := func(op *packet) {
+			conn.Write(newSequence(newInteger(classUniversal|tagInteger, id), op).bytes())
+		}
+
+		switch op.number() {
+		case opBindRequest:
+			code := int64(resultSuccess)
+			if pw, ok := d.passwords[op.children[1].str()]; !ok || pw != op.children[2].str() {
+				code = resultInvalidCreds
+			}
+			reply(result(opBindResponse, code))
+		case opSearchRequest:
+			base, filter := op.children[0].str(), op.children[6]
+			d.mu.Lock()
+			d.filters = append(d.filters, filter)
+			d.mu.Unlock()
+			for _, e := range d.entries {
+				if strings.HasSuffix(e.DN, base) && matches(filter, e) {
+					reply(entryPacket(e))
+				}
+			}
+			reply(result(opSearchDone, resultSuccess))
+		case opUnbindRequest:
+			return
+		}
+	}
+}
+
+func result(op byte, code int64) *packet {
+	return newConstructed(classApplication|op,
+		newInteger(classUniversal|tagEnumerated, code), newString(""), newString(""))
+}
+
+func entryPacket(e *Entry) *packet {
+	attrs := newSequence()
+	for name, values := range e.Attributes {
+		set := newConstructed(classUniversal | tagSet)
+		for _, v := range values {
+			set.append(newString(v))
+		}
+		attrs.append(newSequence(newString(name), set))
+	}
+	return newConstructed(classApplication|opSearchEntry, newString(e.DN), attrs)
+}
+
+// matches evaluates the and, or, not, equality and presence filters
+func matches(f *packet, e *Entry) bool {
+	switch f.number() {
+	case filterAnd:
+		for _, c := range f.children {
+			if !matches(c, e) {
+				return false
+			}
+		}
+		return true
+	case filterOr:
+		for _, c := range f.children {
+			if matches(c, e) {
+				return true
+			}
+		}
+		return false
+	case filterNot:
+		return !matches(f.children[0], e)
+	case filterEqualityMatch:
+		for _, v := range e.Values(f.children[0].str()) {
+			if strings.EqualFold(v, f.children[1].str()) {
+				return true
+			}
+		}
+		return false
+	case filterPresent:
+		return strings.EqualFold(f.str(), "objectClass") || len(e.Values(f.str())) > 0
+	}
+	return false
+}
+
+func dial(t *testing.T, url string) *Conn {
+	t.Helper()
+	conn, err := Dial(url, Options{Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestBind(t *testing.T) {
+	conn := dial(t, newDirectory().serve(t))
+
+	if err := conn.Bind("uid=alice,ou=people,dc=example,dc=org", "alice-secret"); err != nil {
+		t.Fatalf("bind with the right password: %v", err)
+	}
+	if err := conn.Bind("uid=alice,ou=people,dc=example,dc=org", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("bind with a wrong password = %v, want ErrInvalidCredentials", err)
+	}
+	if err := conn.Bind("uid=nobody,dc=example,dc=org", "x"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("bind as an unknown DN = %v, want ErrInvalidCredentials", err)
+	}
+	// Servers accept an empty password as an anonymous bind
+	if err := conn.Bind("uid=alice,ou=people,dc=example,dc=org", ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("bind with an empty password = %v, want ErrInvalidCredentials", err)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	conn := dial(t, newDirectory().serve(t))
+	if err := conn.Bind("cn=svc,dc=example,dc=org", "svc-secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	users, err := conn.Search(SearchRequest{
+		BaseDN: "ou=people,dc=example,dc=org",
+		Scope:  ScopeWholeSubtree,
+		Filter: "(&(objectClass=*)(uid=alice))",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 {
+		t.Fatalf("found %d users, want 1", len(users))
+	}
+	alice := users[0]
+	if alice.DN != "uid=alice,ou=people,dc=example,dc=org" || alice.Get("Mail") != "alice@example.org" {
+		t.Fatalf("unexpected entry %+v", alice)
+	}
+	if got := alice.Values("memberOf"); len(got) != 1 || got[0] != "cn=devs,ou=groups,dc=example,dc=org" {
+		t.Fatalf("memberOf = %v", got)
+	}
+
+	groups, err := conn.Search(SearchRequest{
+		BaseDN: "ou=groups,dc=example,dc=org",
+		Scope:  ScopeWholeSubtree,
+		Filter: "(|(member=" + EscapeFilter(alice.DN) + ")(memberUid=alice))",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || groups[0].Get("cn") != "admins" {
+		t.Fatalf("groups of alice = %+v, want admins only", groups)
+	}
+}
+
+func TestEscapeFilter(t *testing.T) {
+	for in, want := range map[string]string{
+		"alice":         "alice",
+		"*":             `\2a`,
+		"a*)(uid=*":     `a\2a\29\28uid=\2a`,
+		`back\slash`:    `back\5cslash`,
+		"nul\x00byte":   `nul\00byte`,
+		"cn=x,dc=y (z)": `cn=x,dc=y \28z\29`,
+	} {
+		if got := EscapeFilter(in); got != want {
+			t.Errorf("EscapeFilter(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestEscapedValueStaysOneValue(t *testing.T) {
+	dir := newDirectory()
+	conn := dial(t, dir.serve(t))
+
+	// Unescaped, this would match every user
+	injection := "*)(uid=*"
+	users, err := conn.Search(SearchRequest{
+		BaseDN: "dc=example,dc=org",
+		Scope:  ScopeWholeSubtree,
+		Filter: "(uid=" + EscapeFilter(injection) + ")",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 0 {
+		t.Fatalf("escaped filter matched %d entries", len(users))
+	}
+
+	dir.mu.Lock()
+	f := dir.filters[len(dir.filters)-1]
+	dir.mu.Unlock()
+	if f.number() != filterEqualityMatch || f.children[0].str() != "uid" || f.children[1].str() != injection {
+		t.Fatalf("server received filter %d %q, want an equality match of the literal value", f.number(), f.children[1].str())
+	}
+}
+
+func TestCompileFilter(t *testing.T) {
+	valid := map[string]byte{
+		"uid=alice":             filterEqualityMatch,
+		"(uid=*)":               filterPresent,
+		"(cn=ad*in*s)":          filterSubstrings,
+		"(!(uid=bob))":          filterNot,
+		"(uidNumber>=1000)":     filterGreaterOrEqual,
+		"(&(a=1)(|(b=2)(c=3)))": filterAnd,
+		`(cn=\28paren\29)`:      filterEqualityMatch,
+	}
+	for filter, want := range valid {
+		p, err := compileFilter(filter)
+		if err != nil {
+			t.Errorf("compileFilter(%q): %v", filter, err)
+			continue
+		}
+		if p.number() != want {
+			t.Errorf("compileFilter(%q) has tag %d, want %d", filter, p.number(), want)
+		}
+	}
+
+	for _, filter := range []string{"", "(uid=alice", "(&(uid=a)", "(=x)", `(cn=\zz)`, `(cn=\2)`, "(uid=a))"} {
+		if _, err := compileFilter(filter); err == nil {
+			t.Errorf("compileFilter(%q) succeeded, want an error", filter)
+		}
+	}
+
+	p, _ := compileFilter(`(cn=\28paren\29)`)
+	if got := p.children[1].str(); got != "(paren)" {
+		t.Errorf("escaped value decoded to %q", got)
+	}
+}
+
+func TestBERRoundTrip(t *testing.T) {
+	for _, v := range []int64{0, 1, -1, 127, 128, -128, -129, 255, 256, 1 << 31, -(1 << 40)} {
+		p := newInteger(classUniversal|tagInteger, v)
+		decoded, err := readPacket(bufio.NewReader(bytes.NewReader(p.bytes())))
+		if err != nil {
+			t.Fatalf("decode %d: %v", v, err)
+		}
+		if decoded.int() != v {
+			t.Errorf("integer %d decoded as %d", v, decoded.int())
+		}
+	}
+
+	long := strings.Repeat("x", 70000) // Needs a three-byte length
+	msg := newSequence(newString(long), newBoolean(true), newSequence(newString("")))
+	decoded, err := readPacket(bufio.NewReader(bytes.NewReader(msg.bytes())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.children) != 3 || decoded.children[0].str() != long || decoded.children[1].data[0] != 0xff {
+		t.Fatal("sequence did not round-trip")
+	}
+	if !bytes.Equal(decoded.bytes(), msg.bytes()) {
+		t.Fatal("re-encoding differs")
+	}
+}
+
+func TestReadPacketRejectsMalformed(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"truncated content": {0x04, 0x05, 'a', 'b'},
+		"truncated child":   {0x30, 0x03, 0x04, 0x05, 'a'},
+		"long length":       {0x04, 0x85, 1, 0, 0, 0, 0},
+		"multi-byte tag":    {0x1f, 0x01, 0x00},
+		"too large":         {0x04, 0x84, 0x7f, 0xff, 0xff, 0xff},
+	} {
+		if _, err := readPacket(bufio.NewReader(bytes.NewReader(data))); err == nil {
+			t.Errorf("%s: decoded without error", name)
+		}
+	}
+}
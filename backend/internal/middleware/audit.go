@@ -0,0 +1,177 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bizino-services/biz-panel-backend/internal/models"
+	"github.com/bizino-services/biz-panel-backend/internal/store"
+	"github.com/gin-gonic/gin"
+)
+
+// Redacted replaces secret values in audit records
+const Redacted = "[REDACTED]"
+
+// auditBodyLimit is the most request body bytes kept in an audit record
+const auditBodyLimit = 64 << 10
+
+// auditStringLimit truncates long string values (file contents, scripts)
+const auditStringLimit = 2048
+
+// secretKeyParts mark a JSON key as secret when contained in it (case-insensitive)
+var secretKeyParts = []string{"pass", "secret", "token", "privatekey", "private_key", "apikey", "api_key", "credential"}
+
+// secretKeys mark a JSON key as secret when equal to it (case-insensitive)
+var secretKeys = map[string]bool{"key": true, "code": true, "recoverycode": true}
+
+// envKeys hold maps of environment values; keys are kept, values redacted
+var envKeys = map[string]bool{"environment": true, "env": true, "envvars": true, "buildargs": true}
+
+// Audit records every POST, PUT, PATCH and DELETE with the caller, a sanitized
+// body, the response status and latency
+func Audit(repo store.AuditRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			c.Next()
+			return
+		}
+
+		start := time.Now()
+		body := captureBody(c)
+
+		c.Next()
+
+		entry := &models.AuditEntry{
+			Timestamp:  start,
+			UserID:     c.GetString("user_id"),
+			Username:   c.GetString("username"),
+			Role:       c.GetString("role"),
+			AuthMethod: authMethod(c),
+			TokenID:    c.GetString("token_id"),
+			ClientIP:   c.ClientIP(),
+			UserAgent:  c.Request.UserAgent(),
+			Method:     c.Request.Method,
+			Route:      c.FullPath(),
+			Path:       c.Request.URL.Path,
+			Body:       body,
+			Status:     c.Writer.Status(),
+			LatencyMs:  float64(time.Since(start).Microseconds()) / 1000,
+		}
+		if len(c.Params) > 0 {
+			entry.Params = make(map[string]string, len(c.Params))
+			for _, p := range c.Params {
+				entry.Params[p.Key] = p.Value
+			}
+			entry.ResourceID = c.Param("id")
+			if entry.ResourceID == "" {
+				entry.ResourceID = c.Params[0].Value
+			}
+		}
+
+		if err := repo.Append(entry); err != nil {
+			log.Printf("Warning: failed to write audit entry: %v", err)
+		}
+	}
+}
+
+func authMethod(c *gin.Context) string {
+	if method := c.GetString("auth_method"); method != "" {
+		return method
+	}
+	if c.GetString("session_id") != "" {
+		return "session"
+	}
+	return ""
+}
+
+// captureBody reads the request body for the audit record and puts it back
+// for the handler
+func captureBody(c *gin.Context) interface{} {
+	if c.Request.Body == nil || c.Request.ContentLength == 0 {
+		return nil
+	}
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		return "[multipart body omitted]"
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(c.Request.Body, auditBodyLimit+1))
+	c.Request.Body = readCloser{io.MultiReader(bytes.NewReader(buf), c.Request.Body), c.Request.Body}
+	if err != nil || len(buf) == 0 {
+		return nil
+	}
+	if len(buf) > auditBodyLimit {
+		return fmt.Sprintf("[body larger than %d bytes omitted]", auditBodyLimit)
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(buf, &decoded); err != nil {
+		return fmt.Sprintf("[%d bytes of %s]", len(buf), c.ContentType())
+	}
+	return RedactValue("", decoded)
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// IsSecretKey reports whether a JSON key names a secret
+func IsSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	if secretKeys[key] {
+		return true
+	}
+	for _, part := range secretKeyParts {
+		if strings.Contains(key, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactValue returns v with secrets replaced by Redacted. key is the JSON
+// key v was found under ("" at the top level).
+func RedactValue(key string, v interface{}) interface{} {
+	if key != "" && IsSecretKey(key) {
+		if v == nil || v == "" {
+			return v
+		}
+		return Redacted
+	}
+
+	switch val := v.(type) {
+	case map[string]interface{}:
+		if envKeys[strings.ToLower(key)] {
+			for k := range val {
+				val[k] = Redacted
+			}
+			return val
+		}
+		for k, child := range val {
+			val[k] = RedactValue(k, child)
+		}
+		return val
+	case []interface{}:
+		for i, child := range val {
+			val[i] = RedactValue(key, child)
+		}
+		return val
+	case string:
+		if strings.Contains(val, "-----BEGIN") {
+			return Redacted
+		}
+		if len(val) > auditStringLimit {
+			return val[:auditStringLimit] + "...[truncated]"
+		}
+		return val
+	}
+	return v
+}
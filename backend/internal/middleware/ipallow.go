@@ -0,0 +1,142 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IPAllowlist restricts requests to a set of IPs and CIDRs (v4 and v6).
+// Entries come from two sources, the config file and the panel settings;
+// a request is allowed if either matches. An empty allowlist allows everyone.
+type IPAllowlist struct {
+	mu       sync.RWMutex
+	config   []netip.Prefix
+	settings []netip.Prefix
+}
+
+// NewIPAllowlist creates an empty (allow-all) allowlist
+func NewIPAllowlist() *IPAllowlist {
+	return &IPAllowlist{}
+}
+
+// SplitAllowlist splits a free-form list separated by commas, semicolons or whitespace
+func SplitAllowlist(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' ' || r == '\n' || r == '\r' || r == '\t'
+	})
+}
+
+// ParseAllowlist parses IP and CIDR entries into prefixes
+func ParseAllowlist(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q", entry)
+			}
+			if prefix.Addr().Is4In6() {
+				prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP address %q", entry)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// SetConfigEntries replaces the entries from the config file
+func (a *IPAllowlist) SetConfigEntries(entries []string) error {
+	prefixes, err := ParseAllowlist(entries)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.config = prefixes
+	a.mu.Unlock()
+	return nil
+}
+
+// SetSettingsEntries replaces the entries from the panel settings
+func (a *IPAllowlist) SetSettingsEntries(allowedIPs string) error {
+	prefixes, err := ParseAllowlist(SplitAllowlist(allowedIPs))
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.settings = prefixes
+	a.mu.Unlock()
+	return nil
+}
+
+// Allowed reports whether ip may use the panel with the current entries
+func (a *IPAllowlist) Allowed(ip string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return allowedBy(ip, a.config, a.settings)
+}
+
+// AllowedWithSettings reports whether ip would be allowed if the panel
+// settings allowlist were replaced by allowedIPs
+func (a *IPAllowlist) AllowedWithSettings(ip, allowedIPs string) (bool, error) {
+	prefixes, err := ParseAllowlist(SplitAllowlist(allowedIPs))
+	if err != nil {
+		return false, err
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return allowedBy(ip, a.config, prefixes), nil
+}
+
+func allowedBy(ip string, lists ...[]netip.Prefix) bool {
+	empty := true
+	for _, list := range lists {
+		if len(list) > 0 {
+			empty = false
+		}
+	}
+	if empty {
+		return true
+	}
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, list := range lists {
+		for _, prefix := range list {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Middleware rejects requests whose client IP is not allowlisted
+func (a *IPAllowlist) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !a.Allowed(c.ClientIP()) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied from this IP address"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
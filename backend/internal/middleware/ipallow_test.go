@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseAllowlist(t *testing.T) {
+	prefixes, err := ParseAllowlist([]string{" 10.0.0.1 ", "", "192.168.1.77/24", "2001:db8::/32", "::ffff:172.16.0.0/108", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.0.0.1/32", "192.168.1.0/24", "2001:db8::/32", "172.16.0.0/12", "::1/128"}
+	if len(prefixes) != len(want) {
+		t.Fatalf("parsed %v, want %v", prefixes, want)
+	}
+	for i, p := range prefixes {
+		if p.String() != want[i] {
+			t.Errorf("entry %d = %s, want %s", i, p, want[i])
+		}
+	}
+
+	for _, bad := range []string{"10.0.0", "10.0.0.0/33", "example.org", "2001:db8::/129"} {
+		if _, err := ParseAllowlist([]string{bad}); err == nil {
+			t.Errorf("ParseAllowlist(%q) succeeded, want an error", bad)
+		}
+	}
+}
+
+func TestIPAllowlistAllowed(t *testing.T) {
+	a := NewIPAllowlist()
+	if !a.Allowed("203.0.113.9") {
+		t.Fatal("empty allowlist denied a client")
+	}
+
+	if err := a.SetConfigEntries([]string{"10.0.0.0/8", "2001:db8::/32"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.SetSettingsEntries("192.168.1.5, 172.16.0.0/12;\n198.51.100.1"); err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]bool{
+		"10.1.2.3":           true,
+		"::ffff:10.1.2.3":    true, // IPv4-mapped
+		"11.0.0.1":           false,
+		"192.168.1.5":        true,
+		"192.168.1.6":        false,
+		"172.31.255.255":     true,
+		"172.32.0.0":         false,
+		"198.51.100.1":       true,
+		"2001:db8:1::42":     true,
+		"2001:db9::1":        false,
+		"not-an-ip":          false,
+		"":                   false,
+		"10.1.2.3:80":        false,
+		"::ffff:11.0.0.1":    false,
+		"192.168.001.005":    false,
+		"::ffff:192.168.1.5": true,
+	} {
+		if got := a.Allowed(ip); got != want {
+			t.Errorf("Allowed(%q) = %v, want %v", ip, got, want)
+		}
+	}
+
+	if err := a.SetSettingsEntries("10.0.0.0/40"); err == nil {
+		t.Fatal("invalid settings entry accepted")
+	}
+	if !a.Allowed("192.168.1.5") {
+		t.Fatal("a rejected update replaced the settings entries")
+	}
+}
+
+func TestIPAllowlistMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	a := NewIPAllowlist()
+	if err := a.SetConfigEntries([]string{"203.0.113.0/24"}); err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	if err := router.SetTrustedProxies([]string{"10.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	router.Use(a.Middleware())
+	router.GET("/", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+
+	for _, tc := range []struct {
+		name   string
+		remote string
+		xff    string
+		want   int
+	}{
+		{"allowed peer", "203.0.113.7:5000", "", http.StatusOK},
+		{"denied peer", "198.51.100.7:5000", "", http.StatusForbidden},
+		{"trusted proxy forwards an allowed client", "10.0.0.1:5000", "203.0.113.7", http.StatusOK},
+		{"trusted proxy forwards a denied client", "10.0.0.1:5000", "198.51.100.7", http.StatusForbidden},
+		{"client spoofs the header through the proxy", "10.0.0.1:5000", "203.0.113.7, 198.51.100.7", http.StatusForbidden},
+		{"untrusted peer spoofs an allowed client", "198.51.100.7:5000", "203.0.113.7", http.StatusForbidden},
+		{"allowed peer claims a denied client", "203.0.113.7:5000", "198.51.100.7", http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tc.remote
+		if tc.xff != "" {
+			req.Header.Set("X-Forwarded-For", tc.xff)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, w.Code, tc.want)
+		}
+	}
+}
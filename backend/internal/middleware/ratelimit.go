@@ -1,35 +1,76 @@
 package middleware
 
 import (
+	"fmt"
+	"math"
 	"net/http"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-// RateLimiter implements a simple token bucket rate limiter
+// Rate limit keys: what a policy counts requests by
+const (
+	RateLimitKeyIP    = "ip"    // Client IP
+	RateLimitKeyUser  = "user"  // Signed-in user; API tokens count separately from their owner
+	RateLimitKeyToken = "token" // Session or API token
+)
+
+// RateLimitPolicy is a token bucket refilled with Requests every Window
+// that holds up to Burst tokens
+type RateLimitPolicy struct {
+	Name     string
+	Requests int
+	Window   time.Duration
+	Burst    int      // 0 allows Requests at once
+	Key      string   // ip, user or token; requests without a user fall back to ip
+	Routes   []string // Path prefixes the policy applies to
+}
+
+// capacity returns the bucket size
+func (p RateLimitPolicy) capacity() float64 {
+	if p.Burst > 0 {
+		return float64(p.Burst)
+	}
+	return float64(p.Requests)
+}
+
+// refillRate returns tokens added per second
+func (p RateLimitPolicy) refillRate() float64 {
+	return float64(p.Requests) / p.Window.Seconds()
+}
+
+// bucket is one caller's token bucket
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter applies named token-bucket policies. The policy for a request
+// is the one with the longest matching route prefix, otherwise the default
+// policy of the middleware. Clients in an exempt CIDR are never limited.
 type RateLimiter struct {
-	visitors map[string]*visitor
-	mu       sync.RWMutex
-	rate     int           // requests per window
-	window   time.Duration // time window
+	mu       sync.Mutex
+	policies map[string]RateLimitPolicy
+	routes   []routePolicy // Longest prefix first
+	exempt   []netip.Prefix
+	buckets  map[string]map[string]*bucket // Policy -> key -> bucket
 }
 
-type visitor struct {
-	tokens    int
-	lastReset time.Time
+type routePolicy struct {
+	prefix string
+	policy string
 }
 
-// NewRateLimiter creates a new rate limiter
-// rate: max requests allowed in the window
-// window: time window duration
-func NewRateLimiter(rate int, window time.Duration) *RateLimiter {
-	rl := &RateLimiter{
-		visitors: make(map[string]*visitor),
-		rate:     rate,
-		window:   window,
-	}
+// NewRateLimiter creates a rate limiter with the given policies
+func NewRateLimiter(policies []RateLimitPolicy) *RateLimiter {
+	rl := &RateLimiter{buckets: make(map[string]map[string]*bucket)}
+	rl.SetPolicies(policies)
 
 	// Cleanup old entries periodically
 	go rl.cleanup()
@@ -37,66 +78,161 @@ func NewRateLimiter(rate int, window time.Duration) *RateLimiter {
 	return rl
 }
 
+// SetPolicies replaces the policies. Callers keep their remaining tokens,
+// capped at the new burst.
+func (rl *RateLimiter) SetPolicies(policies []RateLimitPolicy) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.policies = make(map[string]RateLimitPolicy, len(policies))
+	rl.routes = nil
+	for _, p := range policies {
+		rl.policies[p.Name] = p
+		for _, prefix := range p.Routes {
+			rl.routes = append(rl.routes, routePolicy{prefix: strings.TrimSuffix(prefix, "/"), policy: p.Name})
+		}
+	}
+	sort.SliceStable(rl.routes, func(i, j int) bool {
+		return len(rl.routes[i].prefix) > len(rl.routes[j].prefix)
+	})
+
+	for name := range rl.buckets {
+		if _, ok := rl.policies[name]; !ok {
+			delete(rl.buckets, name)
+		}
+	}
+}
+
+// SetExempt replaces the IPs and CIDRs that bypass rate limiting
+func (rl *RateLimiter) SetExempt(entries []string) error {
+	prefixes, err := ParseAllowlist(entries)
+	if err != nil {
+		return err
+	}
+	rl.mu.Lock()
+	rl.exempt = prefixes
+	rl.mu.Unlock()
+	return nil
+}
+
 func (rl *RateLimiter) cleanup() {
 	for {
-		time.Sleep(rl.window * 2)
+		time.Sleep(time.Minute)
 		rl.mu.Lock()
 		now := time.Now()
-		for ip, v := range rl.visitors {
-			if now.Sub(v.lastReset) > rl.window*2 {
-				delete(rl.visitors, ip)
+		for name, buckets := range rl.buckets {
+			// A bucket that has refilled completely is the same as a new one
+			p := rl.policies[name]
+			full := time.Duration(p.capacity() / p.refillRate() * float64(time.Second))
+			for key, b := range buckets {
+				if now.Sub(b.last) > full {
+					delete(buckets, key)
+				}
 			}
 		}
 		rl.mu.Unlock()
 	}
 }
 
-func (rl *RateLimiter) getVisitor(ip string) *visitor {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
-	v, exists := rl.visitors[ip]
-	if !exists {
-		v = &visitor{
-			tokens:    rl.rate,
-			lastReset: time.Now(),
+// policyFor returns the policy for path, falling back to defaultPolicy
+func (rl *RateLimiter) policyFor(path, defaultPolicy string) (RateLimitPolicy, bool) {
+	for _, r := range rl.routes {
+		if path == r.prefix || strings.HasPrefix(path, r.prefix+"/") {
+			return rl.policies[r.policy], true
 		}
-		rl.visitors[ip] = v
-	}
-
-	// Reset tokens if window has passed
-	if time.Since(v.lastReset) > rl.window {
-		v.tokens = rl.rate
-		v.lastReset = time.Now()
 	}
-
-	return v
+	p, ok := rl.policies[defaultPolicy]
+	return p, ok
 }
 
-func (rl *RateLimiter) allow(ip string) bool {
-	v := rl.getVisitor(ip)
+// limitResult describes the outcome of taking a token
+type limitResult struct {
+	allowed    bool
+	limit      int
+	remaining  int
+	retryAfter time.Duration
+}
 
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
+// take removes a token from key's bucket under policy p
+func (rl *RateLimiter) take(p RateLimitPolicy, key string, now time.Time) limitResult {
+	buckets := rl.buckets[p.Name]
+	if buckets == nil {
+		buckets = make(map[string]*bucket)
+		rl.buckets[p.Name] = buckets
+	}
 
-	if v.tokens > 0 {
-		v.tokens--
-		return true
+	capacity, rate := p.capacity(), p.refillRate()
+	b, ok := buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, last: now}
+		buckets[key] = b
+	}
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	res := limitResult{limit: int(capacity)}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.allowed = true
+	} else {
+		res.retryAfter = time.Duration((1 - b.tokens) / rate * float64(time.Second))
 	}
+	res.remaining = int(b.tokens)
+	return res
+}
 
-	return false
+// requestKey returns the bucket key for a request under policy p
+func requestKey(c *gin.Context, p RateLimitPolicy) string {
+	switch p.Key {
+	case RateLimitKeyUser:
+		if id := c.GetString("token_id"); id != "" {
+			return "token:" + id
+		}
+		if id := c.GetString("user_id"); id != "" {
+			return "user:" + id
+		}
+	case RateLimitKeyToken:
+		if id := c.GetString("token_id"); id != "" {
+			return "token:" + id
+		}
+		if id := c.GetString("session_id"); id != "" {
+			return "session:" + id
+		}
+	}
+	return "ip:" + c.ClientIP()
 }
 
-// Middleware returns a Gin middleware function for rate limiting
-func (rl *RateLimiter) Middleware() gin.HandlerFunc {
+// Middleware limits requests with the policy matching their path, or
+// defaultPolicy. Install it after authentication for user and token keys.
+func (rl *RateLimiter) Middleware(defaultPolicy string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ip := c.ClientIP()
 
-		if !rl.allow(ip) {
+		rl.mu.Lock()
+		if len(rl.exempt) > 0 && allowedBy(ip, rl.exempt) {
+			rl.mu.Unlock()
+			c.Next()
+			return
+		}
+		p, ok := rl.policyFor(c.Request.URL.Path, defaultPolicy)
+		if !ok {
+			rl.mu.Unlock()
+			c.Next()
+			return
+		}
+		res := rl.take(p, requestKey(c, p), time.Now())
+		rl.mu.Unlock()
+
+		c.Header("X-RateLimit-Limit", strconv.Itoa(res.limit))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(res.remaining))
+
+		if !res.allowed {
+			retryAfter := int(math.Ceil(res.retryAfter.Seconds()))
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
 			c.JSON(http.StatusTooManyRequests, gin.H{
 				"error":      "Too many requests",
-				"message":    "Rate limit exceeded. Please try again later.",
-				"retryAfter": rl.window.Seconds(),
+				"message":    fmt.Sprintf("Rate limit %q exceeded. Please try again later.", p.Name),
+				"retryAfter": retryAfter,
 			})
 			c.Abort()
 			return
@@ -105,17 +241,3 @@ func (rl *RateLimiter) Middleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
-
-// LoginRateLimiter is a stricter rate limiter for login attempts
-func LoginRateLimiter() gin.HandlerFunc {
-	// 5 login attempts per minute per IP
-	limiter := NewRateLimiter(5, time.Minute)
-	return limiter.Middleware()
-}
-
-// APIRateLimiter is a general rate limiter for API endpoints
-func APIRateLimiter() gin.HandlerFunc {
-	// 100 requests per minute per IP
-	limiter := NewRateLimiter(100, time.Minute)
-	return limiter.Middleware()
-}
@@ -0,0 +1,182 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTokenBucket(t *testing.T) {
+	rl := &RateLimiter{buckets: make(map[string]map[string]*bucket)}
+	p := RateLimitPolicy{Name: "api", Requests: 10, Window: time.Minute, Burst: 3} // One token every 6s
+	start := time.Unix(1700000000, 0)
+
+	for _, tc := range []struct {
+		after      time.Duration
+		allowed    bool
+		remaining  int
+		retryAfter time.Duration
+	}{
+		{0, true, 2, 0},
+		{0, true, 1, 0},
+		{0, true, 0, 0},
+		{0, false, 0, 6 * time.Second},
+		{3 * time.Second, false, 0, 3 * time.Second},
+		{6 * time.Second, true, 0, 0},
+		{7 * time.Second, false, 0, 5 * time.Second},
+		{time.Hour, true, 2, 0}, // Refilled up to the burst only
+	} {
+		res := rl.take(p, "ip:192.0.2.1", start.Add(tc.after))
+		if res.allowed != tc.allowed || res.remaining != tc.remaining || res.limit != 3 {
+			t.Fatalf("after %s: %+v, want allowed %v with %d remaining of 3", tc.after, res, tc.allowed, tc.remaining)
+		}
+		if d := res.retryAfter - tc.retryAfter; d < -time.Millisecond || d > time.Millisecond {
+			t.Fatalf("after %s: retry after %s, want %s", tc.after, res.retryAfter, tc.retryAfter)
+		}
+	}
+
+	// Other keys have their own bucket
+	if res := rl.take(p, "ip:192.0.2.2", start); !res.allowed || res.remaining != 2 {
+		t.Fatalf("second key: %+v", res)
+	}
+
+	// Without a burst the bucket holds Requests tokens
+	p = RateLimitPolicy{Name: "login", Requests: 5, Window: time.Minute}
+	for i := 0; i < 5; i++ {
+		if res := rl.take(p, "ip:192.0.2.1", start); !res.allowed || res.limit != 5 {
+			t.Fatalf("request %d: %+v", i+1, res)
+		}
+	}
+	if res := rl.take(p, "ip:192.0.2.1", start); res.allowed {
+		t.Fatal("sixth request allowed")
+	}
+}
+
+func TestPolicyFor(t *testing.T) {
+	rl := NewRateLimiter([]RateLimitPolicy{
+		{Name: "api", Requests: 100, Window: time.Minute},
+		{Name: "deploy", Requests: 5, Window: time.Minute, Routes: []string{"/api/projects/"}},
+		{Name: "terminal", Requests: 5, Window: time.Minute, Routes: []string{"/api/projects/terminal", "/api/terminal"}},
+	})
+	for path, want := range map[string]string{
+		"/api/metrics":              "api",
+		"/api/projects":             "deploy",
+		"/api/projects/1/deploy":    "deploy",
+		"/api/projects/terminal":    "terminal",
+		"/api/projects/terminal/ws": "terminal",
+		"/api/projects/terminals":   "deploy",
+		"/api/terminal":             "terminal",
+		"/api/terminalx":            "api",
+		"/api/projectsx/1":          "api",
+	} {
+		p, ok := rl.policyFor(path, "api")
+		if !ok || p.Name != want {
+			t.Errorf("policyFor(%q) = %q, want %q", path, p.Name, want)
+		}
+	}
+	if _, ok := rl.policyFor("/api/metrics", "missing"); ok {
+		t.Error("unknown default policy matched")
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	rl := NewRateLimiter([]RateLimitPolicy{
+		{Name: "api", Requests: 2, Window: time.Hour, Key: RateLimitKeyUser},
+	})
+	if err := rl.SetExempt([]string{"192.0.2.0/24"}); err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	if err := router.SetTrustedProxies([]string{"10.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	router.Use(func(c *gin.Context) {
+		if user := c.GetHeader("X-Test-User"); user != "" {
+			c.Set("user_id", user)
+		}
+	})
+	router.Use(rl.Middleware("api"))
+	router.GET("/api/metrics", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+
+	send := func(remote, xff, user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/metrics", nil)
+		req.RemoteAddr = remote
+		if xff != "" {
+			req.Header.Set("X-Forwarded-For", xff)
+		}
+		if user != "" {
+			req.Header.Set("X-Test-User", user)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	for i, remaining := range []string{"1", "0"} {
+		w := send("198.51.100.1:4000", "", "")
+		if w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Limit") != "2" || w.Header().Get("X-RateLimit-Remaining") != remaining {
+			t.Fatalf("request %d: %d limit %q remaining %q", i+1, w.Code, w.Header().Get("X-RateLimit-Limit"), w.Header().Get("X-RateLimit-Remaining"))
+		}
+	}
+	w := send("198.51.100.1:4000", "", "")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1800" || w.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Fatalf("third request: %d retry after %q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// A spoofed X-Forwarded-For from an untrusted peer still counts
+	// against the peer, and can't claim an exempt address
+	for _, xff := range []string{"203.0.113.50", "192.0.2.10"} {
+		if w := send("198.51.100.1:4000", xff, ""); w.Code != http.StatusTooManyRequests {
+			t.Fatalf("spoofed client %s: %d", xff, w.Code)
+		}
+	}
+	// Through the trusted proxy, clients are counted by their own address
+	if w := send("10.0.0.1:4000", "203.0.113.50", ""); w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Remaining") != "1" {
+		t.Fatalf("forwarded client: %d remaining %q", w.Code, w.Header().Get("X-RateLimit-Remaining"))
+	}
+
+	// Signed-in users have their own bucket whatever their address
+	if w := send("198.51.100.1:4000", "", "u1"); w.Code != http.StatusOK {
+		t.Fatalf("user from a limited address: %d", w.Code)
+	}
+
+	// Exempt clients are never limited and get no headers
+	for i := 0; i < 5; i++ {
+		if w := send("192.0.2.7:4000", "", ""); w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Limit") != "" {
+			t.Fatalf("exempt request %d: %d", i+1, w.Code)
+		}
+	}
+}
+
+func TestSetPoliciesKeepsTokens(t *testing.T) {
+	rl := &RateLimiter{buckets: make(map[string]map[string]*bucket)}
+	p := RateLimitPolicy{Name: "api", Requests: 10, Window: time.Hour}
+	rl.SetPolicies([]RateLimitPolicy{p})
+	now := time.Now()
+	for i := 0; i < 4; i++ {
+		rl.take(p, "ip:192.0.2.1", now)
+	}
+
+	// A smaller burst caps the tokens left; a larger one doesn't refill
+	p.Burst = 3
+	rl.SetPolicies([]RateLimitPolicy{p})
+	if res := rl.take(p, "ip:192.0.2.1", now); res.remaining != 2 {
+		t.Fatalf("remaining %d after lowering the burst, want 2", res.remaining)
+	}
+	p.Burst = 100
+	rl.SetPolicies([]RateLimitPolicy{p})
+	if res := rl.take(p, "ip:192.0.2.1", now); res.remaining != 1 {
+		t.Fatalf("remaining %d after raising the burst, want 1", res.remaining)
+	}
+
+	// Removing a policy drops its buckets
+	rl.SetPolicies(nil)
+	if len(rl.buckets) != 0 {
+		t.Fatal("buckets of a removed policy kept")
+	}
+}
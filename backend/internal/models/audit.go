@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// AuditEntry records one mutating API request
+type AuditEntry struct {
+	ID         string            `json:"id"`
+	Timestamp  time.Time         `json:"timestamp"`
+	UserID     string            `json:"userId,omitempty"`
+	Username   string            `json:"username,omitempty"`
+	Role       string            `json:"role,omitempty"`
+	AuthMethod string            `json:"authMethod,omitempty"` // session, token
+	TokenID    string            `json:"tokenId,omitempty"`
+	ClientIP   string            `json:"clientIP"`
+	UserAgent  string            `json:"userAgent,omitempty"`
+	Method     string            `json:"method"`
+	Route      string            `json:"route"` // Route pattern, e.g. /api/projects/:id
+	Path       string            `json:"path"`
+	ResourceID string            `json:"resourceId,omitempty"`
+	Params     map[string]string `json:"params,omitempty"`
+	Body       interface{}       `json:"body,omitempty"` // Sanitized request body
+	Status     int               `json:"status"`
+	LatencyMs  float64           `json:"latencyMs"`
+}
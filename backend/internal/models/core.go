@@ -170,3 +170,28 @@ type BackupSettings struct {
 	BackupWebsites  bool   `json:"backupWebsites"`
 	BackupDocker    bool   `json:"backupDocker"`
 }
+
+// SSLCertificate represents an SSL certificate managed by the panel
+type SSLCertificate struct {
+	ID          string    `json:"id"`
+	Domain      string    `json:"domain"`
+	Issuer      string    `json:"issuer"`
+	Provider    string    `json:"provider"` // letsencrypt, custom, self-signed
+	ExpiresAt   time.Time `json:"expiresAt"`
+	IssuedAt    time.Time `json:"issuedAt"`
+	AutoRenew   bool      `json:"autoRenew"`
+	Status      string    `json:"status"` // valid, expired, pending, error
+	CertPath    string    `json:"certPath"`
+	KeyPath     string    `json:"keyPath"`
+	LastChecked time.Time `json:"lastChecked"`
+}
+
+// InstallJob tracks the progress of a software installation
+type InstallJob struct {
+	ID        string    `json:"id"`
+	Software  string    `json:"software"`
+	Status    string    `json:"status"` // pending, installing, success, failed
+	Progress  int       `json:"progress"`
+	Message   string    `json:"message"`
+	StartedAt time.Time `json:"startedAt"`
+}
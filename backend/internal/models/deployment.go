@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// Deployment is a recorded deployment of a project. Project.LastDeploy
+// mirrors the most recent one while it runs.
+type Deployment struct {
+	ID          string          `json:"id"`
+	ProjectID   string          `json:"projectId"`
+	Status      DeployStatus    `json:"status"`
+	StartedAt   time.Time       `json:"startedAt"`
+	FinishedAt  *time.Time      `json:"finishedAt,omitempty"`
+	Duration    int64           `json:"duration"` // Seconds
+	Logs        []string        `json:"logs,omitempty"`
+	CommitSHA   string          `json:"commitSha,omitempty"`
+	Author      string          `json:"author,omitempty"`
+	Message     string          `json:"message,omitempty"`
+	Images      []DeployedImage `json:"images,omitempty"`
+	Release     string          `json:"release,omitempty"`     // Release folder of a static project
+	ComposeFile string          `json:"composeFile,omitempty"` // Compose file the services were started from
+	RollbackOf  string          `json:"rollbackOf,omitempty"`  // Deployment whose images were redeployed
+	Trigger     string          `json:"trigger,omitempty"`     // manual, rollback or webhook
+	TriggeredBy string          `json:"triggeredBy,omitempty"` // User ID
+	Pruned      bool            `json:"pruned,omitempty"`      // Images removed by retention; can't be rolled back to
+}
+
+// Deployment triggers
+const (
+	DeployTriggerManual   = "manual"
+	DeployTriggerRollback = "rollback"
+	DeployTriggerWebhook  = "webhook"
+)
+
+// DeployedImage is an image a deployment ran
+type DeployedImage struct {
+	Service string `json:"service,omitempty"` // Compose service; empty for single-container projects
+	Image   string `json:"image"`             // Tag or reference
+	ImageID string `json:"imageId"`
+}
@@ -14,15 +14,22 @@ type Project struct {
 	Status      ProjectStatus     `json:"status"`
 	Repository  *GitRepository    `json:"repository,omitempty"`
 	Docker      *DockerConfig     `json:"docker,omitempty"`
+	Static      *StaticConfig     `json:"static,omitempty"`
 	Environment map[string]string `json:"environment"`
+	Secrets     []string          `json:"secrets,omitempty"` // Names of Environment entries encrypted at rest and masked
 	NetworkID   string            `json:"networkId"`  // Isolated network
 	Domain      string            `json:"domain,omitempty"`
 	SSL         bool              `json:"ssl"`
+	Strategy    DeployStrategy    `json:"strategy,omitempty"` // recreate (default), blue-green
+	HealthCheck *HealthCheck      `json:"healthCheck,omitempty"`
 	Resources   ResourceLimits    `json:"resources"`
 	Containers  []string          `json:"containers"` // Container IDs in this project
 	CreatedAt   time.Time         `json:"createdAt"`
 	UpdatedAt   time.Time         `json:"updatedAt"`
 	LastDeploy  *DeployInfo       `json:"lastDeploy,omitempty"`
+	Previews    *PreviewConfig    `json:"previews,omitempty"`
+	ParentID    string            `json:"parentId,omitempty"` // Project a preview environment belongs to
+	Preview     *PreviewInfo      `json:"preview,omitempty"`  // Set on preview environments
 }
 
 // ProjectType defines the type of project
@@ -46,11 +53,64 @@ const (
 	ProjectStatusFailed    ProjectStatus = "failed"
 )
 
+// DeployStrategy defines how a deployment replaces the running container
+type DeployStrategy string
+
+const (
+	// DeployStrategyRecreate stops the old container before starting the new one
+	DeployStrategyRecreate DeployStrategy = "recreate"
+	// DeployStrategyBlueGreen starts the new container next to the old one and
+	// moves the project's domain to it once it passes its health check.
+	// Container ports are published on 127.0.0.1 only; the domain is the way in.
+	DeployStrategyBlueGreen DeployStrategy = "blue-green"
+)
+
+// HealthCheck decides when a blue/green deployment may take traffic
+type HealthCheck struct {
+	Type           string `json:"type"`                     // http, tcp or docker (the image's HEALTHCHECK)
+	Port           uint16 `json:"port,omitempty"`           // Container port; defaults to the first TCP port
+	Path           string `json:"path,omitempty"`           // HTTP only; defaults to /
+	ExpectedStatus int    `json:"expectedStatus,omitempty"` // HTTP only; 0 accepts any 2xx or 3xx
+	Interval       int    `json:"interval,omitempty"`       // Seconds between checks; defaults to 2
+	Timeout        int    `json:"timeout,omitempty"`        // Seconds per check; defaults to 5
+	StartTimeout   int    `json:"startTimeout,omitempty"`   // Seconds to become healthy; defaults to 60
+}
+
+// StaticConfig describes how the site of a static project is built. The
+// build command runs in a throwaway container with the checkout in its
+// working directory; the output directory is then served by nginx.
+type StaticConfig struct {
+	BuildImage   string `json:"buildImage,omitempty"`   // Defaults to node:22-alpine
+	BuildCommand string `json:"buildCommand,omitempty"` // Optional, e.g. "npm ci && npm run build"
+	OutputDir    string `json:"outputDir,omitempty"`    // Relative to the repository; defaults to its root
+	SPA          bool   `json:"spa,omitempty"`          // Serve index.html for paths without a file
+}
+
+// PreviewConfig enables preview environments: each open pull request into
+// the project's branch is deployed as a child project on the subdomain
+// pr-<number>.<Domain>, which needs a wildcard DNS record
+type PreviewConfig struct {
+	Enabled     bool              `json:"enabled"`
+	TTL         int               `json:"ttl,omitempty"`         // Hours a preview lives after its last update; defaults to 72
+	ExcludeEnv  []string          `json:"excludeEnv,omitempty"`  // Production variables that aren't copied; secrets never are
+	Environment map[string]string `json:"environment,omitempty"` // Set on top of the copied variables
+}
+
+// PreviewInfo describes the pull request a preview environment deploys
+type PreviewInfo struct {
+	Number    int       `json:"number"`
+	Title     string    `json:"title,omitempty"`
+	URL       string    `json:"url,omitempty"` // The pull request on the git host
+	Branch    string    `json:"branch"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Closed    bool      `json:"closed,omitempty"` // Removed once its deployment has stopped
+}
+
 // GitRepository represents a Git repository configuration
 type GitRepository struct {
 	URL        string `json:"url"`
 	Branch     string `json:"branch"`
-	PrivateKey string `json:"privateKey,omitempty"`
+	PrivateKey string `json:"privateKey,omitempty"` // Deploy key; encrypted at rest and masked
 	AutoDeploy bool   `json:"autoDeploy"`
 }
 
@@ -65,6 +125,10 @@ type DockerConfig struct {
 	Ports         []PortConfig      `json:"ports"`
 	Volumes       []VolumeConfig    `json:"volumes"`
 	Labels        map[string]string `json:"labels"`
+	// Override the detected commands of git projects without a Dockerfile
+	InstallCommand string `json:"installCommand,omitempty"`
+	BuildCommand   string `json:"buildCommand,omitempty"`
+	StartCommand   string `json:"startCommand,omitempty"`
 }
 
 // PortConfig represents port configuration
@@ -106,6 +170,7 @@ type DeployInfo struct {
 type DeployStatus string
 
 const (
+	DeployStatusQueued    DeployStatus = "queued" // Waiting for the project or a deploy slot
 	DeployStatusPending   DeployStatus = "pending"
 	DeployStatusBuilding  DeployStatus = "building"
 	DeployStatusDeploying DeployStatus = "deploying"
@@ -121,10 +186,15 @@ type CreateProjectRequest struct {
 	Type        ProjectType       `json:"type"` // Optional, defaults to "docker"
 	Repository  *GitRepository    `json:"repository,omitempty"`
 	Docker      *DockerConfig     `json:"docker,omitempty"`
+	Static      *StaticConfig     `json:"static,omitempty"`
 	Environment map[string]string `json:"environment"`
+	Secrets     []string          `json:"secrets,omitempty"`
 	Domain      string            `json:"domain"`
 	SSL         bool              `json:"ssl"`
+	Strategy    DeployStrategy    `json:"strategy"`
+	HealthCheck *HealthCheck      `json:"healthCheck,omitempty"`
 	Resources   ResourceLimits    `json:"resources"`
+	Previews    *PreviewConfig    `json:"previews,omitempty"`
 }
 
 // UpdateProjectRequest represents request to update a project
@@ -133,8 +203,24 @@ type UpdateProjectRequest struct {
 	Description string            `json:"description"`
 	Repository  *GitRepository    `json:"repository,omitempty"`
 	Docker      *DockerConfig     `json:"docker,omitempty"`
+	Static      *StaticConfig     `json:"static,omitempty"`
 	Environment map[string]string `json:"environment"`
+	Secrets     []string          `json:"secrets,omitempty"`
 	Domain      string            `json:"domain"`
 	SSL         bool              `json:"ssl"`
+	Strategy    DeployStrategy    `json:"strategy"`
+	HealthCheck *HealthCheck      `json:"healthCheck,omitempty"`
 	Resources   ResourceLimits    `json:"resources"`
+	Previews    *PreviewConfig    `json:"previews,omitempty"`
+}
+
+// ProjectMember gives a user a role within a single project
+type ProjectMember struct {
+	ID        string    `json:"id"` // projectID:userID
+	ProjectID string    `json:"projectId"`
+	UserID    string    `json:"userId"`
+	Role      string    `json:"role"` // maintainer, developer, viewer
+	AddedBy   string    `json:"addedBy"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
 }
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// Ban kinds
+const (
+	BanKindIP       = "ip"
+	BanKindUsername = "username"
+)
+
+// Ban blocks an IP (or CIDR) from the panel, or a username from logging in.
+// Expired automatic bans are kept for a while so repeat offenders escalate.
+type Ban struct {
+	ID             string     `json:"id"` // kind:value, see auth.BanID
+	Kind           string     `json:"kind"`
+	Value          string     `json:"value"`
+	Reason         string     `json:"reason"`
+	Automatic      bool       `json:"automatic"`
+	Level          int        `json:"level"` // Number of previous lockouts, drives the backoff
+	Failures       int        `json:"failures"`
+	CreatedBy      string     `json:"createdBy,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	ExpiresAt      *time.Time `json:"expiresAt,omitempty"` // nil = permanent
+	FirewallRuleID string     `json:"firewallRuleId,omitempty"`
+}
+
+// Active reports whether the ban is in force at t
+func (b *Ban) Active(t time.Time) bool {
+	return b.ExpiresAt == nil || t.Before(*b.ExpiresAt)
+}
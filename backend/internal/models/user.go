@@ -0,0 +1,64 @@
+package models
+
+import "time"
+
+// User represents a panel account
+type User struct {
+	ID           string     `json:"id"`
+	Username     string     `json:"username"`
+	Email        string     `json:"email,omitempty"`
+	PasswordHash string     `json:"passwordHash,omitempty"`
+	Role         string     `json:"role"`                 // admin, operator, developer, viewer
+	Provider     string     `json:"provider,omitempty"`   // oidc, ldap; empty for local accounts
+	ExternalID   string     `json:"externalId,omitempty"` // OIDC subject or LDAP DN
+	Disabled     bool       `json:"disabled"`
+	LastLoginAt  *time.Time `json:"lastLoginAt,omitempty"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	UpdatedAt    time.Time  `json:"updatedAt"`
+}
+
+// TwoFactor holds a user's TOTP enrollment
+type TwoFactor struct {
+	UserID        string     `json:"userId"`
+	Secret        string     `json:"secret,omitempty"`        // Base32, set once enrollment is verified
+	PendingSecret string     `json:"pendingSecret,omitempty"` // Base32, awaiting the first code
+	Enabled       bool       `json:"enabled"`
+	RecoveryCodes []string   `json:"recoveryCodes,omitempty"` // SHA-256 hashes of unused codes
+	LastUsedStep  int64      `json:"lastUsedStep"`            // Rejects replay of an accepted code
+	EnabledAt     *time.Time `json:"enabledAt,omitempty"`
+	UpdatedAt     time.Time  `json:"updatedAt"`
+}
+
+// APIToken is a long-lived personal access token. Only a hash of the token is stored.
+type APIToken struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	UserID     string     `json:"userId"`
+	Username   string     `json:"username"`
+	Prefix     string     `json:"prefix"` // First characters, shown to identify the token
+	TokenHash  string     `json:"tokenHash,omitempty"`
+	Scopes     []string   `json:"scopes"` // resource:action, e.g. projects:deploy
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	LastUsedIP string     `json:"lastUsedIP,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// Session is a signed-in device. Access tokens reference it by ID and the
+// rotating refresh token is stored hashed.
+type Session struct {
+	ID                  string     `json:"id"`
+	UserID              string     `json:"userId"`
+	Username            string     `json:"username"`
+	Role                string     `json:"role"`
+	RefreshTokenHash    string     `json:"refreshTokenHash,omitempty"`
+	PreviousRefreshHash string     `json:"previousRefreshHash,omitempty"` // Reuse means the token leaked
+	UserAgent           string     `json:"userAgent"`
+	IP                  string     `json:"ip"`
+	CreatedAt           time.Time  `json:"createdAt"`
+	LastSeenAt          time.Time  `json:"lastSeenAt"`
+	ExpiresAt           time.Time  `json:"expiresAt"`
+	RevokedAt           *time.Time `json:"revokedAt,omitempty"`
+	RevokeReason        string     `json:"revokeReason,omitempty"`
+}
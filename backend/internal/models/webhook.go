@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+// ProjectWebhook holds the secret a project's git webhooks are verified with
+type ProjectWebhook struct {
+	ProjectID string    `json:"projectId"`
+	Secret    string    `json:"secret"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// WebhookStatus is the outcome of a webhook delivery
+type WebhookStatus string
+
+const (
+	WebhookStatusDeployed WebhookStatus = "deployed" // A deployment was started
+	WebhookStatusRemoved  WebhookStatus = "removed"  // A closed pull request's preview environment was removed
+	WebhookStatusIgnored  WebhookStatus = "ignored"  // Valid, but nothing to deploy
+	WebhookStatusRejected WebhookStatus = "rejected" // Unknown sender, bad signature or payload
+	WebhookStatusFailed   WebhookStatus = "failed"   // The deployment couldn't be started
+)
+
+// WebhookDelivery is a received webhook call and what came of it
+type WebhookDelivery struct {
+	ID         string        `json:"id"`
+	ProjectID  string        `json:"projectId"`
+	Provider   string        `json:"provider"` // github, gitlab or gitea
+	Event      string        `json:"event"`
+	DeliveryID string        `json:"deliveryId,omitempty"` // The provider's ID of the delivery
+	Ref        string        `json:"ref,omitempty"`
+	CommitSHA  string        `json:"commitSha,omitempty"`
+	Author     string        `json:"author,omitempty"`
+	Message    string        `json:"message,omitempty"`
+	Status     WebhookStatus `json:"status"`
+	Reason     string        `json:"reason,omitempty"`
+	DeployID   string        `json:"deployId,omitempty"`
+	Verified   bool          `json:"verified"` // Signed with the project's secret
+	ClientIP   string        `json:"clientIp"`
+	ReceivedAt time.Time     `json:"receivedAt"`
+}
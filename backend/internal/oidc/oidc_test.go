@@ -0,0 +1,294 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testClientID = "biz-panel"
+
+// issuer is a mock OpenID provider serving discovery, JWKS and token
+// endpoints
+type issuer struct {
+	srv *httptest.Server
+
+	mu       sync.Mutex
+	keys     map[string]*rsa.PrivateKey // Published in the JWKS by kid
+	jwksHits int
+	codes    map[string]grant
+}
+
+// grant is an authorization code awaiting exchange
+type grant struct {
+	challenge string
+	idToken   string
+}
+
+func newIssuer(t *testing.T) *issuer {
+	t.Helper()
+	iss := &issuer{keys: make(map[string]*rsa.PrivateKey), codes: make(map[string]grant)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 iss.srv.URL,
+			"authorization_endpoint": iss.srv.URL + "/authorize",
+			"token_endpoint":         iss.srv.URL + "/token",
+			"userinfo_endpoint":      iss.srv.URL + "/userinfo",
+			"jwks_uri":               iss.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		iss.mu.Lock()
+		defer iss.mu.Unlock()
+		iss.jwksHits++
+		set := jwks{}
+		for kid, key := range iss.keys {
+			set.Keys = append(set.Keys, jwk{
+				Kty: "RSA",
+				Kid: kid,
+				Use: "sig",
+				N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(set)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		iss.mu.Lock()
+		g, ok := iss.codes[r.Form.Get("code")]
+		delete(iss.codes, r.Form.Get("code"))
+		iss.mu.Unlock()
+
+		sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+		if !ok || r.Form.Get("client_id") != testClientID || base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"id_token":     g.idToken,
+			"expires_in":   3600,
+		})
+	})
+	iss.srv = httptest.NewServer(mux)
+	t.Cleanup(iss.srv.Close)
+	return iss
+}
+
+// publish replaces the JWKS with a new key and returns it
+func (iss *issuer) publish(t *testing.T, kid string) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss.mu.Lock()
+	iss.keys = map[string]*rsa.PrivateKey{kid: key}
+	iss.mu.Unlock()
+	return key
+}
+
+// authorize issues a code for an ID token, as the authorization endpoint
+// would after the user signed in
+func (iss *issuer) authorize(challenge, idToken string) string {
+	iss.mu.Lock()
+	defer iss.mu.Unlock()
+	code := "code-" + challenge[:8]
+	iss.codes[code] = grant{challenge: challenge, idToken: idToken}
+	return code
+}
+
+func (iss *issuer) hits() int {
+	iss.mu.Lock()
+	defer iss.mu.Unlock()
+	return iss.jwksHits
+}
+
+// claims are valid ID token claims; tests change them to break one check
+func (iss *issuer) claims(nonce string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":   iss.srv.URL,
+		"aud":   testClientID,
+		"sub":   "user-1",
+		"nonce": nonce,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}
+}
+
+func sign(t *testing.T, kid string, key *rsa.PrivateKey, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	raw, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func discover(t *testing.T, iss *issuer) *Provider {
+	t.Helper()
+	p, err := Discover(context.Background(), Config{
+		Issuer:      iss.srv.URL,
+		ClientID:    testClientID,
+		RedirectURL: "https://panel.example.org/login/callback",
+		Scopes:      []string{"openid", "profile"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestLoginFlow(t *testing.T) {
+	iss := newIssuer(t)
+	key := iss.publish(t, "k1")
+	p := discover(t, iss)
+
+	verifier, challenge, err := NewPKCE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL, err := url.Parse(p.AuthCodeURL("state-1", "nonce-1", challenge))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := authURL.Query()
+	if authURL.Path != "/authorize" || q.Get("state") != "state-1" || q.Get("nonce") != "nonce-1" ||
+		q.Get("code_challenge") != challenge || q.Get("code_challenge_method") != "S256" || q.Get("client_id") != testClientID {
+		t.Fatalf("unexpected authorization URL %s", authURL)
+	}
+
+	code := iss.authorize(challenge, sign(t, "k1", key, iss.claims("nonce-1")))
+	token, err := p.Exchange(context.Background(), code, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := p.VerifyIDToken(context.Background(), token.IDToken, "nonce-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.String("sub") != "user-1" {
+		t.Fatalf("sub = %q", claims.String("sub"))
+	}
+}
+
+func TestExchangeRequiresVerifier(t *testing.T) {
+	iss := newIssuer(t)
+	key := iss.publish(t, "k1")
+	p := discover(t, iss)
+
+	_, challenge, _ := NewPKCE()
+	other, _, _ := NewPKCE()
+	code := iss.authorize(challenge, sign(t, "k1", key, iss.claims("n")))
+	if _, err := p.Exchange(context.Background(), code, other); err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Fatalf("exchange with another verifier = %v, want invalid_grant", err)
+	}
+}
+
+func TestDiscoverRejectsOtherIssuer(t *testing.T) {
+	iss := newIssuer(t)
+	_, err := Discover(context.Background(), Config{Issuer: iss.srv.URL + "/realms/other", ClientID: testClientID})
+	if err == nil {
+		t.Fatal("discovery accepted a document for another issuer")
+	}
+}
+
+func TestVerifyIDTokenRejects(t *testing.T) {
+	iss := newIssuer(t)
+	key := iss.publish(t, "k1")
+	p := discover(t, iss)
+	forged, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hmacToken := jwt.NewWithClaims(jwt.SigningMethodHS256, iss.claims("n"))
+	hmacToken.Header["kid"] = "k1"
+	hmacRaw, _ := hmacToken.SignedString([]byte("secret"))
+
+	with := func(change func(jwt.MapClaims)) string {
+		claims := iss.claims("n")
+		change(claims)
+		return sign(t, "k1", key, claims)
+	}
+	for name, raw := range map[string]string{
+		"bad signature":   sign(t, "k1", forged, iss.claims("n")),
+		"wrong nonce":     with(func(c jwt.MapClaims) { c["nonce"] = "other" }),
+		"wrong audience":  with(func(c jwt.MapClaims) { c["aud"] = "another-client" }),
+		"expired":         with(func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }),
+		"no expiry":       with(func(c jwt.MapClaims) { delete(c, "exp") }),
+		"wrong issuer":    with(func(c jwt.MapClaims) { c["iss"] = "https://evil.example.org" }),
+		"missing subject": with(func(c jwt.MapClaims) { delete(c, "sub") }),
+		"foreign azp":     with(func(c jwt.MapClaims) { c["aud"] = []string{testClientID, "other"}; c["azp"] = "other" }),
+		"hmac algorithm":  hmacRaw,
+		"unknown key":     sign(t, "k9", key, iss.claims("n")),
+	} {
+		if _, err := p.VerifyIDToken(context.Background(), raw, "n"); err == nil {
+			t.Errorf("%s: token was accepted", name)
+		}
+	}
+
+	if _, err := p.VerifyIDToken(context.Background(), sign(t, "k1", key, iss.claims("n")), "n"); err != nil {
+		t.Fatalf("valid token rejected: %v", err)
+	}
+}
+
+func TestKeyRollover(t *testing.T) {
+	iss := newIssuer(t)
+	oldKey := iss.publish(t, "k1")
+	p := discover(t, iss)
+	ctx := context.Background()
+
+	if _, err := p.VerifyIDToken(ctx, sign(t, "k1", oldKey, iss.claims("n")), "n"); err != nil {
+		t.Fatal(err)
+	}
+	if iss.hits() != 1 {
+		t.Fatalf("JWKS fetched %d times, want 1", iss.hits())
+	}
+
+	newKey := iss.publish(t, "k2")
+	newToken := sign(t, "k2", newKey, iss.claims("n"))
+
+	// Unknown key IDs refetch the set at most once per interval
+	if _, err := p.VerifyIDToken(ctx, newToken, "n"); err == nil {
+		t.Fatal("token of an unknown key accepted before the JWKS was refetched")
+	}
+	if iss.hits() != 1 {
+		t.Fatalf("JWKS refetched within the refresh interval")
+	}
+
+	p.mu.Lock()
+	p.keysLoaded = time.Now().Add(-2 * jwksRefreshInterval)
+	p.mu.Unlock()
+	if _, err := p.VerifyIDToken(ctx, newToken, "n"); err != nil {
+		t.Fatalf("token of the new key rejected after the rollover: %v", err)
+	}
+	if iss.hits() != 2 {
+		t.Fatalf("JWKS fetched %d times, want 2", iss.hits())
+	}
+
+	// The retired key is no longer trusted
+	if _, err := p.VerifyIDToken(ctx, sign(t, "k1", oldKey, iss.claims("n")), "n"); err == nil {
+		t.Fatal("token of the retired key accepted")
+	}
+}
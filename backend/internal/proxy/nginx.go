@@ -0,0 +1,216 @@
+// Package proxy points nginx sites at the containers that serve projects.
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Default locations of nginx site configs
+const (
+	DefaultSitesDir   = "/etc/nginx/sites-available"
+	DefaultEnabledDir = "/etc/nginx/sites-enabled"
+)
+
+// domainPattern matches the host names a site may be served on
+var domainPattern = regexp.MustCompile(`^(\*\.)?([a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?\.)*[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+
+// ValidDomain reports whether domain can be used as an nginx server name
+func ValidDomain(domain string) bool {
+	return len(domain) <= 253 && domainPattern.MatchString(domain)
+}
+
+// Site is a domain served by a single upstream address, or from a
+// directory of files
+type Site struct {
+	Name     string // Unique name of the site, e.g. the project ID
+	Domain   string
+	Upstream string // host:port
+	Root     string // Directory served instead of an upstream
+	SPA      bool   // With Root, serve index.html for paths without a file
+	SSL      bool   // Also listen on 443 once a Let's Encrypt certificate exists
+}
+
+// Nginx manages site configs of the local nginx
+type Nginx struct {
+	SitesDir      string
+	EnabledDir    string
+	TestCommand   []string
+	ReloadCommand []string
+
+	mu sync.Mutex
+}
+
+// NewNginx manages sites in the given directories, using the defaults for
+// empty values
+func NewNginx(sitesDir, enabledDir string) *Nginx {
+	if sitesDir == "" {
+		sitesDir = DefaultSitesDir
+	}
+	if enabledDir == "" {
+		enabledDir = DefaultEnabledDir
+	}
+	return &Nginx{
+		SitesDir:      sitesDir,
+		EnabledDir:    enabledDir,
+		TestCommand:   []string{"nginx", "-t"},
+		ReloadCommand: []string{"systemctl", "reload", "nginx"},
+	}
+}
+
+// Route writes the site's config and reloads nginx. Requests already being
+// served by the old upstream are finished by nginx's old workers. If nginx
+// rejects the config, the previous one is restored.
+func (n *Nginx) Route(site Site) error {
+	if !ValidDomain(site.Domain) {
+		return fmt.Errorf("invalid domain %q", site.Domain)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	file := "biz-panel-" + site.Name + ".conf"
+	path := filepath.Join(n.SitesDir, file)
+	link := filepath.Join(n.EnabledDir, file)
+
+	previous, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	existed := err == nil
+
+	if err := writeFile(path, []byte(siteConfig(site))); err != nil {
+		return err
+	}
+	created := "" // Link added by this call
+	if _, err := os.Lstat(link); errors.Is(err, os.ErrNotExist) {
+		if err := os.Symlink(path, link); err != nil {
+			restore(path, previous, existed, "")
+			return err
+		}
+		created = link
+	}
+
+	if out, err := run(n.TestCommand); err != nil {
+		restore(path, previous, existed, created)
+		return fmt.Errorf("nginx rejected the config: %s", out)
+	}
+	if out, err := run(n.ReloadCommand); err != nil {
+		restore(path, previous, existed, created)
+		return fmt.Errorf("failed to reload nginx: %s", out)
+	}
+	return nil
+}
+
+// Remove deletes a site's config and reloads nginx. Sites that don't exist
+// are ignored.
+func (n *Nginx) Remove(name string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	file := "biz-panel-" + name + ".conf"
+	path := filepath.Join(n.SitesDir, file)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err := os.Remove(filepath.Join(n.EnabledDir, file)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	if out, err := run(n.ReloadCommand); err != nil {
+		return fmt.Errorf("failed to reload nginx: %s", out)
+	}
+	return nil
+}
+
+// restore puts back the previous config of a site, removing the config
+// and link if there was none
+func restore(path string, previous []byte, existed bool, link string) {
+	if existed {
+		writeFile(path, previous)
+	} else {
+		os.Remove(path)
+	}
+	if link != "" {
+		os.Remove(link)
+	}
+}
+
+// writeFile replaces a file atomically
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// run executes a command and returns its combined output
+func run(command []string) (string, error) {
+	var out bytes.Buffer
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdout, cmd.Stderr = &out, &out
+	err := cmd.Run()
+	msg := strings.TrimSpace(out.String())
+	if msg == "" && err != nil {
+		msg = err.Error()
+	}
+	return msg, err
+}
+
+// siteConfig renders the nginx config of a site
+func siteConfig(site Site) string {
+	upstream := "biz-panel-" + site.Name
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Managed by Biz-Panel - changes are overwritten on deploy\n")
+	if site.Root == "" {
+		fmt.Fprintf(&b, "upstream %s {\n    server %s;\n}\n\n", upstream, site.Upstream)
+	}
+	fmt.Fprintf(&b, "server {\n    listen 80;\n    listen [::]:80;\n")
+
+	cert := "/etc/letsencrypt/live/" + site.Domain
+	if site.SSL && fileExists(cert+"/fullchain.pem") && fileExists(cert+"/privkey.pem") {
+		fmt.Fprintf(&b, "    listen 443 ssl;\n    listen [::]:443 ssl;\n")
+		fmt.Fprintf(&b, "    ssl_certificate %s/fullchain.pem;\n    ssl_certificate_key %s/privkey.pem;\n", cert, cert)
+	}
+
+	fmt.Fprintf(&b, "    server_name %s;\n\n", site.Domain)
+	if site.Root != "" {
+		fallback := "=404"
+		if site.SPA {
+			fallback = "/index.html"
+		}
+		fmt.Fprintf(&b, "    root %s;\n    index index.html;\n\n", site.Root)
+		fmt.Fprintf(&b, "    location / {\n        try_files $uri $uri/ %s;\n    }\n}\n", fallback)
+		return b.String()
+	}
+	fmt.Fprintf(&b, `    location / {
+        proxy_pass http://%s;
+        proxy_http_version 1.1;
+        proxy_set_header Host $host;
+        proxy_set_header X-Real-IP $remote_addr;
+        proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
+        proxy_set_header X-Forwarded-Proto $scheme;
+        proxy_set_header Upgrade $http_upgrade;
+        proxy_set_header Connection $http_connection;
+    }
+}
+`, upstream)
+	return b.String()
+}
+
+// fileExists reports whether path exists
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
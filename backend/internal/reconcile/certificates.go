@@ -0,0 +1,171 @@
+package reconcile
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bizino-services/biz-panel-backend/internal/models"
+	"github.com/google/uuid"
+)
+
+// hostCert is a certificate found on disk
+type hostCert struct {
+	Domain    string
+	Provider  string
+	Issuer    string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	CertPath  string
+	KeyPath   string
+}
+
+// discoverCerts scans the Let's Encrypt live dir and the panel cert dir
+func (r *Reconciler) discoverCerts(report *Report) map[string]*hostCert {
+	certs := make(map[string]*hostCert)
+
+	layouts := []struct {
+		dir, cert, key, provider string
+	}{
+		{r.LetsEncryptLive, "fullchain.pem", "privkey.pem", "letsencrypt"},
+		{r.CertDir, "cert.pem", "key.pem", ""},
+	}
+
+	for _, layout := range layouts {
+		entries, err := os.ReadDir(layout.dir)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			report.error("read %s: %v", layout.dir, err)
+			continue
+		}
+
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+			certPath := filepath.Join(layout.dir, entry.Name(), layout.cert)
+			data, err := os.ReadFile(certPath)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				report.error("read %s: %v", certPath, err)
+				continue
+			}
+
+			cert, err := parseCertificate(data)
+			if err != nil {
+				report.error("parse %s: %v", certPath, err)
+				continue
+			}
+
+			provider := layout.provider
+			if provider == "" {
+				// The panel writes both uploaded and self-signed certs here
+				provider = "custom"
+				if cert.Issuer.String() == cert.Subject.String() {
+					provider = "self-signed"
+				}
+			}
+
+			certs[entry.Name()] = &hostCert{
+				Domain:    entry.Name(),
+				Provider:  provider,
+				Issuer:    issuerName(cert, provider),
+				IssuedAt:  cert.NotBefore,
+				ExpiresAt: cert.NotAfter,
+				CertPath:  certPath,
+				KeyPath:   filepath.Join(layout.dir, entry.Name(), layout.key),
+			}
+		}
+	}
+	return certs
+}
+
+func (r *Reconciler) reconcileCertificates(report *Report, dryRun bool) {
+	certs := r.discoverCerts(report)
+	report.Discovered += len(certs)
+
+	stored, err := r.Store.Certificates.List()
+	if err != nil {
+		report.error("list certificates: %v", err)
+		return
+	}
+
+	known := make(map[string]bool, len(stored))
+	for _, cert := range stored {
+		known[cert.Domain] = true
+
+		found, ok := certs[cert.Domain]
+		if !ok {
+			report.drift(KindCertificate, cert.ID, cert.Domain, "certificate file %s not found on host", cert.CertPath)
+			continue
+		}
+		if found.CertPath != cert.CertPath {
+			report.drift(KindCertificate, cert.ID, cert.Domain, "certificate is at %s on host, %s in panel", found.CertPath, cert.CertPath)
+		}
+		if !found.ExpiresAt.Equal(cert.ExpiresAt) {
+			report.drift(KindCertificate, cert.ID, cert.Domain, "expires %s on host, %s in panel",
+				found.ExpiresAt.Format(time.RFC3339), cert.ExpiresAt.Format(time.RFC3339))
+		}
+	}
+
+	for domain, found := range certs {
+		if known[domain] {
+			continue
+		}
+
+		cert := &models.SSLCertificate{
+			ID:          uuid.New().String()[:8],
+			Domain:      domain,
+			Issuer:      found.Issuer,
+			Provider:    found.Provider,
+			ExpiresAt:   found.ExpiresAt,
+			IssuedAt:    found.IssuedAt,
+			AutoRenew:   found.Provider == "letsencrypt",
+			Status:      "valid",
+			CertPath:    found.CertPath,
+			KeyPath:     found.KeyPath,
+			LastChecked: time.Now(),
+		}
+		if time.Now().After(cert.ExpiresAt) {
+			cert.Status = "expired"
+		}
+
+		if !dryRun {
+			if err := r.Store.Certificates.Save(cert); err != nil {
+				report.error("save certificate %s: %v", domain, err)
+				continue
+			}
+		}
+		report.created(KindCertificate, cert.ID, domain, found.CertPath)
+	}
+}
+
+func parseCertificate(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("failed to parse certificate PEM")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func issuerName(cert *x509.Certificate, provider string) string {
+	switch provider {
+	case "letsencrypt":
+		return "Let's Encrypt"
+	case "self-signed":
+		return "Self-Signed"
+	}
+	if cert.Issuer.CommonName != "" {
+		return cert.Issuer.CommonName
+	}
+	return strings.Join(cert.Issuer.Organization, ", ")
+}
@@ -0,0 +1,97 @@
+package reconcile
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/bizino-services/biz-panel-backend/internal/docker"
+	"github.com/bizino-services/biz-panel-backend/internal/models"
+)
+
+func (r *Reconciler) reconcileProjects(ctx context.Context, report *Report, dryRun bool) {
+	if r.Docker == nil {
+		report.error("docker not available, skipped project networks")
+		return
+	}
+
+	networks, err := r.Docker.ListNetworks(ctx, "")
+	if err != nil {
+		report.error("list docker networks: %v", err)
+		return
+	}
+
+	managed := make(map[string]docker.NetworkInfo)
+	for _, n := range networks {
+		if n.Labels["biz-panel.managed"] == "true" && n.ProjectID != "" {
+			managed[n.ProjectID] = n
+		}
+	}
+	report.Discovered += len(managed)
+
+	stored, err := r.Store.Projects.List()
+	if err != nil {
+		report.error("list projects: %v", err)
+		return
+	}
+
+	known := make(map[string]bool, len(stored))
+	for _, p := range stored {
+		known[p.ID] = true
+
+		n, ok := managed[p.ID]
+		if !ok {
+			report.drift(KindProject, p.ID, p.Name, "network %s not found in Docker", p.NetworkID)
+			continue
+		}
+		if !strings.HasPrefix(p.NetworkID, n.ID) && p.NetworkID != n.Name {
+			report.drift(KindProject, p.ID, p.Name, "network is %s in Docker, %s in panel", n.ID, p.NetworkID)
+		}
+	}
+
+	for projectID, n := range managed {
+		if known[projectID] {
+			continue
+		}
+
+		containerIDs := []string{}
+		if containers, err := r.Docker.ListContainers(ctx, projectID); err == nil {
+			for _, ctr := range containers {
+				containerIDs = append(containerIDs, ctr.ID)
+			}
+		} else {
+			report.error("list containers for project %s: %v", projectID, err)
+		}
+
+		name := n.Labels["biz-panel.project.name"]
+		if name == "" {
+			name = n.Name
+		}
+
+		status := models.ProjectStatusIdle
+		if len(containerIDs) > 0 {
+			status = models.ProjectStatusRunning
+		}
+
+		now := time.Now()
+		project := &models.Project{
+			ID:          projectID,
+			Name:        name,
+			Type:        models.ProjectTypeDocker,
+			Status:      status,
+			Environment: map[string]string{},
+			NetworkID:   n.ID,
+			Containers:  containerIDs,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}
+
+		if !dryRun {
+			if err := r.Store.Projects.Save(project); err != nil {
+				report.error("save project %s: %v", projectID, err)
+				continue
+			}
+		}
+		report.created(KindProject, projectID, name, "docker network "+n.Name)
+	}
+}
@@ -0,0 +1,138 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bizino-services/biz-panel-backend/internal/docker"
+	"github.com/bizino-services/biz-panel-backend/internal/store"
+)
+
+// Default locations of the artifacts the panel writes to the host
+const (
+	DefaultNginxSitesAvailable = "/etc/nginx/sites-available"
+	DefaultNginxSitesEnabled   = "/etc/nginx/sites-enabled"
+	DefaultLetsEncryptLive     = "/etc/letsencrypt/live"
+	DefaultCertDir             = "/etc/biz-panel/certs"
+)
+
+// managedHeader marks nginx configs generated by the panel
+const managedHeader = "# Managed by Biz-Panel"
+
+// Record kinds used in reports
+const (
+	KindWebsite     = "website"
+	KindCertificate = "certificate"
+	KindProject     = "project"
+)
+
+// Reconciler rebuilds panel records from artifacts found on the host
+type Reconciler struct {
+	Store  *store.Store
+	Docker *docker.Client // optional
+
+	NginxSitesAvailable string
+	NginxSitesEnabled   string
+	LetsEncryptLive     string
+	CertDir             string
+
+	mu   sync.Mutex
+	last *Report
+}
+
+// New creates a reconciler using the default host paths
+func New(s *store.Store, dockerClient *docker.Client) *Reconciler {
+	return &Reconciler{
+		Store:               s,
+		Docker:              dockerClient,
+		NginxSitesAvailable: DefaultNginxSitesAvailable,
+		NginxSitesEnabled:   DefaultNginxSitesEnabled,
+		LetsEncryptLive:     DefaultLetsEncryptLive,
+		CertDir:             DefaultCertDir,
+	}
+}
+
+// Report describes the outcome of a reconciliation run
+type Report struct {
+	DryRun     bool      `json:"dryRun"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	Discovered int       `json:"discovered"` // Host artifacts found
+	Created    []Item    `json:"created"`    // Records re-created (or that would be, in a dry run)
+	Drift      []Drift   `json:"drift"`      // Stored records that disagree with the host
+	Errors     []string  `json:"errors"`
+}
+
+// Item is a record rebuilt from a host artifact
+type Item struct {
+	Kind   string `json:"kind"`
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Source string `json:"source"`
+}
+
+// Drift is a mismatch between a stored record and the host
+type Drift struct {
+	Kind  string `json:"kind"`
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Issue string `json:"issue"`
+}
+
+func (r *Report) created(kind, id, name, source string) {
+	r.Created = append(r.Created, Item{Kind: kind, ID: id, Name: name, Source: source})
+}
+
+func (r *Report) drift(kind, id, name, format string, args ...interface{}) {
+	r.Drift = append(r.Drift, Drift{Kind: kind, ID: id, Name: name, Issue: fmt.Sprintf(format, args...)})
+}
+
+func (r *Report) error(format string, args ...interface{}) {
+	r.Errors = append(r.Errors, fmt.Sprintf(format, args...))
+}
+
+// Run discovers host artifacts, re-creates missing records (unless dryRun)
+// and reports drift. Only one run happens at a time.
+func (r *Reconciler) Run(ctx context.Context, dryRun bool) *Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	report := &Report{
+		DryRun:    dryRun,
+		StartedAt: time.Now(),
+		Created:   []Item{},
+		Drift:     []Drift{},
+		Errors:    []string{},
+	}
+
+	r.reconcileWebsites(report, dryRun)
+	r.reconcileCertificates(report, dryRun)
+	r.reconcileProjects(ctx, report, dryRun)
+
+	report.FinishedAt = time.Now()
+	r.last = report
+	return report
+}
+
+// LastReport returns the result of the most recent run, or nil
+func (r *Reconciler) LastReport() *Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.last
+}
+
+// RunAtBoot reconciles once and logs a summary
+func (r *Reconciler) RunAtBoot(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	report := r.Run(ctx, false)
+	log.Printf("Reconciled host state: %d artifacts, %d records re-created, %d drift, %d errors",
+		report.Discovered, len(report.Created), len(report.Drift), len(report.Errors))
+	for _, e := range report.Errors {
+		log.Printf("Warning: reconcile: %s", e)
+	}
+}
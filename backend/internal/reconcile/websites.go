@@ -0,0 +1,199 @@
+package reconcile
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bizino-services/biz-panel-backend/internal/models"
+	"github.com/google/uuid"
+)
+
+var (
+	nginxRootRe   = regexp.MustCompile(`^\s*root\s+([^;]+);`)
+	nginxListenRe = regexp.MustCompile(`^\s*listen\s+(\d+)`)
+	nginxPHPRe    = regexp.MustCompile(`php(\d+\.\d+)-fpm\.sock`)
+	nginxSSLRe    = regexp.MustCompile(`^\s*ssl_certificate\s+`)
+)
+
+// nginxSite is what can be recovered from a panel-generated server block
+type nginxSite struct {
+	Domain       string
+	DocumentRoot string
+	PHPVersion   string
+	Port         int
+	SSL          bool
+	CreatedAt    time.Time
+	Enabled      bool
+	Path         string
+}
+
+// parseNginxSite reads a config file and returns nil if it is not managed by the panel
+func parseNginxSite(path string) (*nginxSite, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	site := &nginxSite{Path: path}
+	scanner := bufio.NewScanner(f)
+	first := true
+	for scanner.Scan() {
+		line := scanner.Text()
+		if first {
+			if strings.TrimSpace(line) != managedHeader {
+				return nil, nil
+			}
+			first = false
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "# Domain:"):
+			site.Domain = strings.TrimSpace(strings.TrimPrefix(line, "# Domain:"))
+		case strings.HasPrefix(line, "# Created:"):
+			site.CreatedAt, _ = time.Parse(time.RFC3339, strings.TrimSpace(strings.TrimPrefix(line, "# Created:")))
+		case nginxRootRe.MatchString(line):
+			site.DocumentRoot = strings.TrimSpace(nginxRootRe.FindStringSubmatch(line)[1])
+		case nginxListenRe.MatchString(line) && site.Port == 0:
+			site.Port, _ = strconv.Atoi(nginxListenRe.FindStringSubmatch(line)[1])
+		case nginxSSLRe.MatchString(line):
+			site.SSL = true
+		}
+		if m := nginxPHPRe.FindStringSubmatch(line); m != nil {
+			site.PHPVersion = m[1]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if first {
+		return nil, nil
+	}
+	if site.Domain == "" {
+		site.Domain = filepath.Base(path)
+	}
+	return site, nil
+}
+
+// discoverSites returns every managed site in sites-available, keyed by domain
+func (r *Reconciler) discoverSites(report *Report) map[string]*nginxSite {
+	sites := make(map[string]*nginxSite)
+
+	entries, err := os.ReadDir(r.NginxSitesAvailable)
+	if errors.Is(err, os.ErrNotExist) {
+		return sites
+	}
+	if err != nil {
+		report.error("read %s: %v", r.NginxSitesAvailable, err)
+		return sites
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(r.NginxSitesAvailable, entry.Name())
+		site, err := parseNginxSite(path)
+		if err != nil {
+			report.error("parse %s: %v", path, err)
+			continue
+		}
+		if site == nil {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(r.NginxSitesEnabled, entry.Name())); err == nil {
+			site.Enabled = true
+		}
+		sites[site.Domain] = site
+	}
+	return sites
+}
+
+func (r *Reconciler) reconcileWebsites(report *Report, dryRun bool) {
+	sites := r.discoverSites(report)
+	report.Discovered += len(sites)
+
+	stored, err := r.Store.Websites.List()
+	if err != nil {
+		report.error("list websites: %v", err)
+		return
+	}
+
+	known := make(map[string]bool, len(stored))
+	for _, w := range stored {
+		known[w.Domain] = true
+
+		site, ok := sites[w.Domain]
+		if !ok {
+			report.drift(KindWebsite, w.ID, w.Domain, "no managed nginx config in %s", r.NginxSitesAvailable)
+			continue
+		}
+		if site.DocumentRoot != "" && site.DocumentRoot != w.DocumentRoot {
+			report.drift(KindWebsite, w.ID, w.Domain, "document root is %s on host, %s in panel", site.DocumentRoot, w.DocumentRoot)
+		}
+		if site.PHPVersion != "" && site.PHPVersion != w.PHPVersion {
+			report.drift(KindWebsite, w.ID, w.Domain, "PHP version is %s on host, %s in panel", site.PHPVersion, w.PHPVersion)
+		}
+		if !site.Enabled && w.Status == models.StatusRunning {
+			report.drift(KindWebsite, w.ID, w.Domain, "site is not enabled in %s", r.NginxSitesEnabled)
+		}
+	}
+
+	for domain, site := range sites {
+		if known[domain] {
+			continue
+		}
+
+		website := websiteFromSite(site)
+		if !dryRun {
+			if err := r.Store.Websites.Save(website); err != nil {
+				report.error("save website %s: %v", domain, err)
+				continue
+			}
+		}
+		report.created(KindWebsite, website.ID, domain, site.Path)
+	}
+}
+
+func websiteFromSite(site *nginxSite) *models.Website {
+	status := models.StatusStopped
+	if site.Enabled {
+		status = models.StatusRunning
+	}
+
+	projectType := "static"
+	if site.PHPVersion != "" {
+		projectType = "php"
+	}
+
+	createdAt := site.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+
+	provider := "none"
+	if site.SSL {
+		provider = "letsencrypt"
+	}
+
+	return &models.Website{
+		ID:           uuid.New().String()[:8],
+		Domain:       site.Domain,
+		Aliases:      []string{},
+		Engine:       models.WebEngineNginx,
+		ProjectType:  projectType,
+		PHPVersion:   site.PHPVersion,
+		SSL:          models.SSLConfig{Enabled: site.SSL, Provider: provider, AutoRenew: site.SSL},
+		DocumentRoot: site.DocumentRoot,
+		Status:       status,
+		CreatedAt:    createdAt,
+		UpdatedAt:    time.Now(),
+	}
+}
@@ -0,0 +1,223 @@
+package secrets
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/bizino-services/biz-panel-backend/internal/models"
+	"github.com/bizino-services/biz-panel-backend/internal/store"
+)
+
+// Mask is shown in place of secret values. Updates that send it back keep
+// the stored value.
+const Mask = "********"
+
+// MaskProject replaces a project's secret environment values and deploy key
+// with Mask, in place
+func MaskProject(p *models.Project) {
+	for _, name := range p.Secrets {
+		if _, ok := p.Environment[name]; ok {
+			p.Environment[name] = Mask
+		}
+	}
+	if p.Repository != nil && p.Repository.PrivateKey != "" {
+		p.Repository.PrivateKey = Mask
+	}
+}
+
+// KeepMasked restores the values an update sent back as Mask from the
+// project as it was stored
+func KeepMasked(p, stored *models.Project) {
+	for name, value := range p.Environment {
+		if previous, ok := stored.Environment[name]; ok && value == Mask {
+			p.Environment[name] = previous
+		}
+	}
+	if p.Repository != nil && p.Repository.PrivateKey == Mask && stored.Repository != nil {
+		p.Repository.PrivateKey = stored.Repository.PrivateKey
+	}
+}
+
+// Seal encrypts a project's secret environment values and deploy key where
+// they are in plaintext, in place. Flags without a variable are dropped.
+// Encrypted values are never decrypted into the project: one no longer
+// flagged as secret is removed, so unflagging a secret can't reveal it and
+// its variable has to be set again in plaintext.
+func (k *Keyring) Seal(p *models.Project) error {
+	flagged := make(map[string]bool, len(p.Secrets))
+	names := make([]string, 0, len(p.Secrets))
+	for _, name := range p.Secrets {
+		if _, ok := p.Environment[name]; ok && !flagged[name] {
+			flagged[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	p.Secrets = names
+
+	for name, value := range p.Environment {
+		switch {
+		case flagged[name] && !IsEncrypted(value):
+			sealed, err := k.Encrypt(value)
+			if err != nil {
+				return fmt.Errorf("environment variable %s: %w", name, err)
+			}
+			p.Environment[name] = sealed
+		case !flagged[name] && IsEncrypted(value):
+			delete(p.Environment, name)
+		}
+	}
+
+	if repo := p.Repository; repo != nil && repo.PrivateKey != "" && !IsEncrypted(repo.PrivateKey) {
+		sealed, err := k.Encrypt(repo.PrivateKey)
+		if err != nil {
+			return fmt.Errorf("deploy key: %w", err)
+		}
+		repo.PrivateKey = sealed
+	}
+	return nil
+}
+
+// Open decrypts a project's secrets in place, for a deployment to inject
+// them. The project must not be saved afterwards.
+func (k *Keyring) Open(p *models.Project) error {
+	for name, value := range p.Environment {
+		if !IsEncrypted(value) {
+			continue
+		}
+		plaintext, err := k.Decrypt(value)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt environment variable %s: %w", name, err)
+		}
+		p.Environment[name] = plaintext
+	}
+	if repo := p.Repository; repo != nil && IsEncrypted(repo.PrivateKey) {
+		plaintext, err := k.Decrypt(repo.PrivateKey)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt deploy key: %w", err)
+		}
+		repo.PrivateKey = plaintext
+	}
+	return nil
+}
+
+// Reveal returns a project's decrypted secret environment values and
+// deploy key
+func (k *Keyring) Reveal(p *models.Project) (env map[string]string, privateKey string, err error) {
+	opened := *p
+	opened.Environment = make(map[string]string, len(p.Secrets))
+	for _, name := range p.Secrets {
+		if value, ok := p.Environment[name]; ok {
+			opened.Environment[name] = value
+		}
+	}
+	if p.Repository != nil {
+		repo := *p.Repository
+		opened.Repository = &repo
+	}
+	if err := k.Open(&opened); err != nil {
+		return nil, "", err
+	}
+	if opened.Repository != nil {
+		privateKey = opened.Repository.PrivateKey
+	}
+	return opened.Environment, privateKey, nil
+}
+
+// reencrypt encrypts a project's secrets again with the current key,
+// returning how many values changed
+func (k *Keyring) reencrypt(p *models.Project) (int, error) {
+	changed := 0
+	for name, value := range p.Environment {
+		if !IsEncrypted(value) || k.current(value) {
+			continue
+		}
+		plaintext, err := k.Decrypt(value)
+		if err == nil {
+			value, err = k.Encrypt(plaintext)
+		}
+		if err != nil {
+			return changed, fmt.Errorf("environment variable %s: %w", name, err)
+		}
+		p.Environment[name] = value
+		changed++
+	}
+	if repo := p.Repository; repo != nil && IsEncrypted(repo.PrivateKey) && !k.current(repo.PrivateKey) {
+		plaintext, err := k.Decrypt(repo.PrivateKey)
+		if err == nil {
+			repo.PrivateKey, err = k.Encrypt(plaintext)
+		}
+		if err != nil {
+			return changed, fmt.Errorf("deploy key: %w", err)
+		}
+		changed++
+	}
+	return changed, nil
+}
+
+// SealAll encrypts the secrets of stored projects that are still in
+// plaintext, e.g. deploy keys saved before secrets were encrypted
+func (k *Keyring) SealAll(projects store.ProjectRepository) error {
+	list, err := projects.List()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, p := range list {
+		if _, err := projects.Update(p.ID, k.Seal); err != nil {
+			errs = append(errs, fmt.Errorf("project %s: %w", p.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Hold runs fn while no key rotation is running. Code that saves encrypted
+// values outside the transaction of the project they were read from, e.g. a
+// project it creates, does so in fn; otherwise a rotation could miss them
+// and retire their key.
+func (k *Keyring) Hold(fn func() error) error {
+	if k == nil {
+		return fn()
+	}
+	k.rotation.RLock()
+	defer k.rotation.RUnlock()
+	return fn()
+}
+
+// Rotate makes a new master key current and encrypts every stored secret
+// again with it. The old keys are removed from the key file once nothing
+// needs them; if a value can't be re-encrypted they are kept and the
+// rotation can be retried. It returns the number of values re-encrypted.
+func (k *Keyring) Rotate(projects store.ProjectRepository) (int, error) {
+	k.rotation.Lock()
+	defer k.rotation.Unlock()
+
+	if err := k.rotate(); err != nil {
+		return 0, fmt.Errorf("failed to save the new master key: %w", err)
+	}
+
+	list, err := projects.List()
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	var errs []error
+	for _, p := range list {
+		_, err := projects.Update(p.ID, func(p *models.Project) error {
+			n, err := k.reencrypt(p)
+			if err != nil {
+				return err
+			}
+			total += n
+			return nil
+		})
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			errs = append(errs, fmt.Errorf("project %s: %w", p.ID, err))
+		}
+	}
+	if len(errs) > 0 {
+		return total, errors.Join(errs...)
+	}
+	return total, k.retire()
+}
@@ -0,0 +1,249 @@
+// Package secrets encrypts project secrets at rest with a master key that is
+// kept outside the database.
+package secrets
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// prefix marks an encrypted value: enc:v1:<key ID>:<base64 nonce and ciphertext>
+const prefix = "enc:v1:"
+
+// keySize is the size of a master key; AES-256
+const keySize = 32
+
+var (
+	ErrUnknownKey = errors.New("value was encrypted with a master key that is not in the key file")
+	ErrCorrupt    = errors.New("encrypted value is corrupt")
+	ErrNoKeyring  = errors.New("no master key is loaded")
+)
+
+// Keyring holds the master keys. The first encrypts; the others only
+// decrypt values that a key rotation hasn't re-encrypted yet.
+type Keyring struct {
+	mu   sync.RWMutex
+	path string
+	keys []*key
+
+	rotation sync.RWMutex // Held by Rotate, shared by Hold
+}
+
+// key is a master key and the ID stored with the values it encrypts
+type key struct {
+	id   string
+	raw  []byte
+	aead cipher.AEAD
+}
+
+// Load reads the key file, one base64 key per line with the current key
+// first. A missing file is created with a new key.
+func Load(path string) (*Keyring, error) {
+	k := &Keyring{path: path}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		current, err := newKey()
+		if err != nil {
+			return nil, err
+		}
+		k.keys = []*key{current}
+		if err := k.save(); err != nil {
+			return nil, err
+		}
+		return k, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read master key: %w", err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		raw, err := base64.StdEncoding.DecodeString(text)
+		if err != nil || len(raw) != keySize {
+			return nil, fmt.Errorf("%s:%d: a master key must be %d base64-encoded bytes", path, line, keySize)
+		}
+		parsed, err := parseKey(raw)
+		if err != nil {
+			return nil, err
+		}
+		k.keys = append(k.keys, parsed)
+	}
+	if len(k.keys) == 0 {
+		return nil, fmt.Errorf("%s holds no master key", path)
+	}
+	return k, nil
+}
+
+// newKey generates a random master key
+func newKey() (*key, error) {
+	raw := make([]byte, keySize)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	return parseKey(raw)
+}
+
+// parseKey prepares a raw master key for use
+func parseKey(raw []byte) (*key, error) {
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(raw)
+	return &key{id: hex.EncodeToString(sum[:4]), raw: raw, aead: aead}, nil
+}
+
+// save writes the keys to the key file, replacing it atomically
+func (k *Keyring) save() error {
+	var b strings.Builder
+	b.WriteString("# biz-panel master keys: the first encrypts, the others decrypt until rotated out\n")
+	for _, key := range k.keys {
+		b.WriteString(base64.StdEncoding.EncodeToString(key.raw) + "\n")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(k.path), 0700); err != nil {
+		return err
+	}
+	tmp := k.path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, k.path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// KeyID identifies the key new values are encrypted with
+func (k *Keyring) KeyID() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.keys[0].id
+}
+
+// Keys returns the number of keys in the keyring
+func (k *Keyring) Keys() int {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return len(k.keys)
+}
+
+// IsEncrypted reports whether value was produced by Encrypt
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// Encrypt seals plaintext with the current key
+func (k *Keyring) Encrypt(plaintext string) (string, error) {
+	if k == nil {
+		return "", ErrNoKeyring
+	}
+	k.mu.RLock()
+	current := k.keys[0]
+	k.mu.RUnlock()
+
+	nonce := make([]byte, current.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := current.aead.Seal(nonce, nonce, []byte(plaintext), []byte(current.id))
+	return prefix + current.id + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a value produced by Encrypt. Values that aren't encrypted
+// are returned as they are.
+func (k *Keyring) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	if k == nil {
+		return "", ErrNoKeyring
+	}
+	id, data, ok := strings.Cut(strings.TrimPrefix(value, prefix), ":")
+	if !ok {
+		return "", ErrCorrupt
+	}
+
+	k.mu.RLock()
+	var match *key
+	for _, key := range k.keys {
+		if key.id == id {
+			match = key
+			break
+		}
+	}
+	k.mu.RUnlock()
+	if match == nil {
+		return "", fmt.Errorf("%w (key %s)", ErrUnknownKey, id)
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(data)
+	if err != nil || len(sealed) < match.aead.NonceSize() {
+		return "", ErrCorrupt
+	}
+	nonce, ciphertext := sealed[:match.aead.NonceSize()], sealed[match.aead.NonceSize():]
+	plaintext, err := match.aead.Open(nil, nonce, ciphertext, []byte(id))
+	if err != nil {
+		return "", ErrCorrupt
+	}
+	return string(plaintext), nil
+}
+
+// current reports whether value is encrypted with the current key
+func (k *Keyring) current(value string) bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return strings.HasPrefix(value, prefix+k.keys[0].id+":")
+}
+
+// rotate makes a new key current, keeping the old ones for decryption
+func (k *Keyring) rotate() error {
+	next, err := newKey()
+	if err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	previous := k.keys
+	k.keys = append([]*key{next}, previous...)
+	if err := k.save(); err != nil {
+		k.keys = previous
+		return err
+	}
+	return nil
+}
+
+// retire removes every key but the current one
+func (k *Keyring) retire() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	previous := k.keys
+	k.keys = k.keys[:1]
+	if err := k.save(); err != nil {
+		k.keys = previous
+		return err
+	}
+	return nil
+}
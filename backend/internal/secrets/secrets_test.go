@@ -0,0 +1,302 @@
+package secrets
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bizino-services/biz-panel-backend/internal/models"
+	"github.com/bizino-services/biz-panel-backend/internal/store"
+)
+
+func load(t *testing.T) (*Keyring, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys", "master.key")
+	k, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k, path
+}
+
+func newProject() *models.Project {
+	return &models.Project{
+		ID:          "p1",
+		Environment: map[string]string{"DB_PASSWORD": "hunter2", "API_KEY": "key-1", "PORT": "8080"},
+		Secrets:     []string{"DB_PASSWORD", "API_KEY", "API_KEY", "MISSING"},
+		Repository:  &models.GitRepository{URL: "git@example.org:app.git", PrivateKey: "-----BEGIN KEY-----"},
+	}
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	k, path := load(t)
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("key file not created with mode 0600: %v %v", info, err)
+	}
+
+	sealed, err := k.Encrypt("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(sealed) || strings.Contains(sealed, "hunter2") || !strings.HasPrefix(sealed, prefix+k.KeyID()+":") {
+		t.Fatalf("unexpected sealed value %q", sealed)
+	}
+	if again, _ := k.Encrypt("hunter2"); again == sealed {
+		t.Fatal("encrypting twice gave the same value")
+	}
+	if plain, err := k.Decrypt(sealed); err != nil || plain != "hunter2" {
+		t.Fatalf("Decrypt = %q, %v", plain, err)
+	}
+	if plain, err := k.Decrypt("not encrypted"); err != nil || plain != "not encrypted" {
+		t.Fatalf("plaintext Decrypt = %q, %v", plain, err)
+	}
+
+	// A new keyring from the same file decrypts the value
+	reloaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain, err := reloaded.Decrypt(sealed); err != nil || plain != "hunter2" {
+		t.Fatalf("Decrypt after reload = %q, %v", plain, err)
+	}
+}
+
+func TestDecryptRejectsTampering(t *testing.T) {
+	k, _ := load(t)
+	other, _ := load(t)
+	sealed, _ := k.Encrypt("hunter2")
+	id, data, _ := strings.Cut(strings.TrimPrefix(sealed, prefix), ":")
+
+	raw, _ := base64.RawStdEncoding.DecodeString(data)
+	raw[len(raw)-1] ^= 1
+	flipped := base64.RawStdEncoding.EncodeToString(raw)
+	otherID := other.KeyID()
+
+	for name, tc := range map[string]struct {
+		value string
+		want  error
+	}{
+		"changed ciphertext": {prefix + id + ":" + flipped, ErrCorrupt},
+		"truncated":          {prefix + id + ":" + data[:8], ErrCorrupt},
+		"not base64":         {prefix + id + ":!!!", ErrCorrupt},
+		"no key ID":          {prefix + data, ErrCorrupt},
+		"other key ID":       {prefix + otherID + ":" + data, ErrUnknownKey},
+		"unknown key ID":     {prefix + "00000000:" + data, ErrUnknownKey},
+	} {
+		if _, err := k.Decrypt(tc.value); !errors.Is(err, tc.want) {
+			t.Errorf("%s: Decrypt error = %v, want %v", name, err, tc.want)
+		}
+	}
+
+	// The key ID is authenticated, so a value can't be moved to another key
+	// that happens to be in the keyring
+	if err := k.rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := k.Decrypt(prefix + k.KeyID() + ":" + data); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("value relabelled with another key ID: %v", err)
+	}
+}
+
+func TestSealAndOpen(t *testing.T) {
+	k, _ := load(t)
+	p := newProject()
+	if err := k.Seal(p); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(p.Secrets, ","); got != "API_KEY,DB_PASSWORD" {
+		t.Fatalf("secrets = %s, want the flagged variables once, sorted", got)
+	}
+	if !IsEncrypted(p.Environment["DB_PASSWORD"]) || !IsEncrypted(p.Environment["API_KEY"]) || !IsEncrypted(p.Repository.PrivateKey) {
+		t.Fatalf("secrets left in plaintext: %+v %q", p.Environment, p.Repository.PrivateKey)
+	}
+	if p.Environment["PORT"] != "8080" {
+		t.Fatalf("unflagged variable changed to %q", p.Environment["PORT"])
+	}
+
+	// Sealing again leaves the encrypted values alone
+	sealed := map[string]string{"DB_PASSWORD": p.Environment["DB_PASSWORD"], "API_KEY": p.Environment["API_KEY"], "key": p.Repository.PrivateKey}
+	if err := k.Seal(p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Environment["DB_PASSWORD"] != sealed["DB_PASSWORD"] || p.Environment["API_KEY"] != sealed["API_KEY"] || p.Repository.PrivateKey != sealed["key"] {
+		t.Fatal("Seal is not idempotent")
+	}
+
+	env, key, err := k.Reveal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(env) != 2 || env["DB_PASSWORD"] != "hunter2" || env["API_KEY"] != "key-1" || key != "-----BEGIN KEY-----" {
+		t.Fatalf("Reveal = %v %q", env, key)
+	}
+	if !IsEncrypted(p.Environment["DB_PASSWORD"]) {
+		t.Fatal("Reveal decrypted the project in place")
+	}
+
+	if err := k.Open(p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Environment["DB_PASSWORD"] != "hunter2" || p.Environment["PORT"] != "8080" || p.Repository.PrivateKey != "-----BEGIN KEY-----" {
+		t.Fatalf("Open = %+v %q", p.Environment, p.Repository.PrivateKey)
+	}
+}
+
+func TestMaskAndKeepMasked(t *testing.T) {
+	k, _ := load(t)
+	stored := newProject()
+	if err := k.Seal(stored); err != nil {
+		t.Fatal(err)
+	}
+
+	shown := *stored
+	shown.Environment = map[string]string{}
+	for name, value := range stored.Environment {
+		shown.Environment[name] = value
+	}
+	repo := *stored.Repository
+	shown.Repository = &repo
+	MaskProject(&shown)
+	if shown.Environment["DB_PASSWORD"] != Mask || shown.Environment["API_KEY"] != Mask || shown.Repository.PrivateKey != Mask || shown.Environment["PORT"] != "8080" {
+		t.Fatalf("MaskProject = %+v %q", shown.Environment, shown.Repository.PrivateKey)
+	}
+
+	// The client sends back one secret masked and changes the other
+	update := &models.Project{
+		Environment: map[string]string{"DB_PASSWORD": Mask, "API_KEY": "key-2", "PORT": Mask, "NEW": Mask},
+		Secrets:     stored.Secrets,
+		Repository:  &models.GitRepository{URL: repo.URL, PrivateKey: Mask},
+	}
+	KeepMasked(update, stored)
+	if update.Environment["DB_PASSWORD"] != stored.Environment["DB_PASSWORD"] {
+		t.Error("masked secret was not kept")
+	}
+	if update.Environment["API_KEY"] != "key-2" {
+		t.Error("changed secret was overwritten")
+	}
+	if update.Environment["PORT"] != stored.Environment["PORT"] {
+		t.Error("masked plain variable was not kept")
+	}
+	if update.Environment["NEW"] != Mask {
+		t.Error("a value without a stored one was replaced")
+	}
+	if update.Repository.PrivateKey != stored.Repository.PrivateKey {
+		t.Error("masked deploy key was not kept")
+	}
+}
+
+func TestSealDropsUnflaggedSecrets(t *testing.T) {
+	k, _ := load(t)
+	p := newProject()
+	if err := k.Seal(p); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unflagging must not turn the stored value into a readable one
+	p.Secrets = []string{"API_KEY"}
+	if err := k.Seal(p); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.Environment["DB_PASSWORD"]; ok {
+		t.Fatalf("unflagged secret kept as %q", p.Environment["DB_PASSWORD"])
+	}
+	if !IsEncrypted(p.Environment["API_KEY"]) || p.Environment["PORT"] != "8080" {
+		t.Fatalf("other variables changed: %+v", p.Environment)
+	}
+
+	// Setting it again in plaintext is how it becomes a plain variable
+	p.Environment["DB_PASSWORD"] = "now-public"
+	if err := k.Seal(p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Environment["DB_PASSWORD"] != "now-public" {
+		t.Fatalf("plain variable = %q", p.Environment["DB_PASSWORD"])
+	}
+}
+
+func TestRotate(t *testing.T) {
+	k, path := load(t)
+	s := store.NewMemory()
+	for _, id := range []string{"p1", "p2"} {
+		p := newProject()
+		p.ID = id
+		if err := k.Seal(p); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Projects.Save(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	oldID := k.KeyID()
+
+	count, err := k.Rotate(s.Projects)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 6 {
+		t.Fatalf("re-encrypted %d values, want 6", count)
+	}
+	if k.KeyID() == oldID || k.Keys() != 1 {
+		t.Fatalf("old key not retired: %d keys, current %s", k.Keys(), k.KeyID())
+	}
+
+	reloaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Keys() != 1 || reloaded.KeyID() != k.KeyID() {
+		t.Fatal("key file still holds the old key")
+	}
+	for _, id := range []string{"p1", "p2"} {
+		p, _ := s.Projects.Get(id)
+		for _, value := range []string{p.Environment["DB_PASSWORD"], p.Environment["API_KEY"], p.Repository.PrivateKey} {
+			if !strings.HasPrefix(value, prefix+k.KeyID()+":") {
+				t.Fatalf("project %s holds a value of another key: %q", id, value)
+			}
+		}
+		if err := reloaded.Open(p); err != nil || p.Environment["DB_PASSWORD"] != "hunter2" {
+			t.Fatalf("project %s after rotation: %v", id, err)
+		}
+	}
+}
+
+func TestRotateWaitsForHold(t *testing.T) {
+	k, _ := load(t)
+	s := store.NewMemory()
+	oldID := k.KeyID()
+
+	// A project sealed with the old key and saved while the rotation waits
+	// is re-encrypted rather than left with a retired key
+	sealed := make(chan struct{})
+	done := make(chan error)
+	go k.Hold(func() error {
+		p := newProject()
+		k.Seal(p)
+		close(sealed)
+		time.Sleep(50 * time.Millisecond)
+		return s.Projects.Save(p)
+	})
+	<-sealed
+	go func() {
+		_, err := k.Rotate(s.Projects)
+		done <- err
+	}()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := s.Projects.Get("p1")
+	if err != nil {
+		t.Fatal("rotation finished before the held save")
+	}
+	if strings.Contains(p.Environment["DB_PASSWORD"], oldID) {
+		t.Fatal("project saved during the rotation still uses the retired key")
+	}
+	if err := k.Open(p); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,64 @@
+package store
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bizino-services/biz-panel-backend/internal/models"
+)
+
+func TestBoltAuditQueryPages(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "panel.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	total := 2*auditPageSize + 7
+	for i := 1; i <= total; i++ {
+		method := "POST"
+		if i%3 == 0 {
+			method = "DELETE"
+		}
+		if err := s.Audit.Append(&models.AuditEntry{Timestamp: time.Now(), Method: method}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Appending while visiting must not wait for the query, and entries
+	// appended meanwhile are newer than the ones being visited
+	next := total
+	err = s.Audit.Query(AuditFilter{}, func(entry *models.AuditEntry) error {
+		if entry.ID != strconv.Itoa(next) {
+			t.Fatalf("visited entry %s, want %d", entry.ID, next)
+		}
+		next--
+		if next%auditPageSize == 0 {
+			return s.Audit.Append(&models.AuditEntry{Timestamp: time.Now(), Method: "PUT"})
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != 0 {
+		t.Fatalf("query stopped before entry %d", next)
+	}
+
+	deletes := 0
+	err = s.Audit.Query(AuditFilter{Method: "DELETE", Limit: auditPageSize/3 + 5}, func(entry *models.AuditEntry) error {
+		if entry.Method != "DELETE" {
+			t.Fatalf("filter matched a %s entry", entry.Method)
+		}
+		deletes++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deletes != auditPageSize/3+5 {
+		t.Fatalf("limited query visited %d entries, want %d", deletes, auditPageSize/3+5)
+	}
+}
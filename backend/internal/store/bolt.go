@@ -0,0 +1,351 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/bizino-services/biz-panel-backend/internal/models"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Bucket names
+var (
+	bucketMeta         = []byte("meta")
+	bucketWebsites     = []byte("websites")
+	bucketDatabases    = []byte("databases")
+	bucketCronjobs     = []byte("cronjobs")
+	bucketFirewall     = []byte("firewall_rules")
+	bucketProjects     = []byte("projects")
+	bucketMembers      = []byte("project_members")
+	bucketDeployments  = []byte("deployments")
+	bucketWebhooks     = []byte("project_webhooks")
+	bucketDeliveries   = []byte("webhook_deliveries")
+	bucketCertificates = []byte("ssl_certificates")
+	bucketInstallJobs  = []byte("install_jobs")
+	bucketUsers        = []byte("users")
+	bucketTwoFactor    = []byte("two_factor")
+	bucketAPITokens    = []byte("api_tokens")
+	bucketSessions     = []byte("sessions")
+	bucketBans         = []byte("bans")
+	bucketAudit        = []byte("audit_log")
+	bucketActivities   = []byte("activities")
+	bucketSettings     = []byte("settings")
+)
+
+// settingsKey is the single key of the settings document
+var settingsKey = []byte("panel")
+
+// Open opens (or creates) the embedded database at path and applies pending migrations
+func Open(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Store{
+		Websites:     websiteRepo{newBoltRepo(db, bucketWebsites, websiteKey)},
+		Databases:    newBoltRepo(db, bucketDatabases, databaseKey),
+		Cronjobs:     newBoltRepo(db, bucketCronjobs, cronjobKey),
+		Firewall:     newBoltRepo(db, bucketFirewall, firewallKey),
+		Projects:     newBoltRepo(db, bucketProjects, projectKey),
+		Members:      memberRepo{newBoltRepo(db, bucketMembers, memberKey)},
+		Deployments:  deploymentRepo{newBoltRepo(db, bucketDeployments, deploymentKey)},
+		Webhooks:     newBoltRepo(db, bucketWebhooks, webhookKey),
+		Deliveries:   deliveryRepo{newBoltRepo(db, bucketDeliveries, deliveryKey)},
+		Certificates: certificateRepo{newBoltRepo(db, bucketCertificates, certificateKey)},
+		InstallJobs:  newBoltRepo(db, bucketInstallJobs, installJobKey),
+		Users:        userRepo{newBoltRepo(db, bucketUsers, userKey)},
+		TwoFactor:    newBoltRepo(db, bucketTwoFactor, twoFactorKey),
+		APITokens:    apiTokenRepo{newBoltRepo(db, bucketAPITokens, apiTokenKey)},
+		Sessions:     sessionRepo{newBoltRepo(db, bucketSessions, sessionKey)},
+		Bans:         newBoltRepo(db, bucketBans, banKey),
+		Audit:        &boltAudit{db: db},
+		Activities:   &boltActivities{db: db},
+		Settings:     &boltSettings{db: db},
+		close:        db.Close,
+	}, nil
+}
+
+// boltRepo stores JSON-encoded records in a single bucket
+type boltRepo[T any] struct {
+	db     *bolt.DB
+	bucket []byte
+	key    func(item *T) string
+}
+
+func newBoltRepo[T any](db *bolt.DB, bucket []byte, key func(item *T) string) *boltRepo[T] {
+	return &boltRepo[T]{db: db, bucket: bucket, key: key}
+}
+
+func (r *boltRepo[T]) List() ([]*T, error) {
+	items := make([]*T, 0)
+	err := r.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(r.bucket).ForEach(func(_, v []byte) error {
+			item := new(T)
+			if err := json.Unmarshal(v, item); err != nil {
+				return err
+			}
+			items = append(items, item)
+			return nil
+		})
+	})
+	return items, err
+}
+
+func (r *boltRepo[T]) Get(id string) (*T, error) {
+	item := new(T)
+	err := r.db.View(func(tx *bolt.Tx) error {
+		return getRecord(tx.Bucket(r.bucket), id, item)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func (r *boltRepo[T]) Save(item *T) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return putRecord(tx.Bucket(r.bucket), r.key(item), item)
+	})
+}
+
+func (r *boltRepo[T]) Update(id string, fn func(item *T) error) (*T, error) {
+	item := new(T)
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(r.bucket)
+		if err := getRecord(b, id, item); err != nil {
+			return err
+		}
+		if err := fn(item); err != nil {
+			return err
+		}
+		return putRecord(b, id, item)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func (r *boltRepo[T]) Delete(id string) (*T, error) {
+	item := new(T)
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(r.bucket)
+		if err := getRecord(b, id, item); err != nil {
+			return err
+		}
+		return b.Delete([]byte(id))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func getRecord(b *bolt.Bucket, id string, out interface{}) error {
+	data := b.Get([]byte(id))
+	if data == nil {
+		return ErrNotFound
+	}
+	return json.Unmarshal(data, out)
+}
+
+func putRecord(b *bolt.Bucket, id string, item interface{}) error {
+	if id == "" {
+		return fmt.Errorf("record has no ID")
+	}
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(id), data)
+}
+
+// boltActivities keeps activities keyed by a monotonic sequence
+type boltActivities struct {
+	db *bolt.DB
+}
+
+func (r *boltActivities) Append(activity *models.Activity) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketActivities)
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(activity)
+		if err != nil {
+			return err
+		}
+		if err := b.Put(sequenceKey(seq), data); err != nil {
+			return err
+		}
+
+		// Keep only the last maxActivities entries
+		keys := make([][]byte, 0, maxActivities+1)
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			keys = append(keys, append([]byte(nil), k...))
+		}
+		for len(keys) > maxActivities {
+			if err := b.Delete(keys[0]); err != nil {
+				return err
+			}
+			keys = keys[1:]
+		}
+		return nil
+	})
+}
+
+func (r *boltActivities) Recent(limit int) ([]*models.Activity, error) {
+	activities := make([]*models.Activity, 0)
+	err := r.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketActivities).Cursor()
+		for k, v := c.Last(); k != nil && (limit <= 0 || len(activities) < limit); k, v = c.Prev() {
+			var a models.Activity
+			if err := json.Unmarshal(v, &a); err != nil {
+				return err
+			}
+			activities = append(activities, &a)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Oldest first, matching the order they were recorded
+	for i, j := 0, len(activities)-1; i < j; i, j = i+1, j-1 {
+		activities[i], activities[j] = activities[j], activities[i]
+	}
+	return activities, nil
+}
+
+func sequenceKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
+
+// boltSettings stores the settings document under a single key
+type boltSettings struct {
+	db *bolt.DB
+}
+
+func (r *boltSettings) Get() (*models.Settings, error) {
+	var settings models.Settings
+	err := r.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucketSettings).Get(settingsKey)
+		if data == nil {
+			settings = *DefaultSettings()
+			return nil
+		}
+		return json.Unmarshal(data, &settings)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+func (r *boltSettings) Save(settings *models.Settings) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		data, err := json.Marshal(settings)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(bucketSettings).Put(settingsKey, data)
+	})
+}
+
+// boltAudit keeps audit entries keyed by a monotonic sequence and never deletes them
+type boltAudit struct {
+	db *bolt.DB
+}
+
+func (r *boltAudit) Append(entry *models.AuditEntry) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketAudit)
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		entry.ID = strconv.FormatUint(seq, 10)
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		return b.Put(sequenceKey(seq), data)
+	})
+}
+
+// auditPageSize is the number of audit entries read per transaction. Query
+// calls fn between transactions, so a slow reader such as an export download
+// never keeps one open; that would block writes that grow the database.
+const auditPageSize = 500
+
+func (r *boltAudit) Query(filter AuditFilter, fn func(entry *models.AuditEntry) error) error {
+	var last []byte // Key of the oldest entry read so far; nil starts at the newest
+	matched := 0
+	for {
+		var page []*models.AuditEntry
+		done := false
+		err := r.db.View(func(tx *bolt.Tx) error {
+			c := tx.Bucket(bucketAudit).Cursor()
+			k, v := c.Last()
+			if last != nil {
+				// Resume below the last key of the previous page
+				if k, v = c.Seek(last); k == nil {
+					k, v = c.Last()
+				}
+				for k != nil && bytes.Compare(k, last) >= 0 {
+					k, v = c.Prev()
+				}
+			}
+			for read := 0; k != nil && read < auditPageSize; k, v = c.Prev() {
+				read++
+				last = append(last[:0], k...)
+				entry := new(models.AuditEntry)
+				if err := json.Unmarshal(v, entry); err != nil {
+					return err
+				}
+				if filter.Match(entry) {
+					page = append(page, entry)
+				}
+			}
+			done = k == nil
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range page {
+			if err := fn(entry); err != nil {
+				return err
+			}
+			matched++
+			if filter.Limit > 0 && matched >= filter.Limit {
+				return nil
+			}
+		}
+		if done {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/bizino-services/biz-panel-backend/internal/models"
+)
+
+// NewMemory returns a Store kept entirely in memory, for tests and for
+// running the API without a data directory. Records are JSON-encoded just
+// like the embedded database, so callers see the same copy semantics.
+func NewMemory() *Store {
+	firewall := newMemoryRepo(firewallKey)
+	for _, rule := range defaultFirewallRules() {
+		firewall.Save(rule)
+	}
+
+	return &Store{
+		Websites:     websiteRepo{newMemoryRepo(websiteKey)},
+		Databases:    newMemoryRepo(databaseKey),
+		Cronjobs:     newMemoryRepo(cronjobKey),
+		Firewall:     firewall,
+		Projects:     newMemoryRepo(projectKey),
+		Members:      memberRepo{newMemoryRepo(memberKey)},
+		Deployments:  deploymentRepo{newMemoryRepo(deploymentKey)},
+		Webhooks:     newMemoryRepo(webhookKey),
+		Deliveries:   deliveryRepo{newMemoryRepo(deliveryKey)},
+		Certificates: certificateRepo{newMemoryRepo(certificateKey)},
+		InstallJobs:  newMemoryRepo(installJobKey),
+		Users:        userRepo{newMemoryRepo(userKey)},
+		TwoFactor:    newMemoryRepo(twoFactorKey),
+		APITokens:    apiTokenRepo{newMemoryRepo(apiTokenKey)},
+		Sessions:     sessionRepo{newMemoryRepo(sessionKey)},
+		Bans:         newMemoryRepo(banKey),
+		Audit:        &memoryAudit{},
+		Activities:   &memoryActivities{},
+		Settings:     &memorySettings{settings: DefaultSettings()},
+	}
+}
+
+// memoryRepo keeps JSON-encoded records in a map
+type memoryRepo[T any] struct {
+	mu      sync.RWMutex
+	records map[string][]byte
+	key     func(item *T) string
+}
+
+func newMemoryRepo[T any](key func(item *T) string) *memoryRepo[T] {
+	return &memoryRepo[T]{records: make(map[string][]byte), key: key}
+}
+
+func (r *memoryRepo[T]) List() ([]*T, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ids := make([]string, 0, len(r.records))
+	for id := range r.records {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	items := make([]*T, 0, len(ids))
+	for _, id := range ids {
+		item := new(T)
+		if err := json.Unmarshal(r.records[id], item); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (r *memoryRepo[T]) Get(id string) (*T, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.get(id)
+}
+
+func (r *memoryRepo[T]) Save(item *T) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.put(r.key(item), item)
+}
+
+func (r *memoryRepo[T]) Update(id string, fn func(item *T) error) (*T, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	item, err := r.get(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := fn(item); err != nil {
+		return nil, err
+	}
+	if err := r.put(id, item); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func (r *memoryRepo[T]) Delete(id string) (*T, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	item, err := r.get(id)
+	if err != nil {
+		return nil, err
+	}
+	delete(r.records, id)
+	return item, nil
+}
+
+func (r *memoryRepo[T]) get(id string) (*T, error) {
+	data, ok := r.records[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	item := new(T)
+	if err := json.Unmarshal(data, item); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func (r *memoryRepo[T]) put(id string, item *T) error {
+	if id == "" {
+		return fmt.Errorf("record has no ID")
+	}
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	r.records[id] = data
+	return nil
+}
+
+// memoryActivities keeps the activity log in a slice
+type memoryActivities struct {
+	mu         sync.RWMutex
+	activities []models.Activity
+}
+
+func (r *memoryActivities) Append(activity *models.Activity) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.activities = append(r.activities, *activity)
+	if len(r.activities) > maxActivities {
+		r.activities = r.activities[len(r.activities)-maxActivities:]
+	}
+	return nil
+}
+
+func (r *memoryActivities) Recent(limit int) ([]*models.Activity, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	start := 0
+	if limit > 0 && len(r.activities) > limit {
+		start = len(r.activities) - limit
+	}
+
+	activities := make([]*models.Activity, 0, len(r.activities)-start)
+	for i := start; i < len(r.activities); i++ {
+		a := r.activities[i]
+		activities = append(activities, &a)
+	}
+	return activities, nil
+}
+
+// memorySettings holds the settings document
+type memorySettings struct {
+	mu       sync.RWMutex
+	settings *models.Settings
+}
+
+func (r *memorySettings) Get() (*models.Settings, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	settings := *r.settings
+	return &settings, nil
+}
+
+func (r *memorySettings) Save(settings *models.Settings) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	copied := *settings
+	r.settings = &copied
+	return nil
+}
+
+// memoryAudit keeps audit entries in a slice
+type memoryAudit struct {
+	mu      sync.RWMutex
+	entries []models.AuditEntry
+}
+
+func (r *memoryAudit) Append(entry *models.AuditEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry.ID = strconv.Itoa(len(r.entries) + 1)
+	r.entries = append(r.entries, *entry)
+	return nil
+}
+
+func (r *memoryAudit) Query(filter AuditFilter, fn func(entry *models.AuditEntry) error) error {
+	// Entries are only appended, so the snapshot stays valid without the lock
+	r.mu.RLock()
+	entries := r.entries
+	r.mu.RUnlock()
+
+	matched := 0
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if !filter.Match(&entry) {
+			continue
+		}
+		if err := fn(&entry); err != nil {
+			return err
+		}
+		matched++
+		if filter.Limit > 0 && matched >= filter.Limit {
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package store
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// schemaVersionKey holds the last applied migration in the meta bucket
+var schemaVersionKey = []byte("schema_version")
+
+// migration is a single schema change applied inside one transaction
+type migration struct {
+	version uint64
+	name    string
+	up      func(tx *bolt.Tx) error
+}
+
+// migrations are applied in order; never edit or reorder a released migration
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "create buckets",
+		up: func(tx *bolt.Tx) error {
+			for _, name := range [][]byte{
+				bucketWebsites, bucketDatabases, bucketCronjobs, bucketFirewall,
+				bucketProjects, bucketCertificates, bucketInstallJobs,
+				bucketActivities, bucketSettings,
+			} {
+				if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version: 2,
+		name:    "seed default settings and firewall rules",
+		up: func(tx *bolt.Tx) error {
+			settings, err := json.Marshal(DefaultSettings())
+			if err != nil {
+				return err
+			}
+			if err := tx.Bucket(bucketSettings).Put(settingsKey, settings); err != nil {
+				return err
+			}
+			b := tx.Bucket(bucketFirewall)
+			for _, rule := range defaultFirewallRules() {
+				if err := putRecord(b, rule.ID, rule); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version: 3,
+		name:    "create users bucket",
+		up: func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(bucketUsers)
+			return err
+		},
+	},
+	{
+		version: 4,
+		name:    "create two-factor bucket",
+		up: func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(bucketTwoFactor)
+			return err
+		},
+	},
+	{
+		version: 5,
+		name:    "create api tokens bucket",
+		up: func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(bucketAPITokens)
+			return err
+		},
+	},
+	{
+		version: 6,
+		name:    "create sessions bucket",
+		up: func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(bucketSessions)
+			return err
+		},
+	},
+	{
+		version: 7,
+		name:    "create bans bucket",
+		up: func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(bucketBans)
+			return err
+		},
+	},
+	{
+		version: 8,
+		name:    "create audit log bucket",
+		up: func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(bucketAudit)
+			return err
+		},
+	},
+	{
+		version: 9,
+		name:    "create project members bucket",
+		up: func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(bucketMembers)
+			return err
+		},
+	},
+	{
+		version: 10,
+		name:    "create deployments bucket",
+		up: func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(bucketDeployments)
+			return err
+		},
+	},
+	{
+		version: 11,
+		name:    "create webhook buckets",
+		up: func(tx *bolt.Tx) error {
+			for _, bucket := range [][]byte{bucketWebhooks, bucketDeliveries} {
+				if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+}
+
+// migrate applies every migration newer than the stored schema version
+func migrate(db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(bucketMeta)
+		if err != nil {
+			return err
+		}
+
+		var current uint64
+		if v := meta.Get(schemaVersionKey); v != nil {
+			current = binary.BigEndian.Uint64(v)
+		}
+
+		for _, m := range migrations {
+			if m.version <= current {
+				continue
+			}
+			if err := m.up(tx); err != nil {
+				return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.name, err)
+			}
+			if err := meta.Put(schemaVersionKey, sequenceKey(m.version)); err != nil {
+				return err
+			}
+			log.Printf("Applied store migration %d: %s", m.version, m.name)
+		}
+		return nil
+	})
+}
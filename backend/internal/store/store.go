@@ -0,0 +1,376 @@
+package store
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bizino-services/biz-panel-backend/internal/models"
+)
+
+// ErrNotFound is returned when a record does not exist
+var ErrNotFound = errors.New("record not found")
+
+// Repository is the persistence contract shared by every keyed model.
+// Implementations return copies, so callers must Save or Update to persist changes.
+type Repository[T any] interface {
+	List() ([]*T, error)
+	Get(id string) (*T, error)
+	Save(item *T) error
+	Update(id string, fn func(item *T) error) (*T, error)
+	Delete(id string) (*T, error)
+}
+
+// WebsiteRepository stores websites
+type WebsiteRepository interface {
+	Repository[models.Website]
+	FindByDomain(domain string) (*models.Website, error)
+}
+
+// DatabaseRepository stores databases
+type DatabaseRepository interface {
+	Repository[models.Database]
+}
+
+// CronjobRepository stores cronjobs
+type CronjobRepository interface {
+	Repository[models.Cronjob]
+}
+
+// FirewallRepository stores firewall rules
+type FirewallRepository interface {
+	Repository[models.FirewallRule]
+}
+
+// ProjectRepository stores projects
+type ProjectRepository interface {
+	Repository[models.Project]
+}
+
+// SSLCertificateRepository stores SSL certificates
+type SSLCertificateRepository interface {
+	Repository[models.SSLCertificate]
+	FindByDomain(domain string) (*models.SSLCertificate, error)
+}
+
+// InstallJobRepository stores software installation jobs
+type InstallJobRepository interface {
+	Repository[models.InstallJob]
+}
+
+// UserRepository stores panel accounts
+type UserRepository interface {
+	Repository[models.User]
+	FindByUsername(username string) (*models.User, error)
+	FindByExternalID(provider, externalID string) (*models.User, error)
+}
+
+// TwoFactorRepository stores TOTP enrollments keyed by user ID
+type TwoFactorRepository interface {
+	Repository[models.TwoFactor]
+}
+
+// APITokenRepository stores personal access tokens
+type APITokenRepository interface {
+	Repository[models.APIToken]
+	FindByHash(hash string) (*models.APIToken, error)
+}
+
+// SessionRepository stores signed-in sessions
+type SessionRepository interface {
+	Repository[models.Session]
+	FindByRefreshHash(hash string) (*models.Session, error)
+}
+
+// ProjectMemberRepository stores per-project roles of users
+type ProjectMemberRepository interface {
+	Repository[models.ProjectMember]
+	ListByProject(projectID string) ([]*models.ProjectMember, error)
+	ListByUser(userID string) ([]*models.ProjectMember, error)
+}
+
+// DeploymentRepository stores the deployment history of projects
+type DeploymentRepository interface {
+	Repository[models.Deployment]
+	// ListByProject returns a project's deployments, newest first
+	ListByProject(projectID string) ([]*models.Deployment, error)
+}
+
+// WebhookDeliveryRepository stores received webhook calls
+type WebhookDeliveryRepository interface {
+	Repository[models.WebhookDelivery]
+	// ListByProject returns a project's deliveries, newest first
+	ListByProject(projectID string) ([]*models.WebhookDelivery, error)
+}
+
+// BanRepository stores IP and username bans
+type BanRepository interface {
+	Repository[models.Ban]
+}
+
+// ActivityRepository is an append-only log of recent activities
+type ActivityRepository interface {
+	Append(activity *models.Activity) error
+	Recent(limit int) ([]*models.Activity, error)
+}
+
+// AuditFilter narrows an audit query; zero fields match everything
+type AuditFilter struct {
+	User       string // User ID or username
+	Method     string
+	Route      string // Substring of the route pattern or path
+	ResourceID string
+	ClientIP   string
+	StatusMin  int
+	StatusMax  int
+	From       time.Time
+	To         time.Time
+	Limit      int // 0 = no limit
+}
+
+// Match reports whether entry passes the filter
+func (f AuditFilter) Match(e *models.AuditEntry) bool {
+	switch {
+	case f.User != "" && f.User != e.UserID && f.User != e.Username:
+		return false
+	case f.Method != "" && !strings.EqualFold(f.Method, e.Method):
+		return false
+	case f.Route != "" && !strings.Contains(e.Route, f.Route) && !strings.Contains(e.Path, f.Route):
+		return false
+	case f.ResourceID != "" && f.ResourceID != e.ResourceID:
+		return false
+	case f.ClientIP != "" && f.ClientIP != e.ClientIP:
+		return false
+	case f.StatusMin > 0 && e.Status < f.StatusMin:
+		return false
+	case f.StatusMax > 0 && e.Status > f.StatusMax:
+		return false
+	case !f.From.IsZero() && e.Timestamp.Before(f.From):
+		return false
+	case !f.To.IsZero() && e.Timestamp.After(f.To):
+		return false
+	}
+	return true
+}
+
+// AuditRepository is an append-only log of API requests
+type AuditRepository interface {
+	Append(entry *models.AuditEntry) error
+	// Query visits matching entries newest first, up to filter.Limit
+	Query(filter AuditFilter, fn func(entry *models.AuditEntry) error) error
+}
+
+// SettingsRepository stores the panel settings document
+type SettingsRepository interface {
+	Get() (*models.Settings, error)
+	Save(settings *models.Settings) error
+}
+
+// Store groups every repository used by the API
+type Store struct {
+	Websites     WebsiteRepository
+	Databases    DatabaseRepository
+	Cronjobs     CronjobRepository
+	Firewall     FirewallRepository
+	Projects     ProjectRepository
+	Members      ProjectMemberRepository
+	Deployments  DeploymentRepository
+	Webhooks     Repository[models.ProjectWebhook]
+	Deliveries   WebhookDeliveryRepository
+	Certificates SSLCertificateRepository
+	InstallJobs  InstallJobRepository
+	Users        UserRepository
+	TwoFactor    TwoFactorRepository
+	APITokens    APITokenRepository
+	Sessions     SessionRepository
+	Bans         BanRepository
+	Audit        AuditRepository
+	Activities   ActivityRepository
+	Settings     SettingsRepository
+
+	close func() error
+}
+
+// Close releases the underlying storage
+func (s *Store) Close() error {
+	if s.close == nil {
+		return nil
+	}
+	return s.close()
+}
+
+// maxActivities is the number of activities kept in the log
+const maxActivities = 100
+
+// Record keys for each model
+func websiteKey(w *models.Website) string               { return w.ID }
+func databaseKey(d *models.Database) string             { return d.ID }
+func cronjobKey(cj *models.Cronjob) string              { return cj.ID }
+func firewallKey(r *models.FirewallRule) string         { return r.ID }
+func projectKey(p *models.Project) string               { return p.ID }
+func certificateKey(cert *models.SSLCertificate) string { return cert.ID }
+func installJobKey(j *models.InstallJob) string         { return j.ID }
+func userKey(u *models.User) string                     { return u.ID }
+func twoFactorKey(tf *models.TwoFactor) string          { return tf.UserID }
+func apiTokenKey(t *models.APIToken) string             { return t.ID }
+func sessionKey(s *models.Session) string               { return s.ID }
+func banKey(b *models.Ban) string                       { return b.ID }
+func memberKey(m *models.ProjectMember) string          { return m.ID }
+func deploymentKey(d *models.Deployment) string         { return d.ID }
+func webhookKey(w *models.ProjectWebhook) string        { return w.ProjectID }
+func deliveryKey(d *models.WebhookDelivery) string      { return d.ID }
+
+// findFirst returns the first record matching the predicate
+func findFirst[T any](repo Repository[T], match func(item *T) bool) (*T, error) {
+	items, err := repo.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		if match(item) {
+			return item, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// findAll returns every record matching the predicate
+func findAll[T any](repo Repository[T], match func(item *T) bool) ([]*T, error) {
+	items, err := repo.List()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*T, 0)
+	for _, item := range items {
+		if match(item) {
+			result = append(result, item)
+		}
+	}
+	return result, nil
+}
+
+type websiteRepo struct {
+	Repository[models.Website]
+}
+
+func (r websiteRepo) FindByDomain(domain string) (*models.Website, error) {
+	return findFirst[models.Website](r, func(w *models.Website) bool { return w.Domain == domain })
+}
+
+type certificateRepo struct {
+	Repository[models.SSLCertificate]
+}
+
+func (r certificateRepo) FindByDomain(domain string) (*models.SSLCertificate, error) {
+	return findFirst[models.SSLCertificate](r, func(cert *models.SSLCertificate) bool { return cert.Domain == domain })
+}
+
+type userRepo struct {
+	Repository[models.User]
+}
+
+func (r userRepo) FindByUsername(username string) (*models.User, error) {
+	return findFirst[models.User](r, func(u *models.User) bool { return u.Username == username })
+}
+
+func (r userRepo) FindByExternalID(provider, externalID string) (*models.User, error) {
+	return findFirst[models.User](r, func(u *models.User) bool {
+		return u.Provider == provider && u.ExternalID == externalID
+	})
+}
+
+type apiTokenRepo struct {
+	Repository[models.APIToken]
+}
+
+func (r apiTokenRepo) FindByHash(hash string) (*models.APIToken, error) {
+	return findFirst[models.APIToken](r, func(t *models.APIToken) bool { return t.TokenHash == hash })
+}
+
+type sessionRepo struct {
+	Repository[models.Session]
+}
+
+// FindByRefreshHash matches the current or the previous refresh token
+func (r sessionRepo) FindByRefreshHash(hash string) (*models.Session, error) {
+	return findFirst[models.Session](r, func(s *models.Session) bool {
+		return s.RefreshTokenHash == hash || s.PreviousRefreshHash == hash
+	})
+}
+
+type memberRepo struct {
+	Repository[models.ProjectMember]
+}
+
+func (r memberRepo) ListByProject(projectID string) ([]*models.ProjectMember, error) {
+	return findAll[models.ProjectMember](r, func(m *models.ProjectMember) bool { return m.ProjectID == projectID })
+}
+
+func (r memberRepo) ListByUser(userID string) ([]*models.ProjectMember, error) {
+	return findAll[models.ProjectMember](r, func(m *models.ProjectMember) bool { return m.UserID == userID })
+}
+
+type deploymentRepo struct {
+	Repository[models.Deployment]
+}
+
+func (r deploymentRepo) ListByProject(projectID string) ([]*models.Deployment, error) {
+	deployments, err := findAll[models.Deployment](r, func(d *models.Deployment) bool { return d.ProjectID == projectID })
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(deployments, func(i, j int) bool { return deployments[i].StartedAt.After(deployments[j].StartedAt) })
+	return deployments, nil
+}
+
+type deliveryRepo struct {
+	Repository[models.WebhookDelivery]
+}
+
+func (r deliveryRepo) ListByProject(projectID string) ([]*models.WebhookDelivery, error) {
+	deliveries, err := findAll[models.WebhookDelivery](r, func(d *models.WebhookDelivery) bool { return d.ProjectID == projectID })
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ReceivedAt.After(deliveries[j].ReceivedAt) })
+	return deliveries, nil
+}
+
+// DefaultSettings returns the settings used before any have been saved
+func DefaultSettings() *models.Settings {
+	return &models.Settings{
+		General: models.GeneralSettings{
+			PanelTitle: "Biz-Panel",
+			PanelPort:  5173,
+			Timezone:   "Asia/Ho_Chi_Minh",
+			Language:   "en",
+			DarkMode:   true,
+		},
+		Security: models.SecuritySettings{
+			EnableSSL:         true,
+			SessionTimeout:    30,
+			BruteForceEnabled: true,
+		},
+		Notifications: models.NotificationSettings{
+			NotifyDeploy: true,
+			NotifySSL:    true,
+		},
+		Backup: models.BackupSettings{
+			Enabled:         true,
+			Schedule:        "daily",
+			RetentionDays:   30,
+			BackupDatabases: true,
+			BackupWebsites:  true,
+		},
+	}
+}
+
+// defaultFirewallRules returns the rules seeded into a new store
+func defaultFirewallRules() []*models.FirewallRule {
+	return []*models.FirewallRule{
+		{ID: "1", Port: 22, Protocol: "tcp", Source: "0.0.0.0/0", Action: "allow", Description: "SSH Access", Enabled: true},
+		{ID: "2", Port: 80, Protocol: "tcp", Source: "0.0.0.0/0", Action: "allow", Description: "HTTP", Enabled: true},
+		{ID: "3", Port: 443, Protocol: "tcp", Source: "0.0.0.0/0", Action: "allow", Description: "HTTPS", Enabled: true},
+	}
+}
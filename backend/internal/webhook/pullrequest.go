@@ -0,0 +1,144 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// IsPullRequest reports whether an event announces a change to a pull
+// request, called a merge request on GitLab
+func IsPullRequest(provider, event string) bool {
+	if provider == GitLab {
+		return event == "Merge Request Hook"
+	}
+	return event == "pull_request"
+}
+
+// PullRequest is a pull request event
+type PullRequest struct {
+	Number        int
+	Title         string
+	URL           string // Page of the pull request on the git host
+	Branch        string // Branch with the changes
+	Base          string // Branch the changes are merged into
+	SHA           string // Head commit of Branch
+	Author        string // "Name <email>" of the head commit, or the host's user name
+	Message       string // Subject line of the head commit, where the host sends it
+	Closed        bool   // Closed or merged
+	Fork          bool   // Branch lives in another repository
+	DefaultBranch string // Default branch of the repository
+}
+
+// pullRequestPayload holds the fields of GitHub and Gitea pull request
+// events and GitLab merge request events
+type pullRequestPayload struct {
+	Action      string `json:"action"`
+	Number      int    `json:"number"`
+	PullRequest struct {
+		Number  int    `json:"number"`
+		Title   string `json:"title"`
+		HTMLURL string `json:"html_url"`
+		State   string `json:"state"`
+		User    struct {
+			Login string `json:"login"`
+		} `json:"user"`
+		Head pullRequestRef `json:"head"`
+		Base pullRequestRef `json:"base"`
+	} `json:"pull_request"`
+	ObjectAttributes struct { // GitLab
+		IID             int           `json:"iid"`
+		Title           string        `json:"title"`
+		URL             string        `json:"url"`
+		State           string        `json:"state"`
+		Action          string        `json:"action"`
+		SourceBranch    string        `json:"source_branch"`
+		TargetBranch    string        `json:"target_branch"`
+		SourceProjectID int           `json:"source_project_id"`
+		TargetProjectID int           `json:"target_project_id"`
+		LastCommit      payloadCommit `json:"last_commit"`
+	} `json:"object_attributes"`
+	User struct { // GitLab
+		Username string `json:"username"`
+	} `json:"user"`
+	Repository struct {
+		DefaultBranch string `json:"default_branch"`
+	} `json:"repository"`
+	Project struct { // GitLab
+		DefaultBranch string `json:"default_branch"`
+	} `json:"project"`
+}
+
+type pullRequestRef struct {
+	Ref  string `json:"ref"`
+	SHA  string `json:"sha"`
+	Repo *struct {
+		FullName string `json:"full_name"`
+	} `json:"repo"`
+}
+
+// ParsePullRequest decodes the body of a pull request event
+func ParsePullRequest(provider string, body []byte) (*PullRequest, error) {
+	var p pullRequestPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, err
+	}
+
+	var pr *PullRequest
+	if provider == GitLab {
+		attrs := p.ObjectAttributes
+		pr = &PullRequest{
+			Number: attrs.IID,
+			Title:  attrs.Title,
+			URL:    attrs.URL,
+			Branch: attrs.SourceBranch,
+			Base:   attrs.TargetBranch,
+			SHA:    attrs.LastCommit.ID,
+			Author: p.User.Username,
+			Closed: attrs.State == "closed" || attrs.State == "merged" || attrs.Action == "close" || attrs.Action == "merge",
+			Fork:   attrs.SourceProjectID != attrs.TargetProjectID,
+		}
+		if commit := attrs.LastCommit; commit.ID != "" {
+			pr.Message, _, _ = strings.Cut(strings.TrimSpace(commit.Message), "\n")
+			if commit.Author.Name != "" {
+				pr.Author = commit.Author.Name
+				if commit.Author.Email != "" {
+					pr.Author += " <" + commit.Author.Email + ">"
+				}
+			}
+		}
+	} else {
+		req := p.PullRequest
+		pr = &PullRequest{
+			Number: req.Number,
+			Title:  req.Title,
+			URL:    req.HTMLURL,
+			Branch: req.Head.Ref,
+			Base:   req.Base.Ref,
+			SHA:    req.Head.SHA,
+			Author: req.User.Login,
+			Closed: p.Action == "closed" || req.State == "closed",
+		}
+		if pr.Number == 0 {
+			pr.Number = p.Number
+		}
+		// The head repository is null once a fork is deleted
+		pr.Fork = req.Head.Repo == nil || req.Base.Repo == nil || req.Head.Repo.FullName != req.Base.Repo.FullName
+	}
+
+	pr.DefaultBranch = p.Repository.DefaultBranch
+	if pr.DefaultBranch == "" {
+		pr.DefaultBranch = p.Project.DefaultBranch
+	}
+	if pr.Number <= 0 {
+		return nil, errors.New("pull request event has no number")
+	}
+	if pr.Branch == "" || pr.Base == "" {
+		return nil, errors.New("pull request event has no branches")
+	}
+	if !commitSHA.MatchString(pr.SHA) {
+		return nil, fmt.Errorf("pull request event has an invalid commit %q", pr.SHA)
+	}
+	return pr, nil
+}
@@ -0,0 +1,205 @@
+// Package webhook verifies and parses push and pull request webhooks of git
+// hosts.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Supported git hosts
+const (
+	GitHub = "github"
+	GitLab = "gitlab"
+	Gitea  = "gitea"
+)
+
+var (
+	ErrUnknownProvider = errors.New("request is not a GitHub, GitLab or Gitea webhook")
+	ErrBadSignature    = errors.New("signature does not match the webhook secret")
+)
+
+// Detect returns the git host that sent a webhook, or "" if unknown.
+// Gitea also sends GitHub's headers, so it is checked first.
+func Detect(h http.Header) string {
+	switch {
+	case h.Get("X-Gitea-Event") != "":
+		return Gitea
+	case h.Get("X-Gitlab-Event") != "":
+		return GitLab
+	case h.Get("X-GitHub-Event") != "":
+		return GitHub
+	}
+	return ""
+}
+
+// Event returns the event name of a webhook, e.g. "push"
+func Event(provider string, h http.Header) string {
+	switch provider {
+	case Gitea:
+		return h.Get("X-Gitea-Event")
+	case GitLab:
+		return h.Get("X-Gitlab-Event")
+	case GitHub:
+		return h.Get("X-GitHub-Event")
+	}
+	return ""
+}
+
+// DeliveryID returns the host's ID of a webhook delivery
+func DeliveryID(provider string, h http.Header) string {
+	switch provider {
+	case Gitea:
+		return h.Get("X-Gitea-Delivery")
+	case GitLab:
+		return h.Get("X-Gitlab-Event-UUID")
+	case GitHub:
+		return h.Get("X-GitHub-Delivery")
+	}
+	return ""
+}
+
+// IsPush reports whether an event announces pushed commits
+func IsPush(provider, event string) bool {
+	if provider == GitLab {
+		return event == "Push Hook"
+	}
+	return event == "push"
+}
+
+// IsPing reports whether an event only tests the webhook
+func IsPing(provider, event string) bool {
+	return provider == GitHub && event == "ping"
+}
+
+// Verify checks a webhook against the project's secret. GitHub and Gitea
+// sign the body with HMAC-SHA256; GitLab sends the secret as a token.
+func Verify(provider string, h http.Header, body []byte, secret string) error {
+	if secret == "" {
+		return ErrBadSignature
+	}
+	var signature string
+	switch provider {
+	case GitLab:
+		if subtle.ConstantTimeCompare([]byte(h.Get("X-Gitlab-Token")), []byte(secret)) != 1 {
+			return ErrBadSignature
+		}
+		return nil
+	case Gitea:
+		signature = h.Get("X-Gitea-Signature")
+	case GitHub:
+		var ok bool
+		if signature, ok = strings.CutPrefix(h.Get("X-Hub-Signature-256"), "sha256="); !ok {
+			return ErrBadSignature
+		}
+	default:
+		return ErrUnknownProvider
+	}
+
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return ErrBadSignature
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// commitSHA matches a full or abbreviated commit hash. Commits from
+// payloads reach git's command line, so anything else is refused.
+var commitSHA = regexp.MustCompile(`^[0-9a-fA-F]{7,64}$`)
+
+// Push is a push event
+type Push struct {
+	Ref           string // e.g. refs/heads/main
+	Branch        string // Empty for tag pushes
+	SHA           string // Commit the branch points to after the push
+	Author        string // "Name <email>", as recorded for deployments
+	Message       string // Subject line of the head commit
+	Deleted       bool   // The branch was deleted
+	DefaultBranch string // Default branch of the repository
+}
+
+// payload holds the fields of GitHub, GitLab and Gitea push events
+type payload struct {
+	Ref         string          `json:"ref"`
+	After       string          `json:"after"`
+	CheckoutSHA string          `json:"checkout_sha"` // GitLab
+	Deleted     bool            `json:"deleted"`
+	HeadCommit  *payloadCommit  `json:"head_commit"`
+	Commits     []payloadCommit `json:"commits"`
+	Repository  struct {
+		DefaultBranch string `json:"default_branch"`
+	} `json:"repository"`
+	Project struct { // GitLab
+		DefaultBranch string `json:"default_branch"`
+	} `json:"project"`
+}
+
+type payloadCommit struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+	Author  struct {
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	} `json:"author"`
+}
+
+// ParsePush decodes the body of a push event
+func ParsePush(body []byte) (*Push, error) {
+	var p payload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, err
+	}
+	if p.Ref == "" {
+		return nil, errors.New("push event has no ref")
+	}
+
+	push := &Push{
+		Ref:           p.Ref,
+		SHA:           p.After,
+		DefaultBranch: p.Repository.DefaultBranch,
+	}
+	if p.CheckoutSHA != "" {
+		push.SHA = p.CheckoutSHA
+	}
+	if push.DefaultBranch == "" {
+		push.DefaultBranch = p.Project.DefaultBranch
+	}
+	if !commitSHA.MatchString(push.SHA) {
+		return nil, fmt.Errorf("push event has an invalid commit %q", push.SHA)
+	}
+	if branch, ok := strings.CutPrefix(p.Ref, "refs/heads/"); ok {
+		push.Branch = branch
+	}
+	push.Deleted = p.Deleted || strings.Trim(push.SHA, "0") == ""
+
+	head := p.HeadCommit
+	if head == nil || head.ID != push.SHA {
+		head = nil
+		for i := range p.Commits {
+			if p.Commits[i].ID == push.SHA {
+				head = &p.Commits[i]
+			}
+		}
+	}
+	if head != nil {
+		push.Message, _, _ = strings.Cut(strings.TrimSpace(head.Message), "\n")
+		push.Author = head.Author.Name
+		if head.Author.Email != "" {
+			push.Author += " <" + head.Author.Email + ">"
+		}
+	}
+	return push, nil
+}
@@ -0,0 +1,26 @@
+package webhook
+
+import "testing"
+
+func TestParseRejectsInvalidCommits(t *testing.T) {
+	for _, sha := range []string{"", "--upload-pack=touch /tmp/x", "-abcdef1", "abc", "0123456789abcdeg", "HEAD"} {
+		push := `{"ref":"refs/heads/main","after":"` + sha + `"}`
+		if _, err := ParsePush([]byte(push)); err == nil {
+			t.Errorf("ParsePush accepted commit %q", sha)
+		}
+
+		pr := `{"action":"opened","number":1,"pull_request":{"number":1,"head":{"ref":"feature","sha":"` + sha + `"},"base":{"ref":"main"}}}`
+		if _, err := ParsePullRequest(GitHub, []byte(pr)); err == nil {
+			t.Errorf("ParsePullRequest accepted commit %q", sha)
+		}
+	}
+
+	sha := "0123456789abcdef0123456789abcdef01234567"
+	if push, err := ParsePush([]byte(`{"ref":"refs/heads/main","after":"` + sha + `"}`)); err != nil || push.SHA != sha {
+		t.Fatalf("ParsePush(valid commit) = %+v, %v", push, err)
+	}
+	mr := `{"object_attributes":{"iid":2,"source_branch":"feature","target_branch":"main","last_commit":{"id":"` + sha + `"}}}`
+	if pr, err := ParsePullRequest(GitLab, []byte(mr)); err != nil || pr.SHA != sha {
+		t.Fatalf("ParsePullRequest(valid commit) = %+v, %v", pr, err)
+	}
+}